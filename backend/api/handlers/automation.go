@@ -1,11 +1,20 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/defioptimization/shared/database"
 	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/shared/rules"
 	"github.com/gin-gonic/gin"
 )
 
@@ -34,6 +43,23 @@ func CreateAutomationRule(c *gin.Context) {
 
 	rule.UserID = userID.(uint)
 
+	if err := validateConfigs(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateRuleLimits(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Execution tracking is owned by the automation engine
+	rule.LastExecutedAt = nil
+	rule.ExecutionCount = 0
+	rule.ExecutionsToday = 0
+	rule.ExecutionDay = ""
+	rule.Armed = true
+
 	if err := database.DB.Create(&rule).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create automation rule"})
 		return
@@ -53,11 +79,31 @@ func UpdateAutomationRule(c *gin.Context) {
 		return
 	}
 
+	tracking := rule
 	if err := c.ShouldBindJSON(&rule); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	if err := validateConfigs(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateRuleLimits(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Execution tracking is owned by the automation engine
+	rule.ID = tracking.ID
+	rule.UserID = tracking.UserID
+	rule.LastExecutedAt = tracking.LastExecutedAt
+	rule.ExecutionCount = tracking.ExecutionCount
+	rule.ExecutionsToday = tracking.ExecutionsToday
+	rule.ExecutionDay = tracking.ExecutionDay
+	rule.Armed = tracking.Armed || rule.ResetThreshold == nil
+
 	if err := database.DB.Save(&rule).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update automation rule"})
 		return
@@ -79,3 +125,403 @@ func DeleteAutomationRule(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Automation rule deleted"})
 }
 
+// maxExecutionsPageSize caps the limit of a rule execution history page
+const maxExecutionsPageSize = 200
+
+// ruleExecutionView is an execution record with the job that ran its action
+// and the transactions the user's wallet sent for it
+type ruleExecutionView struct {
+	models.RuleExecution
+	Job          *models.ActionJob    `json:"job,omitempty"`
+	Transactions []models.Transaction `json:"transactions,omitempty"`
+}
+
+// GetRuleExecutions returns a page of an automation rule's evaluations,
+// newest first, with what the trigger saw, the decision and any transactions
+func GetRuleExecutions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var rule models.AutomationRule
+	if err := database.DB.Unscoped().Where("id = ? AND user_id = ?", id, userID).First(&rule).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Automation rule not found"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 || limit > maxExecutionsPageSize {
+		limit = maxExecutionsPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	query := database.DB.Model(&models.RuleExecution{}).Where("rule_id = ?", rule.ID)
+	if decision := c.Query("decision"); decision != "" {
+		query = query.Where("decision = ?", decision)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rule executions"})
+		return
+	}
+
+	var executions []models.RuleExecution
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&executions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rule executions"})
+		return
+	}
+
+	views, err := linkTransactions(rule.UserID, executions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rule transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"executions": views,
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+	})
+}
+
+// linkTransactions attaches to each execution its action job and the
+// transactions its wallet requests were answered with. The wallet service
+// records each transaction with the request it answered, and the request
+// with the hash of the transaction, so either links them.
+func linkTransactions(userID uint, executions []models.RuleExecution) ([]ruleExecutionView, error) {
+	var jobIDs []uint
+	for _, exec := range executions {
+		if exec.JobID != nil {
+			jobIDs = append(jobIDs, *exec.JobID)
+		}
+	}
+	jobs := make(map[uint]*models.ActionJob)
+	if len(jobIDs) > 0 {
+		var found []models.ActionJob
+		if err := database.DB.Where("id IN ?", jobIDs).Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for i := range found {
+			jobs[found[i].ID] = &found[i]
+		}
+	}
+
+	views := make([]ruleExecutionView, len(executions))
+	var requestIDs []int64
+	for i, exec := range executions {
+		views[i].RuleExecution = exec
+		// A fired rule's action runs later in its job, which records the steps
+		if exec.JobID != nil {
+			if job, ok := jobs[*exec.JobID]; ok {
+				views[i].Job = job
+				views[i].Actions = job.Steps
+			}
+		}
+		for _, step := range views[i].Actions {
+			if requested(step) {
+				requestIDs = append(requestIDs, step.RequestID)
+			}
+		}
+	}
+	if len(requestIDs) == 0 {
+		return views, nil
+	}
+
+	var requests []models.WalletRequest
+	if err := database.DB.Where("user_id = ? AND request_id IN ? AND tx_hash <> ''", userID, requestIDs).
+		Find(&requests).Error; err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(requests))
+	for i, r := range requests {
+		hashes[i] = r.TxHash
+	}
+
+	var txs []models.Transaction
+	if err := database.DB.Where("user_id = ?", userID).
+		Where("request_id IN ? OR tx_hash IN ?", requestIDs, hashes).
+		Order("created_at").
+		Find(&txs).Error; err != nil {
+		return nil, err
+	}
+
+	// Request IDs are only unique per session, so the chain and sender must
+	// match too
+	sentFor := func(step models.ExecutionStep) string {
+		for _, r := range requests {
+			if r.RequestID == step.RequestID && r.Chain == step.Chain && strings.EqualFold(r.FromAddress, step.From) {
+				return r.TxHash
+			}
+		}
+		return ""
+	}
+	claimed := make(map[uint]bool)
+	for i := range views {
+		for _, step := range views[i].Actions {
+			if !requested(step) {
+				continue
+			}
+			hash := sentFor(step)
+			for _, tx := range txs {
+				linked := (tx.RequestID != nil && *tx.RequestID == step.RequestID) || (hash != "" && strings.EqualFold(tx.TxHash, hash))
+				if linked && tx.Chain == step.Chain && strings.EqualFold(tx.FromAddress, step.From) && !claimed[tx.ID] {
+					claimed[tx.ID] = true
+					views[i].Transactions = append(views[i].Transactions, tx)
+				}
+			}
+		}
+	}
+	return views, nil
+}
+
+// requested reports whether a step was sent to the wallet for signing
+func requested(step models.ExecutionStep) bool {
+	return step.Status == "requested" && step.RequestID != 0
+}
+
+// GetBridgeTransfers returns the current user's cross-chain rebalance
+// transfers, newest first, optionally filtered by status
+func GetBridgeTransfers(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > maxExecutionsPageSize {
+		limit = maxExecutionsPageSize
+	}
+
+	query := database.DB.Where("user_id = ?", userID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var transfers []models.BridgeTransfer
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&transfers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bridge transfers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transfers": transfers})
+}
+
+// serviceClient calls internal services; backtests over long ranges can
+// take a while
+var serviceClient = &http.Client{Timeout: 2 * time.Minute}
+
+// DryRunAutomationRule evaluates a rule now against live data and returns
+// what would happen, including simulated transactions, without executing
+// anything
+func DryRunAutomationRule(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var rule models.AutomationRule
+	if err := database.DB.Where("id = ? AND user_id = ?", id, userID).First(&rule).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Automation rule not found"})
+		return
+	}
+
+	proxyAutomation(c, "/api/v1/rules/dry-run", gin.H{"rule": rule})
+}
+
+// BacktestAutomationRule replays a stored rule, or a rule that hasn't been
+// created yet, over stored market history and reports when it would have
+// fired, the estimated outcomes and the gas spent
+func BacktestAutomationRule(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req struct {
+		RuleID uint                   `json:"rule_id"`
+		Rule   *models.AutomationRule `json:"rule"`
+		From   time.Time              `json:"from" binding:"required"`
+		To     time.Time              `json:"to" binding:"required"`
+		Step   string                 `json:"step"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rule models.AutomationRule
+	switch {
+	case req.RuleID != 0:
+		if err := database.DB.Where("id = ? AND user_id = ?", req.RuleID, userID).First(&rule).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Automation rule not found"})
+			return
+		}
+	case req.Rule != nil:
+		rule = *req.Rule
+		rule.ID = 0
+		rule.UserID = userID.(uint)
+		if err := validateConfigs(&rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateRuleLimits(&rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rule_id or rule is required"})
+		return
+	}
+
+	proxyAutomation(c, "/api/v1/rules/backtest", gin.H{
+		"rule": rule,
+		"from": req.From,
+		"to":   req.To,
+		"step": req.Step,
+	})
+}
+
+// proxyAutomation posts a request to the automation service and relays its
+// response
+func proxyAutomation(c *gin.Context, path string, body interface{}) {
+	baseURL := os.Getenv("AUTOMATION_SERVICE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8083"
+	}
+	proxyService(c, "Automation", baseURL+path, body)
+}
+
+// proxyService posts a request to an internal service and relays its
+// response
+func proxyService(c *gin.Context, service, url string, body interface{}) {
+	reqJSON, err := json.Marshal(body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), "POST", url, bytes.NewReader(reqJSON))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Internal-Token", os.Getenv("INTERNAL_API_TOKEN"))
+
+	resp, err := serviceClient.Do(req)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": service + " service unavailable"})
+		return
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read " + strings.ToLower(service) + " service response"})
+		return
+	}
+
+	c.Data(resp.StatusCode, "application/json", respBody)
+}
+
+// GetAutomationSchemas returns the JSON Schemas of every trigger and action
+// config, for building rule forms
+func GetAutomationSchemas(c *gin.Context) {
+	c.JSON(http.StatusOK, rules.Schemas())
+}
+
+// GetAutomationTemplates returns the rule templates and their parameters
+func GetAutomationTemplates(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"templates": rules.Templates()})
+}
+
+// CreateRuleFromTemplate creates an automation rule from a template and
+// the template's parameters
+func CreateRuleFromTemplate(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var params map[string]interface{}
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	spec, err := rules.BuildTemplate(c.Param("name"), params)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := models.AutomationRule{
+		UserID:          userID.(uint),
+		Name:            spec.Name,
+		Description:     spec.Description,
+		Enabled:         true,
+		TriggerType:     spec.TriggerType,
+		TriggerConfig:   spec.TriggerConfig,
+		ActionType:      spec.ActionType,
+		ActionConfig:    spec.ActionConfig,
+		CooldownSeconds: spec.CooldownSeconds,
+		Armed:           true,
+	}
+	if err := validateConfigs(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create automation rule"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+// validateConfigs checks a rule's trigger and action configs against the
+// schemas for their types
+func validateConfigs(rule *models.AutomationRule) error {
+	if err := rules.ValidateTrigger(rule.TriggerType, rule.TriggerConfig); err != nil {
+		return fmt.Errorf("invalid trigger_config: %w", err)
+	}
+	if err := rules.ValidateAction(rule.ActionType, rule.ActionConfig); err != nil {
+		return fmt.Errorf("invalid action_config: %w", err)
+	}
+	return nil
+}
+
+// validateRuleLimits checks a rule's cooldown, caps and reset threshold
+func validateRuleLimits(rule *models.AutomationRule) error {
+	if rule.CooldownSeconds < 0 {
+		return errors.New("cooldown_seconds must not be negative")
+	}
+	if rule.MaxExecutions < 0 {
+		return errors.New("max_executions must not be negative")
+	}
+	if rule.MaxExecutionsPerDay < 0 {
+		return errors.New("max_executions_per_day must not be negative")
+	}
+	if rule.FireOnce && rule.MaxExecutions > 1 {
+		return errors.New("fire_once rules can't have max_executions above 1")
+	}
+
+	if rule.ResetThreshold == nil {
+		return nil
+	}
+
+	// The reset threshold must be on the recovered side of the trigger
+	// threshold, or the rule would re-arm while still triggered
+	threshold, ok := rule.TriggerConfig["threshold"].(float64)
+	if !ok {
+		return errors.New("reset_threshold requires a threshold in trigger_config")
+	}
+	reset := *rule.ResetThreshold
+
+	above, ok := rules.FiresAbove(rule.TriggerType, threshold)
+	switch {
+	case !ok:
+		return fmt.Errorf("reset_threshold is not supported for trigger type %s", rule.TriggerType)
+	case above && reset >= threshold:
+		return fmt.Errorf("reset_threshold must be below the %s threshold %g", rule.TriggerType, threshold)
+	case !above && reset <= threshold:
+		return fmt.Errorf("reset_threshold must be above the %s threshold %g", rule.TriggerType, threshold)
+	}
+
+	return nil
+}
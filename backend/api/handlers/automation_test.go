@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/gin-gonic/gin"
+)
+
+// create stores a record and deletes it when the test finishes
+func create(t *testing.T, record interface{}) {
+	t.Helper()
+	if err := database.DB.Create(record).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.DB.Unscoped().Delete(record) })
+}
+
+func TestGetRuleExecutionsLinksTransactions(t *testing.T) {
+	testDB(t)
+	gin.SetMode(gin.TestMode)
+	user := testUser(t)
+	rule := models.AutomationRule{UserID: user.ID, Name: t.Name(), TriggerType: "gas_below", ActionType: "withdraw"}
+	create(t, &rule)
+
+	nonce := uint64(7)
+	job := models.ActionJob{
+		IdempotencyKey: fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano()),
+		RuleID:         rule.ID,
+		UserID:         user.ID,
+		ActionType:     "withdraw",
+		Status:         models.JobSucceeded,
+		RunAt:          time.Now(),
+		Steps: []models.ExecutionStep{
+			{Description: "approve", Chain: "ethereum", From: user.WalletAddress, Nonce: &nonce, RequestID: 41, Status: "requested"},
+			{Description: "withdraw", Chain: "ethereum", From: user.WalletAddress, RequestID: 42, Status: "requested"},
+		},
+	}
+	create(t, &job)
+	create(t, &models.RuleExecution{RuleID: rule.ID, UserID: user.ID, Source: "poll", Decision: models.DecisionFired, JobID: &job.ID})
+
+	hash := func(n int) string { return fmt.Sprintf("0x%064x", time.Now().UnixNano()+int64(n)) }
+	transaction := func(txHash, chain string, requestID *int64, nonce *uint64) models.Transaction {
+		tx := models.Transaction{UserID: user.ID, TxHash: txHash, Chain: chain, FromAddress: user.WalletAddress,
+			Status: models.TxStatusPending, Nonce: nonce, RequestID: requestID}
+		create(t, &tx)
+		return tx
+	}
+	first, second := int64(41), hash(2)
+
+	// Recorded with the request it answered
+	approve := transaction(hash(1), "ethereum", &first, &nonce)
+	// Recorded before the answer, so linked by the hash on the request
+	withdraw := transaction(second, "ethereum", nil, nil)
+	create(t, &models.WalletRequest{Topic: "topic", RequestID: 42, UserID: user.ID, Chain: "ethereum", FromAddress: user.WalletAddress,
+		Status: models.RequestStatusSent, TxHash: second, ExpiresAt: time.Now().Add(5 * time.Minute)})
+	// Same nonce or request ID, but not the transactions the steps requested
+	transaction(hash(3), "ethereum", nil, &nonce)
+	transaction(hash(4), "base", &first, nil)
+
+	router := gin.New()
+	router.GET("/rules/:id/executions", func(c *gin.Context) {
+		c.Set("user_id", user.ID)
+		GetRuleExecutions(c)
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/rules/%d/executions", rule.ID), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var body struct {
+		Executions []ruleExecutionView `json:"executions"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Executions) != 1 {
+		t.Fatalf("%d executions, want 1", len(body.Executions))
+	}
+	exec := body.Executions[0]
+	if exec.Job == nil || exec.Job.ID != job.ID || len(exec.Actions) != 2 {
+		t.Fatalf("execution job = %+v, actions %+v", exec.Job, exec.Actions)
+	}
+	var linked []string
+	for _, tx := range exec.Transactions {
+		linked = append(linked, tx.TxHash)
+	}
+	if len(linked) != 2 || linked[0] != approve.TxHash || linked[1] != withdraw.TxHash {
+		t.Fatalf("linked transactions %v, want %s and %s", linked, approve.TxHash, withdraw.TxHash)
+	}
+}
+
+// serve runs a handler for the user on a request with a JSON body
+func serve(t *testing.T, userID uint, method, path, route string, handler gin.HandlerFunc, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Handle(method, route, func(c *gin.Context) {
+		c.Set("user_id", userID)
+		handler(c)
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+func TestCreateAutomationRuleRejectsConfigs(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"unknown trigger", `{"trigger_type": "moon_phase", "trigger_config": {}, "action_type": "withdraw",
+			"action_config": {"protocol": "aave", "asset": "USDC", "amount": 10}}`, "invalid trigger_config: unknown trigger type"},
+		{"missing threshold", `{"trigger_type": "apy_drop", "trigger_config": {"protocol": "aave", "asset": "USDC"}, "action_type": "withdraw",
+			"action_config": {"protocol": "aave", "asset": "USDC", "amount": 10}}`, "invalid trigger_config: threshold is required"},
+		{"unsupported chain", `{"trigger_type": "gas_below", "trigger_config": {"threshold": 20, "chain": "solana"}, "action_type": "withdraw",
+			"action_config": {"protocol": "aave", "asset": "USDC", "amount": 10}}`, "invalid trigger_config: chain must be one of"},
+		{"unknown protocol", `{"trigger_type": "gas_below", "trigger_config": {"threshold": 20}, "action_type": "withdraw",
+			"action_config": {"protocol": "maker", "asset": "USDC", "amount": 10}}`, "invalid action_config: protocol must be one of"},
+		{"unlisted asset", `{"trigger_type": "gas_below", "trigger_config": {"threshold": 20}, "action_type": "deposit",
+			"action_config": {"protocol": "aave", "asset": "USDT", "chain": "base", "amount": 10}}`, "invalid action_config: asset USDT not supported on base"},
+		{"rebalance without amount", `{"trigger_type": "gas_below", "trigger_config": {"threshold": 20}, "action_type": "rebalance",
+			"action_config": {"from_protocol": "aave", "to_protocol": "compound", "asset": "USDC"}}`, "invalid action_config: amount is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Configs are rejected before the rule is stored
+			w := serve(t, 1, http.MethodPost, "/rules", "/rules", CreateAutomationRule, tt.body)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+				t.Fatalf("status %d: %s, want 400 with %q", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateAutomationRuleValidatesConfigs(t *testing.T) {
+	testDB(t)
+	user := testUser(t)
+	rule := models.AutomationRule{UserID: user.ID, Name: t.Name(), Enabled: true, Armed: true,
+		TriggerType: "gas_below", TriggerConfig: map[string]interface{}{"threshold": 20.0},
+		ActionType: "withdraw", ActionConfig: map[string]interface{}{"protocol": "aave", "asset": "USDC", "amount": 10.0}}
+	create(t, &rule)
+	path := fmt.Sprintf("/rules/%d", rule.ID)
+
+	w := serve(t, user.ID, http.MethodPut, path, "/rules/:id", UpdateAutomationRule,
+		`{"trigger_type": "health_factor", "trigger_config": {"threshold": 0.9}}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "threshold must be above 1") {
+		t.Fatalf("status %d: %s, want the health factor threshold rejected", w.Code, w.Body)
+	}
+	var stored models.AutomationRule
+	if err := database.DB.First(&stored, rule.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.TriggerType != "gas_below" {
+		t.Fatalf("rejected update stored trigger %s", stored.TriggerType)
+	}
+
+	w = serve(t, user.ID, http.MethodPut, path, "/rules/:id", UpdateAutomationRule,
+		`{"trigger_type": "health_factor", "trigger_config": {"threshold": 1.4, "protocol": "aave"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if err := database.DB.First(&stored, rule.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.TriggerType != "health_factor" || stored.TriggerConfig["threshold"] != 1.4 {
+		t.Fatalf("stored trigger %s %v", stored.TriggerType, stored.TriggerConfig)
+	}
+}
+
+func TestGetAutomationSchemas(t *testing.T) {
+	w := serve(t, 1, http.MethodGet, "/schemas", "/schemas", GetAutomationSchemas, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var body struct {
+		Triggers map[string]struct {
+			Title      string                 `json:"title"`
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"triggers"`
+		Actions map[string]struct {
+			Required []string `json:"required"`
+		} `json:"actions"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	for _, trigger := range []string{"apy_drop", "health_factor", "risk_threshold", "price_change", "schedule", "composite"} {
+		if body.Triggers[trigger].Title != trigger {
+			t.Errorf("no schema for trigger %s", trigger)
+		}
+	}
+	if _, ok := body.Triggers["apy_drop"].Properties["threshold"]; !ok {
+		t.Errorf("apy_drop schema properties = %v", body.Triggers["apy_drop"].Properties)
+	}
+	for _, action := range []string{"rebalance", "withdraw", "deposit", "deleverage"} {
+		if len(body.Actions[action].Required) == 0 {
+			t.Errorf("action %s schema has no required fields", action)
+		}
+	}
+}
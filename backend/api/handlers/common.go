@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"os"
 
+	"github.com/defioptimization/shared/clients"
 	"github.com/gin-gonic/gin"
 )
 
@@ -14,29 +17,45 @@ func HealthCheck(c *gin.Context) {
 	})
 }
 
-// GetProtocols returns available DeFi protocols
+// defiClient reads protocol data from the DeFi service
+var defiClient *clients.DeFi
+
+// InitServiceClients creates the clients of the internal services
+func InitServiceClients() {
+	defiClient = clients.NewDeFi(defiServiceURL())
+}
+
+// defiServiceURL returns the DeFi service's base URL
+func defiServiceURL() string {
+	if url := os.Getenv("DEFI_SERVICE_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:8081"
+}
+
+// GetProtocols returns the protocols the DeFi service has registered, with
+// the chains they are on and their capabilities
 func GetProtocols(c *gin.Context) {
-	protocols := []gin.H{
-		{
-			"name":        "Aave",
-			"description": "Decentralized lending and borrowing",
-			"chains":      []string{"ethereum", "base"},
-			"supported":   true,
-		},
-		{
-			"name":        "Compound",
-			"description": "Algorithmic money markets",
-			"chains":      []string{"ethereum", "base"},
-			"supported":   true,
-		},
-		{
-			"name":        "EigenLayer",
-			"description": "Restaking protocol",
-			"chains":      []string{"ethereum"},
-			"supported":   true,
-		},
+	protocols, err := defiClient.Protocols(c.Request.Context())
+	if err != nil {
+		respondServiceError(c, "DeFi", err)
+		return
 	}
 
 	c.JSON(http.StatusOK, protocols)
 }
 
+// respondServiceError relays an internal service's error response, or
+// reports the service unavailable when it could not be reached
+func respondServiceError(c *gin.Context, service string, err error) {
+	var apiErr *clients.Error
+	if !errors.As(err, &apiErr) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": service + " service unavailable"})
+		return
+	}
+	message := apiErr.Message
+	if message == "" {
+		message = http.StatusText(apiErr.StatusCode)
+	}
+	c.JSON(apiErr.StatusCode, gin.H{"error": message})
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"gorm.io/gorm/logger"
+)
+
+// testDB connects database.DB to the Postgres database named by
+// TEST_DATABASE_URL and migrates it, skipping the test when it isn't set.
+// Tests create their own users and records and delete them when they finish.
+func testDB(t *testing.T) {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	t.Setenv("DATABASE_URL", url)
+	if err := database.InitDatabase(); err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	database.DB.Logger = logger.Default.LogMode(logger.Silent)
+}
+
+// testUser creates a user with a fresh wallet address
+func testUser(t *testing.T) models.User {
+	t.Helper()
+	user := models.User{WalletAddress: fmt.Sprintf("0x%040x", time.Now().UnixNano())}
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.DB.Unscoped().Delete(&user) })
+	return user
+}
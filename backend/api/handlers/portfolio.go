@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/defioptimization/shared/clients"
 	"github.com/defioptimization/shared/database"
 	"github.com/defioptimization/shared/models"
 	"github.com/gin-gonic/gin"
@@ -93,3 +94,72 @@ func DeletePortfolio(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Portfolio deleted"})
 }
 
+// OptimizePortfolio proposes the allocation of a portfolio's supplied and
+// staked positions across protocols and chains that earns the most net
+// yield within its risk limits, and the transactions that reach it. Nothing
+// is sent; the caller signs the returned calls.
+func OptimizePortfolio(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var portfolio models.Portfolio
+	if err := database.DB.Where("id = ? AND user_id = ?", id, userID).Preload("Positions").First(&portfolio).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Portfolio not found"})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req struct {
+		Risk        string                 `json:"risk"`
+		Limits      clients.OptimizeLimits `json:"limits"`
+		Protocols   []string               `json:"protocols"`
+		Chains      []string               `json:"chains"`
+		HorizonDays float64                `json:"horizon_days"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Risk == "" {
+		req.Risk = portfolio.RiskTolerance
+	}
+
+	// Borrowed positions are debt, not funds to allocate
+	holdings := make([]clients.Holding, 0, len(portfolio.Positions))
+	for _, p := range portfolio.Positions {
+		if p.PositionType == "borrowing" {
+			continue
+		}
+		holdings = append(holdings, clients.Holding{
+			Protocol: p.Protocol,
+			Chain:    p.Chain,
+			Asset:    p.Asset,
+			Amount:   p.Amount,
+		})
+	}
+	if len(holdings) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Portfolio has no positions to optimize"})
+		return
+	}
+
+	plan, err := defiClient.Optimize(c.Request.Context(), clients.OptimizeRequest{
+		UserAddress: user.WalletAddress,
+		Holdings:    holdings,
+		Risk:        req.Risk,
+		Limits:      req.Limits,
+		Protocols:   req.Protocols,
+		Chains:      req.Chains,
+		HorizonDays: req.HorizonDays,
+	})
+	if err != nil {
+		respondServiceError(c, "DeFi", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"plan": plan})
+}
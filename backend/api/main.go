@@ -22,6 +22,9 @@ func main() {
 	// Initialize Stripe
 	handlers.InitStripe()
 
+	// Initialize internal service clients
+	handlers.InitServiceClients()
+
 	// Initialize WebSocket hub
 	hub := websocket.NewHub()
 	go hub.Run()
@@ -64,12 +67,20 @@ func main() {
 		protected.GET("/portfolios/:id", handlers.GetPortfolio)
 		protected.PUT("/portfolios/:id", handlers.UpdatePortfolio)
 		protected.DELETE("/portfolios/:id", handlers.DeletePortfolio)
+		protected.POST("/portfolios/:id/optimize", handlers.OptimizePortfolio)
 
 		// Automation routes
 		protected.GET("/automation/rules", handlers.GetAutomationRules)
 		protected.POST("/automation/rules", handlers.CreateAutomationRule)
 		protected.PUT("/automation/rules/:id", handlers.UpdateAutomationRule)
 		protected.DELETE("/automation/rules/:id", handlers.DeleteAutomationRule)
+		protected.GET("/automation/rules/:id/executions", handlers.GetRuleExecutions)
+		protected.POST("/automation/rules/:id/dry-run", handlers.DryRunAutomationRule)
+		protected.POST("/automation/rules/backtest", handlers.BacktestAutomationRule)
+		protected.GET("/automation/schemas", handlers.GetAutomationSchemas)
+		protected.GET("/automation/templates", handlers.GetAutomationTemplates)
+		protected.POST("/automation/templates/:name", handlers.CreateRuleFromTemplate)
+		protected.GET("/automation/transfers", handlers.GetBridgeTransfers)
 
 		// Transaction routes
 		protected.GET("/transactions", handlers.GetTransactions)
@@ -85,6 +96,13 @@ func main() {
 		protected.GET("/ws", websocket.HandleWebSocket(hub))
 	}
 
+	// Internal routes (service-to-service, shared token)
+	internal := r.Group("/api/v1/internal")
+	internal.Use(middleware.InternalAuthMiddleware())
+	{
+		internal.POST("/events", websocket.HandleInternalEvent(hub))
+	}
+
 	// Stripe webhook (no auth required, uses signature verification)
 	r.POST("/api/v1/webhooks/stripe", handlers.HandleStripeWebhook)
 
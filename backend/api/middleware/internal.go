@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InternalAuthMiddleware authenticates service-to-service requests using a shared token
+func InternalAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expected := os.Getenv("INTERNAL_API_TOKEN")
+		provided := c.GetHeader("X-Internal-Token")
+
+		if expected == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid internal token"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
 
@@ -45,6 +46,28 @@ func HandleWebSocket(hub *Hub) gin.HandlerFunc {
 	}
 }
 
+// InternalEvent is an event pushed by another backend service for delivery to a user
+type InternalEvent struct {
+	UserID  uint            `json:"user_id" binding:"required"`
+	Type    string          `json:"type" binding:"required"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// HandleInternalEvent relays events from backend services to the owning user's connections
+func HandleInternalEvent(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var event InternalEvent
+		if err := c.ShouldBindJSON(&event); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		hub.SendToUser(event.UserID, NewMessage(event.Type, event.Payload))
+
+		c.JSON(http.StatusOK, gin.H{"delivered": true})
+	}
+}
+
 // readPump pumps messages from the WebSocket connection to the hub
 func (c *Client) readPump() {
 	defer func() {
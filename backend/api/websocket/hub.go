@@ -35,7 +35,7 @@ func (h *Hub) Run() {
 			h.mu.Lock()
 			h.clients[client] = true
 			h.mu.Unlock()
-			log.Printf("Client connected: %s", client.userID)
+			log.Printf("Client connected: %d", client.userID)
 
 		case client := <-h.unregister:
 			h.mu.Lock()
@@ -44,7 +44,7 @@ func (h *Hub) Run() {
 				close(client.send)
 			}
 			h.mu.Unlock()
-			log.Printf("Client disconnected: %s", client.userID)
+			log.Printf("Client disconnected: %d", client.userID)
 
 		case message := <-h.broadcast:
 			h.mu.RLock()
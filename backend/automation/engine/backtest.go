@@ -0,0 +1,309 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/shared/rules"
+)
+
+const (
+	// maxBacktestSteps bounds the evaluations of one backtest
+	maxBacktestSteps = 10000
+	// maxSampleAge is how old the latest sample of a series may be at an
+	// evaluation time before the data counts as missing
+	maxSampleAge = time.Hour
+	// maxLookback is how far before the backtest range samples are loaded,
+	// covering the longest price_change window
+	maxLookback  = 168*time.Hour + maxSampleAge
+	hoursPerYear = 365 * 24
+)
+
+// estimatedGas approximates the gas used by each kind of transaction, for
+// the gas cost of backtested actions
+var estimatedGas = map[string]uint64{
+	"approve":  50000,
+	"deposit":  250000,
+	"withdraw": 250000,
+	"swap":     180000,
+	"bridge":   300000, // bridging, and claiming on the destination
+}
+
+// errNoHistory is returned by the history source when no sample covers the
+// requested time
+var errNoHistory = errors.New("no stored history")
+
+// BacktestRange is the period a backtest replays and its evaluation step
+type BacktestRange struct {
+	From time.Time
+	To   time.Time
+	Step time.Duration
+}
+
+// BacktestResult reports when a rule would have fired over a period
+type BacktestResult struct {
+	From        time.Time        `json:"from"`
+	To          time.Time        `json:"to"`
+	Step        string           `json:"step"`
+	Evaluations int              `json:"evaluations"`
+	MissingData int              `json:"missing_data"` // evaluations without stored samples
+	Errors      int              `json:"errors"`       // evaluations whose trigger failed otherwise
+	LastError   string           `json:"last_error,omitempty"`
+	Firings     []BacktestFiring `json:"firings"`
+
+	TotalGasCostUSD        float64 `json:"total_gas_cost_usd"`
+	TotalEstimatedYieldUSD float64 `json:"total_estimated_yield_usd"`
+	NetUSD                 float64 `json:"net_usd"`
+}
+
+// BacktestFiring is one time the rule would have fired
+type BacktestFiring struct {
+	At      time.Time              `json:"at"`
+	Value   float64                `json:"value"`
+	Inputs  map[string]interface{} `json:"inputs"`
+	Outcome BacktestOutcome        `json:"outcome"`
+}
+
+// BacktestOutcome estimates the effect of an action from history. Yield is
+// the change in interest earned on the amount until the next firing or the
+// end of the range; a withdrawal's yield is the interest it gave up.
+type BacktestOutcome struct {
+	Action            string   `json:"action"`
+	Asset             string   `json:"asset"`
+	Chain             string   `json:"chain"`
+	Amount            float64  `json:"amount"`
+	APYChange         float64  `json:"apy_change"` // percentage points earned on the amount
+	HeldHours         float64  `json:"held_hours"`
+	EstimatedYield    float64  `json:"estimated_yield"` // in asset units
+	EstimatedYieldUSD float64  `json:"estimated_yield_usd"`
+	GasUnits          uint64   `json:"gas_units"`
+	GasPriceGwei      float64  `json:"gas_price_gwei"`
+	GasCostETH        float64  `json:"gas_cost_eth"`
+	GasCostUSD        float64  `json:"gas_cost_usd"`
+	Warnings          []string `json:"warnings,omitempty"`
+
+	assetPrice float64
+}
+
+// Backtest replays a rule over stored samples, evaluating it every step with
+// the live trigger checks and execution limits. Actions are not simulated;
+// their outcome and gas cost are estimated from the same history.
+func (e *Engine) Backtest(ctx context.Context, rule models.AutomationRule, r BacktestRange) (*BacktestResult, error) {
+	if r.Step < sampleInterval {
+		return nil, fmt.Errorf("step must be at least %s", sampleInterval)
+	}
+	if !r.To.After(r.From) {
+		return nil, errors.New("to must be after from")
+	}
+	if r.To.Sub(r.From)/r.Step > maxBacktestSteps {
+		return nil, fmt.Errorf("range needs more than %d steps, use a larger step", maxBacktestSteps)
+	}
+
+	hist := &historySource{from: r.From.Add(-maxLookback), to: r.To, series: make(map[string][]models.MetricSample)}
+	sandbox := e.sandboxed(modeBacktest, hist)
+
+	// Replay from a fresh rule
+	rule.CreatedAt = r.From
+	rule.LastExecutedAt = nil
+	rule.ExecutionCount, rule.ExecutionsToday, rule.ExecutionDay = 0, 0, ""
+	rule.Armed, rule.Enabled = true, true
+
+	result := &BacktestResult{From: r.From, To: r.To, Step: r.Step.String(), Firings: []BacktestFiring{}}
+	for t := r.From; !t.After(r.To) && rule.Enabled; t = t.Add(r.Step) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hist.now = t
+		stepCtx, a := withAudit(ctx)
+
+		var exec models.RuleExecution
+		err := sandbox.runRule(stepCtx, &rule, t, &exec)
+		result.Evaluations++
+		switch {
+		case errors.Is(err, errNoHistory):
+			result.MissingData++
+			continue
+		case err != nil:
+			result.Errors++
+			result.LastError = err.Error()
+			continue
+		case exec.Decision != models.DecisionFired:
+			continue
+		}
+
+		a.fill(&exec)
+		result.Firings = append(result.Firings, BacktestFiring{
+			At:      t,
+			Value:   exec.Value,
+			Inputs:  exec.Inputs,
+			Outcome: sandbox.estimateOutcome(ctx, rule),
+		})
+	}
+
+	// An action's yield accrues until the rule fires again
+	for i := range result.Firings {
+		f := &result.Firings[i]
+		until := r.To
+		if i+1 < len(result.Firings) {
+			until = result.Firings[i+1].At
+		}
+		o := &f.Outcome
+		o.HeldHours = until.Sub(f.At).Hours()
+		o.EstimatedYield = o.Amount * o.APYChange / 100 * o.HeldHours / hoursPerYear
+		o.EstimatedYieldUSD = o.EstimatedYield * o.assetPrice
+
+		result.TotalGasCostUSD += o.GasCostUSD
+		result.TotalEstimatedYieldUSD += o.EstimatedYieldUSD
+	}
+	result.NetUSD = result.TotalEstimatedYieldUSD - result.TotalGasCostUSD
+
+	return result, nil
+}
+
+// estimateOutcome estimates an action's APY change and gas cost at the
+// history source's current time
+func (e *Engine) estimateOutcome(ctx context.Context, rule models.AutomationRule) BacktestOutcome {
+	o := BacktestOutcome{Action: rule.ActionType}
+	warn := func(format string, args ...interface{}) {
+		o.Warnings = append(o.Warnings, fmt.Sprintf(format, args...))
+	}
+	apy := func(protocol, asset, chain string) float64 {
+		v, err := e.source.APY(ctx, protocol, asset, chain)
+		if err != nil {
+			warn("%s %s APY on %s unknown: %v", protocol, asset, chain, err)
+		}
+		return v
+	}
+
+	switch rule.ActionType {
+	case "rebalance":
+		var config rules.RebalanceAction
+		if err := rules.Decode(rule.ActionConfig, &config); err != nil {
+			warn("%v", err)
+			return o
+		}
+		o.Asset, o.Chain, o.Amount = config.Asset, config.Chain, config.Amount
+		// A swap keeps roughly the same value, so the yield is still measured
+		// in the source asset
+		o.APYChange = apy(config.ToProtocol, config.ToAsset, config.ToChain) - apy(config.FromProtocol, config.Asset, config.Chain)
+		o.GasUnits = estimatedGas["withdraw"] + estimatedGas["approve"] + estimatedGas["deposit"]
+		if config.Swaps() {
+			o.GasUnits += estimatedGas["approve"] + estimatedGas["swap"]
+		}
+		if config.Bridges() {
+			o.GasUnits += estimatedGas["approve"] + estimatedGas["bridge"]
+			warn("bridge fees and transit time are not estimated; gas is priced on %s", config.Chain)
+		}
+	case "deposit":
+		var config rules.DepositAction
+		if err := rules.Decode(rule.ActionConfig, &config); err != nil {
+			warn("%v", err)
+			return o
+		}
+		o.Asset, o.Chain, o.Amount = config.Asset, config.Chain, config.Amount
+		o.APYChange = apy(config.Protocol, config.Asset, config.Chain)
+		o.GasUnits = estimatedGas["approve"] + estimatedGas["deposit"]
+	case "withdraw":
+		var config rules.WithdrawAction
+		if err := rules.Decode(rule.ActionConfig, &config); err != nil {
+			warn("%v", err)
+			return o
+		}
+		o.Asset, o.Chain, o.Amount = config.Asset, config.Chain, config.Amount
+		o.APYChange = -apy(config.Protocol, config.Asset, config.Chain)
+		o.GasUnits = estimatedGas["withdraw"]
+	default:
+		warn("outcome of %s actions is not estimated", rule.ActionType)
+		return o
+	}
+
+	price, err := e.source.Price(ctx, o.Asset, o.Chain, 0)
+	if err != nil {
+		warn("%s price unknown: %v", o.Asset, err)
+	}
+	o.assetPrice = price
+
+	gasPrice, err := e.source.GasPrice(ctx, o.Chain)
+	if err != nil {
+		warn("gas price unknown: %v", err)
+		return o
+	}
+	o.GasPriceGwei = gasPrice
+	o.GasCostETH = float64(o.GasUnits) * gasPrice / 1e9
+
+	ethPrice, err := e.source.Price(ctx, "WETH", o.Chain, 0)
+	if err != nil {
+		warn("ETH price unknown: %v", err)
+		return o
+	}
+	o.GasCostUSD = o.GasCostETH * ethPrice
+	return o
+}
+
+// historySource replays stored samples. Each series is loaded the first time
+// it's read; reads return the latest sample at or before now.
+type historySource struct {
+	from   time.Time
+	to     time.Time
+	now    time.Time
+	series map[string][]models.MetricSample
+}
+
+func (h *historySource) Now() time.Time {
+	return h.now
+}
+
+func (h *historySource) APY(ctx context.Context, protocol, asset, chain string) (float64, error) {
+	return h.value(ctx, models.MetricSample{Metric: metricAPY, Chain: chain, Protocol: protocol, Asset: asset}, h.now)
+}
+
+func (h *historySource) Price(ctx context.Context, asset, chain string, ago time.Duration) (float64, error) {
+	return h.value(ctx, models.MetricSample{Metric: metricPrice, Chain: chain, Asset: asset}, h.now.Add(-ago))
+}
+
+func (h *historySource) Utilization(ctx context.Context, protocol, asset, chain string) (float64, error) {
+	return h.value(ctx, models.MetricSample{Metric: metricUtilization, Chain: chain, Protocol: protocol, Asset: asset}, h.now)
+}
+
+func (h *historySource) GasPrice(ctx context.Context, chain string) (float64, error) {
+	return h.value(ctx, models.MetricSample{Metric: metricGasPrice, Chain: chain}, h.now)
+}
+
+func (h *historySource) HealthFactor(ctx context.Context, protocol, chain, account string) (float64, error) {
+	return h.value(ctx, models.MetricSample{Metric: metricHealthFactor, Chain: chain, Protocol: protocol, Account: strings.ToLower(account)}, h.now)
+}
+
+func (h *historySource) LiquidationRisk(ctx context.Context, userID uint, chain, account string) (float64, error) {
+	return h.value(ctx, models.MetricSample{Metric: metricLiquidationRisk, Chain: chain, Account: strings.ToLower(account)}, h.now)
+}
+
+// value returns the series' latest sample at or before at. The series is
+// identified by the metric, chain, protocol, asset and account of series.
+func (h *historySource) value(ctx context.Context, series models.MetricSample, at time.Time) (float64, error) {
+	key := seriesKey(&series)
+	samples, ok := h.series[key]
+	if !ok {
+		err := database.DB.WithContext(ctx).
+			Where("metric = ? AND chain = ? AND protocol = ? AND asset = ? AND account = ?",
+				series.Metric, series.Chain, series.Protocol, series.Asset, series.Account).
+			Where("sampled_at BETWEEN ? AND ?", h.from, h.to).
+			Order("sampled_at").
+			Find(&samples).Error
+		if err != nil {
+			return 0, err
+		}
+		h.series[key] = samples
+	}
+
+	i := sort.Search(len(samples), func(i int) bool { return samples[i].SampledAt.After(at) }) - 1
+	if i < 0 || at.Sub(samples[i].SampledAt) > maxSampleAge {
+		return 0, fmt.Errorf("%w: %s %s at %s", errNoHistory, series.Metric, strings.Join(strings.Fields(series.Chain+" "+series.Protocol+" "+series.Asset), " "), at.Format(time.RFC3339))
+	}
+	return samples[i].Value, nil
+}
@@ -0,0 +1,226 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+)
+
+func TestBacktestRejectsRange(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		r    BacktestRange
+		want string
+	}{
+		{"step too small", BacktestRange{From: from, To: from.Add(time.Hour), Step: time.Minute}, "step must be at least 5m0s"},
+		{"empty range", BacktestRange{From: from, To: from, Step: time.Hour}, "to must be after from"},
+		{"too many steps", BacktestRange{From: from, To: from.Add(10001 * 5 * time.Minute), Step: 5 * time.Minute}, "more than 10000 steps"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := (&Engine{}).Backtest(context.Background(), models.AutomationRule{}, tt.r)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistorySourceValue(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	gas := models.MetricSample{Metric: metricGasPrice, Chain: "ethereum"}
+	h := &historySource{series: map[string][]models.MetricSample{
+		seriesKey(&gas): {
+			{SampledAt: start, Value: 30},
+			{SampledAt: start.Add(10 * time.Minute), Value: 12},
+			{SampledAt: start.Add(3 * time.Hour), Value: 40},
+		},
+	}}
+
+	tests := []struct {
+		at      time.Duration
+		want    float64
+		missing bool
+	}{
+		{-time.Minute, 0, true}, // before the first sample
+		{0, 30, false},
+		{9 * time.Minute, 30, false},
+		{10 * time.Minute, 12, false},
+		{70 * time.Minute, 12, false},
+		{71 * time.Minute, 0, true}, // latest sample over an hour old
+		{4 * time.Hour, 40, false},
+	}
+	for _, tt := range tests {
+		h.now = start.Add(tt.at)
+		got, err := h.GasPrice(context.Background(), "ethereum")
+		if tt.missing {
+			if !errors.Is(err, errNoHistory) {
+				t.Errorf("at +%s: %g, %v, want no history", tt.at, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("at +%s: %g, %v, want %g", tt.at, got, err, tt.want)
+		}
+	}
+}
+
+func TestEstimateOutcome(t *testing.T) {
+	src := &fakeSource{
+		apy:    map[string]float64{"aave|USDC|ethereum": 3, "compound|USDC|ethereum": 5},
+		prices: map[string]float64{"USDC|ethereum|0s": 1, "WETH|ethereum|0s": 2000},
+		gas:    map[string]float64{"ethereum": 20},
+	}
+	e := (&Engine{}).sandboxed(modeBacktest, src)
+
+	tests := []struct {
+		name      string
+		action    string
+		config    map[string]interface{}
+		apyChange float64
+		gasUnits  uint64
+		warnings  int
+	}{
+		{"rebalance", "rebalance", map[string]interface{}{"from_protocol": "aave", "to_protocol": "compound", "asset": "USDC", "amount": 1000.0}, 2, 550000, 0},
+		{"deposit", "deposit", map[string]interface{}{"protocol": "compound", "asset": "USDC", "amount": 1000.0}, 5, 300000, 0},
+		{"withdraw", "withdraw", map[string]interface{}{"protocol": "aave", "asset": "USDC", "amount": 1000.0}, -3, 250000, 0},
+		{"unknown market", "withdraw", map[string]interface{}{"protocol": "aave", "asset": "DAI", "amount": 1000.0}, 0, 250000, 2},
+		{"deleverage", "deleverage", map[string]interface{}{"debt_asset": "USDC", "target_health_factor": 1.8}, 0, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := e.estimateOutcome(context.Background(), models.AutomationRule{ActionType: tt.action, ActionConfig: tt.config})
+			if o.APYChange != tt.apyChange || o.GasUnits != tt.gasUnits || len(o.Warnings) != tt.warnings {
+				t.Fatalf("outcome = %+v", o)
+			}
+			// Gas is priced at 20 gwei and $2000 per ETH
+			if want := float64(tt.gasUnits) * 20e-9 * 2000; math.Abs(o.GasCostUSD-want) > 1e-9 {
+				t.Errorf("gas cost = $%f, want $%f", o.GasCostUSD, want)
+			}
+		})
+	}
+}
+
+// sample stores a metric sample and deletes it when the test finishes
+func sample(t *testing.T, s models.MetricSample) {
+	t.Helper()
+	if err := database.DB.Create(&s).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.DB.Delete(&s) })
+}
+
+func TestBacktest(t *testing.T) {
+	testDB(t)
+
+	// Gas is cheap for the first two hours of a four hour range in the past,
+	// and no gas was sampled in its last hour
+	from := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(time.Now().UnixNano()%1000) * 24 * time.Hour)
+	for i := 0; i < 36; i++ {
+		at := from.Add(time.Duration(i) * 5 * time.Minute)
+		gas := 12.0
+		if i >= 24 {
+			gas = 40
+		}
+		sample(t, models.MetricSample{Metric: metricGasPrice, Chain: "ethereum", Value: gas, SampledAt: at})
+		sample(t, models.MetricSample{Metric: metricAPY, Chain: "ethereum", Protocol: "aave", Asset: "USDC", Value: 4, SampledAt: at})
+		sample(t, models.MetricSample{Metric: metricPrice, Chain: "ethereum", Asset: "USDC", Value: 1, SampledAt: at})
+		sample(t, models.MetricSample{Metric: metricPrice, Chain: "ethereum", Asset: "WETH", Value: 2000, SampledAt: at})
+	}
+
+	rule := models.AutomationRule{
+		ID:              1,
+		TriggerType:     "gas_below",
+		TriggerConfig:   map[string]interface{}{"threshold": 20.0},
+		ActionType:      "withdraw",
+		ActionConfig:    map[string]interface{}{"protocol": "aave", "asset": "USDC", "amount": 8760.0},
+		CooldownSeconds: 3600,
+		// Already executed live, which the replay starts over from
+		ExecutionCount: 9,
+		MaxExecutions:  10,
+	}
+	result, err := (&Engine{}).Backtest(context.Background(), rule, BacktestRange{From: from, To: from.Add(4 * time.Hour), Step: 30 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Evaluated every 30 minutes: fired at 0h and 1h, in cooldown at 0:30
+	// and 1:30, not triggered from 2h and out of data from 4h
+	if result.Evaluations != 9 || result.MissingData != 1 || result.Errors != 0 {
+		t.Fatalf("result = %+v", result)
+	}
+	if len(result.Firings) != 2 || !result.Firings[0].At.Equal(from) || !result.Firings[1].At.Equal(from.Add(time.Hour)) {
+		t.Fatalf("firings = %+v", result.Firings)
+	}
+	first := result.Firings[0]
+	if first.Value != 12 || first.Inputs["gas_price_gwei"] != 12.0 {
+		t.Errorf("first firing value %g, inputs %v", first.Value, first.Inputs)
+	}
+
+	// Withdrawing 8760 USDC from 4% gives up 0.04 USDC an hour: one hour
+	// until the second firing, then three until the end of the range
+	if o := result.Firings[0].Outcome; o.HeldHours != 1 || math.Abs(o.EstimatedYieldUSD+0.04) > 1e-9 {
+		t.Errorf("first outcome = %+v", o)
+	}
+	if o := result.Firings[1].Outcome; o.HeldHours != 3 || math.Abs(o.EstimatedYieldUSD+0.12) > 1e-9 {
+		t.Errorf("second outcome = %+v", o)
+	}
+	// 250000 gas at 12 gwei and $2000 per ETH is $6 a withdrawal
+	if math.Abs(result.TotalGasCostUSD-12) > 1e-9 || math.Abs(result.NetUSD+12.16) > 1e-9 {
+		t.Errorf("gas $%f, net $%f", result.TotalGasCostUSD, result.NetUSD)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	src := &fakeSource{now: time.Now(), gas: map[string]float64{"ethereum": 25}}
+	e := &Engine{source: src}
+	lastRun := time.Now().Add(-time.Minute)
+	rule := models.AutomationRule{
+		TriggerType:   "gas_below",
+		TriggerConfig: map[string]interface{}{"threshold": 20.0},
+		ActionType:    "withdraw",
+		ActionConfig:  map[string]interface{}{"protocol": "aave", "asset": "USDC", "amount": 10.0},
+		Armed:         true,
+		Enabled:       true,
+	}
+
+	exec, err := e.DryRun(context.Background(), rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exec.Source != modeDryRun || exec.Decision != models.DecisionNotTriggered || exec.Triggered || exec.Value != 25 {
+		t.Fatalf("execution = %+v", exec)
+	}
+	if exec.Inputs["gas_price_gwei"] != 25.0 {
+		t.Errorf("inputs = %v", exec.Inputs)
+	}
+
+	// Dry runs honour the rule's execution limits
+	rule.CooldownSeconds, rule.LastExecutedAt = 3600, &lastRun
+	src.gas["ethereum"] = 12
+	exec, err = e.DryRun(context.Background(), rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exec.Decision != models.DecisionSkipped || exec.Reason != "cooldown" {
+		t.Fatalf("execution in cooldown = %+v", exec)
+	}
+
+	// Trigger errors are reported on the execution rather than returned
+	delete(src.gas, "ethereum")
+	rule.LastExecutedAt = nil
+	exec, err = e.DryRun(context.Background(), rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exec.Decision != models.DecisionError || !strings.Contains(exec.Error, "no data") {
+		t.Fatalf("execution without gas data = %+v", exec)
+	}
+}
@@ -0,0 +1,454 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/defioptimization/shared/clients"
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/shared/rules"
+	"github.com/defioptimization/shared/tokens"
+	"gorm.io/gorm"
+)
+
+// transferLockClass namespaces the advisory locks held while advancing
+// bridge transfers
+const transferLockClass = 0x42524447 // "BRDG"
+
+const (
+	// defaultHorizonDays is how long moved funds are assumed to stay when
+	// weighing a cross-chain rebalance
+	defaultHorizonDays = 30
+	// transferSendTimeout is how long the user has to send the bridge
+	// transaction before the transfer is given up
+	transferSendTimeout = 2 * time.Hour
+	// transferArrivalGrace is how long past its expected arrival a transfer
+	// is followed before it is given up
+	transferArrivalGrace = 24 * time.Hour
+)
+
+// bridgeOption is a bridge quote weighed against leaving the funds in place
+type bridgeOption struct {
+	quote   clients.BridgeQuote
+	feeUSD  float64
+	gasUSD  float64
+	gainUSD float64 // extra yield over the horizon, net of transit time
+	netUSD  float64
+}
+
+// executeBridgeRebalance withdraws on the source chain and bridges the funds
+// to the destination, where the transfer tracker deposits them once they
+// arrive. The rebalance only goes ahead if the extra yield over the horizon
+// outweighs the bridge fee, the gas on both chains and the yield lost in
+// transit.
+func (e *Engine) executeBridgeRebalance(ctx context.Context, rule models.AutomationRule, config rules.RebalanceAction, user models.User) error {
+	token, ok := tokens.Lookup(config.Chain, config.Asset)
+	if !ok {
+		return fmt.Errorf("asset %s not supported on %s", config.Asset, config.Chain)
+	}
+
+	option, err := e.chooseBridge(ctx, config)
+	if err != nil {
+		return err
+	}
+	observe(ctx, "bridge", option.quote.Bridge)
+	observe(ctx, "bridge_transit_hours", float64(option.quote.ExpectedSeconds)/3600)
+	observe(ctx, "bridge_fee_usd", option.feeUSD)
+	observe(ctx, "bridge_gas_usd", option.gasUSD)
+	observe(ctx, "bridge_yield_gain_usd", option.gainUSD)
+	observe(ctx, "bridge_net_usd", option.netUSD)
+	if option.netUSD <= 0 {
+		log.Printf("Rule %d: rebalancing %f %s to %s is not worth it: gain $%.2f, fees and gas $%.2f",
+			rule.ID, config.Amount, token.Symbol, config.ToChain, option.gainUSD, option.feeUSD+option.gasUSD)
+		return nil
+	}
+
+	withdraw, err := e.fetchProtocolCalls(ctx, config.FromProtocol, "withdraw", config.Asset, config.Amount, config.Chain, user.WalletAddress)
+	if err != nil {
+		return err
+	}
+	bridge, err := e.fetchBridgeCalls(ctx, option.quote.Bridge, config, user.WalletAddress)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Executing cross-chain rebalance for rule %d: %f %s from %s on %s to %s on %s via %s",
+		rule.ID, config.Amount, token.Symbol, config.FromProtocol, config.Chain, config.ToProtocol, config.ToChain, bridge.Bridge)
+
+	plan := actionPlan{Chain: config.Chain, MaxDeviation: config.MaxDeviation, AllowUntraced: config.AllowUntraced}
+	for _, call := range withdraw.Calls {
+		plan.Steps = append(plan.Steps, planStep{
+			Call:   call,
+			Expect: &expectation{Token: withdraw.Token, Amount: config.Amount},
+		})
+	}
+	for _, call := range bridge.Calls {
+		plan.Steps = append(plan.Steps, planStep{
+			Call:     call,
+			Expect:   &expectation{Token: bridge.Token, Amount: -config.Amount},
+			Balances: map[string]string{bridge.Token: bridge.Amount},
+		})
+	}
+
+	plan.Transfer = &models.BridgeTransfer{
+		RuleID:          rule.ID,
+		UserID:          user.ID,
+		Bridge:          bridge.Bridge,
+		Asset:           token.Symbol,
+		FromChain:       config.Chain,
+		ToChain:         config.ToChain,
+		Amount:          bridge.Amount,
+		AmountOut:       bridge.Quote.AmountOut,
+		Sender:          user.WalletAddress,
+		ToProtocol:      config.ToProtocol,
+		AllowUntraced:   config.AllowUntraced,
+		ExpectedArrival: time.Now().Add(time.Duration(bridge.Quote.ExpectedSeconds) * time.Second),
+		Status:          models.TransferSending,
+	}
+	return e.submitPlan(ctx, rule, user, plan)
+}
+
+// chooseBridge quotes the route and returns the configured bridge, or the
+// one that leaves the most after fees, gas and transit time
+func (e *Engine) chooseBridge(ctx context.Context, config rules.RebalanceAction) (*bridgeOption, error) {
+	quotes, err := e.fetchBridgeQuotes(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *bridgeOption
+	for _, q := range quotes {
+		if config.Bridge != "" && q.Bridge != config.Bridge {
+			continue
+		}
+		option, err := e.weighBridge(ctx, config, q)
+		if err != nil {
+			return nil, err
+		}
+		if best == nil || option.netUSD > best.netUSD {
+			best = option
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("bridge %s does not support %s from %s to %s", config.Bridge, config.Asset, config.Chain, config.ToChain)
+	}
+	return best, nil
+}
+
+// weighBridge compares the yield of moving the funds over the horizon with
+// leaving them: moved funds earn nothing in transit and the destination APY
+// after, and the move costs the bridge fee and gas on both chains
+func (e *Engine) weighBridge(ctx context.Context, config rules.RebalanceAction, q clients.BridgeQuote) (*bridgeOption, error) {
+	token, _ := tokens.Lookup(config.Chain, config.Asset)
+	price, err := e.source.Price(ctx, config.Asset, config.Chain, 0)
+	if err != nil {
+		return nil, err
+	}
+	apyFrom, err := e.source.APY(ctx, config.FromProtocol, config.Asset, config.Chain)
+	if err != nil {
+		return nil, err
+	}
+	apyTo, err := e.source.APY(ctx, config.ToProtocol, config.Asset, config.ToChain)
+	if err != nil {
+		return nil, err
+	}
+
+	fee, ok := new(big.Int).SetString(q.Fee, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid bridge fee: %s", q.Fee)
+	}
+	sourceGas, err := e.gasCostUSD(ctx, config.Chain, estimatedGas["withdraw"]+estimatedGas["approve"]+q.SourceGas)
+	if err != nil {
+		return nil, err
+	}
+	destinationGas, err := e.gasCostUSD(ctx, config.ToChain, q.DestinationGas+estimatedGas["approve"]+estimatedGas["deposit"])
+	if err != nil {
+		return nil, err
+	}
+
+	horizon := config.HorizonDays * 24
+	if horizon == 0 {
+		horizon = defaultHorizonDays * 24
+	}
+	transit := float64(q.ExpectedSeconds) / 3600
+	value := config.Amount * price
+
+	o := &bridgeOption{
+		quote:   q,
+		feeUSD:  tokens.FromBaseUnits(fee, token.Decimals) * price,
+		gasUSD:  sourceGas + destinationGas,
+		gainUSD: value * (apyTo*(horizon-transit) - apyFrom*horizon) / 100 / hoursPerYear,
+	}
+	o.netUSD = o.gainUSD - o.feeUSD - o.gasUSD
+	return o, nil
+}
+
+// gasCostUSD prices gas units on a chain
+func (e *Engine) gasCostUSD(ctx context.Context, chain string, units uint64) (float64, error) {
+	gasPrice, err := e.source.GasPrice(ctx, chain)
+	if err != nil {
+		return 0, err
+	}
+	ethPrice, err := e.source.Price(ctx, "WETH", chain, 0)
+	if err != nil {
+		return 0, err
+	}
+	return float64(units) * gasPrice / 1e9 * ethPrice, nil
+}
+
+// fetchBridgeQuotes asks the DeFi service to quote the route on every bridge
+func (e *Engine) fetchBridgeQuotes(ctx context.Context, config rules.RebalanceAction) ([]clients.BridgeQuote, error) {
+	quotes, err := e.defi.BridgeQuotes(ctx, clients.BridgeRequest{
+		Asset:     config.Asset,
+		Amount:    config.Amount,
+		FromChain: config.Chain,
+		ToChain:   config.ToChain,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to quote bridges: %w", err)
+	}
+	return quotes, nil
+}
+
+// fetchBridgeCalls asks the DeFi service for the calls that start a transfer
+func (e *Engine) fetchBridgeCalls(ctx context.Context, bridge string, config rules.RebalanceAction, userAddress string) (*clients.BridgeCalls, error) {
+	result, err := e.defi.BridgeCalls(ctx, bridge, clients.BridgeRequest{
+		Asset:       config.Asset,
+		Amount:      config.Amount,
+		FromChain:   config.Chain,
+		ToChain:     config.ToChain,
+		UserAddress: userAddress,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build bridge calls: %w", err)
+	}
+	return result, nil
+}
+
+// fetchTransferStatus asks the DeFi service where a transfer stands
+func (e *Engine) fetchTransferStatus(ctx context.Context, t *models.BridgeTransfer) (*clients.TransferStatus, error) {
+	status, err := e.defi.TransferStatus(ctx, t.Bridge, t.FromChain, t.ToChain, t.SourceTxHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transfer status: %w", err)
+	}
+	return status, nil
+}
+
+// recordTransfer stores a transfer about to be dispatched, with the nonce of
+// the transaction that starts it
+func recordTransfer(ctx context.Context, t *models.BridgeTransfer, tx clients.Transaction) error {
+	if tx.Nonce == nil {
+		return errors.New("bridge transaction has no nonce to follow")
+	}
+	t.SourceNonce = tx.Nonce
+	if job, _ := ctx.Value(jobKey{}).(*models.ActionJob); job != nil {
+		t.JobID = &job.ID
+	}
+	if err := database.DB.Create(t).Error; err != nil {
+		return fmt.Errorf("failed to record bridge transfer: %w", err)
+	}
+	return nil
+}
+
+// checkUnsent fails a transfer whose bridge transaction the wallet refused,
+// or never sent in time
+func checkUnsent(ctx context.Context, t *models.BridgeTransfer, now time.Time) error {
+	var refused models.WalletRequest
+	err := database.DB.WithContext(ctx).
+		Where("chain = ? AND LOWER(from_address) = LOWER(?) AND nonce = ? AND status = ? AND created_at >= ?",
+			t.FromChain, t.Sender, *t.SourceNonce, models.RequestStatusRejected, t.CreatedAt).
+		First(&refused).Error
+	switch {
+	case err == nil:
+		failTransfer(t, fmt.Errorf("wallet refused the bridge transaction: %s", refused.Error))
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	case now.Sub(t.CreatedAt) > transferSendTimeout:
+		failTransfer(t, errors.New("bridge transaction was never sent"))
+	}
+	return nil
+}
+
+// failTransfer gives up a transfer
+func failTransfer(t *models.BridgeTransfer, reason error) {
+	now := time.Now()
+	err := database.DB.Model(t).Updates(map[string]interface{}{
+		"status":       models.TransferFailed,
+		"last_error":   reason.Error(),
+		"completed_at": &now,
+	}).Error
+	if err != nil {
+		log.Printf("Error failing bridge transfer %d: %v", t.ID, err)
+	}
+}
+
+// advanceTransfers moves every open bridge transfer forward
+func (e *Engine) advanceTransfers(ctx context.Context) {
+	var ids []uint
+	if err := database.DB.WithContext(ctx).Model(&models.BridgeTransfer{}).
+		Where("status IN ?", []string{models.TransferSending, models.TransferInFlight}).
+		Pluck("id", &ids).Error; err != nil {
+		log.Printf("Error fetching bridge transfers: %v", err)
+		return
+	}
+
+	for _, id := range ids {
+		if err := e.advanceTransferExclusive(ctx, id); err != nil {
+			log.Printf("Error advancing bridge transfer %d: %v", id, err)
+		}
+	}
+}
+
+// advanceTransferExclusive advances a transfer while holding its advisory
+// lock, so its deposit can't be requested twice. A transfer locked elsewhere
+// is skipped.
+func (e *Engine) advanceTransferExclusive(ctx context.Context, id uint) error {
+	return database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var locked bool
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?, ?)", transferLockClass, int32(id)).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+
+		var t models.BridgeTransfer
+		err := database.DB.WithContext(ctx).
+			Where("id = ? AND status IN ?", id, []string{models.TransferSending, models.TransferInFlight}).
+			First(&t).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return e.advanceTransfer(ctx, &t, time.Now())
+	})
+}
+
+// advanceTransfer follows a transfer from its bridge transaction to the
+// destination deposit
+func (e *Engine) advanceTransfer(ctx context.Context, t *models.BridgeTransfer, now time.Time) error {
+	switch t.Status {
+	case models.TransferSending:
+		// The wallet sends the transaction itself, so it is found by nonce
+		var sent models.Transaction
+		err := database.DB.WithContext(ctx).
+			Where("chain = ? AND LOWER(from_address) = LOWER(?) AND nonce = ? AND created_at >= ?", t.FromChain, t.Sender, *t.SourceNonce, t.CreatedAt).
+			Order("created_at DESC").
+			First(&sent).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return checkUnsent(ctx, t, now)
+		}
+		if err != nil {
+			return err
+		}
+
+		switch sent.Status {
+		case models.TxStatusConfirmed:
+			log.Printf("Bridge transfer %d: %s sent in %s, awaiting arrival on %s", t.ID, t.Asset, sent.TxHash, t.ToChain)
+			return database.DB.Model(t).Updates(map[string]interface{}{
+				"status":         models.TransferInFlight,
+				"source_tx_hash": sent.TxHash,
+			}).Error
+		case models.TxStatusFailed, models.TxStatusDropped, models.TxStatusReplaced:
+			failTransfer(t, fmt.Errorf("bridge transaction %s %s", sent.TxHash, sent.Status))
+		}
+		return nil
+
+	case models.TransferInFlight:
+		status, err := e.fetchTransferStatus(ctx, t)
+		if err != nil {
+			return err
+		}
+		switch status.State {
+		case clients.TransferPending:
+			if now.After(t.ExpectedArrival.Add(transferArrivalGrace)) {
+				failTransfer(t, fmt.Errorf("transfer did not arrive on %s (%s); it may still be claimable", t.ToChain, status.Detail))
+			}
+			return nil
+		case clients.TransferFailed:
+			failTransfer(t, fmt.Errorf("transfer failed: %s", status.Detail))
+			return nil
+		case clients.TransferClaimable, clients.TransferArrived:
+			return e.completeTransfer(ctx, t, status.Calls, now)
+		}
+		return fmt.Errorf("unknown transfer state %q", status.State)
+	}
+	return nil
+}
+
+// completeTransfer asks the user to claim the funds, if the bridge needs it,
+// and deposit them on the destination. A failure is retried on the next
+// poll until the transfer is given up.
+func (e *Engine) completeTransfer(ctx context.Context, t *models.BridgeTransfer, claims []clients.Call, now time.Time) error {
+	var user models.User
+	if err := database.DB.First(&user, t.UserID).Error; err != nil {
+		return err
+	}
+	token, ok := tokens.Lookup(t.ToChain, t.Asset)
+	if !ok {
+		return fmt.Errorf("asset %s not supported on %s", t.Asset, t.ToChain)
+	}
+	amount, ok := new(big.Int).SetString(t.AmountOut, 10)
+	if !ok {
+		return fmt.Errorf("invalid transfer amount: %s", t.AmountOut)
+	}
+	amountOut := tokens.FromBaseUnits(amount, token.Decimals)
+
+	deposit, err := e.fetchProtocolCalls(ctx, t.ToProtocol, "deposit", t.Asset, amountOut, t.ToChain, user.WalletAddress)
+	if err != nil {
+		return e.retryTransfer(t, err, now)
+	}
+
+	plan := actionPlan{Chain: t.ToChain, AllowUntraced: t.AllowUntraced}
+	for _, call := range claims {
+		plan.Steps = append(plan.Steps, planStep{Call: call})
+	}
+	for _, call := range deposit.Calls {
+		step := planStep{
+			Call:   call,
+			Expect: &expectation{Token: deposit.Token, Amount: -amountOut},
+		}
+		// Claimed funds only exist once the claim lands
+		if len(claims) > 0 {
+			step.Balances = map[string]string{deposit.Token: deposit.Amount}
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+
+	actx, a := withAudit(ctx)
+	rule := models.AutomationRule{ID: t.RuleID, UserID: t.UserID, ActionType: "rebalance"}
+	if err := e.submitPlan(actx, rule, user, plan); err != nil {
+		return e.retryTransfer(t, err, now)
+	}
+
+	log.Printf("Bridge transfer %d: requested deposit of %f %s into %s on %s", t.ID, amountOut, t.Asset, t.ToProtocol, t.ToChain)
+	a.mu.Lock()
+	steps := a.steps
+	a.mu.Unlock()
+	return database.DB.Model(t).Select("status", "steps", "last_error", "completed_at").Updates(&models.BridgeTransfer{
+		Status:      models.TransferCompleted,
+		Steps:       steps,
+		LastError:   "",
+		CompletedAt: &now,
+	}).Error
+}
+
+// retryTransfer records why a transfer couldn't complete, giving it up once
+// it is past its arrival grace period
+func (e *Engine) retryTransfer(t *models.BridgeTransfer, reason error, now time.Time) error {
+	if now.After(t.ExpectedArrival.Add(transferArrivalGrace)) {
+		failTransfer(t, fmt.Errorf("funds arrived but could not be deposited: %w", reason))
+		return nil
+	}
+	if err := database.DB.Model(t).Update("last_error", reason.Error()).Error; err != nil {
+		return err
+	}
+	return reason
+}
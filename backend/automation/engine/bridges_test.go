@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/shared/clients"
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+)
+
+// testTransfer records a transfer of the rule's waiting for its bridge
+// transaction, sent with the given nonce
+func testTransfer(t *testing.T, rule models.AutomationRule, sender string, nonce uint64) *models.BridgeTransfer {
+	t.Helper()
+	transfer := models.BridgeTransfer{
+		RuleID:          rule.ID,
+		UserID:          rule.UserID,
+		Bridge:          "across",
+		Asset:           "USDC",
+		FromChain:       "ethereum",
+		ToChain:         "base",
+		Amount:          "1000000000",
+		AmountOut:       "999000000",
+		Sender:          sender,
+		SourceNonce:     &nonce,
+		ToProtocol:      "aave",
+		ExpectedArrival: time.Now().Add(20 * time.Minute),
+		AllowUntraced:   true,
+		Status:          models.TransferSending,
+	}
+	if err := database.DB.Create(&transfer).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.DB.Delete(&transfer) })
+	return &transfer
+}
+
+func loadTransfer(t *testing.T, id uint) models.BridgeTransfer {
+	t.Helper()
+	var transfer models.BridgeTransfer
+	if err := database.DB.First(&transfer, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return transfer
+}
+
+func TestAdvanceTransfer(t *testing.T) {
+	testDB(t)
+	ctx := context.Background()
+	user := testUser(t)
+	rule := testRule(t, user, models.AutomationRule{
+		TriggerType:   "gas_below",
+		TriggerConfig: map[string]interface{}{"threshold": 20.0},
+		ActionType:    "rebalance",
+		ActionConfig:  map[string]interface{}{"from_protocol": "aave", "to_protocol": "aave", "asset": "USDC", "chain": "ethereum", "to_chain": "base"},
+	})
+
+	const sourceNonce = 7
+	txHash := fmt.Sprintf("0x%064x", time.Now().UnixNano())
+
+	// One server plays both the DeFi and the wallet service
+	var requests []clients.SessionRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/bridges/across/status":
+			if r.URL.Query().Get("tx_hash") != txHash {
+				t.Errorf("status of %s, want %s", r.URL.Query().Get("tx_hash"), txHash)
+			}
+			json.NewEncoder(w).Encode(clients.TransferStatus{State: clients.TransferArrived})
+		case r.URL.Path == "/api/v1/protocols/aave/transactions":
+			var req clients.TransactionRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Action != "deposit" || req.Chain != "base" || req.Amount != 999 {
+				t.Errorf("deposit request = %+v", req)
+			}
+			json.NewEncoder(w).Encode(clients.ProtocolCalls{
+				Protocol: "aave",
+				Asset:    "USDC",
+				Token:    "0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913",
+				Amount:   "999000000",
+				Calls:    []clients.Call{{To: "0xpool", Data: "0xdeposit", Value: "0", Description: "deposit"}},
+			})
+		case r.URL.Path == "/api/v1/wallet/sessions/active":
+			json.NewEncoder(w).Encode(clients.Session{Topic: "topic", WalletAddress: user.WalletAddress})
+		case r.URL.Path == "/api/v1/wallet/simulate":
+			json.NewEncoder(w).Encode(clients.SimulationResult{Success: true})
+		case r.URL.Path == "/api/v1/wallet/build":
+			nonce := uint64(3)
+			json.NewEncoder(w).Encode(clients.Transaction{From: user.WalletAddress, To: "0xpool", Data: "0xdeposit", Value: "0", Nonce: &nonce})
+		case strings.HasSuffix(r.URL.Path, "/request"):
+			var req clients.SessionRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			requests = append(requests, req)
+			json.NewEncoder(w).Encode(map[string]interface{}{"topic": "topic", "request_id": 42})
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	e := NewEngine(srv.URL, srv.URL, srv.URL)
+
+	advance := func(t *testing.T, transfer *models.BridgeTransfer, want string) models.BridgeTransfer {
+		t.Helper()
+		if err := e.advanceTransferExclusive(ctx, transfer.ID); err != nil {
+			t.Fatal(err)
+		}
+		stored := loadTransfer(t, transfer.ID)
+		if stored.Status != want {
+			t.Fatalf("transfer status = %s (%s), want %s", stored.Status, stored.LastError, want)
+		}
+		return stored
+	}
+
+	t.Run("sent to completed", func(t *testing.T) {
+		transfer := testTransfer(t, rule, user.WalletAddress, sourceNonce)
+
+		// The wallet hasn't answered the request yet
+		advance(t, transfer, models.TransferSending)
+
+		// The wallet service records the transaction the wallet answered with
+		nonce, requestID := uint64(sourceNonce), int64(41)
+		sent := models.Transaction{
+			UserID:           user.ID,
+			TxHash:           txHash,
+			Chain:            "ethereum",
+			FromAddress:      user.WalletAddress,
+			Type:             "rebalance",
+			Status:           models.TxStatusPending,
+			Nonce:            &nonce,
+			AutomationRuleID: &rule.ID,
+			RequestID:        &requestID,
+		}
+		if err := database.DB.Create(&sent).Error; err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { database.DB.Delete(&sent) })
+		advance(t, transfer, models.TransferSending)
+
+		// The tracker confirms it
+		database.DB.Model(&sent).Update("status", models.TxStatusConfirmed)
+		if stored := advance(t, transfer, models.TransferInFlight); stored.SourceTxHash != txHash {
+			t.Fatalf("source tx hash = %s, want %s", stored.SourceTxHash, txHash)
+		}
+
+		// The funds arrived, so the deposit is requested
+		stored := advance(t, transfer, models.TransferCompleted)
+		if len(requests) != 1 {
+			t.Fatalf("%d wallet requests, want 1", len(requests))
+		}
+		req := requests[0]
+		if req.Chain != "base" || req.Method != "eth_sendTransaction" || req.Type != "rebalance" ||
+			req.AutomationRuleID == nil || *req.AutomationRuleID != rule.ID {
+			t.Errorf("wallet request = %+v", req)
+		}
+		if len(stored.Steps) != 1 || stored.Steps[0].RequestID != 42 || stored.Steps[0].Status != "requested" {
+			t.Errorf("transfer steps = %+v", stored.Steps)
+		}
+		if stored.CompletedAt == nil {
+			t.Error("completed transfer has no completion time")
+		}
+	})
+
+	t.Run("refused by the wallet", func(t *testing.T) {
+		transfer := testTransfer(t, rule, user.WalletAddress, sourceNonce+1)
+		nonce := uint64(sourceNonce + 1)
+		refused := models.WalletRequest{
+			Topic:       "topic",
+			RequestID:   time.Now().UnixNano(),
+			UserID:      user.ID,
+			Chain:       "ethereum",
+			FromAddress: user.WalletAddress,
+			Nonce:       &nonce,
+			Type:        "rebalance",
+			Status:      models.RequestStatusRejected,
+			Error:       "wallet error 5000: User rejected.",
+			ExpiresAt:   time.Now().Add(5 * time.Minute),
+		}
+		if err := database.DB.Create(&refused).Error; err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { database.DB.Delete(&refused) })
+
+		if stored := advance(t, transfer, models.TransferFailed); !strings.Contains(stored.LastError, "User rejected") {
+			t.Fatalf("last error = %q", stored.LastError)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+)
+
+const (
+	// heartbeatInterval is how often a worker renews its lease
+	heartbeatInterval = 5 * time.Second
+	// leaseTTL is how long after its last heartbeat a worker still owns its
+	// shard. A worker that dies loses its rules to the others after this.
+	leaseTTL = 15 * time.Second
+	// staleWorkerAge is when the leader deletes the rows of dead workers
+	staleWorkerAge = time.Hour
+)
+
+// membership tracks this worker's lease and its shard of the rules. Rules
+// are split by ID modulo the number of live workers, ordered by worker ID.
+// Shards can briefly overlap while workers join or leave; the per-rule
+// lock and the execution claim keep that safe.
+type membership struct {
+	id       string
+	hostname string
+}
+
+// newMembership creates a membership with a random worker ID
+func newMembership() *membership {
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		log.Printf("Error generating worker ID: %v", err)
+	}
+	return &membership{
+		id:       hostname + "-" + hex.EncodeToString(suffix),
+		hostname: hostname,
+	}
+}
+
+// run heartbeats until the context is cancelled, then leaves so the other
+// workers take over this worker's rules without waiting for the lease
+func (m *membership) run(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.heartbeat(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Error renewing worker lease: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := database.DB.Where("worker_id = ?", m.id).Delete(&models.EngineWorker{}).Error; err != nil {
+				log.Printf("Error releasing worker lease: %v", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// heartbeat renews this worker's lease. Times come from the database clock
+// so workers on hosts with skewed clocks agree on who is live.
+func (m *membership) heartbeat(ctx context.Context) error {
+	return database.DB.WithContext(ctx).Exec(`
+		INSERT INTO engine_workers (worker_id, hostname, heartbeat_at, created_at, updated_at)
+		VALUES (?, ?, NOW(), NOW(), NOW())
+		ON CONFLICT (worker_id) DO UPDATE SET heartbeat_at = NOW(), updated_at = NOW()`,
+		m.id, m.hostname).Error
+}
+
+// shard returns this worker's index among the live workers and their
+// count. ok is false while this worker's own lease has lapsed, in which
+// case it must not evaluate anything.
+func (m *membership) shard(ctx context.Context) (index, count int, ok bool, err error) {
+	var ids []string
+	err = database.DB.WithContext(ctx).Model(&models.EngineWorker{}).
+		Where("heartbeat_at > NOW() - make_interval(secs => ?)", leaseTTL.Seconds()).
+		Pluck("worker_id", &ids).Error
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	sort.Strings(ids)
+	for i, id := range ids {
+		if id == m.id {
+			return i, len(ids), true, nil
+		}
+	}
+	return 0, 0, false, nil
+}
+
+// pruneWorkers deletes the rows of workers that stopped long ago
+func pruneWorkers() {
+	err := database.DB.Where("heartbeat_at < NOW() - make_interval(secs => ?)", staleWorkerAge.Seconds()).
+		Delete(&models.EngineWorker{}).Error
+	if err != nil {
+		log.Printf("Error pruning engine workers: %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/shared/rules"
+	"gorm.io/gorm"
+)
+
+// checkComposite evaluates a rule whose trigger is a condition tree. Leaves
+// are checked with the regular trigger checks; the fetch cache on the
+// context makes leaves reading the same data share one request. Composite
+// triggers have no single metric, so the returned value is always 0.
+func (e *Engine) checkComposite(ctx context.Context, rule models.AutomationRule) (bool, float64, error) {
+	root, err := rules.ParseCondition(rule.TriggerConfig["condition"])
+	if err != nil {
+		return false, 0, err
+	}
+
+	held, err := e.loadHeld(rule.ID)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to load condition state: %w", err)
+	}
+	prior := make(map[string]time.Time, len(held))
+	for path, since := range held {
+		prior[path] = since
+	}
+
+	triggered, err := e.evalCondition(ctx, rule, root, held, e.source.Now())
+	if err != nil {
+		return false, 0, err
+	}
+
+	// Nodes only ever start or stop holding, so comparing keys finds changes
+	changed := len(held) != len(prior)
+	for path := range held {
+		if _, ok := prior[path]; !ok {
+			changed = true
+		}
+	}
+	if changed {
+		if err := e.saveHeld(rule.ID, held); err != nil {
+			return false, 0, fmt.Errorf("failed to save condition state: %w", err)
+		}
+	}
+	return triggered, 0, nil
+}
+
+// evalCondition evaluates a node of a condition tree. Every child is
+// evaluated, without short-circuiting, so the held-since time of each node
+// with a "for" duration stays accurate. held maps node paths to the time the
+// node started holding and is updated in place.
+func (e *Engine) evalCondition(ctx context.Context, rule models.AutomationRule, c *rules.Condition, held map[string]time.Time, now time.Time) (bool, error) {
+	var result bool
+
+	switch {
+	case c.And != nil:
+		result = true
+		for _, child := range c.And {
+			ok, err := e.evalCondition(ctx, rule, child, held, now)
+			if err != nil {
+				return false, err
+			}
+			result = result && ok
+		}
+
+	case c.Or != nil:
+		for _, child := range c.Or {
+			ok, err := e.evalCondition(ctx, rule, child, held, now)
+			if err != nil {
+				return false, err
+			}
+			result = result || ok
+		}
+
+	case c.Not != nil:
+		ok, err := e.evalCondition(ctx, rule, c.Not, held, now)
+		if err != nil {
+			return false, err
+		}
+		result = !ok
+
+	default:
+		leaf := rule
+		leaf.TriggerType = c.Type
+		leaf.TriggerConfig = c.Config
+		ok, _, err := e.checkTrigger(withAuditScope(ctx, c.Path), leaf)
+		if err != nil {
+			return false, fmt.Errorf("%s (%s): %w", c.Path, c.Type, err)
+		}
+		result = ok
+	}
+
+	observe(ctx, c.Path, result)
+
+	if c.For == 0 {
+		return result, nil
+	}
+	if !result {
+		delete(held, c.Path)
+		return false, nil
+	}
+
+	since, ok := held[c.Path]
+	if !ok {
+		since = now
+		held[c.Path] = now
+	}
+	observe(ctx, c.Path+".held_since", since)
+	return now.Sub(since) >= c.For, nil
+}
+
+// loadHeld returns the held-since times of a rule's nodes, from memory when
+// the engine is sandboxed
+func (e *Engine) loadHeld(ruleID uint) (map[string]time.Time, error) {
+	if e.held == nil {
+		return loadConditionStates(ruleID)
+	}
+	held := make(map[string]time.Time, len(e.held[ruleID]))
+	for path, since := range e.held[ruleID] {
+		held[path] = since
+	}
+	return held, nil
+}
+
+// saveHeld stores the held-since times of a rule's nodes
+func (e *Engine) saveHeld(ruleID uint, held map[string]time.Time) error {
+	if e.held == nil {
+		return saveConditionStates(ruleID, held)
+	}
+	e.held[ruleID] = held
+	return nil
+}
+
+// loadConditionStates returns the held-since times of a rule's nodes
+func loadConditionStates(ruleID uint) (map[string]time.Time, error) {
+	var states []models.ConditionState
+	if err := database.DB.Where("rule_id = ?", ruleID).Find(&states).Error; err != nil {
+		return nil, err
+	}
+
+	held := make(map[string]time.Time, len(states))
+	for _, s := range states {
+		held[s.Path] = s.Since
+	}
+	return held, nil
+}
+
+// saveConditionStates replaces a rule's held-since times
+func saveConditionStates(ruleID uint, held map[string]time.Time) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("rule_id = ?", ruleID).Delete(&models.ConditionState{}).Error; err != nil {
+			return err
+		}
+		for path, since := range held {
+			state := models.ConditionState{RuleID: ruleID, Path: path, Since: since}
+			if err := tx.Create(&state).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
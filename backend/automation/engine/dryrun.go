@@ -0,0 +1,36 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/defioptimization/shared/clients"
+	"github.com/defioptimization/shared/models"
+)
+
+// DryRun evaluates a rule now against live data and reports what would
+// happen. Actions are built and simulated but nothing is sent to the wallet,
+// and neither the rule nor its condition states are changed.
+func (e *Engine) DryRun(ctx context.Context, rule models.AutomationRule) (*models.RuleExecution, error) {
+	now := time.Now()
+	ctx = clients.WithReadCache(ctx)
+	ctx, a := withAudit(ctx)
+
+	sandbox := e.sandboxed(modeDryRun, e.source)
+	if rule.ID != 0 {
+		held, err := loadConditionStates(rule.ID)
+		if err != nil {
+			return nil, err
+		}
+		sandbox.held[rule.ID] = held
+	}
+
+	exec := &models.RuleExecution{RuleID: rule.ID, UserID: rule.UserID, Source: modeDryRun, CreatedAt: now}
+	if err := sandbox.runRule(ctx, &rule, now, exec); err != nil {
+		exec.Error = err.Error()
+	}
+	exec.DurationMs = time.Since(now).Milliseconds()
+	a.fill(exec)
+
+	return exec, nil
+}
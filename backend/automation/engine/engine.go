@@ -1,44 +1,136 @@
 package engine
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
+	"os"
+	"sync"
 	"time"
 
+	"github.com/defioptimization/automation/events"
+	"github.com/defioptimization/shared/clients"
 	"github.com/defioptimization/shared/database"
 	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/shared/rules"
+	"github.com/defioptimization/shared/tokens"
+	"gorm.io/gorm"
 )
 
 // Engine manages automation rules and executes actions
 type Engine struct {
-	defiServiceURL   string
-	walletServiceURL string
-	mlServiceURL     string
-	httpClient       *http.Client
+	defi      *clients.DeFi
+	wallet    *clients.Wallet
+	ml        *clients.ML
+	events    chan events.Event
+	holdings  *holdings
+	lastPrune time.Time
+
+	// members splits the rules between engine replicas; workers bounds the
+	// rules this replica evaluates at once
+	members *membership
+	workers int
+
+	// jobWorkers run the actions of fired rules; jobsReady wakes one when a
+	// job is queued
+	jobWorkers int
+	jobsReady  chan struct{}
+
+	// source supplies the data triggers read; live unless sandboxed
+	source     DataSource
+	samples    *sampler
+	lastSample time.Time
+
+	// mode is empty for the live engine. Sandboxed copies keep composite
+	// condition states in held instead of the database.
+	mode string
+	held map[uint]map[string]time.Time
+}
+
+// Sandbox modes
+const (
+	// modeDryRun evaluates against live data and only simulates actions
+	modeDryRun = "dry_run"
+	// modeBacktest evaluates against history and runs no actions
+	modeBacktest = "backtest"
+)
+
+// sandboxed returns a copy of the engine that reads from source and changes
+// nothing outside itself
+func (e *Engine) sandboxed(mode string, source DataSource) *Engine {
+	c := *e
+	c.mode = mode
+	c.source = source
+	c.held = make(map[uint]map[string]time.Time)
+	return &c
 }
 
 // NewEngine creates a new automation engine
 func NewEngine(defiServiceURL, walletServiceURL, mlServiceURL string) *Engine {
-	return &Engine{
-		defiServiceURL:   defiServiceURL,
-		walletServiceURL: walletServiceURL,
-		mlServiceURL:     mlServiceURL,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-	}
+	e := &Engine{
+		defi:       clients.NewDeFi(defiServiceURL),
+		wallet:     clients.NewWallet(walletServiceURL, clients.WithInternalToken(os.Getenv("INTERNAL_API_TOKEN"))),
+		ml:         clients.NewML(mlServiceURL),
+		events:     make(chan events.Event, eventQueueSize),
+		samples:    &sampler{last: make(map[string]time.Time)},
+		members:    newMembership(),
+		workers:    defaultWorkers,
+		jobWorkers: defaultJobWorkers,
+		jobsReady:  make(chan struct{}, 1),
+	}
+	e.holdings = newHoldings(e.defi)
+	e.source = e.live()
+	return e
 }
 
-// Start begins monitoring and executing automation rules
-func (e *Engine) Start(ctx context.Context, interval time.Duration) error {
+// live returns the data source reading the services
+func (e *Engine) live() *liveSource {
+	return &liveSource{e: e, sampler: e.samples}
+}
+
+// Start begins monitoring and executing automation rules. Rules affected by
+// on-chain events passed to HandleEvent are evaluated as the events arrive;
+// the interval poll re-evaluates every rule as a fallback. Any number of
+// engines can run against the same database: each evaluates its shard of the
+// rules with up to workers rules at once. The actions of rules that fire are
+// queued and run by jobWorkers goroutines, apart from trigger evaluation.
+// When the context is cancelled Start waits for the running jobs and for the
+// worker lease to be released, then returns the context's error.
+func (e *Engine) Start(ctx context.Context, interval time.Duration, workers, jobWorkers int) error {
+	if workers > 0 {
+		e.workers = workers
+	}
+	if jobWorkers > 0 {
+		e.jobWorkers = jobWorkers
+	}
+
+	// Each rule evaluation holds a connection for its lock besides the ones
+	// its queries use
+	if sqlDB, err := database.DB.DB(); err == nil {
+		if max := sqlDB.Stats().MaxOpenConnections; max > 0 && max <= e.workers {
+			return fmt.Errorf("database pool of %d connections is too small for %d concurrent rules", max, e.workers)
+		}
+	}
+
+	// Join the cluster before the first poll so this worker gets a shard
+	if err := e.members.heartbeat(ctx); err != nil {
+		return fmt.Errorf("failed to register worker: %w", err)
+	}
+	// Shutdown waits for the lease to be released and the running jobs to
+	// finish
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		e.members.run(ctx)
+	}()
+	e.runJobs(ctx, &wg)
+
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	log.Println("Automation engine started")
+	log.Printf("Automation engine started as worker %s (%d concurrent rules, %d job workers)", e.members.id, e.workers, e.jobWorkers)
 
 	// Initial check
 	e.processRules(ctx)
@@ -46,59 +138,159 @@ func (e *Engine) Start(ctx context.Context, interval time.Duration) error {
 	for {
 		select {
 		case <-ctx.Done():
+			wg.Wait()
 			return ctx.Err()
 		case <-ticker.C:
 			e.processRules(ctx)
+		case ev := <-e.events:
+			e.processEvent(ctx, ev)
 		}
 	}
 }
 
-// processRules processes all enabled automation rules
+// processRules processes the enabled automation rules in this worker's
+// shard. The first worker also does the cluster's housekeeping.
 func (e *Engine) processRules(ctx context.Context) {
+	query, leader, ok := e.shardQuery(ctx)
+	if !ok {
+		return
+	}
+
 	var rules []models.AutomationRule
-	if err := database.DB.Where("enabled = ?", true).Find(&rules).Error; err != nil {
+	if err := query.Where("enabled = ?", true).Find(&rules).Error; err != nil {
 		log.Printf("Error fetching automation rules: %v", err)
 		return
 	}
 
-	for _, rule := range rules {
-		if err := e.evaluateRule(ctx, rule); err != nil {
-			log.Printf("Error evaluating rule %d: %v", rule.ID, err)
-			continue
-		}
+	e.evaluateAll(ctx, rules, "poll")
+
+	if leader {
+		e.sampleMarkets(ctx, time.Now())
+		e.pruneExecutions(time.Now())
+		pruneWorkers()
+		reclaimStuckJobs()
+		e.advanceTransfers(ctx)
+	}
+}
+
+// shardQuery returns a query limited to the rules in this worker's shard and
+// whether this worker leads the cluster. ok is false when this worker holds
+// no lease and must not evaluate rules.
+func (e *Engine) shardQuery(ctx context.Context) (query *gorm.DB, leader, ok bool) {
+	index, count, ok, err := e.members.shard(ctx)
+	if err != nil {
+		log.Printf("Error reading engine workers: %v", err)
+		return nil, false, false
+	}
+	if !ok {
+		log.Printf("Worker %s has no lease, skipping evaluation", e.members.id)
+		return nil, false, false
 	}
+	return database.DB.WithContext(ctx).Where("id % ? = ?", count, index), index == 0, true
 }
 
-// evaluateRule evaluates a single automation rule
-func (e *Engine) evaluateRule(ctx context.Context, rule models.AutomationRule) error {
+// evaluateRule evaluates a single automation rule and records the
+// evaluation. source is what caused it: poll, or the name of an on-chain event.
+func (e *Engine) evaluateRule(ctx context.Context, rule models.AutomationRule, source string) error {
+	now := time.Now()
+	ctx = clients.WithReadCache(ctx)
+	ctx, a := withAudit(ctx)
+
+	exec := models.RuleExecution{RuleID: rule.ID, UserID: rule.UserID, Source: source}
+	err := e.runRule(ctx, &rule, now, &exec)
+	if err != nil {
+		exec.Error = err.Error()
+	}
+	exec.DurationMs = time.Since(now).Milliseconds()
+	saveExecution(&exec, a)
+
+	return err
+}
+
+// runRule checks a rule's trigger and runs its action, filling in the
+// decision of the execution record and updating the rule's execution
+// tracking. Sandboxed engines only update the rule in memory.
+func (e *Engine) runRule(ctx context.Context, rule *models.AutomationRule, now time.Time, exec *models.RuleExecution) error {
+	// An armed rule in its cooldown or over its caps can't fire, so skip the
+	// trigger check. A disarmed rule is still checked to see if it recovered.
+	if rule.Armed {
+		if reason := limitReached(*rule, now); reason != "" {
+			exec.Decision, exec.Reason = models.DecisionSkipped, reason
+			return nil
+		}
+	}
+
 	// Check if trigger conditions are met
-	triggered, err := e.checkTrigger(ctx, rule)
+	triggered, value, err := e.checkTrigger(ctx, *rule)
 	if err != nil {
+		exec.Decision = models.DecisionError
 		return fmt.Errorf("error checking trigger: %w", err)
 	}
+	exec.Triggered, exec.Value = triggered, value
+
+	if !rule.Armed {
+		if !recovered(*rule, value) {
+			exec.Decision = models.DecisionDisarmed
+			exec.Reason = fmt.Sprintf("waiting for the metric to recover past %g", *rule.ResetThreshold)
+			return nil
+		}
+		log.Printf("Rule %d: metric recovered to %.4f, re-arming", rule.ID, value)
+		exec.Decision = models.DecisionRearmed
+		rule.Armed = true
+		if e.mode == "" {
+			if err := database.DB.Model(rule).Update("armed", true).Error; err != nil {
+				log.Printf("Error re-arming rule %d: %v", rule.ID, err)
+			}
+		}
+		return nil
+	}
 
 	if !triggered {
+		exec.Decision = models.DecisionNotTriggered
 		return nil
 	}
 
-	// Execute action
-	if err := e.executeAction(ctx, rule); err != nil {
-		return fmt.Errorf("error executing action: %w", err)
+	// A backtest estimates the action's outcome instead of running it
+	if e.mode == modeBacktest {
+		exec.Decision = models.DecisionFired
+		recordExecution(rule, now)
+		return nil
 	}
 
-	// Update rule execution tracking
-	now := time.Now()
-	rule.LastExecutedAt = &now
-	rule.ExecutionCount++
-	if err := database.DB.Save(&rule).Error; err != nil {
-		log.Printf("Error updating rule execution: %v", err)
+	// A dry run builds and simulates the action now
+	if e.mode == modeDryRun {
+		if err := e.executeAction(ctx, *rule); err != nil {
+			exec.Decision = models.DecisionFailed
+			return fmt.Errorf("error executing action: %w", err)
+		}
+		exec.Decision = models.DecisionFired
+		return nil
+	}
+
+	// Claim the execution and queue its action for the job workers, so slow
+	// chain calls don't hold up trigger evaluation
+	job, err := enqueueAction(ctx, rule, now)
+	switch {
+	case errors.Is(err, errExecutionClaimed):
+		exec.Decision, exec.Reason = models.DecisionSkipped, "executed by another worker or disabled"
+		return nil
+	case err != nil:
+		exec.Decision = models.DecisionFailed
+		return fmt.Errorf("error queueing action: %w", err)
 	}
+	exec.Decision, exec.JobID = models.DecisionFired, &job.ID
+	e.notifyJobs()
 
 	return nil
 }
 
-// checkTrigger checks if a rule's trigger conditions are met
-func (e *Engine) checkTrigger(ctx context.Context, rule models.AutomationRule) (bool, error) {
+// checkTrigger checks if a rule's trigger conditions are met and returns the
+// metric the trigger compared against its threshold
+func (e *Engine) checkTrigger(ctx context.Context, rule models.AutomationRule) (bool, float64, error) {
+	if threshold, ok := rule.TriggerConfig["threshold"]; ok {
+		observe(ctx, "threshold", threshold)
+	}
+
 	switch rule.TriggerType {
 	case "apy_drop":
 		return e.checkAPYDrop(ctx, rule)
@@ -106,169 +298,87 @@ func (e *Engine) checkTrigger(ctx context.Context, rule models.AutomationRule) (
 		return e.checkHealthFactor(ctx, rule)
 	case "risk_threshold":
 		return e.checkRiskThreshold(ctx, rule)
+	case "price_change":
+		return e.checkPriceChange(ctx, rule)
+	case "apy_spread":
+		return e.checkAPYSpread(ctx, rule)
+	case "utilization":
+		return e.checkUtilization(ctx, rule)
+	case "gas_below":
+		return e.checkGasBelow(ctx, rule)
+	case "schedule":
+		return e.checkSchedule(ctx, rule)
+	case "composite":
+		return e.checkComposite(ctx, rule)
 	default:
-		return false, fmt.Errorf("unknown trigger type: %s", rule.TriggerType)
+		return false, 0, fmt.Errorf("unknown trigger type: %s", rule.TriggerType)
 	}
 }
 
 // checkAPYDrop checks if APY has dropped below threshold
-func (e *Engine) checkAPYDrop(ctx context.Context, rule models.AutomationRule) (bool, error) {
-	config := rule.TriggerConfig
-	if config == nil {
-		return false, fmt.Errorf("trigger config is nil")
-	}
-
-	protocol, ok := config["protocol"].(string)
-	if !ok {
-		return false, fmt.Errorf("protocol not specified in trigger config")
-	}
-
-	asset, ok := config["asset"].(string)
-	if !ok {
-		return false, fmt.Errorf("asset not specified in trigger config")
-	}
-
-	threshold, ok := config["threshold"].(float64)
-	if !ok {
-		return false, fmt.Errorf("threshold not specified in trigger config")
-	}
-
-	chain := "ethereum"
-	if c, ok := config["chain"].(string); ok {
-		chain = c
+func (e *Engine) checkAPYDrop(ctx context.Context, rule models.AutomationRule) (bool, float64, error) {
+	var config rules.APYDropTrigger
+	if err := rules.Decode(rule.TriggerConfig, &config); err != nil {
+		return false, 0, err
 	}
 
 	// Fetch current APY from DeFi service
-	url := fmt.Sprintf("%s/api/v1/protocols/%s/apy?asset=%s&chain=%s", e.defiServiceURL, protocol, asset, chain)
-	resp, err := e.httpClient.Get(url)
+	apy, err := e.source.APY(ctx, config.Protocol, config.Asset, config.Chain)
 	if err != nil {
-		return false, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("failed to fetch APY: status %d", resp.StatusCode)
-	}
-
-	var result struct {
-		APY float64 `json:"apy"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return false, err
+		return false, 0, err
 	}
+	observe(ctx, "apy", apy)
 
 	// Check if APY is below threshold
-	return result.APY < threshold, nil
+	return apy < config.Threshold, apy, nil
 }
 
 // checkHealthFactor checks if health factor is below threshold
-func (e *Engine) checkHealthFactor(ctx context.Context, rule models.AutomationRule) (bool, error) {
-	config := rule.TriggerConfig
-	if config == nil {
-		return false, fmt.Errorf("trigger config is nil")
-	}
-
-	threshold, ok := config["threshold"].(float64)
-	if !ok {
-		return false, fmt.Errorf("threshold not specified in trigger config")
-	}
-
-	protocol, ok := config["protocol"].(string)
-	if !ok {
-		protocol = "aave" // Default
+func (e *Engine) checkHealthFactor(ctx context.Context, rule models.AutomationRule) (bool, float64, error) {
+	var config rules.HealthFactorTrigger
+	if err := rules.Decode(rule.TriggerConfig, &config); err != nil {
+		return false, 0, err
 	}
 
 	// Get user from rule
 	var user models.User
 	if err := database.DB.First(&user, rule.UserID).Error; err != nil {
-		return false, err
-	}
-
-	chain := "ethereum"
-	if c, ok := config["chain"].(string); ok {
-		chain = c
+		return false, 0, err
 	}
 
 	// Fetch health factor from DeFi service
-	url := fmt.Sprintf("%s/api/v1/protocols/%s/health-factor?user_address=%s&chain=%s",
-		e.defiServiceURL, protocol, user.WalletAddress, chain)
-	resp, err := e.httpClient.Get(url)
+	healthFactor, err := e.source.HealthFactor(ctx, config.Protocol, config.Chain, user.WalletAddress)
 	if err != nil {
-		return false, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("failed to fetch health factor: status %d", resp.StatusCode)
-	}
-
-	var result struct {
-		HealthFactor float64 `json:"health_factor"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return false, err
+		return false, 0, err
 	}
+	observe(ctx, "health_factor", healthFactor)
 
 	// Check if health factor is below threshold
-	return result.HealthFactor < threshold, nil
+	return healthFactor < config.Threshold, healthFactor, nil
 }
 
 // checkRiskThreshold checks if risk exceeds threshold
-func (e *Engine) checkRiskThreshold(ctx context.Context, rule models.AutomationRule) (bool, error) {
-	config := rule.TriggerConfig
-	if config == nil {
-		return false, fmt.Errorf("trigger config is nil")
-	}
-
-	threshold, ok := config["threshold"].(float64)
-	if !ok {
-		return false, fmt.Errorf("threshold not specified in trigger config")
+func (e *Engine) checkRiskThreshold(ctx context.Context, rule models.AutomationRule) (bool, float64, error) {
+	var config rules.RiskThresholdTrigger
+	if err := rules.Decode(rule.TriggerConfig, &config); err != nil {
+		return false, 0, err
 	}
 
 	// Get user from rule
 	var user models.User
 	if err := database.DB.First(&user, rule.UserID).Error; err != nil {
-		return false, err
-	}
-
-	// Fetch risk forecast from ML service
-	// This is a simplified version - in production, you'd fetch actual positions
-	url := fmt.Sprintf("%s/api/v1/risk/forecast", e.mlServiceURL)
-	reqBody := map[string]interface{}{
-		"user_address": user.WalletAddress,
-		"positions":    []interface{}{},
+		return false, 0, err
 	}
 
-	reqJSON, err := json.Marshal(reqBody)
+	// Fetch risk forecast of the user's positions from ML service
+	risk, err := e.source.LiquidationRisk(ctx, user.ID, config.Chain, user.WalletAddress)
 	if err != nil {
-		return false, err
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqJSON))
-	if err != nil {
-		return false, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := e.httpClient.Do(req)
-	if err != nil {
-		return false, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("failed to fetch risk forecast: status %d", resp.StatusCode)
-	}
-
-	var result struct {
-		LiquidationRisk float64 `json:"liquidation_risk"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return false, err
+		return false, 0, err
 	}
+	observe(ctx, "liquidation_risk", risk)
 
 	// Check if risk exceeds threshold
-	return result.LiquidationRisk > threshold, nil
+	return risk > config.Threshold, risk, nil
 }
 
 // executeAction executes the action specified in the rule
@@ -280,25 +390,24 @@ func (e *Engine) executeAction(ctx context.Context, rule models.AutomationRule)
 		return e.executeWithdraw(ctx, rule)
 	case "deposit":
 		return e.executeDeposit(ctx, rule)
+	case "deleverage":
+		return e.executeDeleverage(ctx, rule)
 	default:
 		return fmt.Errorf("unknown action type: %s", rule.ActionType)
 	}
 }
 
-// executeRebalance executes a rebalancing action
+// executeRebalance moves funds between protocols. When the target asset
+// differs, the withdrawn funds are swapped and the swap's minimum output is
+// deposited, so the deposit can't spend more than the swap is guaranteed to
+// return.
 func (e *Engine) executeRebalance(ctx context.Context, rule models.AutomationRule) error {
-	config := rule.ActionConfig
-	if config == nil {
-		return fmt.Errorf("action config is nil")
+	var config rules.RebalanceAction
+	if err := rules.Decode(rule.ActionConfig, &config); err != nil {
+		return err
 	}
-
-	fromProtocol, _ := config["from_protocol"].(string)
-	toProtocol, _ := config["to_protocol"].(string)
-	asset, _ := config["asset"].(string)
-	amount, _ := config["amount"].(float64)
-
-	log.Printf("Executing rebalance: %s from %s to %s, amount: %f %s",
-		asset, fromProtocol, toProtocol, amount, asset)
+	log.Printf("Executing rebalance: %s from %s to %s %s, amount: %f %s",
+		config.Asset, config.FromProtocol, config.ToProtocol, config.ToAsset, config.Amount, config.Asset)
 
 	// Get user
 	var user models.User
@@ -306,38 +415,235 @@ func (e *Engine) executeRebalance(ctx context.Context, rule models.AutomationRul
 		return err
 	}
 
-	// TODO: Implement actual rebalancing logic
+	if config.Bridges() {
+		return e.executeBridgeRebalance(ctx, rule, config, user)
+	}
+
+	plan, err := e.rebalancePlan(ctx, config, user.WalletAddress)
+	if err != nil {
+		return err
+	}
+
+	// Hand the transactions to the user
+	return e.submitPlan(ctx, rule, user, plan)
+}
+
+// rebalancePlan builds the steps of a rebalance on a single chain: withdraw
+// from the source protocol, swap to the target asset when it differs, and
+// deposit what the swap is guaranteed to return
+func (e *Engine) rebalancePlan(ctx context.Context, config rules.RebalanceAction, wallet string) (actionPlan, error) {
+	asset, amount, chain := config.Asset, config.Amount, config.Chain
+
 	// 1. Withdraw from source protocol
-	// 2. Deposit to target protocol
-	// 3. Record transaction
+	withdraw, err := e.fetchProtocolCalls(ctx, config.FromProtocol, "withdraw", asset, amount, chain, wallet)
+	if err != nil {
+		return actionPlan{}, err
+	}
 
-	// For now, just log the action
-	log.Printf("Rebalancing executed for user %s", user.WalletAddress)
+	plan := actionPlan{Chain: chain, MaxDeviation: config.MaxDeviation, AllowUntraced: config.AllowUntraced}
+	for _, call := range withdraw.Calls {
+		plan.Steps = append(plan.Steps, planStep{
+			Call:   call,
+			Expect: &expectation{Token: withdraw.Token, Amount: amount},
+		})
+	}
 
-	return nil
+	// 2. Swap to the target asset
+	depositAmount := amount
+	if config.Swaps() {
+		swap, err := e.fetchSwapCalls(ctx, chain, asset, config.ToAsset, amount, config.SlippageBps, wallet)
+		if err != nil {
+			return actionPlan{}, err
+		}
+		observe(ctx, "swap_amount_out", swap.AmountOut)
+		observe(ctx, "swap_min_amount_out", swap.MinAmountOut)
+		for _, call := range swap.Plan.Calls {
+			plan.Steps = append(plan.Steps, planStep{
+				Call:     call,
+				Expect:   &expectation{Token: withdraw.Token, Amount: -amount},
+				Balances: map[string]string{withdraw.Token: withdraw.Amount},
+			})
+		}
+		depositAmount = swap.MinAmountOut
+	}
+
+	// 3. Deposit to target protocol
+	deposit, err := e.fetchProtocolCalls(ctx, config.ToProtocol, "deposit", config.ToAsset, depositAmount, chain, wallet)
+	if err != nil {
+		return actionPlan{}, err
+	}
+	for _, call := range deposit.Calls {
+		// The deposit spends what the earlier steps return, so simulate it as
+		// if they had already landed
+		plan.Steps = append(plan.Steps, planStep{
+			Call:     call,
+			Expect:   &expectation{Token: deposit.Token, Amount: -depositAmount},
+			Balances: map[string]string{deposit.Token: deposit.Amount},
+		})
+	}
+	return plan, nil
 }
 
 // executeWithdraw executes a withdrawal action
 func (e *Engine) executeWithdraw(ctx context.Context, rule models.AutomationRule) error {
-	config := rule.ActionConfig
-	if config == nil {
-		return fmt.Errorf("action config is nil")
+	var config rules.WithdrawAction
+	if err := rules.Decode(rule.ActionConfig, &config); err != nil {
+		return err
 	}
 
 	log.Printf("Executing withdraw action for rule %d", rule.ID)
-	// TODO: Implement withdrawal logic
-	return nil
+	return e.executeSingle(ctx, rule, singleAction{
+		Action:        "withdraw",
+		Protocol:      config.Protocol,
+		Asset:         config.Asset,
+		Chain:         config.Chain,
+		Amount:        config.Amount,
+		MaxDeviation:  config.MaxDeviation,
+		AllowUntraced: config.AllowUntraced,
+	})
 }
 
 // executeDeposit executes a deposit action
 func (e *Engine) executeDeposit(ctx context.Context, rule models.AutomationRule) error {
-	config := rule.ActionConfig
-	if config == nil {
-		return fmt.Errorf("action config is nil")
+	var config rules.DepositAction
+	if err := rules.Decode(rule.ActionConfig, &config); err != nil {
+		return err
 	}
 
 	log.Printf("Executing deposit action for rule %d", rule.ID)
-	// TODO: Implement deposit logic
-	return nil
+	return e.executeSingle(ctx, rule, singleAction{
+		Action:        "deposit",
+		Protocol:      config.Protocol,
+		Asset:         config.Asset,
+		Chain:         config.Chain,
+		Amount:        config.Amount,
+		AllowPartial:  config.AllowPartial,
+		MaxDeviation:  config.MaxDeviation,
+		AllowUntraced: config.AllowUntraced,
+	})
 }
 
+// executeDeleverage repays enough debt to bring the user's health factor up
+// to the target. Repaying from the wallet is limited to what it holds, since
+// a partial repayment still moves the position away from liquidation.
+func (e *Engine) executeDeleverage(ctx context.Context, rule models.AutomationRule) error {
+	var config rules.DeleverageAction
+	if err := rules.Decode(rule.ActionConfig, &config); err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, rule.UserID).Error; err != nil {
+		return err
+	}
+
+	maxAmount := config.MaxAmount
+	if config.Source == "wallet" {
+		token, ok := tokens.Lookup(config.Chain, config.DebtAsset)
+		if !ok {
+			return fmt.Errorf("asset %s not supported on %s", config.DebtAsset, config.Chain)
+		}
+		available, err := e.availableBalance(ctx, config.Chain, user.WalletAddress, token.Address)
+		if err != nil {
+			return err
+		}
+		if available <= 0 {
+			return fmt.Errorf("no %s in the wallet to repay debt with", token.Symbol)
+		}
+		if maxAmount == 0 || available < maxAmount {
+			maxAmount = available
+		}
+	}
+
+	plan, err := e.fetchDeleveragePlan(ctx, config.Protocol, config, maxAmount, user.WalletAddress)
+	if err != nil {
+		return err
+	}
+	observe(ctx, "health_factor_before", plan.HealthFactor)
+	observe(ctx, "projected_health_factor", plan.ProjectedHealthFactor)
+	for _, w := range plan.Warnings {
+		log.Printf("Rule %d: deleverage: %s", rule.ID, w)
+	}
+	if plan.RepayAmount == 0 {
+		log.Printf("Rule %d: health factor %.4f needs no repayment to reach %.4f", rule.ID, plan.HealthFactor, config.TargetHealthFactor)
+		return nil
+	}
+
+	log.Printf("Executing deleverage for rule %d: repay %f %s from %s, health factor %.4f -> %.4f",
+		rule.ID, plan.RepayAmount, plan.Asset, config.Source, plan.HealthFactor, plan.ProjectedHealthFactor)
+
+	// Repaying from the wallet spends the debt asset; repaying with supplied
+	// collateral burns aTokens and leaves the wallet's balance alone
+	actions := actionPlan{Chain: config.Chain, MaxDeviation: config.MaxDeviation, AllowUntraced: config.AllowUntraced}
+	for _, call := range plan.Calls {
+		step := planStep{Call: call}
+		if config.Source == "wallet" {
+			step.Expect = &expectation{Token: plan.Token, Amount: -plan.RepayAmount}
+		}
+		actions.Steps = append(actions.Steps, step)
+	}
+
+	return e.submitPlan(ctx, rule, user, actions)
+}
+
+// singleAction is a deposit into or withdrawal from one protocol
+type singleAction struct {
+	Action        string // deposit, withdraw
+	Protocol      string
+	Asset         string
+	Chain         string
+	Amount        float64
+	AllowPartial  bool
+	MaxDeviation  float64
+	AllowUntraced bool
+}
+
+// executeSingle builds and submits a one-protocol deposit or withdrawal
+func (e *Engine) executeSingle(ctx context.Context, rule models.AutomationRule, single singleAction) error {
+	action, protocol, asset, chain, amount := single.Action, single.Protocol, single.Asset, single.Chain, single.Amount
+
+	// The direction the sender's token balance is expected to move
+	sign := 1.0
+	if action == "deposit" {
+		sign = -1
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, rule.UserID).Error; err != nil {
+		return err
+	}
+
+	if action == "deposit" {
+		token, ok := tokens.Lookup(chain, asset)
+		if !ok {
+			return fmt.Errorf("asset %s not supported on %s", asset, chain)
+		}
+		available, err := e.availableBalance(ctx, chain, user.WalletAddress, token.Address)
+		if err != nil {
+			return err
+		}
+		if available < amount {
+			// allow_partial deposits whatever the wallet holds instead of failing
+			if !single.AllowPartial || available <= 0 {
+				return fmt.Errorf("insufficient %s balance: %f available, %f required", token.Symbol, available, amount)
+			}
+			log.Printf("Rule %d: depositing available %f %s instead of %f", rule.ID, available, token.Symbol, amount)
+			amount = available
+		}
+	}
+
+	calls, err := e.fetchProtocolCalls(ctx, protocol, action, asset, amount, chain, user.WalletAddress)
+	if err != nil {
+		return err
+	}
+
+	plan := actionPlan{Chain: chain, MaxDeviation: single.MaxDeviation, AllowUntraced: single.AllowUntraced}
+	for _, call := range calls.Calls {
+		plan.Steps = append(plan.Steps, planStep{
+			Call:   call,
+			Expect: &expectation{Token: calls.Token, Amount: sign * amount},
+		})
+	}
+
+	return e.submitPlan(ctx, rule, user, plan)
+}
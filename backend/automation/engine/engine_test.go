@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/defioptimization/shared/clients"
+	"github.com/defioptimization/shared/rules"
+)
+
+func TestRebalancePlan(t *testing.T) {
+	const (
+		wallet = "0x1111111111111111111111111111111111111111"
+		usdc   = "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+		usdt   = "0xdAC17F958D2ee523a2206206994597C13D831ec7"
+	)
+	tokenOf := map[string]string{"USDC": usdc, "USDT": usdt}
+
+	// The DeFi service quotes 1000 USDC at 999.5 USDT, at least 994.5 after slippage
+	var requests []string
+	var deposited float64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/swaps/transactions" {
+			var req clients.SwapRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			requests = append(requests, "swap "+req.TokenIn+" "+req.TokenOut)
+			if req.Amount != 1000 || req.SlippageBps != 50 || req.UserAddress != wallet {
+				t.Errorf("swap request = %+v", req)
+			}
+			var swap clients.SwapCalls
+			swap.TokenIn, swap.TokenOut = usdc, usdt
+			swap.AmountIn, swap.AmountOut, swap.MinAmountOut = 1000, 999.5, 994.5
+			swap.Plan.SlippageBps = 50
+			swap.Plan.MinAmountOut = "994500000"
+			swap.Plan.Calls = []clients.Call{{To: "0xrouter", Data: "0xswap", Value: "0"}}
+			json.NewEncoder(w).Encode(swap)
+			return
+		}
+
+		protocol := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/protocols/"), "/transactions")
+		var req clients.TransactionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req.Action+" "+protocol+" "+req.Asset)
+		if req.Action == "deposit" {
+			deposited = req.Amount
+		}
+		json.NewEncoder(w).Encode(clients.ProtocolCalls{
+			Protocol: protocol,
+			Asset:    req.Asset,
+			Token:    tokenOf[req.Asset],
+			Amount:   "base units",
+			Calls:    []clients.Call{{To: "0x" + protocol, Data: "0x" + req.Action, Value: "0"}},
+		})
+	}))
+	defer srv.Close()
+
+	e := &Engine{defi: clients.NewDeFi(srv.URL)}
+
+	t.Run("cross asset", func(t *testing.T) {
+		requests, deposited = nil, 0
+		plan, err := e.rebalancePlan(context.Background(), rules.RebalanceAction{
+			FromProtocol: "aave", ToProtocol: "compound",
+			Asset: "USDC", ToAsset: "USDT",
+			Chain: "ethereum", ToChain: "ethereum",
+			Amount: 1000, SlippageBps: 50,
+		}, wallet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"withdraw aave USDC", "swap USDC USDT", "deposit compound USDT"}
+		if !reflect.DeepEqual(requests, want) {
+			t.Fatalf("requests = %v, want %v", requests, want)
+		}
+		// Only what the swap is guaranteed to return is deposited
+		if deposited != 994.5 {
+			t.Fatalf("deposited %v, want the swap's minimum output 994.5", deposited)
+		}
+
+		if len(plan.Steps) != 3 || plan.Chain != "ethereum" {
+			t.Fatalf("plan = %+v", plan)
+		}
+		withdraw, swap, deposit := plan.Steps[0], plan.Steps[1], plan.Steps[2]
+		if withdraw.Call.Data != "0xwithdraw" || *withdraw.Expect != (expectation{Token: usdc, Amount: 1000}) || withdraw.Balances != nil {
+			t.Errorf("withdraw step = %+v", withdraw)
+		}
+		if swap.Call.Data != "0xswap" || *swap.Expect != (expectation{Token: usdc, Amount: -1000}) {
+			t.Errorf("swap step = %+v", swap)
+		}
+		// Later steps are simulated with the funds the earlier ones return
+		if !reflect.DeepEqual(swap.Balances, map[string]string{usdc: "base units"}) {
+			t.Errorf("swap balances = %v", swap.Balances)
+		}
+		if deposit.Call.Data != "0xdeposit" || *deposit.Expect != (expectation{Token: usdt, Amount: -994.5}) {
+			t.Errorf("deposit step = %+v", deposit)
+		}
+		if !reflect.DeepEqual(deposit.Balances, map[string]string{usdt: "base units"}) {
+			t.Errorf("deposit balances = %v", deposit.Balances)
+		}
+	})
+
+	t.Run("same asset", func(t *testing.T) {
+		requests, deposited = nil, 0
+		plan, err := e.rebalancePlan(context.Background(), rules.RebalanceAction{
+			FromProtocol: "aave", ToProtocol: "compound",
+			Asset: "USDC", ToAsset: "USDC",
+			Chain: "ethereum", ToChain: "ethereum",
+			Amount: 1000,
+		}, wallet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"withdraw aave USDC", "deposit compound USDC"}
+		if !reflect.DeepEqual(requests, want) {
+			t.Fatalf("requests = %v, want %v", requests, want)
+		}
+		if deposited != 1000 || len(plan.Steps) != 2 {
+			t.Fatalf("deposited %v in %d steps, want 1000 in 2", deposited, len(plan.Steps))
+		}
+	})
+}
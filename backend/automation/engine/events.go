@@ -0,0 +1,292 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/defioptimization/automation/events"
+	"github.com/defioptimization/shared/clients"
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/shared/rules"
+	"github.com/defioptimization/shared/tokens"
+)
+
+// eventQueueSize bounds the events waiting for evaluation. When it is full
+// further events are refused, and the subscriber delivers them again later.
+const eventQueueSize = 256
+
+// holdingsTTL is how long the assets an account was seen holding are trusted
+// for matching price events. Position events on the account clear them sooner.
+const holdingsTTL = 10 * time.Minute
+
+// HandleEvent queues an on-chain event for evaluation of the rules it
+// affects, and reports false if the queue is full
+func (e *Engine) HandleEvent(ev events.Event) bool {
+	select {
+	case e.events <- ev:
+		return true
+	default:
+		log.Printf("Event queue full, refusing %s event on %s (block %d)", ev.Source, ev.Chain, ev.BlockNumber)
+		return false
+	}
+}
+
+// processEvent evaluates only the rules an event can affect. Only one
+// replica follows each chain, so events aren't limited to this worker's
+// shard; the rule locks keep evaluations from overlapping with other workers.
+func (e *Engine) processEvent(ctx context.Context, ev events.Event) {
+	query := database.DB.WithContext(ctx).Where("enabled = ?", true)
+
+	switch ev.Kind {
+	case events.KindRates:
+		query = query.Where("trigger_type IN ?", []string{"apy_drop", "apy_spread", "utilization", "composite"})
+	case events.KindPrice:
+		query = query.Where("trigger_type IN ?", []string{"health_factor", "risk_threshold", "price_change", "composite"})
+	case events.KindPosition:
+		e.holdings.forget(ev.Chain, ev.Users)
+		userIDs, err := usersByWallet(ev.Users)
+		if err != nil {
+			log.Printf("Error resolving users for %s event: %v", ev.Source, err)
+			return
+		}
+		if len(userIDs) == 0 {
+			return
+		}
+		query = query.Where("user_id IN ? AND trigger_type IN ?", userIDs, []string{"health_factor", "risk_threshold", "composite"})
+	default:
+		return
+	}
+
+	var rules []models.AutomationRule
+	if err := query.Find(&rules).Error; err != nil {
+		log.Printf("Error fetching automation rules: %v", err)
+		return
+	}
+
+	exposed := func(uint, string) bool { return false }
+	if ev.Kind == events.KindPrice {
+		exposed = e.priceExposure(ctx, ev, rules)
+	}
+
+	var affected []models.AutomationRule
+	for _, rule := range rules {
+		if ruleAffectedBy(rule, ev, exposed) {
+			log.Printf("Rule %d: evaluating after %s on %s (block %d)", rule.ID, ev.Source, ev.Chain, ev.BlockNumber)
+			affected = append(affected, rule)
+		}
+	}
+	e.evaluateAll(ctx, affected, ev.Source)
+}
+
+// exposure reports whether a user holds the asset of a price event on a
+// protocol, or on any protocol when protocol is empty
+type exposure func(userID uint, protocol string) bool
+
+// ruleAffectedBy reports whether a rule's trigger reads state an event changed
+func ruleAffectedBy(rule models.AutomationRule, ev events.Event, exposed exposure) bool {
+	if rule.TriggerType == "composite" {
+		root, err := rules.ParseCondition(rule.TriggerConfig["condition"])
+		if err != nil {
+			return false
+		}
+		for _, c := range root.Leaves() {
+			leaf := rule
+			leaf.TriggerType = c.Type
+			leaf.TriggerConfig = c.Config
+			if ruleAffectedBy(leaf, ev, exposed) {
+				return true
+			}
+		}
+		return false
+	}
+
+	chain := configChain(rule.TriggerConfig)
+	if chain != ev.Chain {
+		return false
+	}
+
+	switch ev.Kind {
+	case events.KindRates:
+		switch rule.TriggerType {
+		case "apy_drop", "utilization":
+			protocol, _ := rule.TriggerConfig["protocol"].(string)
+			return protocol == ev.Protocol && sameAsset(chain, rule.TriggerConfig, ev.Asset)
+		case "apy_spread":
+			// Any protocol's rates move the spread
+			return sameAsset(chain, rule.TriggerConfig, ev.Asset)
+		}
+		return false
+	case events.KindPosition:
+		if rule.TriggerType != "health_factor" && rule.TriggerType != "risk_threshold" {
+			return false
+		}
+		// Only risk_threshold rules span protocols
+		return rule.TriggerType == "risk_threshold" || triggerProtocol(rule) == ev.Protocol
+	case events.KindPrice:
+		// A price only moves the health factor and risk of positions in the asset
+		switch rule.TriggerType {
+		case "price_change":
+			return sameAsset(chain, rule.TriggerConfig, ev.Asset)
+		case "health_factor":
+			return exposed(rule.UserID, triggerProtocol(rule))
+		case "risk_threshold":
+			return exposed(rule.UserID, "")
+		}
+	}
+	return false
+}
+
+// triggerProtocol returns the protocol a health_factor trigger watches
+func triggerProtocol(rule models.AutomationRule) string {
+	if protocol, ok := rule.TriggerConfig["protocol"].(string); ok {
+		return protocol
+	}
+	return "aave"
+}
+
+// priceExposure returns the exposure of the rules' owners to the asset of a
+// price event. When their positions can't be read the rules are treated as
+// exposed; a needless evaluation is cheaper than a missed liquidation.
+func (e *Engine) priceExposure(ctx context.Context, ev events.Event, rules []models.AutomationRule) exposure {
+	var ids []uint
+	for _, rule := range rules {
+		ids = append(ids, rule.UserID)
+	}
+	var users []models.User
+	if len(ids) > 0 {
+		if err := database.DB.WithContext(ctx).Select("id", "wallet_address").Where("id IN ?", ids).Find(&users).Error; err != nil {
+			log.Printf("Error resolving rule owners for %s event: %v", ev.Source, err)
+			return func(uint, string) bool { return true }
+		}
+	}
+	wallets := make(map[uint]string, len(users))
+	for _, u := range users {
+		wallets[u.ID] = u.WalletAddress
+	}
+
+	return func(userID uint, protocol string) bool {
+		wallet, ok := wallets[userID]
+		if !ok {
+			return false
+		}
+		held, err := e.holdings.holds(ctx, ev.Chain, wallet, protocol, ev.Asset)
+		if err != nil {
+			log.Printf("Error reading positions of %s on %s: %v", wallet, ev.Chain, err)
+			return true
+		}
+		return held
+	}
+}
+
+// holdings caches the assets accounts hold on each protocol
+type holdings struct {
+	defi *clients.DeFi
+
+	mu       sync.Mutex
+	accounts map[string]heldAssets // chain|account
+}
+
+type heldAssets struct {
+	assets    map[string]map[string]bool // protocol -> token symbols
+	fetchedAt time.Time
+}
+
+func newHoldings(defi *clients.DeFi) *holdings {
+	return &holdings{defi: defi, accounts: make(map[string]heldAssets)}
+}
+
+// holds reports whether an account has a position in the asset on the
+// protocol, or on any protocol when protocol is empty
+func (h *holdings) holds(ctx context.Context, chain, account, protocol, symbol string) (bool, error) {
+	key := chain + "|" + strings.ToLower(account)
+
+	h.mu.Lock()
+	held, ok := h.accounts[key]
+	h.mu.Unlock()
+
+	if !ok || time.Since(held.fetchedAt) >= holdingsTTL {
+		portfolio, err := h.defi.Portfolio(ctx, account, chain)
+		if err != nil {
+			return false, err
+		}
+		held = heldAssets{assets: make(map[string]map[string]bool), fetchedAt: time.Now()}
+		for _, p := range portfolio.Protocols {
+			if p.Error != "" {
+				return false, fmt.Errorf("failed to fetch %s positions: %s", p.Protocol, p.Error)
+			}
+			assets := make(map[string]bool)
+			for _, position := range p.Positions {
+				assets[tokenSymbol(chain, position.Asset)] = true
+			}
+			held.assets[p.Protocol] = assets
+		}
+
+		h.mu.Lock()
+		h.accounts[key] = held
+		h.mu.Unlock()
+	}
+
+	if protocol != "" {
+		return held.assets[protocol][symbol], nil
+	}
+	for _, assets := range held.assets {
+		if assets[symbol] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// forget drops the cached assets of accounts whose positions changed
+func (h *holdings) forget(chain string, accounts []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, a := range accounts {
+		delete(h.accounts, chain+"|"+strings.ToLower(a))
+	}
+}
+
+// tokenSymbol resolves an asset alias such as ETH to its registry symbol
+func tokenSymbol(chain, asset string) string {
+	if t, ok := tokens.Lookup(chain, asset); ok {
+		return t.Symbol
+	}
+	return strings.ToUpper(asset)
+}
+
+// configChain returns the chain a trigger config targets
+func configChain(config map[string]interface{}) string {
+	if c, ok := config["chain"].(string); ok {
+		return c
+	}
+	return "ethereum"
+}
+
+// sameAsset reports whether a trigger config's asset is the event's asset
+func sameAsset(chain string, config map[string]interface{}, symbol string) bool {
+	asset, _ := config["asset"].(string)
+	token, ok := tokens.Lookup(chain, asset)
+	return ok && token.Symbol == symbol
+}
+
+// usersByWallet returns the IDs of users owning any of the addresses
+func usersByWallet(addresses []string) ([]uint, error) {
+	if len(addresses) == 0 {
+		return nil, nil
+	}
+	lower := make([]string, len(addresses))
+	for i, a := range addresses {
+		lower[i] = strings.ToLower(a)
+	}
+
+	var ids []uint
+	err := database.DB.Model(&models.User{}).
+		Where("LOWER(wallet_address) IN ?", lower).
+		Pluck("id", &ids).Error
+	return ids, err
+}
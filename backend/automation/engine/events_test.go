@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/defioptimization/automation/events"
+	"github.com/defioptimization/shared/clients"
+	"github.com/defioptimization/shared/models"
+)
+
+func TestRuleAffectedByPrice(t *testing.T) {
+	ev := events.Event{Chain: "ethereum", Kind: events.KindPrice, Asset: "WETH", Source: "AnswerUpdated"}
+
+	// User 1 holds WETH on Aave, user 2 holds it on Compound only
+	exposed := func(userID uint, protocol string) bool {
+		switch userID {
+		case 1:
+			return protocol == "" || protocol == "aave"
+		case 2:
+			return protocol == "" || protocol == "compound"
+		}
+		return false
+	}
+
+	tests := []struct {
+		name string
+		rule models.AutomationRule
+		want bool
+	}{
+		{"price_change on the asset", models.AutomationRule{UserID: 3, TriggerType: "price_change", TriggerConfig: map[string]interface{}{"asset": "ETH", "window": "1h"}}, true},
+		{"price_change on another asset", models.AutomationRule{UserID: 3, TriggerType: "price_change", TriggerConfig: map[string]interface{}{"asset": "USDC", "window": "1h"}}, false},
+		{"health_factor of a holder", models.AutomationRule{UserID: 1, TriggerType: "health_factor", TriggerConfig: map[string]interface{}{"threshold": 1.3}}, true},
+		{"health_factor on a protocol without the asset", models.AutomationRule{UserID: 2, TriggerType: "health_factor", TriggerConfig: map[string]interface{}{"threshold": 1.3}}, false},
+		{"health_factor on the holding protocol", models.AutomationRule{UserID: 2, TriggerType: "health_factor", TriggerConfig: map[string]interface{}{"threshold": 1.3, "protocol": "compound"}}, true},
+		{"health_factor of a non-holder", models.AutomationRule{UserID: 3, TriggerType: "health_factor", TriggerConfig: map[string]interface{}{"threshold": 1.3}}, false},
+		{"risk_threshold of a holder", models.AutomationRule{UserID: 2, TriggerType: "risk_threshold", TriggerConfig: map[string]interface{}{"threshold": 0.5}}, true},
+		{"risk_threshold of a non-holder", models.AutomationRule{UserID: 3, TriggerType: "risk_threshold", TriggerConfig: map[string]interface{}{"threshold": 0.5}}, false},
+		{"risk_threshold on another chain", models.AutomationRule{UserID: 1, TriggerType: "risk_threshold", TriggerConfig: map[string]interface{}{"threshold": 0.5, "chain": "base"}}, false},
+		{"composite with a holder's health_factor", models.AutomationRule{UserID: 1, TriggerType: "composite", TriggerConfig: map[string]interface{}{
+			"condition": map[string]interface{}{"and": []interface{}{
+				map[string]interface{}{"type": "health_factor", "threshold": 1.3},
+				map[string]interface{}{"type": "gas_below", "threshold": 20.0},
+			}},
+		}}, true},
+		{"composite with a non-holder's health_factor", models.AutomationRule{UserID: 3, TriggerType: "composite", TriggerConfig: map[string]interface{}{
+			"condition": map[string]interface{}{"and": []interface{}{
+				map[string]interface{}{"type": "health_factor", "threshold": 1.3},
+				map[string]interface{}{"type": "gas_below", "threshold": 20.0},
+			}},
+		}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ruleAffectedBy(tt.rule, ev, exposed); got != tt.want {
+				t.Fatalf("ruleAffectedBy = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHoldings(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"protocols":[
+			{"protocol":"aave","positions":[{"asset":"ETH","type":"lending","amount":2},{"asset":"USDC","type":"borrowing","amount":1000}]},
+			{"protocol":"compound","positions":[]}
+		]}`))
+	}))
+	defer srv.Close()
+
+	h := newHoldings(clients.NewDeFi(srv.URL))
+	ctx := context.Background()
+	const account = "0x1111111111111111111111111111111111111111"
+
+	tests := []struct {
+		protocol, symbol string
+		want             bool
+	}{
+		{"aave", "WETH", true}, // ETH resolves to WETH
+		{"aave", "USDC", true},
+		{"aave", "DAI", false},
+		{"compound", "WETH", false},
+		{"", "USDC", true},
+		{"", "DAI", false},
+	}
+	for _, tt := range tests {
+		got, err := h.holds(ctx, "ethereum", account, tt.protocol, tt.symbol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("holds(%q, %q) = %v, want %v", tt.protocol, tt.symbol, got, tt.want)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("read positions %d times, want once", requests)
+	}
+
+	// A position event on the account reads its positions again
+	h.forget("ethereum", []string{"0x1111111111111111111111111111111111111111"})
+	if _, err := h.holds(ctx, "ethereum", account, "", "USDC"); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Fatalf("read positions %d times after forget, want twice", requests)
+	}
+}
@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+)
+
+const (
+	// executionRetention is how long rule execution records are kept
+	executionRetention = 30 * 24 * time.Hour
+	// pruneInterval is how often expired execution records are deleted
+	pruneInterval = time.Hour
+)
+
+type auditKey struct{}
+type auditScopeKey struct{}
+
+// audit collects the inputs a rule evaluation read and the transactions it
+// requested, for the rule's execution record
+type audit struct {
+	mu     sync.Mutex
+	inputs map[string]interface{}
+	steps  []models.ExecutionStep
+}
+
+// withAudit returns a context whose observations are collected in the
+// returned audit
+func withAudit(ctx context.Context) (context.Context, *audit) {
+	a := &audit{inputs: make(map[string]interface{})}
+	return context.WithValue(ctx, auditKey{}, a), a
+}
+
+// withAuditScope prefixes the names of inputs observed under the returned
+// context, so leaves of a composite trigger don't overwrite each other
+func withAuditScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, auditScopeKey{}, scope)
+}
+
+// observe records a trigger input
+func observe(ctx context.Context, name string, value interface{}) {
+	a, _ := ctx.Value(auditKey{}).(*audit)
+	if a == nil {
+		return
+	}
+	if scope, ok := ctx.Value(auditScopeKey{}).(string); ok {
+		name = scope + "." + name
+	}
+	a.mu.Lock()
+	a.inputs[name] = value
+	a.mu.Unlock()
+}
+
+// recordStep records a transaction the evaluation asked the wallet to sign
+func recordStep(ctx context.Context, step models.ExecutionStep) {
+	a, _ := ctx.Value(auditKey{}).(*audit)
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.steps = append(a.steps, step)
+	a.mu.Unlock()
+}
+
+// fill copies the collected inputs and steps into an execution record
+func (a *audit) fill(exec *models.RuleExecution) {
+	a.mu.Lock()
+	exec.Inputs = a.inputs
+	exec.Actions = a.steps
+	a.mu.Unlock()
+}
+
+// saveExecution stores an execution record. Failing to store it never fails
+// the evaluation.
+func saveExecution(exec *models.RuleExecution, a *audit) {
+	a.fill(exec)
+	if err := database.DB.Create(exec).Error; err != nil {
+		log.Printf("Error recording execution of rule %d: %v", exec.RuleID, err)
+	}
+}
+
+// pruneExecutions deletes execution records, and action jobs that finished,
+// older than the retention period, at most once per pruneInterval
+func (e *Engine) pruneExecutions(now time.Time) {
+	if now.Sub(e.lastPrune) < pruneInterval {
+		return
+	}
+	e.lastPrune = now
+
+	result := database.DB.Where("created_at < ?", now.Add(-executionRetention)).Delete(&models.RuleExecution{})
+	if result.Error != nil {
+		log.Printf("Error pruning rule executions: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Pruned %d rule executions older than %s", result.RowsAffected, executionRetention)
+	}
+
+	result = database.DB.Where("completed_at < ?", now.Add(-executionRetention)).Delete(&models.ActionJob{})
+	if result.Error != nil {
+		log.Printf("Error pruning action jobs: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Pruned %d action jobs older than %s", result.RowsAffected, executionRetention)
+	}
+}
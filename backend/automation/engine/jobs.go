@@ -0,0 +1,384 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/defioptimization/shared/clients"
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// defaultJobWorkers is the number of action jobs run concurrently
+	defaultJobWorkers = 4
+	// maxJobAttempts is how many times a job runs before it is dead-lettered
+	maxJobAttempts = 5
+	// jobBackoff is the delay before a job's first retry; it doubles with
+	// every further attempt up to maxJobBackoff
+	jobBackoff    = 30 * time.Second
+	maxJobBackoff = 30 * time.Minute
+	// jobPollInterval is how often idle job workers look for due jobs
+	jobPollInterval = 2 * time.Second
+	// stuckJobAge is how long a job may run, or wait past its due time,
+	// before it counts as stuck. Running jobs past it are taken back from
+	// their worker, which is assumed to have stopped.
+	stuckJobAge = 15 * time.Minute
+	// maxListedJobs bounds the stuck and dead jobs listed in queue stats
+	maxListedJobs = 50
+)
+
+// ErrJobNotRetryable is returned when retrying a job that isn't
+// dead-lettered, or that had already dispatched transactions
+var ErrJobNotRetryable = errors.New("only dead jobs that dispatched nothing can be retried")
+
+// errJobReclaimed is returned when a job was taken back from this worker
+// before it dispatched anything
+var errJobReclaimed = errors.New("job was reclaimed by another worker")
+
+// errRuleDeleted fails the jobs of rules deleted after they fired; nothing
+// is sent for them
+var errRuleDeleted = errors.New("rule was deleted")
+
+// ErrJobNotFound is returned when retrying a job that doesn't exist
+var ErrJobNotFound = errors.New("job not found")
+
+type jobKey struct{}
+
+// withJob returns a context under which beginDispatch fences on job
+func withJob(ctx context.Context, job *models.ActionJob) context.Context {
+	return context.WithValue(ctx, jobKey{}, job)
+}
+
+// notifyJobs wakes an idle job worker after a job was queued
+func (e *Engine) notifyJobs() {
+	select {
+	case e.jobsReady <- struct{}{}:
+	default:
+	}
+}
+
+// runJobs runs action jobs on jobWorkers goroutines until the context is
+// cancelled, adding them to wg. Jobs are shared by every replica: each
+// claims due jobs with SKIP LOCKED, so a job is run by one worker at a time.
+func (e *Engine) runJobs(ctx context.Context, wg *sync.WaitGroup) {
+	for i := 0; i < e.jobWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.jobWorker(ctx)
+		}()
+	}
+}
+
+// jobWorker runs due jobs until the queue is empty, then waits for a new job
+// or the next poll. A job that is running when the context is cancelled
+// runs to the end, so shutdown doesn't leave it half done.
+func (e *Engine) jobWorker(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			job, err := claimJob(ctx, e.members.id)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Error claiming action job: %v", err)
+				}
+				break
+			}
+			if job == nil {
+				break
+			}
+			e.runJob(context.WithoutCancel(ctx), job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-e.jobsReady:
+		}
+	}
+}
+
+// claimJob locks the next due job and marks it running for this worker.
+// Jobs locked by other workers are skipped rather than waited for. It
+// returns nil when no job is due.
+func claimJob(ctx context.Context, workerID string) (*models.ActionJob, error) {
+	var job models.ActionJob
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND run_at <= NOW()", models.JobQueued).
+			Order("run_at, id").
+			First(&job).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&job).Updates(map[string]interface{}{
+			"status":    models.JobRunning,
+			"attempts":  gorm.Expr("attempts + 1"),
+			"lease":     gorm.Expr("lease + 1"),
+			"locked_by": workerID,
+			"locked_at": gorm.Expr("NOW()"),
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	job.Status = models.JobRunning
+	job.Attempts++
+	job.Lease++
+	job.LockedBy = workerID
+	return &job, nil
+}
+
+// runJob runs a job's action for its rule and records the outcome. The
+// action is the one the rule had when it fired.
+func (e *Engine) runJob(ctx context.Context, job *models.ActionJob) {
+	log.Printf("Rule %d: running action job %d (attempt %d/%d)", job.RuleID, job.ID, job.Attempts, job.MaxAttempts)
+
+	var rule models.AutomationRule
+	err := database.DB.WithContext(ctx).First(&rule, job.RuleID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = errRuleDeleted
+	}
+
+	var steps []models.ExecutionStep
+	if err == nil {
+		rule.ActionType, rule.ActionConfig = job.ActionType, job.ActionConfig
+		jobCtx, a := withAudit(clients.WithReadCache(ctx))
+		err = e.executeAction(withJob(jobCtx, job), rule)
+		steps = a.steps
+	}
+
+	finishJob(job, steps, err)
+}
+
+// finishJob records a job's outcome. A failed job is queued again with
+// backoff unless it dispatched transactions or ran out of attempts, in which
+// case it is dead-lettered. Updates are fenced on the job's lease, so a
+// worker whose job was taken back as stuck can't overwrite the job's new state.
+func finishJob(job *models.ActionJob, steps []models.ExecutionStep, runErr error) {
+	now := time.Now()
+	job.Steps = steps
+	job.LockedBy, job.LockedAt = "", nil
+	job.LastError = ""
+
+	switch {
+	case runErr == nil:
+		job.Status, job.CompletedAt = models.JobSucceeded, &now
+	case job.Dispatched:
+		job.Status, job.CompletedAt = models.JobDead, &now
+		job.LastError = fmt.Sprintf("failed after dispatching, not retried: %v", runErr)
+	case job.Attempts >= job.MaxAttempts, errors.Is(runErr, errRuleDeleted):
+		job.Status, job.CompletedAt = models.JobDead, &now
+		job.LastError = runErr.Error()
+	default:
+		job.Status, job.RunAt = models.JobQueued, now.Add(retryDelay(job.Attempts))
+		job.LastError = runErr.Error()
+	}
+
+	result := database.DB.Model(job).
+		Where("status = ? AND lease = ?", models.JobRunning, job.Lease).
+		Select("status", "run_at", "steps", "locked_by", "locked_at", "last_error", "completed_at").
+		Updates(job)
+	if result.Error != nil {
+		log.Printf("Error recording outcome of action job %d: %v", job.ID, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		log.Printf("Action job %d was reclaimed while running, outcome discarded", job.ID)
+		return
+	}
+
+	switch job.Status {
+	case models.JobSucceeded:
+		log.Printf("Rule %d: action job %d succeeded", job.RuleID, job.ID)
+	case models.JobDead:
+		log.Printf("Rule %d: action job %d dead-lettered after %d attempts: %s", job.RuleID, job.ID, job.Attempts, job.LastError)
+	default:
+		log.Printf("Rule %d: action job %d failed, retrying at %s: %s", job.RuleID, job.ID, job.RunAt.Format(time.RFC3339), job.LastError)
+	}
+}
+
+// retryDelay is the exponential backoff after a job's attempt, with jitter
+// so jobs that failed together don't all retry at once
+func retryDelay(attempt int) time.Duration {
+	delay := maxJobBackoff
+	if attempt < 16 {
+		if d := jobBackoff << (attempt - 1); d < maxJobBackoff {
+			delay = d
+		}
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay/5)+1))
+}
+
+// beginDispatch marks the job being run as dispatched before the wallet is
+// asked to sign anything. The update is fenced on the job's lease, so if
+// the job was taken back as stuck only one worker can dispatch it. Outside a
+// job, as in a dry run, it does nothing.
+func beginDispatch(ctx context.Context) error {
+	job, _ := ctx.Value(jobKey{}).(*models.ActionJob)
+	if job == nil || job.Dispatched {
+		return nil
+	}
+
+	result := database.DB.Model(&models.ActionJob{}).
+		Where("id = ? AND status = ? AND lease = ? AND dispatched = ?", job.ID, models.JobRunning, job.Lease, false).
+		Update("dispatched", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errJobReclaimed
+	}
+
+	job.Dispatched = true
+	return nil
+}
+
+// reclaimStuckJobs takes back jobs whose worker stopped while running them.
+// A job that hadn't dispatched is queued again, or dead-lettered if it was
+// on its last attempt; one that had may have sent transactions, so it is
+// dead-lettered for the user to review.
+func reclaimStuckJobs() {
+	stuck := database.DB.Model(&models.ActionJob{}).
+		Where("status = ? AND locked_at < NOW() - make_interval(secs => ?)", models.JobRunning, stuckJobAge.Seconds())
+
+	requeued := stuck.Session(&gorm.Session{}).
+		Where("dispatched = ? AND attempts < max_attempts", false).
+		Updates(map[string]interface{}{
+			"status":     models.JobQueued,
+			"run_at":     gorm.Expr("NOW()"),
+			"lease":      gorm.Expr("lease + 1"),
+			"locked_by":  "",
+			"locked_at":  nil,
+			"last_error": "worker stopped while running the job",
+		})
+	if requeued.Error != nil {
+		log.Printf("Error requeueing stuck action jobs: %v", requeued.Error)
+	} else if requeued.RowsAffected > 0 {
+		log.Printf("Requeued %d stuck action jobs", requeued.RowsAffected)
+	}
+
+	dead := stuck.Session(&gorm.Session{}).
+		Updates(map[string]interface{}{
+			"status":       models.JobDead,
+			"lease":        gorm.Expr("lease + 1"),
+			"locked_by":    "",
+			"locked_at":    nil,
+			"completed_at": gorm.Expr("NOW()"),
+			"last_error": gorm.Expr("CASE WHEN dispatched THEN ? ELSE ? END",
+				"worker stopped after dispatching, not retried", "worker stopped while running the job"),
+		})
+	if dead.Error != nil {
+		log.Printf("Error dead-lettering stuck action jobs: %v", dead.Error)
+	} else if dead.RowsAffected > 0 {
+		log.Printf("Dead-lettered %d stuck action jobs", dead.RowsAffected)
+	}
+}
+
+// QueueStats describes the action job queue
+type QueueStats struct {
+	Depth map[string]int64 `json:"depth"` // jobs by status
+	// Due is the number of queued jobs whose time has come, and
+	// OldestDueSeconds how long the oldest of them has waited
+	Due              int64   `json:"due"`
+	OldestDueSeconds float64 `json:"oldest_due_seconds"`
+	// Stuck lists jobs running or due for longer than stuckJobAge
+	Stuck       []models.ActionJob `json:"stuck"`
+	DeadLetters []models.ActionJob `json:"dead_letters"` // latest first
+}
+
+// QueueStats reports the queue's depth, stuck jobs and dead letters
+func (e *Engine) QueueStats(ctx context.Context) (*QueueStats, error) {
+	db := database.DB.WithContext(ctx)
+	stats := &QueueStats{Depth: make(map[string]int64)}
+
+	var counts []struct {
+		Status string
+		Count  int64
+	}
+	if err := db.Model(&models.ActionJob{}).Select("status, COUNT(*) AS count").Group("status").Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		stats.Depth[c.Status] = c.Count
+	}
+
+	var due struct {
+		Count   int64
+		Seconds *float64
+	}
+	err := db.Model(&models.ActionJob{}).
+		Select("COUNT(*) AS count, EXTRACT(EPOCH FROM NOW() - MIN(run_at)) AS seconds").
+		Where("status = ? AND run_at <= NOW()", models.JobQueued).
+		Scan(&due).Error
+	if err != nil {
+		return nil, err
+	}
+	stats.Due = due.Count
+	if due.Seconds != nil {
+		stats.OldestDueSeconds = *due.Seconds
+	}
+
+	err = db.Where("status = ? AND locked_at < NOW() - make_interval(secs => ?)", models.JobRunning, stuckJobAge.Seconds()).
+		Or("status = ? AND run_at < NOW() - make_interval(secs => ?)", models.JobQueued, stuckJobAge.Seconds()).
+		Order("id").Limit(maxListedJobs).
+		Find(&stats.Stuck).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Where("status = ?", models.JobDead).
+		Order("completed_at DESC, id DESC").Limit(maxListedJobs).
+		Find(&stats.DeadLetters).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// RetryJob queues a dead-lettered job again with fresh attempts. Jobs that
+// dispatched transactions can't be retried, since that could send them twice.
+func (e *Engine) RetryJob(ctx context.Context, id uint) error {
+	var job models.ActionJob
+	err := database.DB.WithContext(ctx).First(&job, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrJobNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	result := database.DB.WithContext(ctx).Model(&models.ActionJob{}).
+		Where("id = ? AND status = ? AND dispatched = ?", id, models.JobDead, false).
+		Updates(map[string]interface{}{
+			"status":       models.JobQueued,
+			"run_at":       gorm.Expr("NOW()"),
+			"attempts":     0,
+			"completed_at": nil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrJobNotRetryable
+	}
+
+	e.notifyJobs()
+	return nil
+}
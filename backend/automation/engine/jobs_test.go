@@ -0,0 +1,201 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		base    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{6, 16 * time.Minute},
+		{7, maxJobBackoff}, // 32 minutes, capped
+		{40, maxJobBackoff},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			// Jitter adds up to a fifth of the delay
+			if got := retryDelay(tt.attempt); got < tt.base || got > tt.base+tt.base/5 {
+				t.Fatalf("retryDelay(%d) = %s, want %s plus up to a fifth", tt.attempt, got, tt.base)
+			}
+		}
+	}
+}
+
+// testJob queues a job of the rule that has been due since long before any
+// other, so it is the next one claimed
+func testJob(t *testing.T, rule models.AutomationRule, maxAttempts int) models.ActionJob {
+	t.Helper()
+	job := models.ActionJob{
+		IdempotencyKey: fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano()),
+		RuleID:         rule.ID,
+		UserID:         rule.UserID,
+		ActionType:     rule.ActionType,
+		ActionConfig:   rule.ActionConfig,
+		Status:         models.JobQueued,
+		RunAt:          time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		MaxAttempts:    maxAttempts,
+	}
+	if err := database.DB.Create(&job).Error; err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+// claim claims the next job and checks it is the one expected
+func claim(t *testing.T, worker string, want uint) *models.ActionJob {
+	t.Helper()
+	job, err := claimJob(context.Background(), worker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil || job.ID != want {
+		t.Fatalf("claimed %+v, want job %d", job, want)
+	}
+	return job
+}
+
+func loadJob(t *testing.T, id uint) models.ActionJob {
+	t.Helper()
+	var job models.ActionJob
+	if err := database.DB.First(&job, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestClaimJobSkipsLocked(t *testing.T) {
+	testDB(t)
+	rule := firingRule(t)
+	first, second := testJob(t, rule, 3), testJob(t, rule, 3)
+
+	// Another worker holds the first job's row lock
+	tx := database.DB.Begin()
+	defer tx.Rollback()
+	var locked models.ActionJob
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, first.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	job := claim(t, "worker-a", second.ID)
+	if job.Status != models.JobRunning || job.Attempts != 1 || job.Lease != 1 || job.LockedBy != "worker-a" {
+		t.Fatalf("claimed job = %+v", job)
+	}
+	stored := loadJob(t, second.ID)
+	if stored.Status != models.JobRunning || stored.Attempts != 1 || stored.Lease != 1 || stored.LockedBy != "worker-a" || stored.LockedAt == nil {
+		t.Fatalf("stored job = %+v", stored)
+	}
+
+	tx.Rollback()
+	claim(t, "worker-b", first.ID)
+	if job, err := claimJob(context.Background(), "worker-c"); err != nil || (job != nil && job.RuleID == rule.ID) {
+		t.Fatalf("claimed %+v, %v with both jobs running", job, err)
+	}
+}
+
+func TestFinishJob(t *testing.T) {
+	testDB(t)
+	rule := firingRule(t)
+	failure := errors.New("simulation failed")
+
+	tests := []struct {
+		name        string
+		maxAttempts int
+		dispatched  bool
+		err         error
+		status      string
+	}{
+		{"succeeded", 3, false, nil, models.JobSucceeded},
+		{"retried with backoff", 3, false, failure, models.JobQueued},
+		{"dead-lettered on the last attempt", 1, false, failure, models.JobDead},
+		{"dead-lettered after dispatching", 3, true, failure, models.JobDead},
+		{"dead-lettered when the rule is gone", 3, false, errRuleDeleted, models.JobDead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			created := testJob(t, rule, tt.maxAttempts)
+			job := claim(t, "worker-a", created.ID)
+			if tt.dispatched {
+				if err := beginDispatch(withJob(context.Background(), job)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			before := time.Now()
+			finishJob(job, nil, tt.err)
+			stored := loadJob(t, job.ID)
+			if stored.Status != tt.status || stored.LockedBy != "" || stored.LockedAt != nil {
+				t.Fatalf("stored job = %+v, want status %s and unlocked", stored, tt.status)
+			}
+			if tt.err != nil && stored.LastError == "" {
+				t.Fatal("failure not recorded")
+			}
+
+			switch tt.status {
+			case models.JobQueued:
+				if wait := stored.RunAt.Sub(before); wait < jobBackoff || wait > jobBackoff+jobBackoff/5+time.Second {
+					t.Fatalf("retried in %s, want %s plus jitter", wait, jobBackoff)
+				}
+			default:
+				if stored.CompletedAt == nil {
+					t.Fatal("finished job has no completion time")
+				}
+			}
+		})
+	}
+}
+
+func TestJobLeaseFencing(t *testing.T) {
+	testDB(t)
+	rule := firingRule(t)
+	created := testJob(t, rule, 1)
+
+	// Worker a stops responding on the job's only attempt; it is taken back,
+	// dead-lettered, retried by hand and claimed by worker b
+	stale := claim(t, "worker-a", created.ID)
+	if err := database.DB.Model(&models.ActionJob{}).Where("id = ?", created.ID).
+		Update("locked_at", gorm.Expr("NOW() - make_interval(secs => ?)", 2*stuckJobAge.Seconds())).Error; err != nil {
+		t.Fatal(err)
+	}
+	reclaimStuckJobs()
+	if stored := loadJob(t, created.ID); stored.Status != models.JobDead {
+		t.Fatalf("stuck job status = %s, want dead", stored.Status)
+	}
+	if err := testEngine().RetryJob(context.Background(), created.ID); err != nil {
+		t.Fatal(err)
+	}
+	current := claim(t, "worker-b", created.ID)
+
+	// Both claims are on attempt 1, but only worker b holds the lease
+	if current.Attempts != stale.Attempts || current.Lease <= stale.Lease {
+		t.Fatalf("leases %d then %d on attempts %d and %d", stale.Lease, current.Lease, stale.Attempts, current.Attempts)
+	}
+	if err := beginDispatch(withJob(context.Background(), stale)); !errors.Is(err, errJobReclaimed) {
+		t.Fatalf("stale beginDispatch = %v, want errJobReclaimed", err)
+	}
+	finishJob(stale, nil, nil)
+	if stored := loadJob(t, created.ID); stored.Status != models.JobRunning || stored.LockedBy != "worker-b" || stored.Dispatched {
+		t.Fatalf("stale worker changed the job: %+v", stored)
+	}
+
+	if err := beginDispatch(withJob(context.Background(), current)); err != nil {
+		t.Fatal(err)
+	}
+	finishJob(current, nil, nil)
+	if stored := loadJob(t, created.ID); stored.Status != models.JobSucceeded || !stored.Dispatched {
+		t.Fatalf("job = %+v, want dispatched and succeeded", stored)
+	}
+}
@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"log"
+	"time"
+
+	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/shared/rules"
+)
+
+// executionDay returns the UTC date daily execution caps count against
+func executionDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// limitReached returns why a rule may not execute now given its cooldown
+// and execution caps, or "" when it may
+func limitReached(rule models.AutomationRule, now time.Time) string {
+	if rule.CooldownSeconds > 0 && rule.LastExecutedAt != nil {
+		if now.Sub(*rule.LastExecutedAt) < time.Duration(rule.CooldownSeconds)*time.Second {
+			return "cooldown"
+		}
+	}
+
+	if rule.MaxExecutions > 0 && rule.ExecutionCount >= rule.MaxExecutions {
+		return "max_executions reached"
+	}
+
+	if rule.MaxExecutionsPerDay > 0 && rule.ExecutionDay == executionDay(now) &&
+		rule.ExecutionsToday >= rule.MaxExecutionsPerDay {
+		return "max_executions_per_day reached"
+	}
+
+	return ""
+}
+
+// recovered reports whether a disarmed rule's metric is back past its reset
+// threshold. Rules without a reset threshold are never disarmed.
+func recovered(rule models.AutomationRule, value float64) bool {
+	if rule.ResetThreshold == nil {
+		return true
+	}
+
+	threshold, _ := rule.TriggerConfig["threshold"].(float64)
+	if above, _ := rules.FiresAbove(rule.TriggerType, threshold); above {
+		return value <= *rule.ResetThreshold
+	}
+	return value >= *rule.ResetThreshold
+}
+
+// recordExecution updates a rule's execution tracking after its action ran,
+// disarming it for hysteresis and disabling it once it has used up its
+// lifetime executions
+func recordExecution(rule *models.AutomationRule, now time.Time) {
+	rule.LastExecutedAt = &now
+	rule.ExecutionCount++
+
+	day := executionDay(now)
+	if rule.ExecutionDay != day {
+		rule.ExecutionDay = day
+		rule.ExecutionsToday = 0
+	}
+	rule.ExecutionsToday++
+
+	if rule.ResetThreshold != nil {
+		rule.Armed = false
+	}
+
+	if rule.FireOnce || (rule.MaxExecutions > 0 && rule.ExecutionCount >= rule.MaxExecutions) {
+		log.Printf("Rule %d: reached its execution limit, disabling", rule.ID)
+		rule.Enabled = false
+	}
+}
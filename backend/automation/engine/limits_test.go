@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/shared/models"
+)
+
+func TestLimitReached(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	minuteAgo := now.Add(-time.Minute)
+
+	tests := []struct {
+		name string
+		rule models.AutomationRule
+		want string
+	}{
+		{"no limits", models.AutomationRule{ExecutionCount: 50, LastExecutedAt: &minuteAgo}, ""},
+		{"never executed", models.AutomationRule{CooldownSeconds: 3600}, ""},
+		{"in cooldown", models.AutomationRule{CooldownSeconds: 120, LastExecutedAt: &minuteAgo}, "cooldown"},
+		{"cooldown over", models.AutomationRule{CooldownSeconds: 60, LastExecutedAt: &minuteAgo}, ""},
+		{"lifetime cap", models.AutomationRule{MaxExecutions: 3, ExecutionCount: 3}, "max_executions reached"},
+		{"under lifetime cap", models.AutomationRule{MaxExecutions: 3, ExecutionCount: 2}, ""},
+		{"daily cap", models.AutomationRule{MaxExecutionsPerDay: 2, ExecutionDay: "2024-03-10", ExecutionsToday: 2}, "max_executions_per_day reached"},
+		{"daily cap from yesterday", models.AutomationRule{MaxExecutionsPerDay: 2, ExecutionDay: "2024-03-09", ExecutionsToday: 2}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := limitReached(tt.rule, now); got != tt.want {
+				t.Fatalf("limitReached = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecovered(t *testing.T) {
+	reset := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name  string
+		rule  models.AutomationRule
+		value float64
+		want  bool
+	}{
+		{"no reset threshold", models.AutomationRule{TriggerType: "apy_drop"}, 1, true},
+		// apy_drop fires below 3%, so it rearms once the APY is back above 4%
+		{"below firer still low", models.AutomationRule{TriggerType: "apy_drop", TriggerConfig: map[string]interface{}{"threshold": 3.0}, ResetThreshold: reset(4)}, 3.5, false},
+		{"below firer recovered", models.AutomationRule{TriggerType: "apy_drop", TriggerConfig: map[string]interface{}{"threshold": 3.0}, ResetThreshold: reset(4)}, 4, true},
+		// utilization fires above 90%, so it rearms once back under 80%
+		{"above firer still high", models.AutomationRule{TriggerType: "utilization", TriggerConfig: map[string]interface{}{"threshold": 90.0}, ResetThreshold: reset(80)}, 85, false},
+		{"above firer recovered", models.AutomationRule{TriggerType: "utilization", TriggerConfig: map[string]interface{}{"threshold": 90.0}, ResetThreshold: reset(80)}, 79, true},
+		// price_change follows the sign of its threshold
+		{"price drop recovered", models.AutomationRule{TriggerType: "price_change", TriggerConfig: map[string]interface{}{"threshold": -10.0}, ResetThreshold: reset(-5)}, -4, true},
+		{"price drop still down", models.AutomationRule{TriggerType: "price_change", TriggerConfig: map[string]interface{}{"threshold": -10.0}, ResetThreshold: reset(-5)}, -8, false},
+		{"price rise recovered", models.AutomationRule{TriggerType: "price_change", TriggerConfig: map[string]interface{}{"threshold": 10.0}, ResetThreshold: reset(5)}, 4, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := recovered(tt.rule, tt.value); got != tt.want {
+				t.Fatalf("recovered(%g) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordExecution(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	reset := 4.0
+
+	t.Run("counts per day", func(t *testing.T) {
+		rule := models.AutomationRule{Enabled: true, Armed: true, ExecutionCount: 5, ExecutionDay: "2024-03-09", ExecutionsToday: 3}
+		recordExecution(&rule, now)
+		if rule.ExecutionCount != 6 || rule.ExecutionDay != "2024-03-10" || rule.ExecutionsToday != 1 {
+			t.Fatalf("after a new day's execution: count %d, day %s, today %d", rule.ExecutionCount, rule.ExecutionDay, rule.ExecutionsToday)
+		}
+		recordExecution(&rule, now.Add(time.Hour))
+		if rule.ExecutionsToday != 2 || !rule.LastExecutedAt.Equal(now.Add(time.Hour)) {
+			t.Fatalf("after a second execution: today %d, last %v", rule.ExecutionsToday, rule.LastExecutedAt)
+		}
+		if !rule.Enabled || !rule.Armed {
+			t.Fatal("rule without limits disarmed or disabled")
+		}
+	})
+
+	t.Run("disarms with a reset threshold", func(t *testing.T) {
+		rule := models.AutomationRule{Enabled: true, Armed: true, ResetThreshold: &reset}
+		recordExecution(&rule, now)
+		if rule.Armed || !rule.Enabled {
+			t.Fatalf("armed %v, enabled %v, want disarmed and enabled", rule.Armed, rule.Enabled)
+		}
+	})
+
+	t.Run("fire once", func(t *testing.T) {
+		rule := models.AutomationRule{Enabled: true, Armed: true, FireOnce: true}
+		recordExecution(&rule, now)
+		if rule.Enabled {
+			t.Fatal("fire-once rule still enabled")
+		}
+	})
+
+	t.Run("lifetime cap", func(t *testing.T) {
+		rule := models.AutomationRule{Enabled: true, Armed: true, MaxExecutions: 2, ExecutionCount: 1}
+		recordExecution(&rule, now)
+		if rule.Enabled {
+			t.Fatal("rule at its lifetime cap still enabled")
+		}
+	})
+}
+
+func TestRunRuleHysteresis(t *testing.T) {
+	// A gas rule that fires under 20 gwei and rearms over 30, evaluated the
+	// way a backtest does so nothing is written
+	source := &fakeSource{gas: map[string]float64{}}
+	e := &Engine{source: source, mode: modeBacktest}
+	reset := 30.0
+	rule := models.AutomationRule{
+		TriggerType:    "gas_below",
+		TriggerConfig:  map[string]interface{}{"threshold": 20.0},
+		ResetThreshold: &reset,
+		Enabled:        true,
+		Armed:          true,
+	}
+
+	start := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	steps := []struct {
+		gas  float64
+		want string
+	}{
+		{12, models.DecisionFired},
+		{12, models.DecisionDisarmed}, // still low, but fired already
+		{25, models.DecisionDisarmed}, // back over the threshold but not the reset
+		{35, models.DecisionRearmed},
+		{35, models.DecisionNotTriggered},
+		{15, models.DecisionFired},
+	}
+	for i, step := range steps {
+		source.gas["ethereum"] = step.gas
+		var exec models.RuleExecution
+		if err := e.runRule(context.Background(), &rule, start.Add(time.Duration(i)*time.Hour), &exec); err != nil {
+			t.Fatal(err)
+		}
+		if exec.Decision != step.want {
+			t.Fatalf("step %d at %g gwei: decision %s (%s), want %s", i, step.gas, exec.Decision, exec.Reason, step.want)
+		}
+	}
+	if rule.ExecutionCount != 2 || rule.Armed {
+		t.Fatalf("executed %d times, armed %v, want twice and disarmed", rule.ExecutionCount, rule.Armed)
+	}
+}
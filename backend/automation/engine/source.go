@@ -0,0 +1,295 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/defioptimization/shared/clients"
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/shared/tokens"
+)
+
+// sampleInterval is the minimum time between two stored samples of one
+// metric series, and how often the engine samples market data on its own
+const sampleInterval = 5 * time.Minute
+
+// blockTimes are the average block intervals used to find the block a past
+// price was read at
+var blockTimes = map[string]time.Duration{
+	"ethereum": 12 * time.Second,
+	"base":     2 * time.Second,
+}
+
+// Metric names of stored samples
+const (
+	metricAPY             = "apy"
+	metricPrice           = "price"
+	metricUtilization     = "utilization"
+	metricGasPrice        = "gas_price"
+	metricHealthFactor    = "health_factor"
+	metricLiquidationRisk = "liquidation_risk"
+)
+
+// DataSource supplies the market and account data triggers read. The live
+// source reads the DeFi, wallet and ML services; backtests replay stored
+// samples through the same trigger checks.
+type DataSource interface {
+	// Now is the time the data is read at
+	Now() time.Time
+	// APY returns a protocol's supply APY for an asset, in percent
+	APY(ctx context.Context, protocol, asset, chain string) (float64, error)
+	// Price returns an asset's oracle USD price, ago before Now
+	Price(ctx context.Context, asset, chain string, ago time.Duration) (float64, error)
+	// Utilization returns the borrowed share of a reserve, in percent
+	Utilization(ctx context.Context, protocol, asset, chain string) (float64, error)
+	// GasPrice returns the expected gas price on a chain, in gwei
+	GasPrice(ctx context.Context, chain string) (float64, error)
+	// HealthFactor returns an account's health factor on a protocol
+	HealthFactor(ctx context.Context, protocol, chain, account string) (float64, error)
+	// LiquidationRisk returns the ML service's liquidation risk for an
+	// account's positions on a chain. userID is the account's owner.
+	LiquidationRisk(ctx context.Context, userID uint, chain, account string) (float64, error)
+}
+
+// liveSource reads current data from the services and stores what it reads
+// as samples for backtests
+type liveSource struct {
+	e       *Engine
+	sampler *sampler
+}
+
+// sampler throttles stored samples to one per series per sampleInterval
+type sampler struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func (s *liveSource) Now() time.Time {
+	return time.Now()
+}
+
+func (s *liveSource) APY(ctx context.Context, protocol, asset, chain string) (float64, error) {
+	result, err := s.e.defi.APY(ctx, protocol, asset, chain)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch APY: %w", err)
+	}
+	s.record(models.MetricSample{Metric: metricAPY, Chain: chain, Protocol: protocol, Asset: asset, Value: result.APY, BlockNumber: result.BlockNumber})
+	return result.APY, nil
+}
+
+// Price reads the price at the latest block, or for a past time at the block
+// that many average block intervals back, which needs the DeFi service to
+// have archive access
+func (s *liveSource) Price(ctx context.Context, asset, chain string, ago time.Duration) (float64, error) {
+	current, head, err := s.priceAt(ctx, asset, chain, 0)
+	if err != nil {
+		return 0, err
+	}
+	if ago <= 0 {
+		s.record(models.MetricSample{Metric: metricPrice, Chain: chain, Asset: asset, Value: current, BlockNumber: head})
+		return current, nil
+	}
+
+	blockTime, ok := blockTimes[chain]
+	if !ok {
+		return 0, fmt.Errorf("unsupported chain: %s", chain)
+	}
+	blocksBack := uint64(ago / blockTime)
+	if blocksBack >= head {
+		return 0, fmt.Errorf("window %s reaches before genesis", ago)
+	}
+	past, _, err := s.priceAt(ctx, asset, chain, head-blocksBack)
+	if err != nil {
+		return 0, err
+	}
+	if past == 0 {
+		return 0, fmt.Errorf("no %s price at block %d", asset, head-blocksBack)
+	}
+	return past, nil
+}
+
+// priceAt returns an asset's oracle price at a block, or at the latest block
+// when block is 0, along with the block it was read at
+func (s *liveSource) priceAt(ctx context.Context, asset, chain string, block uint64) (float64, uint64, error) {
+	result, err := s.e.defi.Price(ctx, "aave", asset, chain, block)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to fetch %s price: %w", asset, err)
+	}
+	if result.BlockNumber == 0 {
+		return 0, 0, errors.New("price response has no block number")
+	}
+	return result.Price, result.BlockNumber, nil
+}
+
+func (s *liveSource) Utilization(ctx context.Context, protocol, asset, chain string) (float64, error) {
+	result, err := s.e.defi.Utilization(ctx, protocol, asset, chain)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch utilization: %w", err)
+	}
+	s.record(models.MetricSample{Metric: metricUtilization, Chain: chain, Protocol: protocol, Asset: asset, Value: result.Utilization, BlockNumber: result.BlockNumber})
+	return result.Utilization, nil
+}
+
+func (s *liveSource) GasPrice(ctx context.Context, chain string) (float64, error) {
+	result, err := s.e.wallet.GasPrice(ctx, chain)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch gas price: %w", err)
+	}
+	s.record(models.MetricSample{Metric: metricGasPrice, Chain: chain, Value: result.GasPriceGwei})
+	return result.GasPriceGwei, nil
+}
+
+func (s *liveSource) HealthFactor(ctx context.Context, protocol, chain, account string) (float64, error) {
+	result, err := s.e.defi.HealthFactor(ctx, protocol, account, chain)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch health factor: %w", err)
+	}
+	s.record(models.MetricSample{Metric: metricHealthFactor, Chain: chain, Protocol: protocol, Account: strings.ToLower(account), Value: result.HealthFactor, BlockNumber: result.BlockNumber})
+	return result.HealthFactor, nil
+}
+
+// LiquidationRisk asks the ML service for a risk forecast of the account's
+// positions on a chain, and stores it on the owner's portfolio positions on
+// that chain
+func (s *liveSource) LiquidationRisk(ctx context.Context, userID uint, chain, account string) (float64, error) {
+	req, err := s.riskRequest(ctx, chain, account)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := s.e.ml.RiskForecast(ctx, *req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch risk forecast: %w", err)
+	}
+	s.record(models.MetricSample{Metric: metricLiquidationRisk, Chain: chain, Account: strings.ToLower(account), Value: result.LiquidationRisk})
+
+	now := time.Now()
+	portfolios := database.DB.Model(&models.Portfolio{}).Select("id").Where("user_id = ?", userID)
+	if err := database.DB.Model(&models.Position{}).
+		Where("chain = ? AND portfolio_id IN (?)", chain, portfolios).
+		Updates(map[string]interface{}{"liquidation_risk": result.LiquidationRisk, "last_risk_check": now}).Error; err != nil {
+		log.Printf("Error storing liquidation risk of %s: %v", account, err)
+	}
+
+	return result.LiquidationRisk, nil
+}
+
+// riskRequest reads the account's positions and health factors on a chain
+// from the DeFi service and values them at oracle prices
+func (s *liveSource) riskRequest(ctx context.Context, chain, account string) (*clients.RiskForecastRequest, error) {
+	portfolio, err := s.e.defi.Portfolio(ctx, account, chain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch positions: %w", err)
+	}
+
+	req := &clients.RiskForecastRequest{UserAddress: account, Positions: []clients.RiskPosition{}}
+	for _, p := range portfolio.Protocols {
+		// A forecast missing a protocol's positions would understate the risk
+		if p.Error != "" {
+			return nil, fmt.Errorf("failed to fetch %s positions: %s", p.Protocol, p.Error)
+		}
+
+		borrows := false
+		for _, position := range p.Positions {
+			price, err := s.Price(ctx, position.Asset, chain, 0)
+			if err != nil {
+				return nil, err
+			}
+			value := position.Amount * price
+
+			switch position.Type {
+			case "lending":
+				req.TotalCollateral += value
+			case "borrowing":
+				req.TotalDebt += value
+				borrows = true
+			}
+			req.Positions = append(req.Positions, clients.RiskPosition{
+				Protocol:     p.Protocol,
+				Chain:        chain,
+				Asset:        position.Asset,
+				Type:         position.Type,
+				Amount:       position.Amount,
+				APY:          position.APY,
+				Price:        price,
+				ValueUSD:     value,
+				HealthFactor: p.HealthFactor,
+			})
+		}
+
+		if borrows && (req.HealthFactor == nil || p.HealthFactor < *req.HealthFactor) {
+			hf := p.HealthFactor
+			req.HealthFactor = &hf
+		}
+	}
+	return req, nil
+}
+
+// seriesKey identifies the series a sample belongs to. Asset aliases such as
+// ETH are resolved to their registry symbol.
+func seriesKey(sample *models.MetricSample) string {
+	if t, ok := tokens.Lookup(sample.Chain, sample.Asset); ok {
+		sample.Asset = t.Symbol
+	}
+	return strings.Join([]string{sample.Metric, sample.Chain, sample.Protocol, sample.Asset, sample.Account}, "|")
+}
+
+// record stores a sample unless its series was sampled within sampleInterval
+func (s *liveSource) record(sample models.MetricSample) {
+	now := time.Now()
+	key := seriesKey(&sample)
+
+	s.sampler.mu.Lock()
+	if now.Sub(s.sampler.last[key]) < sampleInterval {
+		s.sampler.mu.Unlock()
+		return
+	}
+	s.sampler.last[key] = now
+	s.sampler.mu.Unlock()
+
+	sample.SampledAt = now
+	if err := database.DB.Create(&sample).Error; err != nil {
+		log.Printf("Error storing %s sample: %v", sample.Metric, err)
+	}
+}
+
+// sampleMarkets reads the APY of every supported asset, token prices and gas
+// prices, so backtests have market history for assets no rule watches yet
+func (e *Engine) sampleMarkets(ctx context.Context, now time.Time) {
+	if now.Sub(e.lastSample) < sampleInterval {
+		return
+	}
+	e.lastSample = now
+
+	live := e.live()
+	for _, chain := range tokens.Chains() {
+		for _, protocol := range defaultAlternatives {
+			result, err := e.defi.APYs(ctx, protocol, chain)
+			if err != nil {
+				log.Printf("Error sampling %s APYs on %s: %v", protocol, chain, err)
+				continue
+			}
+			for _, a := range result.APYs {
+				if a.Error == "" {
+					live.record(models.MetricSample{Metric: metricAPY, Chain: chain, Protocol: protocol, Asset: a.Asset, Value: a.APY, BlockNumber: result.BlockNumber})
+				}
+			}
+		}
+
+		for _, t := range tokens.List(chain) {
+			if _, err := live.Price(ctx, t.Symbol, chain, 0); err != nil {
+				log.Printf("Error sampling %s price on %s: %v", t.Symbol, chain, err)
+			}
+		}
+
+		if _, err := live.GasPrice(ctx, chain); err != nil {
+			log.Printf("Error sampling gas price on %s: %v", chain, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/shared/clients"
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+)
+
+func TestLiquidationRisk(t *testing.T) {
+	testDB(t)
+
+	// Wallet addresses differ only in case between the two users, so the
+	// risk must be stored by the owner rather than by address
+	wallet := fmt.Sprintf("0xabcdef%034x", time.Now().UnixNano())
+	owner := models.User{WalletAddress: wallet}
+	other := models.User{WalletAddress: "0x" + strings.ToUpper(wallet[2:])}
+	position := func(user *models.User, chain string) *models.Position {
+		if user.ID == 0 {
+			if err := database.DB.Create(user).Error; err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { database.DB.Unscoped().Delete(user) })
+		}
+		portfolio := models.Portfolio{UserID: user.ID, Name: t.Name()}
+		if err := database.DB.Create(&portfolio).Error; err != nil {
+			t.Fatal(err)
+		}
+		p := models.Position{PortfolioID: portfolio.ID, Protocol: "aave", Chain: chain, Asset: "WETH", PositionType: "lending", Amount: 10, Address: "0x87870Bca3F3fD6335C3F4ce8392A693fcE16f1D7"}
+		if err := database.DB.Create(&p).Error; err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			database.DB.Unscoped().Delete(&p)
+			database.DB.Unscoped().Delete(&portfolio)
+		})
+		return &p
+	}
+	onChain, otherChain, otherUser := position(&owner, "ethereum"), position(&owner, "base"), position(&other, "ethereum")
+
+	prices := map[string]float64{"WETH": 2000, "USDC": 1}
+	var posted clients.RiskForecastRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/portfolio":
+			if r.URL.Query().Get("user_address") != wallet || r.URL.Query().Get("chain") != "ethereum" {
+				t.Errorf("portfolio query = %s", r.URL.RawQuery)
+			}
+			json.NewEncoder(w).Encode(clients.Portfolio{
+				UserAddress: wallet,
+				Chain:       "ethereum",
+				Protocols: []clients.ProtocolPortfolio{
+					{Protocol: "aave", HealthFactor: 3.2, Positions: []clients.Position{
+						{Asset: "WETH", Type: "lending", Amount: 10, APY: 2},
+						{Asset: "USDC", Type: "borrowing", Amount: 5000, APY: 5},
+					}},
+					// Without debt, compound's health factor doesn't count
+					{Protocol: "compound", Positions: []clients.Position{
+						{Asset: "USDC", Type: "lending", Amount: 1000, APY: 4},
+					}},
+				},
+				BlockNumber: 100,
+			})
+		case "/api/v1/protocols/aave/price":
+			asset := r.URL.Query().Get("asset")
+			json.NewEncoder(w).Encode(clients.Price{Asset: asset, Chain: "ethereum", Price: prices[asset], BlockNumber: 100})
+		case "/api/v1/risk/forecast":
+			json.NewDecoder(r.Body).Decode(&posted)
+			json.NewEncoder(w).Encode(clients.RiskForecast{LiquidationRisk: 0.12, RiskLevel: "low"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	e := NewEngine(srv.URL, srv.URL, srv.URL)
+	risk, err := e.live().LiquidationRisk(context.Background(), owner.ID, "ethereum", wallet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if risk != 0.12 {
+		t.Fatalf("risk = %v, want 0.12", risk)
+	}
+
+	wantPositions := []clients.RiskPosition{
+		{Protocol: "aave", Chain: "ethereum", Asset: "WETH", Type: "lending", Amount: 10, APY: 2, Price: 2000, ValueUSD: 20000, HealthFactor: 3.2},
+		{Protocol: "aave", Chain: "ethereum", Asset: "USDC", Type: "borrowing", Amount: 5000, APY: 5, Price: 1, ValueUSD: 5000, HealthFactor: 3.2},
+		{Protocol: "compound", Chain: "ethereum", Asset: "USDC", Type: "lending", Amount: 1000, APY: 4, Price: 1, ValueUSD: 1000},
+	}
+	if !reflect.DeepEqual(posted.Positions, wantPositions) {
+		t.Errorf("posted positions = %+v, want %+v", posted.Positions, wantPositions)
+	}
+	if posted.UserAddress != wallet || posted.TotalCollateral != 21000 || posted.TotalDebt != 5000 {
+		t.Errorf("posted %s with collateral %v and debt %v, want %s with 21000 and 5000",
+			posted.UserAddress, posted.TotalCollateral, posted.TotalDebt, wallet)
+	}
+	if posted.HealthFactor == nil || *posted.HealthFactor != 3.2 {
+		t.Errorf("posted health factor = %v, want 3.2", posted.HealthFactor)
+	}
+
+	// Only the owner's positions on the chain are updated
+	tests := []struct {
+		name    string
+		p       *models.Position
+		updated bool
+	}{
+		{"owner's position on the chain", onChain, true},
+		{"owner's position on another chain", otherChain, false},
+		{"other user's position", otherUser, false},
+	}
+	for _, tt := range tests {
+		var got models.Position
+		if err := database.DB.First(&got, tt.p.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if updated := got.LastRiskCheck != nil; updated != tt.updated {
+			t.Errorf("%s: updated = %v, want %v", tt.name, updated, tt.updated)
+		}
+		if want := map[bool]float64{true: 0.12}[tt.updated]; got.LiquidationRisk != want {
+			t.Errorf("%s: liquidation risk = %v, want %v", tt.name, got.LiquidationRisk, want)
+		}
+	}
+}
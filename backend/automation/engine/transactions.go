@@ -0,0 +1,467 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/defioptimization/shared/clients"
+	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/shared/rules"
+)
+
+// defaultMaxDeviation is the tolerated relative difference between the
+// simulated and expected balance change of an action step
+const defaultMaxDeviation = 0.005
+
+// errUntraced is returned for a step whose balance changes the node could
+// not trace, unless the action allows it
+var errUntraced = errors.New("simulation could not trace balance changes; set allow_untraced to submit without the check")
+
+// expectation is the balance change a step must produce for the sender
+type expectation struct {
+	Token  string  // token address
+	Amount float64 // signed, in token units
+}
+
+// planStep is one transaction of an action
+type planStep struct {
+	Call   clients.Call
+	Expect *expectation
+	// Balances overrides the sender's token balances during simulation, for
+	// steps that spend funds produced by an earlier step
+	Balances map[string]string
+	// Allowances overrides the sender's allowances (token => spender) during
+	// simulation, for steps that spend an approval sent by an earlier step
+	Allowances map[string]map[string]string
+}
+
+// dependent reports whether the step relies on state an earlier step creates
+func (s planStep) dependent() bool {
+	return len(s.Balances) > 0 || len(s.Allowances) > 0
+}
+
+// actionPlan is the ordered list of transactions an action needs
+type actionPlan struct {
+	Chain string
+	Steps []planStep
+	// MaxDeviation overrides defaultMaxDeviation when set
+	MaxDeviation float64
+	// AllowUntraced submits steps whose balance changes the node can't
+	// trace. Otherwise such steps are refused, since their outcome is unchecked.
+	AllowUntraced bool
+	// Transfer is the bridge transfer the last step starts. It is recorded
+	// with that step's nonce once dispatch begins, for the engine to follow.
+	Transfer *models.BridgeTransfer
+}
+
+// fetchProtocolCalls asks the DeFi service for the calls that perform an action
+func (e *Engine) fetchProtocolCalls(ctx context.Context, protocol, action, asset string, amount float64, chain, userAddress string) (*clients.ProtocolCalls, error) {
+	result, err := e.defi.ProtocolCalls(ctx, protocol, clients.TransactionRequest{
+		Action:      action,
+		Asset:       asset,
+		Amount:      amount,
+		Chain:       chain,
+		UserAddress: userAddress,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build %s calls: %w", action, err)
+	}
+	return result, nil
+}
+
+// fetchSwapCalls asks the DeFi service to quote a swap and build its calls
+func (e *Engine) fetchSwapCalls(ctx context.Context, chain, tokenIn, tokenOut string, amount float64, slippageBps int, userAddress string) (*clients.SwapCalls, error) {
+	result, err := e.defi.SwapCalls(ctx, clients.SwapRequest{
+		TokenIn:     tokenIn,
+		TokenOut:    tokenOut,
+		Amount:      amount,
+		Chain:       chain,
+		SlippageBps: slippageBps,
+		UserAddress: userAddress,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build swap calls: %w", err)
+	}
+	return result, nil
+}
+
+// fetchDeleveragePlan asks the DeFi service for the repayment that restores
+// a target health factor
+func (e *Engine) fetchDeleveragePlan(ctx context.Context, protocol string, config rules.DeleverageAction, maxAmount float64, userAddress string) (*clients.DeleveragePlan, error) {
+	plan, err := e.defi.Deleverage(ctx, protocol, clients.DeleverageRequest{
+		DebtAsset:          config.DebtAsset,
+		TargetHealthFactor: config.TargetHealthFactor,
+		Source:             config.Source,
+		MaxAmount:          maxAmount,
+		Chain:              config.Chain,
+		UserAddress:        userAddress,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan deleverage: %w", err)
+	}
+	return plan, nil
+}
+
+// submitPlan simulates every step of an action and only hands the
+// transactions to the user once all of them succeed with the expected outcome
+func (e *Engine) submitPlan(ctx context.Context, rule models.AutomationRule, user models.User, plan actionPlan) error {
+	maxDeviation := defaultMaxDeviation
+	if plan.MaxDeviation > 0 {
+		maxDeviation = plan.MaxDeviation
+	}
+
+	// Nothing is reserved or built unless the user's wallet can be reached.
+	// A dry run sends nothing, so it doesn't need the wallet.
+	var session *clients.Session
+	var err error
+	if e.mode != modeDryRun {
+		if session, err = e.activeSession(ctx, plan.Chain, user.WalletAddress); err != nil {
+			return err
+		}
+	}
+
+	plan, err = e.addApprovals(ctx, plan, user.WalletAddress)
+	if err != nil {
+		return err
+	}
+
+	for i, step := range plan.Steps {
+		sim, err := e.simulate(ctx, plan.Chain, user.WalletAddress, step)
+		if err != nil {
+			return fmt.Errorf("step %d (%s): %w", i+1, step.Call.Description, err)
+		}
+		if e.mode == modeDryRun {
+			recordStep(ctx, simulatedStep(plan.Chain, user.WalletAddress, step, sim))
+		}
+		if !sim.Success {
+			return fmt.Errorf("step %d (%s): simulation reverted: %s", i+1, step.Call.Description, sim.RevertReason)
+		}
+		if err := checkExpectation(sim, step.Expect, maxDeviation, plan.AllowUntraced); err != nil {
+			return fmt.Errorf("step %d (%s): %w", i+1, step.Call.Description, err)
+		}
+	}
+	if e.mode == modeDryRun {
+		return nil
+	}
+
+	// Every step gets its own nonce so concurrent rules for the same
+	// account can't collide. If the plan can't be completed, the nonces from
+	// the first step not yet handed to the wallet on are released.
+	built := make([]clients.Transaction, 0, len(plan.Steps))
+	release := func(from int) {
+		for _, tx := range built[from:] {
+			if tx.Nonce != nil {
+				e.releaseNonce(ctx, plan.Chain, user.WalletAddress, *tx.Nonce)
+			}
+		}
+	}
+
+	for i, step := range plan.Steps {
+		// Later steps can't be gas-estimated until earlier ones are mined
+		if step.dependent() {
+			n, err := e.reserveNonce(ctx, plan.Chain, user.WalletAddress)
+			if err != nil {
+				release(0)
+				return fmt.Errorf("step %d (%s): %w", i+1, step.Call.Description, err)
+			}
+			built = append(built, clients.Transaction{
+				From:  user.WalletAddress,
+				To:    step.Call.To,
+				Value: step.Call.Value,
+				Data:  step.Call.Data,
+				Nonce: &n,
+			})
+			continue
+		}
+		tx, err := e.buildTransaction(ctx, plan.Chain, user.WalletAddress, step.Call)
+		if err != nil {
+			release(0)
+			return fmt.Errorf("step %d (%s): %w", i+1, step.Call.Description, err)
+		}
+		built = append(built, *tx)
+	}
+
+	// Once the job is marked dispatched it is never retried, so a failure
+	// past this point can't send the transactions twice
+	if err := beginDispatch(ctx); err != nil {
+		release(0)
+		return err
+	}
+	if plan.Transfer != nil {
+		if err := recordTransfer(ctx, plan.Transfer, built[len(built)-1]); err != nil {
+			release(0)
+			return err
+		}
+	}
+	if requested, err := e.dispatch(ctx, rule, user, session, plan, built); err != nil {
+		release(requested)
+		if plan.Transfer != nil {
+			failTransfer(plan.Transfer, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// simulatedStep describes a simulated step for a dry run
+func simulatedStep(chain, from string, step planStep, sim *clients.SimulationResult) models.ExecutionStep {
+	s := models.ExecutionStep{
+		Description: step.Call.Description,
+		Chain:       chain,
+		From:        from,
+		To:          step.Call.To,
+		GasUsed:     sim.GasUsed,
+		Status:      "simulated",
+	}
+	if !sim.Success {
+		s.Status, s.Error = "reverted", sim.RevertReason
+	}
+	return s
+}
+
+// addApprovals puts an exact-amount approve in front of every step that
+// spends more allowance than the user has granted, after an approve(0) for
+// tokens that can't change a non-zero allowance. Unlimited approvals are
+// never requested.
+func (e *Engine) addApprovals(ctx context.Context, plan actionPlan, owner string) (actionPlan, error) {
+	steps := make([]planStep, 0, len(plan.Steps))
+	for _, step := range plan.Steps {
+		req := step.Call.Requires
+		if req == nil {
+			steps = append(steps, step)
+			continue
+		}
+
+		check, err := e.checkAllowance(ctx, plan.Chain, owner, req)
+		if err != nil {
+			return plan, fmt.Errorf("%s: %w", step.Call.Description, err)
+		}
+		if check.Sufficient || check.Approval == nil {
+			steps = append(steps, step)
+			continue
+		}
+
+		approval := planStep{Call: *check.Approval}
+		if check.Reset != nil {
+			steps = append(steps, planStep{Call: *check.Reset})
+			// The approval is simulated as if the reset had already run
+			approval.Allowances = map[string]map[string]string{req.Token: {req.Spender: "0"}}
+		}
+		steps = append(steps, approval)
+		if step.Allowances == nil {
+			step.Allowances = make(map[string]map[string]string)
+		}
+		step.Allowances[req.Token] = map[string]string{req.Spender: req.Amount}
+		steps = append(steps, step)
+	}
+
+	plan.Steps = steps
+	return plan, nil
+}
+
+// checkAllowance asks the wallet service whether an allowance covers a call
+func (e *Engine) checkAllowance(ctx context.Context, chain, owner string, req *clients.AllowanceRequirement) (*clients.AllowanceCheck, error) {
+	result, err := e.wallet.CheckAllowance(ctx, clients.AllowanceCheckRequest{
+		Chain:   chain,
+		Owner:   owner,
+		Token:   req.Token,
+		Spender: req.Spender,
+		Amount:  req.Amount,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check allowance: %w", err)
+	}
+	return result, nil
+}
+
+// dispatch asks the user's wallet to sign and send each transaction over
+// their WalletConnect session. The wallet broadcasts the transactions itself.
+// It returns how many were requested, whose nonces the wallet may still use
+// even if a later request fails.
+func (e *Engine) dispatch(ctx context.Context, rule models.AutomationRule, user models.User, session *clients.Session, plan actionPlan, built []clients.Transaction) (int, error) {
+	for i, tx := range built {
+		req := clients.SessionRequest{
+			Chain:  plan.Chain,
+			Method: "eth_sendTransaction",
+			Params: []interface{}{sendTransactionParams(tx)},
+			Type:   rule.ActionType,
+		}
+		if rule.ID != 0 {
+			req.AutomationRuleID = &rule.ID
+		}
+
+		step := models.ExecutionStep{
+			Description: plan.Steps[i].Call.Description,
+			Chain:       plan.Chain,
+			From:        user.WalletAddress,
+			To:          tx.To,
+			Nonce:       tx.Nonce,
+		}
+
+		requestID, err := e.wallet.Request(ctx, session.Topic, req)
+		if err != nil {
+			step.Status, step.Error = "failed", err.Error()
+			recordStep(ctx, step)
+			return i, fmt.Errorf("step %d (%s): failed to request signature: %w", i+1, plan.Steps[i].Call.Description, err)
+		}
+		step.Status, step.RequestID = "requested", requestID
+		recordStep(ctx, step)
+
+		log.Printf("Rule %d: requested signature %d/%d from %s (%s) on %s: %s (request %d, max cost %s wei)",
+			rule.ID, i+1, len(built), user.WalletAddress, session.PeerName, plan.Chain,
+			plan.Steps[i].Call.Description, requestID, tx.MaxCost)
+	}
+	return len(built), nil
+}
+
+// activeSession finds the WalletConnect session that can sign for the user on a chain
+func (e *Engine) activeSession(ctx context.Context, chain, address string) (*clients.Session, error) {
+	session, err := e.wallet.ActiveSession(ctx, address, chain)
+	if clients.IsNotFound(err) {
+		return nil, fmt.Errorf("user %s has no active wallet session on %s, cannot request signature", address, chain)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up wallet session: %w", err)
+	}
+	return session, nil
+}
+
+// availableBalance returns how much of a token the wallet holds, in token units
+func (e *Engine) availableBalance(ctx context.Context, chain, address, token string) (float64, error) {
+	result, err := e.wallet.Balances(ctx, address, chain)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch balances: %w", err)
+	}
+
+	for _, b := range result.Balances {
+		if strings.EqualFold(b.Token, token) {
+			if b.Error != "" {
+				return 0, fmt.Errorf("failed to read balance of %s: %s", token, b.Error)
+			}
+			return b.Amount, nil
+		}
+	}
+	return 0, nil
+}
+
+// sendTransactionParams encodes a built transaction as eth_sendTransaction
+// parameters. Fields left empty by the wallet service are left to the wallet.
+func sendTransactionParams(tx clients.Transaction) map[string]interface{} {
+	params := map[string]interface{}{
+		"from": tx.From,
+		"to":   tx.To,
+		"data": tx.Data,
+	}
+	if v := toHexQuantity(tx.Value); v != "" {
+		params["value"] = v
+	}
+	if tx.GasLimit > 0 {
+		params["gas"] = fmt.Sprintf("0x%x", tx.GasLimit)
+	}
+	if v := toHexQuantity(tx.MaxFeePerGas); v != "" {
+		params["maxFeePerGas"] = v
+	}
+	if v := toHexQuantity(tx.MaxPriorityFeePerGas); v != "" {
+		params["maxPriorityFeePerGas"] = v
+	}
+	if tx.Nonce != nil {
+		params["nonce"] = fmt.Sprintf("0x%x", *tx.Nonce)
+	}
+	return params
+}
+
+// toHexQuantity converts a decimal string to a JSON-RPC hex quantity
+func toHexQuantity(decimal string) string {
+	n, ok := new(big.Int).SetString(decimal, 10)
+	if !ok {
+		return ""
+	}
+	return "0x" + n.Text(16)
+}
+
+// simulate runs a step through the wallet service's simulator
+func (e *Engine) simulate(ctx context.Context, chain, from string, step planStep) (*clients.SimulationResult, error) {
+	result, err := e.wallet.Simulate(ctx, clients.SimulationRequest{
+		Chain:           chain,
+		From:            from,
+		To:              step.Call.To,
+		Value:           step.Call.Value,
+		Data:            step.Call.Data,
+		TokenBalances:   step.Balances,
+		TokenAllowances: step.Allowances,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("simulation failed: %w", err)
+	}
+	return result, nil
+}
+
+// buildTransaction has the wallet service price and estimate a call
+func (e *Engine) buildTransaction(ctx context.Context, chain, from string, call clients.Call) (*clients.Transaction, error) {
+	tx, err := e.wallet.Build(ctx, clients.BuildRequest{
+		Chain:        chain,
+		From:         from,
+		To:           call.To,
+		Value:        call.Value,
+		Data:         call.Data,
+		ReserveNonce: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build transaction: %w", err)
+	}
+	return tx, nil
+}
+
+// reserveNonce reserves the next nonce for an account through the wallet service
+func (e *Engine) reserveNonce(ctx context.Context, chain, address string) (uint64, error) {
+	nonce, err := e.wallet.ReserveNonce(ctx, chain, address)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reserve nonce: %w", err)
+	}
+	return nonce, nil
+}
+
+// releaseNonce returns a reserved nonce that will not be used
+func (e *Engine) releaseNonce(ctx context.Context, chain, address string, nonce uint64) {
+	if err := e.wallet.ReleaseNonce(ctx, chain, address, nonce); err != nil {
+		log.Printf("Error releasing nonce %d for %s: %v", nonce, address, err)
+	}
+}
+
+// checkExpectation compares a simulated balance change against the expected
+// one. Without a trace the change is unknown, which fails the check unless
+// allowUntraced is set.
+func checkExpectation(sim *clients.SimulationResult, expect *expectation, maxDeviation float64, allowUntraced bool) error {
+	if expect == nil {
+		return nil
+	}
+	if !sim.TraceAvailable {
+		if !allowUntraced {
+			return errUntraced
+		}
+		log.Printf("Balance changes unavailable from simulation, skipping expectation check for %s", expect.Token)
+		return nil
+	}
+
+	var actual float64
+	for _, change := range sim.BalanceChanges {
+		if strings.EqualFold(change.Token, expect.Token) {
+			actual = change.Amount
+			break
+		}
+	}
+
+	if expect.Amount == 0 {
+		return nil
+	}
+	deviation := math.Abs(actual-expect.Amount) / math.Abs(expect.Amount)
+	if deviation > maxDeviation {
+		return fmt.Errorf("simulated balance change %f deviates from expected %f", actual, expect.Amount)
+	}
+	return nil
+}
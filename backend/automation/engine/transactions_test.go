@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/defioptimization/shared/clients"
+	"github.com/defioptimization/shared/models"
+)
+
+func TestCheckExpectation(t *testing.T) {
+	const token = "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	traced := func(amount float64) *clients.SimulationResult {
+		return &clients.SimulationResult{
+			Success:        true,
+			TraceAvailable: true,
+			BalanceChanges: []clients.BalanceChange{{Token: "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", Amount: amount}},
+		}
+	}
+	untraced := &clients.SimulationResult{Success: true}
+
+	tests := []struct {
+		name          string
+		sim           *clients.SimulationResult
+		expect        *expectation
+		allowUntraced bool
+		wantErr       error
+		fails         bool
+	}{
+		{"no expectation", untraced, nil, false, nil, false},
+		{"matches", traced(-100), &expectation{Token: token, Amount: -100}, false, nil, false},
+		{"within deviation", traced(-99.6), &expectation{Token: token, Amount: -100}, false, nil, false},
+		{"beyond deviation", traced(-90), &expectation{Token: token, Amount: -100}, false, nil, true},
+		{"token missing from trace", &clients.SimulationResult{Success: true, TraceAvailable: true}, &expectation{Token: token, Amount: 100}, false, nil, true},
+		{"untraced refused", untraced, &expectation{Token: token, Amount: -100}, false, errUntraced, true},
+		{"untraced allowed", untraced, &expectation{Token: token, Amount: -100}, true, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkExpectation(tt.sim, tt.expect, defaultMaxDeviation, tt.allowUntraced)
+			if (err != nil) != tt.fails {
+				t.Fatalf("checkExpectation() = %v, want failure %v", err, tt.fails)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("checkExpectation() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddApprovalsResetsNonZeroAllowance(t *testing.T) {
+	const (
+		usdt   = "0xdAC17F958D2ee523a2206206994597C13D831ec7"
+		pool   = "0x87870Bca3F3fD6335C3F4ce8392A693fcE16f1D7"
+		reset  = "0xreset"
+		assign = "0xapprove"
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A partial allowance is outstanding, so the wallet service asks for a reset first
+		json.NewEncoder(w).Encode(clients.AllowanceCheck{
+			Allowance: "5",
+			Approval:  &clients.Call{To: usdt, Data: assign, Value: "0"},
+			Reset:     &clients.Call{To: usdt, Data: reset, Value: "0"},
+		})
+	}))
+	defer srv.Close()
+
+	e := &Engine{wallet: clients.NewWallet(srv.URL)}
+	deposit := planStep{Call: clients.Call{
+		To:       pool,
+		Data:     "0xsupply",
+		Requires: &clients.AllowanceRequirement{Token: usdt, Spender: pool, Amount: "100"},
+	}}
+	plan, err := e.addApprovals(context.Background(), actionPlan{Chain: "ethereum", Steps: []planStep{deposit}}, "0x1111111111111111111111111111111111111111")
+	if err != nil {
+		t.Fatalf("addApprovals: %v", err)
+	}
+
+	if len(plan.Steps) != 3 {
+		t.Fatalf("got %d steps, want reset, approve and deposit", len(plan.Steps))
+	}
+	if plan.Steps[0].Call.Data != reset || plan.Steps[0].dependent() {
+		t.Fatalf("step 1 = %+v, want the independent reset", plan.Steps[0])
+	}
+	if plan.Steps[1].Call.Data != assign || plan.Steps[1].Allowances[usdt][pool] != "0" {
+		t.Fatalf("step 2 = %+v, want the approve simulated after the reset", plan.Steps[1])
+	}
+	if plan.Steps[2].Call.Data != "0xsupply" || plan.Steps[2].Allowances[usdt][pool] != "100" {
+		t.Fatalf("step 3 = %+v, want the deposit simulated with the approval", plan.Steps[2])
+	}
+}
+
+func TestSubmitPlanReleasesUnrequestedNonces(t *testing.T) {
+	const wallet = "0x1111111111111111111111111111111111111111"
+
+	// The wallet takes the first request and the relay fails on the second
+	var requested, released []uint64
+	next := uint64(10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/wallet/sessions/active":
+			json.NewEncoder(w).Encode(clients.Session{Topic: "topic", WalletAddress: wallet})
+		case "/api/v1/wallet/simulate":
+			json.NewEncoder(w).Encode(clients.SimulationResult{Success: true})
+		case "/api/v1/wallet/build":
+			nonce := next
+			next++
+			json.NewEncoder(w).Encode(clients.Transaction{From: wallet, To: "0xpool", Data: "0x", Value: "0", Nonce: &nonce})
+		case "/api/v1/wallet/sessions/topic/request":
+			var req clients.SessionRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if len(requested) == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				json.NewEncoder(w).Encode(map[string]string{"error": "relay unavailable"})
+				return
+			}
+			var nonce uint64
+			fmt.Sscanf(req.Params[0].(map[string]interface{})["nonce"].(string), "0x%x", &nonce)
+			requested = append(requested, nonce)
+			json.NewEncoder(w).Encode(map[string]interface{}{"request_id": 1})
+		case "/api/v1/wallet/nonces/release":
+			var req struct {
+				Nonce uint64 `json:"nonce"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			released = append(released, req.Nonce)
+			json.NewEncoder(w).Encode(map[string]string{"status": "released"})
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	e := &Engine{wallet: clients.NewWallet(srv.URL)}
+	plan := actionPlan{Chain: "ethereum", Steps: []planStep{
+		{Call: clients.Call{To: "0xpool", Data: "0x", Value: "0", Description: "first"}},
+		{Call: clients.Call{To: "0xpool", Data: "0x", Value: "0", Description: "second"}},
+		{Call: clients.Call{To: "0xpool", Data: "0x", Value: "0", Description: "third"}},
+	}}
+	err := e.submitPlan(context.Background(), models.AutomationRule{ID: 1, ActionType: "deposit"}, models.User{WalletAddress: wallet}, plan)
+	if err == nil {
+		t.Fatal("submitPlan succeeded with the relay down")
+	}
+
+	// The wallet may still send the first transaction, so only the later
+	// nonces go back to the pool
+	if !reflect.DeepEqual(requested, []uint64{10}) || !reflect.DeepEqual(released, []uint64{11, 12}) {
+		t.Fatalf("requested %v and released %v, want [10] and [11 12]", requested, released)
+	}
+}
@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/shared/rules"
+)
+
+// defaultAlternatives are the protocols apy_spread compares against when the
+// rule doesn't list its own
+var defaultAlternatives = []string{"aave", "compound"}
+
+// checkPriceChange checks if an asset's oracle price moved by the threshold
+// percentage over the window. A negative threshold watches for drops.
+func (e *Engine) checkPriceChange(ctx context.Context, rule models.AutomationRule) (bool, float64, error) {
+	var config rules.PriceChangeTrigger
+	if err := rules.Decode(rule.TriggerConfig, &config); err != nil {
+		return false, 0, err
+	}
+
+	current, err := e.source.Price(ctx, config.Asset, config.Chain, 0)
+	if err != nil {
+		return false, 0, err
+	}
+	past, err := e.source.Price(ctx, config.Asset, config.Chain, config.WindowDuration())
+	if err != nil {
+		return false, 0, err
+	}
+	if past == 0 {
+		return false, 0, fmt.Errorf("no %s price %s ago", config.Asset, config.WindowDuration())
+	}
+
+	change := (current - past) / past * 100
+	observe(ctx, "price", current)
+	observe(ctx, "past_price", past)
+	observe(ctx, "change_pct", change)
+	if config.Threshold < 0 {
+		return change <= config.Threshold, change, nil
+	}
+	return change >= config.Threshold, change, nil
+}
+
+// checkAPYSpread checks if the best alternative protocol pays more than the
+// threshold above the rule's protocol, in percentage points
+func (e *Engine) checkAPYSpread(ctx context.Context, rule models.AutomationRule) (bool, float64, error) {
+	var config rules.APYSpreadTrigger
+	if err := rules.Decode(rule.TriggerConfig, &config); err != nil {
+		return false, 0, err
+	}
+	protocol, asset, chain := config.Protocol, config.Asset, config.Chain
+
+	alternatives := config.Alternatives
+	if len(alternatives) == 0 {
+		alternatives = defaultAlternatives
+	}
+
+	current, err := e.source.APY(ctx, protocol, asset, chain)
+	if err != nil {
+		return false, 0, err
+	}
+	observe(ctx, "apy", current)
+
+	// Alternatives that don't list the asset are skipped
+	best, found := 0.0, false
+	for _, alt := range alternatives {
+		if alt == protocol {
+			continue
+		}
+		apy, err := e.source.APY(ctx, alt, asset, chain)
+		if err != nil {
+			continue
+		}
+		observe(ctx, alt+"_apy", apy)
+		if !found || apy > best {
+			best, found = apy, true
+		}
+	}
+	if !found {
+		return false, 0, fmt.Errorf("no alternative protocol reports an APY for %s on %s", asset, chain)
+	}
+
+	spread := best - current
+	observe(ctx, "spread", spread)
+	return spread > config.Threshold, spread, nil
+}
+
+// checkUtilization checks if a reserve's utilization is above the threshold
+// percentage, an early warning that withdrawals may run out of liquidity
+func (e *Engine) checkUtilization(ctx context.Context, rule models.AutomationRule) (bool, float64, error) {
+	var config rules.UtilizationTrigger
+	if err := rules.Decode(rule.TriggerConfig, &config); err != nil {
+		return false, 0, err
+	}
+
+	utilization, err := e.source.Utilization(ctx, config.Protocol, config.Asset, config.Chain)
+	if err != nil {
+		return false, 0, err
+	}
+	observe(ctx, "utilization", utilization)
+
+	return utilization > config.Threshold, utilization, nil
+}
+
+// checkGasBelow checks if the expected gas price is below the threshold in
+// gwei, so actions only run while gas is cheap
+func (e *Engine) checkGasBelow(ctx context.Context, rule models.AutomationRule) (bool, float64, error) {
+	var config rules.GasBelowTrigger
+	if err := rules.Decode(rule.TriggerConfig, &config); err != nil {
+		return false, 0, err
+	}
+
+	gasPrice, err := e.source.GasPrice(ctx, config.Chain)
+	if err != nil {
+		return false, 0, err
+	}
+	observe(ctx, "gas_price_gwei", gasPrice)
+
+	return gasPrice < config.Threshold, gasPrice, nil
+}
+
+// checkSchedule checks if the cron schedule fired since the rule last
+// executed, or since it was created. A run missed while the engine was down,
+// or while other conditions of a composite trigger were false, is made up
+// once rather than once per missed slot.
+func (e *Engine) checkSchedule(ctx context.Context, rule models.AutomationRule) (bool, float64, error) {
+	var config rules.ScheduleTrigger
+	if err := rules.Decode(rule.TriggerConfig, &config); err != nil {
+		return false, 0, err
+	}
+	schedule, err := rules.ParseCron(config.Cron)
+	if err != nil {
+		return false, 0, err
+	}
+
+	since := rule.CreatedAt
+	if rule.LastExecutedAt != nil {
+		since = *rule.LastExecutedAt
+	}
+
+	next := schedule.Next(since.In(config.Location()))
+	observe(ctx, "next_run", next)
+	return !next.IsZero() && !next.After(e.source.Now()), 0, nil
+}
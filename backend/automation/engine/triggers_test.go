@@ -0,0 +1,321 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/shared/clients"
+	"github.com/defioptimization/shared/models"
+)
+
+// fakeSource serves fixed trigger data. Keys are joined with "|"; a missing
+// key is an error, as a protocol not listing an asset would be.
+type fakeSource struct {
+	now         time.Time
+	apy         map[string]float64 // protocol|asset|chain
+	prices      map[string]float64 // asset|chain|ago
+	utilization map[string]float64 // protocol|asset|chain
+	gas         map[string]float64 // chain
+	health      map[string]float64 // protocol|chain|account
+	risk        map[string]float64 // chain|account
+}
+
+var errNoData = errors.New("no data")
+
+func lookup(m map[string]float64, key ...string) (float64, error) {
+	v, ok := m[strings.Join(key, "|")]
+	if !ok {
+		return 0, errNoData
+	}
+	return v, nil
+}
+
+func (s *fakeSource) Now() time.Time { return s.now }
+func (s *fakeSource) APY(ctx context.Context, protocol, asset, chain string) (float64, error) {
+	return lookup(s.apy, protocol, asset, chain)
+}
+func (s *fakeSource) Price(ctx context.Context, asset, chain string, ago time.Duration) (float64, error) {
+	return lookup(s.prices, asset, chain, ago.String())
+}
+func (s *fakeSource) Utilization(ctx context.Context, protocol, asset, chain string) (float64, error) {
+	return lookup(s.utilization, protocol, asset, chain)
+}
+func (s *fakeSource) GasPrice(ctx context.Context, chain string) (float64, error) {
+	return lookup(s.gas, chain)
+}
+func (s *fakeSource) HealthFactor(ctx context.Context, protocol, chain, account string) (float64, error) {
+	return lookup(s.health, protocol, chain, strings.ToLower(account))
+}
+func (s *fakeSource) LiquidationRisk(ctx context.Context, userID uint, chain, account string) (float64, error) {
+	return lookup(s.risk, chain, strings.ToLower(account))
+}
+
+// triggerCase is a trigger evaluated against a fakeSource
+type triggerCase struct {
+	name    string
+	trigger string
+	config  map[string]interface{}
+	fired   bool
+	value   float64
+	wantErr bool
+}
+
+func runTriggerCases(t *testing.T, e *Engine, rule models.AutomationRule, tests []triggerCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rule
+			r.TriggerType = tt.trigger
+			r.TriggerConfig = tt.config
+			fired, value, err := e.checkTrigger(context.Background(), r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkTrigger error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if fired != tt.fired {
+				t.Fatalf("checkTrigger fired = %v, want %v", fired, tt.fired)
+			}
+			if !tt.wantErr && tt.trigger != "schedule" && tt.trigger != "composite" && value != tt.value {
+				t.Fatalf("checkTrigger value = %v, want %v", value, tt.value)
+			}
+		})
+	}
+}
+
+func TestCheckTrigger(t *testing.T) {
+	src := &fakeSource{
+		now: time.Date(2024, 3, 1, 11, 5, 0, 0, time.UTC),
+		apy: map[string]float64{
+			"aave|USDC|ethereum":     3.5,
+			"compound|USDC|ethereum": 2,
+			"aave|DAI|ethereum":      4,
+		},
+		prices: map[string]float64{
+			"WETH|ethereum|0s":     2700,
+			"WETH|ethereum|1h0m0s": 3000,
+			"DAI|ethereum|0s":      1,
+			"DAI|ethereum|1h0m0s":  0,
+			"WETH|base|0s":         3000,
+			"WETH|base|24h0m0s":    2400,
+		},
+		utilization: map[string]float64{"aave|USDC|ethereum": 92},
+		gas:         map[string]float64{"ethereum": 12, "base": 0.05},
+	}
+	e := (&Engine{}).sandboxed(modeBacktest, src)
+	rule := models.AutomationRule{ID: 1, CreatedAt: time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)}
+
+	runTriggerCases(t, e, rule, []triggerCase{
+		{"apy_drop fired", "apy_drop", map[string]interface{}{"protocol": "compound", "asset": "USDC", "threshold": 2.5}, true, 2, false},
+		{"apy_drop not fired", "apy_drop", map[string]interface{}{"protocol": "aave", "asset": "USDC", "threshold": 2.5}, false, 3.5, false},
+		{"apy_drop without data", "apy_drop", map[string]interface{}{"protocol": "compound", "asset": "DAI", "threshold": 2.5}, false, 0, true},
+		{"apy_drop invalid config", "apy_drop", map[string]interface{}{"protocol": "aave", "asset": "USDC"}, false, 0, true},
+
+		{"price_change drop fired", "price_change", map[string]interface{}{"asset": "WETH", "window": "1h", "threshold": -5.0}, true, -10, false},
+		{"price_change drop not fired", "price_change", map[string]interface{}{"asset": "WETH", "window": "1h", "threshold": -15.0}, false, -10, false},
+		{"price_change rise not fired on a drop", "price_change", map[string]interface{}{"asset": "WETH", "window": "1h", "threshold": 5.0}, false, -10, false},
+		{"price_change rise fired", "price_change", map[string]interface{}{"asset": "WETH", "chain": "base", "window": "24h", "threshold": 20.0}, true, 25, false},
+		{"price_change without a past price", "price_change", map[string]interface{}{"asset": "DAI", "window": "1h", "threshold": 5.0}, false, 0, true},
+
+		{"apy_spread fired", "apy_spread", map[string]interface{}{"protocol": "compound", "asset": "USDC", "threshold": 1.0}, true, 1.5, false},
+		{"apy_spread not fired", "apy_spread", map[string]interface{}{"protocol": "compound", "asset": "USDC", "threshold": 2.0}, false, 1.5, false},
+		{"apy_spread negative", "apy_spread", map[string]interface{}{"protocol": "aave", "asset": "USDC", "threshold": 0.5}, false, -1.5, false},
+		// compound doesn't list DAI, leaving no alternative to compare with
+		{"apy_spread without alternatives", "apy_spread", map[string]interface{}{"protocol": "aave", "asset": "DAI", "threshold": 0.5}, false, 0, true},
+
+		{"utilization fired", "utilization", map[string]interface{}{"protocol": "aave", "asset": "USDC", "threshold": 90.0}, true, 92, false},
+		{"utilization not fired", "utilization", map[string]interface{}{"protocol": "aave", "asset": "USDC", "threshold": 95.0}, false, 92, false},
+
+		{"gas_below fired", "gas_below", map[string]interface{}{"threshold": 20.0}, true, 12, false},
+		{"gas_below not fired", "gas_below", map[string]interface{}{"threshold": 10.0}, false, 12, false},
+		{"gas_below on base", "gas_below", map[string]interface{}{"chain": "base", "threshold": 0.1}, true, 0.05, false},
+
+		// The rule was created at 10:30 and it is now 11:05
+		{"schedule fired since creation", "schedule", map[string]interface{}{"cron": "0 * * * *"}, true, 0, false},
+		{"schedule not due", "schedule", map[string]interface{}{"cron": "30 11 * * *"}, false, 0, false},
+		{"schedule in a time zone", "schedule", map[string]interface{}{"cron": "0 12 * * *", "timezone": "Europe/Berlin"}, true, 0, false},
+		{"schedule that never fires", "schedule", map[string]interface{}{"cron": "0 0 31 2 *"}, false, 0, false},
+
+		{"unknown trigger", "moon_phase", map[string]interface{}{}, false, 0, true},
+	})
+}
+
+func TestCheckScheduleSinceLastExecution(t *testing.T) {
+	now := time.Date(2024, 3, 1, 11, 5, 0, 0, time.UTC)
+	e := (&Engine{}).sandboxed(modeBacktest, &fakeSource{now: now})
+	config := map[string]interface{}{"cron": "0 * * * *"}
+
+	for _, tt := range []struct {
+		lastExecuted time.Time
+		fired        bool
+	}{
+		{time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC), false},
+		{time.Date(2024, 3, 1, 10, 59, 0, 0, time.UTC), true},
+		// Missed runs are made up once
+		{time.Date(2024, 2, 28, 3, 0, 0, 0, time.UTC), true},
+	} {
+		last := tt.lastExecuted
+		rule := models.AutomationRule{
+			CreatedAt:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			LastExecutedAt: &last,
+			TriggerType:    "schedule",
+			TriggerConfig:  config,
+		}
+		fired, _, err := e.checkTrigger(context.Background(), rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fired != tt.fired {
+			t.Errorf("last executed %s: fired = %v, want %v", last.Format(time.Kitchen), fired, tt.fired)
+		}
+	}
+}
+
+func TestCheckComposite(t *testing.T) {
+	src := &fakeSource{
+		now:         time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		gas:         map[string]float64{"ethereum": 12},
+		utilization: map[string]float64{"aave|USDC|ethereum": 92},
+	}
+	e := (&Engine{}).sandboxed(modeBacktest, src)
+	cheapGas := map[string]interface{}{"type": "gas_below", "threshold": 20.0}
+	highUtilization := map[string]interface{}{"type": "utilization", "protocol": "aave", "asset": "USDC", "threshold": 90.0}
+	lowUtilization := map[string]interface{}{"type": "utilization", "protocol": "aave", "asset": "USDC", "threshold": 95.0}
+
+	runTriggerCases(t, e, models.AutomationRule{ID: 1}, []triggerCase{
+		{"and fired", "composite", map[string]interface{}{"condition": map[string]interface{}{"and": []interface{}{cheapGas, highUtilization}}}, true, 0, false},
+		{"and not fired", "composite", map[string]interface{}{"condition": map[string]interface{}{"and": []interface{}{cheapGas, lowUtilization}}}, false, 0, false},
+		{"or fired", "composite", map[string]interface{}{"condition": map[string]interface{}{"or": []interface{}{lowUtilization, cheapGas}}}, true, 0, false},
+		{"not", "composite", map[string]interface{}{"condition": map[string]interface{}{"not": cheapGas}}, false, 0, false},
+		{"leaf error", "composite", map[string]interface{}{"condition": map[string]interface{}{"and": []interface{}{
+			cheapGas,
+			map[string]interface{}{"type": "gas_below", "chain": "base", "threshold": 1.0},
+		}}}, false, 0, true},
+	})
+
+	// A node with a for duration only counts once it has held that long
+	rule := models.AutomationRule{ID: 2, TriggerType: "composite", TriggerConfig: map[string]interface{}{
+		"condition": map[string]interface{}{"type": "gas_below", "threshold": 20.0, "for": "30m"},
+	}}
+	for _, step := range []struct {
+		gas   float64
+		after time.Duration
+		fired bool
+	}{
+		{12, 0, false},
+		{12, 20 * time.Minute, false},
+		{12, 30 * time.Minute, true},
+		{25, 0, false}, // stops holding
+		{12, 10 * time.Minute, false},
+	} {
+		src.now = src.now.Add(step.after)
+		src.gas["ethereum"] = step.gas
+		fired, _, err := e.checkTrigger(context.Background(), rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fired != step.fired {
+			t.Fatalf("at %s with gas %v: fired = %v, want %v", src.now.Format(time.Kitchen), step.gas, fired, step.fired)
+		}
+	}
+}
+
+// TestCompositeSharedFetch checks that leaves reading the same data during
+// one evaluation share a request to the services
+func TestCompositeSharedFetch(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path+"?"+r.URL.RawQuery]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/api/v1/protocols/aave/utilization":
+			json.NewEncoder(w).Encode(clients.Utilization{Utilization: 92, BlockNumber: 100})
+		case "/api/v1/wallet/gas":
+			json.NewEncoder(w).Encode(clients.GasPrice{GasPriceGwei: 12})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	e := NewEngine(srv.URL, srv.URL, srv.URL)
+	// The series were just sampled, so the reads store nothing
+	for _, sample := range []models.MetricSample{
+		{Metric: metricUtilization, Chain: "ethereum", Protocol: "aave", Asset: "USDC"},
+		{Metric: metricGasPrice, Chain: "ethereum"},
+	} {
+		e.samples.last[seriesKey(&sample)] = time.Now().Add(time.Hour)
+	}
+	e = e.sandboxed(modeDryRun, e.live())
+
+	utilization := func(threshold float64) map[string]interface{} {
+		return map[string]interface{}{"type": "utilization", "protocol": "aave", "asset": "USDC", "threshold": threshold}
+	}
+	cheapGas := map[string]interface{}{"type": "gas_below", "threshold": 20.0}
+	rule := models.AutomationRule{ID: 1, TriggerType: "composite", TriggerConfig: map[string]interface{}{
+		"condition": map[string]interface{}{"or": []interface{}{
+			map[string]interface{}{"and": []interface{}{utilization(95), cheapGas}},
+			map[string]interface{}{"and": []interface{}{utilization(90), map[string]interface{}{"not": cheapGas}}},
+			utilization(80),
+		}},
+	}}
+
+	for i := 0; i < 2; i++ {
+		fired, _, err := e.checkTrigger(clients.WithReadCache(context.Background()), rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fired {
+			t.Fatal("composite trigger didn't fire")
+		}
+	}
+
+	// Each evaluation fetched each series once
+	if len(requests) != 2 {
+		t.Fatalf("requests = %v, want utilization and gas", requests)
+	}
+	for path, n := range requests {
+		if n != 2 {
+			t.Errorf("%s requested %d times over two evaluations, want 2", path, n)
+		}
+	}
+}
+
+// TestCheckAccountTriggers covers the triggers that read the rule owner's
+// wallet address from the database
+func TestCheckAccountTriggers(t *testing.T) {
+	testDB(t)
+	user := testUser(t)
+	account := strings.ToLower(user.WalletAddress)
+
+	src := &fakeSource{
+		now:    time.Now(),
+		health: map[string]float64{"aave|ethereum|" + account: 1.2, "compound|base|" + account: 2.5},
+		risk:   map[string]float64{"ethereum|" + account: 0.35},
+	}
+	e := (&Engine{}).sandboxed(modeBacktest, src)
+
+	runTriggerCases(t, e, models.AutomationRule{UserID: user.ID}, []triggerCase{
+		{"health_factor fired", "health_factor", map[string]interface{}{"threshold": 1.3}, true, 1.2, false},
+		{"health_factor not fired", "health_factor", map[string]interface{}{"threshold": 1.1}, false, 1.2, false},
+		{"health_factor on compound", "health_factor", map[string]interface{}{"protocol": "compound", "chain": "base", "threshold": 1.5}, false, 2.5, false},
+		{"health_factor without a position", "health_factor", map[string]interface{}{"protocol": "compound", "threshold": 1.5}, false, 0, true},
+		{"risk_threshold fired", "risk_threshold", map[string]interface{}{"threshold": 0.3}, true, 0.35, false},
+		{"risk_threshold not fired", "risk_threshold", map[string]interface{}{"threshold": 0.5}, false, 0.35, false},
+	})
+
+	// Rules of a deleted user can't be evaluated
+	_, _, err := e.checkTrigger(context.Background(), models.AutomationRule{
+		UserID: user.ID + 1_000_000, TriggerType: "health_factor", TriggerConfig: map[string]interface{}{"threshold": 1.3},
+	})
+	if err == nil {
+		t.Fatal("health_factor of a missing user evaluated")
+	}
+}
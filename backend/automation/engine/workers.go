@@ -0,0 +1,168 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"gorm.io/gorm"
+)
+
+// ruleLockClass namespaces the advisory locks held while evaluating rules
+const ruleLockClass = 0x52554c45 // "RULE"
+
+// defaultWorkers is the number of rules evaluated concurrently
+const defaultWorkers = 8
+
+const (
+	// ruleEvaluationTimeout bounds how long an evaluation holds its rule's
+	// lock, and the connection the lock lives on
+	ruleEvaluationTimeout = 2 * time.Minute
+	// ruleUnlockTimeout bounds releasing a rule's lock
+	ruleUnlockTimeout = 5 * time.Second
+)
+
+// errExecutionClaimed is returned when a rule's execution could not be
+// claimed because another worker executed it, or it was disabled, after
+// this worker loaded it
+var errExecutionClaimed = errors.New("execution already claimed")
+
+// evaluateAll evaluates rules concurrently on at most e.workers goroutines
+func (e *Engine) evaluateAll(ctx context.Context, rules []models.AutomationRule, source string) {
+	sem := make(chan struct{}, e.workers)
+	var wg sync.WaitGroup
+
+	for _, rule := range rules {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+
+		wg.Add(1)
+		go func(id uint) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := e.evaluateExclusive(ctx, id, source); err != nil {
+				log.Printf("Error evaluating rule %d: %v", id, err)
+			}
+		}(rule.ID)
+	}
+
+	wg.Wait()
+}
+
+// evaluateExclusive evaluates a rule while holding its advisory lock, so no
+// two workers evaluate it at once. The lock is held on a connection set aside
+// for the evaluation, for at most ruleEvaluationTimeout, and released when it
+// ends, or by Postgres when the connection drops. The evaluation's own queries
+// use other connections, so the pool needs more than workers of them. A rule
+// locked elsewhere is skipped; that worker is already evaluating it.
+func (e *Engine) evaluateExclusive(ctx context.Context, ruleID uint, source string) error {
+	ctx, cancel := context.WithTimeout(ctx, ruleEvaluationTimeout)
+	defer cancel()
+
+	sqlDB, err := database.DB.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1, $2)", int32(ruleLockClass), int32(ruleID)).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	defer unlockRule(conn, ruleID)
+
+	// The copy loaded with the shard may predate another worker's
+	// execution, so evaluate the rule as it is now
+	var rule models.AutomationRule
+	err = database.DB.WithContext(ctx).Where("id = ? AND enabled = ?", ruleID, true).First(&rule).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return e.evaluateRule(ctx, rule, source)
+}
+
+// unlockRule releases a rule's advisory lock. A connection whose lock can't
+// be released is closed rather than returned to the pool still holding it.
+func unlockRule(conn *sql.Conn, ruleID uint) {
+	ctx, cancel := context.WithTimeout(context.Background(), ruleUnlockTimeout)
+	defer cancel()
+
+	var unlocked bool
+	err := conn.QueryRowContext(ctx, "SELECT pg_advisory_unlock($1, $2)", int32(ruleLockClass), int32(ruleID)).Scan(&unlocked)
+	if err == nil && unlocked {
+		return
+	}
+	log.Printf("Error releasing lock of rule %d (released: %v): %v", ruleID, unlocked, err)
+	conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+}
+
+// enqueueAction claims a rule's execution and queues its action in one
+// transaction, updating the rule's execution tracking. The claim only applies
+// if the execution count is still the one the evaluation read, so of two
+// workers racing on a rule only one can queue the execution; the other gets
+// errExecutionClaimed. The job's idempotency key is the claimed execution's
+// number, so an execution is never queued twice. Executions happen at most
+// once: one whose job is dead-lettered is still counted.
+func enqueueAction(ctx context.Context, rule *models.AutomationRule, now time.Time) (*models.ActionJob, error) {
+	claimed := *rule
+	recordExecution(&claimed, now)
+
+	job := &models.ActionJob{
+		IdempotencyKey: fmt.Sprintf("rule-%d-execution-%d", rule.ID, claimed.ExecutionCount),
+		RuleID:         rule.ID,
+		UserID:         rule.UserID,
+		ActionType:     rule.ActionType,
+		ActionConfig:   rule.ActionConfig,
+		Status:         models.JobQueued,
+		RunAt:          now,
+		MaxAttempts:    maxJobAttempts,
+	}
+
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.AutomationRule{}).
+			Where("id = ? AND enabled = ? AND execution_count = ?", rule.ID, true, rule.ExecutionCount).
+			Updates(map[string]interface{}{
+				"last_executed_at": claimed.LastExecutedAt,
+				"execution_count":  claimed.ExecutionCount,
+				"executions_today": claimed.ExecutionsToday,
+				"execution_day":    claimed.ExecutionDay,
+				"armed":            claimed.Armed,
+				"enabled":          claimed.Enabled,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errExecutionClaimed
+		}
+		return tx.Create(job).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	*rule = claimed
+	return job, nil
+}
@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+)
+
+// firingRule creates a rule whose gas trigger fires against gasSource, with
+// a cooldown that lets it execute once
+func firingRule(t *testing.T) models.AutomationRule {
+	t.Helper()
+	rule := testRule(t, testUser(t), models.AutomationRule{
+		TriggerType:     "gas_below",
+		TriggerConfig:   map[string]interface{}{"threshold": 20.0},
+		ActionType:      "withdraw",
+		ActionConfig:    map[string]interface{}{"protocol": "aave", "asset": "USDC", "amount": 100.0},
+		CooldownSeconds: 3600,
+	})
+	t.Cleanup(func() {
+		database.DB.Where("rule_id = ?", rule.ID).Delete(&models.ActionJob{})
+		database.DB.Where("rule_id = ?", rule.ID).Delete(&models.RuleExecution{})
+	})
+	return rule
+}
+
+var gasSource = &fakeSource{gas: map[string]float64{"ethereum": 12}}
+
+func testEngine() *Engine {
+	return &Engine{source: gasSource, jobsReady: make(chan struct{}, 1)}
+}
+
+// executions returns a rule's execution count and queued jobs
+func executions(t *testing.T, ruleID uint) (int, int64) {
+	t.Helper()
+	var rule models.AutomationRule
+	if err := database.DB.First(&rule, ruleID).Error; err != nil {
+		t.Fatal(err)
+	}
+	var jobs int64
+	if err := database.DB.Model(&models.ActionJob{}).Where("rule_id = ?", ruleID).Count(&jobs).Error; err != nil {
+		t.Fatal(err)
+	}
+	return rule.ExecutionCount, jobs
+}
+
+func TestEnqueueActionClaimsOnce(t *testing.T) {
+	testDB(t)
+	rule := firingRule(t)
+
+	// Every worker read the rule before any of them executed it
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stale := rule
+			_, err := enqueueAction(context.Background(), &stale, time.Now())
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	queued := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			queued++
+		case !errors.Is(err, errExecutionClaimed):
+			t.Fatalf("enqueueAction: %v", err)
+		}
+	}
+	if queued != 1 {
+		t.Fatalf("%d workers queued the execution, want 1", queued)
+	}
+	if count, jobs := executions(t, rule.ID); count != 1 || jobs != 1 {
+		t.Fatalf("execution count %d with %d jobs, want 1 and 1", count, jobs)
+	}
+}
+
+func TestEvaluateExclusive(t *testing.T) {
+	testDB(t)
+	ctx := context.Background()
+
+	t.Run("skips a rule locked elsewhere", func(t *testing.T) {
+		rule := firingRule(t)
+		sqlDB, err := database.DB.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := sqlDB.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		var locked bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1, $2)", int32(ruleLockClass), int32(rule.ID)).Scan(&locked); err != nil || !locked {
+			t.Fatalf("locking rule: %v, %v", locked, err)
+		}
+
+		if err := testEngine().evaluateExclusive(ctx, rule.ID, "poll"); err != nil {
+			t.Fatal(err)
+		}
+		if count, jobs := executions(t, rule.ID); count != 0 || jobs != 0 {
+			t.Fatalf("locked rule executed: count %d, %d jobs", count, jobs)
+		}
+
+		unlockRule(conn, rule.ID)
+		if err := testEngine().evaluateExclusive(ctx, rule.ID, "poll"); err != nil {
+			t.Fatal(err)
+		}
+		if count, jobs := executions(t, rule.ID); count != 1 || jobs != 1 {
+			t.Fatalf("unlocked rule: count %d, %d jobs, want 1 and 1", count, jobs)
+		}
+	})
+
+	t.Run("two engines race", func(t *testing.T) {
+		rule := firingRule(t)
+		var wg sync.WaitGroup
+		for _, e := range []*Engine{testEngine(), testEngine()} {
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func(e *Engine) {
+					defer wg.Done()
+					if err := e.evaluateExclusive(ctx, rule.ID, "poll"); err != nil {
+						t.Error(err)
+					}
+				}(e)
+			}
+		}
+		wg.Wait()
+
+		if count, jobs := executions(t, rule.ID); count != 1 || jobs != 1 {
+			t.Fatalf("execution count %d with %d jobs, want 1 and 1", count, jobs)
+		}
+	})
+}
+
+func TestStartShutdown(t *testing.T) {
+	testDB(t)
+	rule := firingRule(t)
+	job := testJob(t, rule, 3)
+
+	// The services hang until released, so the job is mid-run at shutdown
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	defer func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	}()
+
+	e := NewEngine(srv.URL, srv.URL, srv.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- e.Start(ctx, time.Hour, 1, 1) }()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for loadJob(t, job.ID).Status == models.JobQueued {
+		if time.Now().After(deadline) {
+			t.Fatal("job never claimed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Start returned %v while a job was running", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(release)
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Start returned %v, want context.Canceled", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("Start didn't return after shutdown")
+	}
+
+	// The job ran to the end rather than failing on the cancelled context
+	stored := loadJob(t, job.ID)
+	if stored.Status == models.JobRunning || strings.Contains(stored.LastError, "context canceled") {
+		t.Fatalf("job after shutdown = %s (%s)", stored.Status, stored.LastError)
+	}
+
+	var leases int64
+	database.DB.Model(&models.EngineWorker{}).Where("worker_id = ?", e.members.id).Count(&leases)
+	if leases != 0 {
+		t.Fatal("worker lease kept after shutdown")
+	}
+}
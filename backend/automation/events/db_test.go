@@ -0,0 +1,24 @@
+package events
+
+import (
+	"os"
+	"testing"
+
+	"github.com/defioptimization/shared/database"
+	"gorm.io/gorm/logger"
+)
+
+// testDB connects database.DB to the Postgres database named by
+// TEST_DATABASE_URL and migrates it, skipping the test when it isn't set
+func testDB(t *testing.T) {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	t.Setenv("DATABASE_URL", url)
+	if err := database.InitDatabase(); err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	database.DB.Logger = logger.Default.LogMode(logger.Silent)
+}
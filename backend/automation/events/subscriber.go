@@ -0,0 +1,249 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxBlockRange bounds a single eth_getLogs request; most providers reject
+// larger ranges
+const maxBlockRange = 1000
+
+// cursorLockClass namespaces the advisory locks that let only one engine
+// replica at a time follow a chain
+const cursorLockClass = 0x43555253 // "CURS"
+
+// Handler receives events and reports whether it accepted them. It must not
+// block for long; the subscriber waits for it before moving on to the next
+// block range, and stops at a range with an event the handler refused so the
+// range is read again on the next poll.
+type Handler func(Event) bool
+
+// Subscriber follows new blocks on each chain and turns logs from the
+// watched protocol and oracle contracts into events. It polls eth_getLogs
+// over block ranges rather than holding a websocket subscription, so it
+// works with plain HTTP RPC endpoints and can resume from a stored cursor.
+// It stays confirmations blocks behind the head, so logs a shallow reorg
+// removes are never read.
+type Subscriber struct {
+	clients       map[string]*ethclient.Client
+	handler       Handler
+	interval      time.Duration
+	confirmations uint64
+}
+
+// NewSubscriber creates a subscriber over the given chain clients
+func NewSubscriber(clients map[string]*ethclient.Client, handler Handler, interval time.Duration, confirmations uint64) *Subscriber {
+	return &Subscriber{
+		clients:       clients,
+		handler:       handler,
+		interval:      interval,
+		confirmations: confirmations,
+	}
+}
+
+// Start follows every chain until the context is cancelled
+func (s *Subscriber) Start(ctx context.Context) {
+	for chain, client := range s.clients {
+		go s.follow(ctx, chain, client)
+	}
+	<-ctx.Done()
+}
+
+// follow processes new blocks on one chain
+func (s *Subscriber) follow(ctx context.Context, chain string, client *ethclient.Client) {
+	watch := newWatchlist(ctx, client, chain)
+	log.Printf("Watching %d contracts on %s", len(watch.addresses), chain)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.catchUp(ctx, chain, client, watch); err != nil && ctx.Err() == nil {
+			log.Printf("Error processing %s logs: %v", chain, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// catchUpTimeout bounds one catch-up, so a stalled node can't hold a
+// chain's lock indefinitely
+const catchUpTimeout = 5 * time.Minute
+
+// catchUp processes every confirmed block after the stored cursor.
+// With several engine replicas, the one holding the chain's lock follows it
+// and the others skip it until the lock is free. The lock is held on a
+// connection set aside for the catch-up and released when it ends, or by
+// Postgres when the connection drops.
+func (s *Subscriber) catchUp(ctx context.Context, chain string, client *ethclient.Client, watch *watchlist) error {
+	ctx, cancel := context.WithTimeout(ctx, catchUpTimeout)
+	defer cancel()
+
+	sqlDB, err := database.DB.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1, hashtext($2))", int32(cursorLockClass), chain).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	defer unlockChain(conn, chain)
+
+	return s.catchUpLocked(ctx, chain, client, watch)
+}
+
+// unlockChain releases a chain's advisory lock. A connection whose lock can't
+// be released is closed rather than returned to the pool still holding it.
+func unlockChain(conn *sql.Conn, chain string) {
+	ctx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+	defer cancel()
+
+	var unlocked bool
+	err := conn.QueryRowContext(ctx, "SELECT pg_advisory_unlock($1, hashtext($2))", int32(cursorLockClass), chain).Scan(&unlocked)
+	if err == nil && unlocked {
+		return
+	}
+	log.Printf("Error releasing lock of %s cursor (released: %v): %v", chain, unlocked, err)
+	conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+}
+
+// unlockTimeout bounds releasing a chain's lock
+const unlockTimeout = 5 * time.Second
+
+// catchUpLocked processes blocks while holding the chain's lock. The cursor
+// is saved after each range on its own, so a failed request later on only
+// repeats the ranges after it.
+func (s *Subscriber) catchUpLocked(ctx context.Context, chain string, client *ethclient.Client, watch *watchlist) error {
+	head, err := client.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	if head < s.confirmations {
+		return nil
+	}
+	head -= s.confirmations
+
+	db := database.DB.WithContext(ctx)
+	last, err := loadCursor(db, chain)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// First run: start from the confirmed head instead of replaying history
+		return saveCursor(db, chain, head)
+	}
+	if err != nil {
+		return err
+	}
+
+	for from := last + 1; from <= head; from += maxBlockRange {
+		to := from + maxBlockRange - 1
+		if to > head {
+			to = head
+		}
+
+		logs, err := client.FilterLogs(ctx, watch.query(from, to))
+		if err != nil {
+			return err
+		}
+
+		for _, ev := range coalesce(watch, logs) {
+			if !s.handler(ev) {
+				// Keep the cursor before this range; the events already
+				// accepted from it are delivered again next time
+				log.Printf("Handler refused %s event on %s, retrying blocks %d-%d", ev.Source, chain, from, to)
+				return nil
+			}
+		}
+
+		if err := saveCursor(db, chain, to); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// coalesce decodes logs and merges events with the same kind, protocol and
+// asset, so a burst of updates to one reserve triggers a single evaluation
+func coalesce(watch *watchlist, logs []types.Log) []Event {
+	var events []Event
+	index := make(map[string]int)
+
+	for _, l := range logs {
+		if l.Removed {
+			continue
+		}
+		ev, ok := watch.decode(l)
+		if !ok {
+			continue
+		}
+
+		key := ev.Kind + "|" + ev.Protocol + "|" + ev.Asset
+		i, seen := index[key]
+		if !seen {
+			index[key] = len(events)
+			events = append(events, ev)
+			continue
+		}
+
+		merged := &events[i]
+		if ev.BlockNumber > merged.BlockNumber {
+			merged.BlockNumber = ev.BlockNumber
+		}
+		for _, u := range ev.Users {
+			if !containsFold(merged.Users, u) {
+				merged.Users = append(merged.Users, u)
+			}
+		}
+	}
+
+	return events
+}
+
+func loadCursor(tx *gorm.DB, chain string) (uint64, error) {
+	var cursor models.EventCursor
+	if err := tx.Where("chain = ?", chain).First(&cursor).Error; err != nil {
+		return 0, err
+	}
+	return cursor.BlockNumber, nil
+}
+
+func saveCursor(tx *gorm.DB, chain string, block uint64) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chain"}},
+		DoUpdates: clause.AssignmentColumns([]string{"block_number", "updated_at"}),
+	}).Create(&models.EventCursor{Chain: chain, BlockNumber: block}).Error
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// fakeNode serves eth_blockNumber and an empty eth_getLogs, failing log
+// requests from failFrom on when it is set
+type fakeNode struct {
+	mu       sync.Mutex
+	head     uint64
+	failFrom uint64
+	ranges   []string
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+		Params []struct {
+			FromBlock hexutil.Uint64 `json:"fromBlock"`
+			ToBlock   hexutil.Uint64 `json:"toBlock"`
+		} `json:"params"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+	switch req.Method {
+	case "eth_blockNumber":
+		resp["result"] = hexutil.Uint64(n.head)
+	case "eth_getLogs":
+		from, to := uint64(req.Params[0].FromBlock), uint64(req.Params[0].ToBlock)
+		n.ranges = append(n.ranges, fmt.Sprintf("%d-%d", from, to))
+		if n.failFrom != 0 && from >= n.failFrom {
+			resp["error"] = map[string]interface{}{"code": -32005, "message": "rate limited"}
+		} else {
+			resp["result"] = []interface{}{}
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func cursor(t *testing.T, chain string) uint64 {
+	t.Helper()
+	block, err := loadCursor(database.DB, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestCatchUp(t *testing.T) {
+	testDB(t)
+	ctx := context.Background()
+
+	chain := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	t.Cleanup(func() { database.DB.Where("chain = ?", chain).Delete(&models.EventCursor{}) })
+	if err := saveCursor(database.DB, chain, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2500 confirmed blocks to catch up on, in three ranges
+	node := &fakeNode{head: 2600 + 12, failFrom: 1101}
+	srv := httptest.NewServer(node)
+	defer srv.Close()
+	client, err := ethclient.Dial(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSubscriber(nil, func(Event) bool { return true }, time.Minute, 12)
+	watch := &watchlist{chain: chain}
+
+	// The second range fails; the first stays done
+	if err := s.catchUp(ctx, chain, client, watch); err == nil {
+		t.Fatal("catchUp succeeded with the node failing")
+	}
+	if got := cursor(t, chain); got != 1100 {
+		t.Fatalf("cursor = %d after a failed range, want 1100", got)
+	}
+
+	node.failFrom, node.ranges = 0, nil
+	if err := s.catchUp(ctx, chain, client, watch); err != nil {
+		t.Fatal(err)
+	}
+	if got := cursor(t, chain); got != 2600 {
+		t.Fatalf("cursor = %d, want the confirmed head 2600", got)
+	}
+	if fmt.Sprint(node.ranges) != "[1101-2100 2101-2600]" {
+		t.Fatalf("requested ranges %v, want only the ones left", node.ranges)
+	}
+
+	// Another replica holds the chain's lock
+	sqlDB, err := database.DB.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1, hashtext($2))", int32(cursorLockClass), chain).Scan(&locked); err != nil || !locked {
+		t.Fatalf("locking chain: %v, %v", locked, err)
+	}
+
+	node.head, node.ranges = 3600+12, nil
+	if err := s.catchUp(ctx, chain, client, watch); err != nil {
+		t.Fatal(err)
+	}
+	if got := cursor(t, chain); got != 2600 || len(node.ranges) != 0 {
+		t.Fatalf("locked chain was followed: cursor %d, ranges %v", got, node.ranges)
+	}
+
+	unlockChain(conn, chain)
+	if err := s.catchUp(ctx, chain, client, watch); err != nil {
+		t.Fatal(err)
+	}
+	if got := cursor(t, chain); got != 3600 {
+		t.Fatalf("cursor = %d after the lock was released, want 3600", got)
+	}
+}
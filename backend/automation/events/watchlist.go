@@ -0,0 +1,221 @@
+package events
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"strings"
+
+	"github.com/defioptimization/shared/tokens"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// Event kinds
+const (
+	// KindRates is a change in a reserve's interest rates
+	KindRates = "rates"
+	// KindPosition is a change to specific users' positions
+	KindPosition = "position"
+	// KindPrice is an oracle price update for an asset
+	KindPrice = "price"
+)
+
+// Event is an on-chain change that may affect automation rules
+type Event struct {
+	Chain       string
+	Kind        string
+	Protocol    string   // aave, compound; empty for price updates
+	Asset       string   // token symbol
+	Users       []string // affected wallet addresses, for position events
+	BlockNumber uint64
+	Source      string // event name, e.g. ReserveDataUpdated
+}
+
+var (
+	reserveDataUpdatedTopic = crypto.Keccak256Hash([]byte("ReserveDataUpdated(address,uint256,uint256,uint256,uint256,uint256)"))
+	borrowTopic             = crypto.Keccak256Hash([]byte("Borrow(address,address,address,uint256,uint8,uint256,uint16)"))
+	liquidationCallTopic    = crypto.Keccak256Hash([]byte("LiquidationCall(address,address,address,uint256,uint256,address,bool)"))
+	cometSupplyTopic        = crypto.Keccak256Hash([]byte("Supply(address,address,uint256)"))
+	cometWithdrawTopic      = crypto.Keccak256Hash([]byte("Withdraw(address,address,uint256)"))
+	answerUpdatedTopic      = crypto.Keccak256Hash([]byte("AnswerUpdated(int256,uint256,uint256)"))
+)
+
+// Aave V3 pools
+var aavePools = map[string]common.Address{
+	"ethereum": common.HexToAddress("0x87870Bca3F3fD6335C3F4ce8392A693fcE16f1D7"),
+	"base":     common.HexToAddress("0xA238Dd80C259a72e81d7e4664a9801593F98d1c5"),
+}
+
+// Compound III markets keyed by base asset symbol
+var cometMarkets = map[string]map[string]common.Address{
+	"ethereum": {
+		"USDC": common.HexToAddress("0xc3d688B66703497DAA19211EEdff47f25384cdc3"),
+		"WETH": common.HexToAddress("0xA17581A9E3356d9A858b789D68B4d866e593aE94"),
+	},
+	"base": {
+		"USDC": common.HexToAddress("0xb125E6687d4313864e53df431d5425969c15Eb2F"),
+		"WETH": common.HexToAddress("0x46e6b214b524310239732D51387075E0e70970bf"),
+	},
+}
+
+// Chainlink USD feed proxies keyed by asset symbol. Proxies don't emit
+// AnswerUpdated themselves; the current aggregator behind each is resolved
+// when the watchlist is built.
+var priceFeeds = map[string]map[string]common.Address{
+	"ethereum": {
+		"WETH": common.HexToAddress("0x5f4eC3Df9cbd43714FE2740f5E3616155c5b8419"),
+		"USDC": common.HexToAddress("0x8fFfFfd4AfB6115b954Bd326cbe7B4BA576818f6"),
+		"USDT": common.HexToAddress("0x3E7d1eAB13ad0104d2750B8863b489D65364e32D"),
+		"DAI":  common.HexToAddress("0xAed0c38402a5d19df6E4c03F4E2DceD6e29c1ee9"),
+	},
+	"base": {
+		"WETH": common.HexToAddress("0x71041dddad3595F9CEd3DcCFBe3D1F4b0a16Bb70"),
+		"USDC": common.HexToAddress("0x7e860098F58bBFC8648a4311b374B1D669a2bc6B"),
+	},
+}
+
+const aggregatorProxyABI = `[{"inputs":[],"name":"aggregator","outputs":[{"name":"","type":"address"}],"stateMutability":"view","type":"function"}]`
+
+var parsedAggregatorProxyABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(aggregatorProxyABI))
+	if err != nil {
+		panic("invalid ABI definition: " + err.Error())
+	}
+	return parsed
+}()
+
+// watchlist is the set of contracts and topics watched on one chain
+type watchlist struct {
+	chain      string
+	addresses  []common.Address
+	topics     []common.Hash
+	comets     map[common.Address]string // market => base asset
+	aggregator map[common.Address]string // aggregator => asset
+}
+
+// newWatchlist builds the watchlist for a chain, resolving price feed
+// aggregators. A feed that can't be resolved is skipped, not fatal.
+func newWatchlist(ctx context.Context, client *ethclient.Client, chain string) *watchlist {
+	w := &watchlist{
+		chain:      chain,
+		topics:     []common.Hash{reserveDataUpdatedTopic, borrowTopic, liquidationCallTopic, cometSupplyTopic, cometWithdrawTopic, answerUpdatedTopic},
+		comets:     make(map[common.Address]string),
+		aggregator: make(map[common.Address]string),
+	}
+
+	if pool, ok := aavePools[chain]; ok {
+		w.addresses = append(w.addresses, pool)
+	}
+	for asset, market := range cometMarkets[chain] {
+		w.addresses = append(w.addresses, market)
+		w.comets[market] = asset
+	}
+
+	input, err := parsedAggregatorProxyABI.Pack("aggregator")
+	if err != nil {
+		return w
+	}
+	for asset, proxy := range priceFeeds[chain] {
+		proxy := proxy
+		output, err := client.CallContract(ctx, ethereum.CallMsg{To: &proxy, Data: input}, nil)
+		if err != nil || len(output) < 32 {
+			log.Printf("Could not resolve %s price feed aggregator on %s: %v", asset, chain, err)
+			continue
+		}
+		aggregator := common.BytesToAddress(output[12:32])
+		w.addresses = append(w.addresses, aggregator)
+		w.aggregator[aggregator] = asset
+	}
+
+	return w
+}
+
+// query returns the log filter for a block range
+func (w *watchlist) query(from, to uint64) ethereum.FilterQuery {
+	return ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(from),
+		ToBlock:   new(big.Int).SetUint64(to),
+		Addresses: w.addresses,
+		Topics:    [][]common.Hash{w.topics},
+	}
+}
+
+// decode maps a log to the event it represents. Logs that match a watched
+// topic but not its expected layout are ignored.
+func (w *watchlist) decode(l types.Log) (Event, bool) {
+	if len(l.Topics) == 0 {
+		return Event{}, false
+	}
+	ev := Event{Chain: w.chain, BlockNumber: l.BlockNumber}
+
+	switch l.Topics[0] {
+	case reserveDataUpdatedTopic:
+		if len(l.Topics) < 2 {
+			return Event{}, false
+		}
+		ev.Kind, ev.Protocol, ev.Source = KindRates, "aave", "ReserveDataUpdated"
+		ev.Asset = w.symbol(common.BytesToAddress(l.Topics[1].Bytes()))
+
+	case borrowTopic:
+		// Borrow(reserve indexed, user, onBehalfOf indexed, ...)
+		if len(l.Topics) < 3 || len(l.Data) < 32 {
+			return Event{}, false
+		}
+		ev.Kind, ev.Protocol, ev.Source = KindPosition, "aave", "Borrow"
+		ev.Asset = w.symbol(common.BytesToAddress(l.Topics[1].Bytes()))
+		ev.Users = []string{
+			common.BytesToAddress(l.Topics[2].Bytes()).Hex(),
+			common.BytesToAddress(l.Data[:32]).Hex(),
+		}
+
+	case liquidationCallTopic:
+		// LiquidationCall(collateral indexed, debt indexed, user indexed, ...)
+		if len(l.Topics) < 4 {
+			return Event{}, false
+		}
+		ev.Kind, ev.Protocol, ev.Source = KindPosition, "aave", "LiquidationCall"
+		ev.Asset = w.symbol(common.BytesToAddress(l.Topics[1].Bytes()))
+		ev.Users = []string{common.BytesToAddress(l.Topics[3].Bytes()).Hex()}
+
+	case cometSupplyTopic, cometWithdrawTopic:
+		asset, ok := w.comets[l.Address]
+		if !ok || len(l.Topics) < 3 {
+			return Event{}, false
+		}
+		ev.Kind, ev.Protocol, ev.Asset = KindPosition, "compound", asset
+		ev.Source = "Supply"
+		if l.Topics[0] == cometWithdrawTopic {
+			ev.Source = "Withdraw"
+		}
+		ev.Users = []string{
+			common.BytesToAddress(l.Topics[1].Bytes()).Hex(),
+			common.BytesToAddress(l.Topics[2].Bytes()).Hex(),
+		}
+
+	case answerUpdatedTopic:
+		asset, ok := w.aggregator[l.Address]
+		if !ok {
+			return Event{}, false
+		}
+		ev.Kind, ev.Asset, ev.Source = KindPrice, asset, "AnswerUpdated"
+
+	default:
+		return Event{}, false
+	}
+
+	return ev, true
+}
+
+// symbol returns the registry symbol for a token address, or the address
+// itself for tokens outside the registry
+func (w *watchlist) symbol(address common.Address) string {
+	if t, ok := tokens.LookupAddress(w.chain, address.Hex()); ok {
+		return t.Symbol
+	}
+	return address.Hex()
+}
@@ -2,14 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/defioptimization/automation/engine"
+	"github.com/defioptimization/automation/events"
+	"github.com/defioptimization/automation/server"
 	"github.com/defioptimization/shared/database"
+	"github.com/ethereum/go-ethereum/ethclient"
 )
 
 func main() {
@@ -51,10 +56,68 @@ func main() {
 		cancel()
 	}()
 
-	// Start monitoring loop
+	// Follow on-chain events so affected rules are evaluated immediately
+	clients := make(map[string]*ethclient.Client)
+	for chain, env := range map[string]string{"ethereum": "ETH_RPC_URL", "base": "BASE_RPC_URL"} {
+		url := os.Getenv(env)
+		if url == "" {
+			log.Printf("%s not set, not watching %s events", env, chain)
+			continue
+		}
+		client, err := ethclient.Dial(url)
+		if err != nil {
+			log.Printf("Failed to connect to %s RPC, not watching events: %v", chain, err)
+			continue
+		}
+		defer client.Close()
+		clients[chain] = client
+	}
+	if len(clients) > 0 {
+		confirmations := uint64(3)
+		if v := os.Getenv("EVENT_CONFIRMATIONS"); v != "" {
+			if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+				confirmations = n
+			}
+		}
+		subscriber := events.NewSubscriber(clients, automationEngine.HandleEvent, 5*time.Second, confirmations)
+		go subscriber.Start(ctx)
+	}
+
+	// Serve dry runs and backtests for the API gateway, and queue admin
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8083"
+	}
+	srv := server.NewServer(automationEngine)
+	go func() {
+		log.Printf("Automation API starting on port %s", port)
+		if err := srv.Start(":" + port); err != nil {
+			log.Printf("Automation API stopped: %v", err)
+		}
+	}()
+
+	// Start monitoring loop; with events enabled this is the fallback
 	interval := 30 * time.Second // Check every 30 seconds
-	if err := automationEngine.Start(ctx, interval); err != nil {
+	if v := os.Getenv("RULE_POLL_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			interval = d
+		}
+	}
+	workers := 0 // engine default
+	if v := os.Getenv("RULE_WORKERS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			workers = n
+		}
+	}
+	jobWorkers := 0 // engine default
+	if v := os.Getenv("JOB_WORKERS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			jobWorkers = n
+		}
+	}
+	err := automationEngine.Start(ctx, interval, workers, jobWorkers)
+	if err != nil && !errors.Is(err, context.Canceled) {
 		log.Fatalf("Failed to start automation engine: %v", err)
 	}
+	log.Println("Automation engine stopped")
 }
-
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/defioptimization/automation/engine"
+	"github.com/defioptimization/shared/models"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultBacktestStep is the evaluation step of a backtest that doesn't set one
+const defaultBacktestStep = time.Hour
+
+// Server handles the automation service's internal HTTP API, called by the
+// API gateway on behalf of authenticated users
+type Server struct {
+	engine *engine.Engine
+	router *gin.Engine
+}
+
+// NewServer creates a new server instance
+func NewServer(e *engine.Engine) *Server {
+	r := gin.Default()
+	s := &Server{
+		engine: e,
+		router: r,
+	}
+	s.setupRoutes()
+	return s
+}
+
+// setupRoutes configures API routes
+func (s *Server) setupRoutes() {
+	api := s.router.Group("/api/v1")
+	{
+		api.GET("/health", s.healthCheck)
+
+		internal := api.Group("")
+		internal.Use(internalAuth())
+		internal.POST("/rules/dry-run", s.dryRun)
+		internal.POST("/rules/backtest", s.backtest)
+
+		admin := internal.Group("/admin")
+		admin.GET("/jobs", s.jobStats)
+		admin.POST("/jobs/:id/retry", s.retryJob)
+	}
+}
+
+// Start starts the HTTP server
+func (s *Server) Start(addr string) error {
+	return s.router.Run(addr)
+}
+
+// internalAuth authenticates service-to-service requests using a shared token
+func internalAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expected := os.Getenv("INTERNAL_API_TOKEN")
+		provided := c.GetHeader("X-Internal-Token")
+
+		if expected == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid internal token"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// healthCheck returns service health status
+func (s *Server) healthCheck(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "healthy",
+		"service": "automation",
+	})
+}
+
+// dryRun evaluates a rule now and reports what would happen without sending
+// anything to the user's wallet
+func (s *Server) dryRun(c *gin.Context) {
+	var req struct {
+		Rule models.AutomationRule `json:"rule" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := s.engine.DryRun(c.Request.Context(), req.Rule)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// backtest replays a rule over stored history
+func (s *Server) backtest(c *gin.Context) {
+	var req struct {
+		Rule models.AutomationRule `json:"rule" binding:"required"`
+		From time.Time             `json:"from" binding:"required"`
+		To   time.Time             `json:"to" binding:"required"`
+		Step string                `json:"step"` // duration, e.g. 1h
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	step := defaultBacktestStep
+	if req.Step != "" {
+		d, err := time.ParseDuration(req.Step)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "step must be a duration such as 1h"})
+			return
+		}
+		step = d
+	}
+
+	result, err := s.engine.Backtest(c.Request.Context(), req.Rule, engine.BacktestRange{From: req.From, To: req.To, Step: step})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// jobStats reports the action job queue's depth, stuck jobs and dead letters
+func (s *Server) jobStats(c *gin.Context) {
+	stats, err := s.engine.QueueStats(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read job queue"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// retryJob queues a dead-lettered job again
+func (s *Server) retryJob(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	err = s.engine.RetryJob(c.Request.Context(), uint(id))
+	switch {
+	case errors.Is(err, engine.ErrJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, engine.ErrJobNotRetryable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Job queued for retry"})
+}
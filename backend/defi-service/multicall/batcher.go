@@ -0,0 +1,184 @@
+package multicall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Address is the Multicall3 deployment, identical on every chain
+var Address = common.HexToAddress("0xcA11bde05977b3631167028862bE2a173976CA11")
+
+const multicall3ABI = `[{"inputs":[{"components":[{"name":"target","type":"address"},{"name":"allowFailure","type":"bool"},{"name":"callData","type":"bytes"}],"name":"calls","type":"tuple[]"}],"name":"aggregate3","outputs":[{"components":[{"name":"success","type":"bool"},{"name":"returnData","type":"bytes"}],"name":"returnData","type":"tuple[]"}],"stateMutability":"payable","type":"function"}]`
+
+var parsedMulticall3ABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(multicall3ABI))
+	if err != nil {
+		panic("invalid multicall ABI: " + err.Error())
+	}
+	return parsed
+}()
+
+// ErrCallFailed is returned for a call that reverted inside a batch. The
+// other calls of the batch are unaffected.
+var ErrCallFailed = errors.New("multicall: call reverted")
+
+// executeTimeout bounds a batch's RPC call, which outlives any single caller
+const executeTimeout = 15 * time.Second
+
+// Caller is the subset of ethclient.Client the batcher needs
+type Caller interface {
+	CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+type call3 struct {
+	Target       common.Address `abi:"target"`
+	AllowFailure bool           `abi:"allowFailure"`
+	CallData     []byte         `abi:"callData"`
+}
+
+type call3Result struct {
+	Success    bool   `abi:"success"`
+	ReturnData []byte `abi:"returnData"`
+}
+
+type result struct {
+	data []byte
+	err  error
+}
+
+// batch collects calls against one block until it is flushed
+type batch struct {
+	block   *big.Int
+	calls   []call3
+	waiters []chan result
+	timer   *time.Timer
+}
+
+// Batcher coalesces eth_calls issued within a short window into a single
+// Multicall3 aggregate3 call per block. One Batcher serves one chain.
+type Batcher struct {
+	client   Caller
+	window   time.Duration
+	maxBatch int
+
+	mu      sync.Mutex
+	pending map[string]*batch
+}
+
+// NewBatcher creates a batcher that waits up to window for more calls and
+// flushes early once maxBatch calls are queued
+func NewBatcher(client Caller, window time.Duration, maxBatch int) *Batcher {
+	return &Batcher{
+		client:   client,
+		window:   window,
+		maxBatch: maxBatch,
+		pending:  make(map[string]*batch),
+	}
+}
+
+// Call queues a call to be executed at block (nil for latest) and waits for
+// its result
+func (b *Batcher) Call(ctx context.Context, to common.Address, data []byte, block *big.Int) ([]byte, error) {
+	ch := make(chan result, 1)
+	key := blockKey(block)
+
+	b.mu.Lock()
+	bt := b.pending[key]
+	if bt == nil {
+		bt = &batch{block: block}
+		b.pending[key] = bt
+		bt.timer = time.AfterFunc(b.window, func() { b.flush(key, bt) })
+	}
+	bt.calls = append(bt.calls, call3{Target: to, AllowFailure: true, CallData: data})
+	bt.waiters = append(bt.waiters, ch)
+	if len(bt.calls) >= b.maxBatch {
+		delete(b.pending, key)
+		bt.timer.Stop()
+		go b.execute(bt)
+	}
+	b.mu.Unlock()
+
+	select {
+	case r := <-ch:
+		return r.data, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// flush executes a batch once its window has passed, unless it was already
+// sent because it filled up
+func (b *Batcher) flush(key string, bt *batch) {
+	b.mu.Lock()
+	if b.pending[key] != bt {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, key)
+	b.mu.Unlock()
+
+	b.execute(bt)
+}
+
+// execute sends a batch and hands every waiter its result
+func (b *Batcher) execute(bt *batch) {
+	ctx, cancel := context.WithTimeout(context.Background(), executeTimeout)
+	defer cancel()
+
+	// A lone call doesn't need the multicall envelope
+	if len(bt.calls) == 1 {
+		call := bt.calls[0]
+		data, err := b.client.CallContract(ctx, ethereum.CallMsg{To: &call.Target, Data: call.CallData}, bt.block)
+		bt.waiters[0] <- result{data: data, err: err}
+		return
+	}
+
+	results, err := b.aggregate(ctx, bt)
+	for i, ch := range bt.waiters {
+		switch {
+		case err != nil:
+			ch <- result{err: err}
+		case !results[i].Success:
+			ch <- result{err: ErrCallFailed}
+		default:
+			ch <- result{data: results[i].ReturnData}
+		}
+	}
+}
+
+func (b *Batcher) aggregate(ctx context.Context, bt *batch) ([]call3Result, error) {
+	input, err := parsedMulticall3ABI.Pack("aggregate3", bt.calls)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := b.client.CallContract(ctx, ethereum.CallMsg{To: &Address, Data: input}, bt.block)
+	if err != nil {
+		return nil, fmt.Errorf("multicall failed: %w", err)
+	}
+
+	var results []call3Result
+	if err := parsedMulticall3ABI.UnpackIntoInterface(&results, "aggregate3", output); err != nil {
+		return nil, err
+	}
+	if len(results) != len(bt.calls) {
+		return nil, fmt.Errorf("multicall returned %d results for %d calls", len(results), len(bt.calls))
+	}
+	return results, nil
+}
+
+func blockKey(block *big.Int) string {
+	if block == nil {
+		return "latest"
+	}
+	return block.String()
+}
@@ -0,0 +1,244 @@
+package multicall
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// revert is call data the fake node reverts
+var revert = []byte("revert")
+
+// sentCall is an eth_call the fake node received
+type sentCall struct {
+	to    common.Address
+	calls []call3 // when sent to Multicall3
+	block *big.Int
+}
+
+// fakeCaller answers calls with their call data prefixed by "ok:", runs
+// aggregate3 the way Multicall3 does, and records what it was sent
+type fakeCaller struct {
+	mu   sync.Mutex
+	sent []sentCall
+	err  error
+}
+
+func (f *fakeCaller) CallContract(ctx context.Context, msg ethereum.CallMsg, block *big.Int) ([]byte, error) {
+	sent := sentCall{to: *msg.To, block: block}
+	var output []byte
+	var err error
+	if *msg.To == Address {
+		sent.calls, output, err = aggregate3(msg.Data)
+	} else if bytes.Equal(msg.Data, revert) {
+		err = errors.New("execution reverted")
+	} else {
+		output = append([]byte("ok:"), msg.Data...)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, sent)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return output, err
+}
+
+func (f *fakeCaller) calls() []sentCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]sentCall(nil), f.sent...)
+}
+
+// aggregate3 decodes an aggregate3 call and encodes its results
+func aggregate3(input []byte) ([]call3, []byte, error) {
+	method := parsedMulticall3ABI.Methods["aggregate3"]
+	args, err := method.Inputs.Unpack(input[4:])
+	if err != nil {
+		return nil, nil, err
+	}
+	calls := *abi.ConvertType(args[0], new([]call3)).(*[]call3)
+
+	results := make([]call3Result, len(calls))
+	for i, c := range calls {
+		if !bytes.Equal(c.CallData, revert) {
+			results[i] = call3Result{Success: true, ReturnData: append([]byte("ok:"), c.CallData...)}
+		}
+	}
+	output, err := method.Outputs.Pack(results)
+	return calls, output, err
+}
+
+// testCall is a call made through the batcher and its outcome
+type testCall struct {
+	data  []byte
+	block *big.Int
+
+	result []byte
+	err    error
+}
+
+// callAll makes the calls concurrently and waits for all of them
+func callAll(t *testing.T, b *Batcher, calls []*testCall) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i, c := range calls {
+		wg.Add(1)
+		go func(c *testCall, to common.Address) {
+			defer wg.Done()
+			c.result, c.err = b.Call(ctx, to, c.data, c.block)
+		}(c, common.BigToAddress(big.NewInt(int64(i+1))))
+	}
+	wg.Wait()
+}
+
+func TestBatcherWindow(t *testing.T) {
+	node := &fakeCaller{}
+	b := NewBatcher(node, 50*time.Millisecond, 100)
+
+	calls := []*testCall{{data: []byte("a")}, {data: []byte("b")}, {data: []byte("c")}}
+	callAll(t, b, calls)
+
+	sent := node.calls()
+	if len(sent) != 1 || sent[0].to != Address || len(sent[0].calls) != 3 {
+		t.Fatalf("sent %+v, want one aggregate3 of 3 calls", sent)
+	}
+	for _, c := range sent[0].calls {
+		if !c.AllowFailure {
+			t.Errorf("call to %s doesn't allow failure", c.Target)
+		}
+	}
+	for _, c := range calls {
+		if c.err != nil || string(c.result) != "ok:"+string(c.data) {
+			t.Errorf("call %s = %q, %v", c.data, c.result, c.err)
+		}
+	}
+}
+
+func TestBatcherMaxBatch(t *testing.T) {
+	node := &fakeCaller{}
+	// The window never passes, so only filling the batch sends it
+	b := NewBatcher(node, time.Hour, 3)
+
+	calls := []*testCall{{data: []byte("a")}, {data: []byte("b")}, {data: []byte("c")}}
+	start := time.Now()
+	callAll(t, b, calls)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("full batch waited %s", elapsed)
+	}
+
+	if sent := node.calls(); len(sent) != 1 || len(sent[0].calls) != 3 {
+		t.Fatalf("sent %+v, want one aggregate3 of 3 calls", sent)
+	}
+	for _, c := range calls {
+		if c.err != nil || string(c.result) != "ok:"+string(c.data) {
+			t.Errorf("call %s = %q, %v", c.data, c.result, c.err)
+		}
+	}
+}
+
+func TestBatcherCallFailed(t *testing.T) {
+	node := &fakeCaller{}
+	b := NewBatcher(node, 50*time.Millisecond, 100)
+
+	calls := []*testCall{{data: []byte("a")}, {data: revert}, {data: []byte("c")}}
+	callAll(t, b, calls)
+
+	if !errors.Is(calls[1].err, ErrCallFailed) {
+		t.Errorf("reverted call error = %v, want ErrCallFailed", calls[1].err)
+	}
+	for _, c := range []*testCall{calls[0], calls[2]} {
+		if c.err != nil || string(c.result) != "ok:"+string(c.data) {
+			t.Errorf("call %s beside a revert = %q, %v", c.data, c.result, c.err)
+		}
+	}
+}
+
+func TestBatcherMulticallError(t *testing.T) {
+	node := &fakeCaller{err: errors.New("connection refused")}
+	b := NewBatcher(node, 50*time.Millisecond, 100)
+
+	calls := []*testCall{{data: []byte("a")}, {data: []byte("b")}}
+	callAll(t, b, calls)
+
+	for _, c := range calls {
+		if c.err == nil || errors.Is(c.err, ErrCallFailed) {
+			t.Errorf("call %s error = %v, want the multicall's", c.data, c.err)
+		}
+	}
+}
+
+func TestBatcherSingleCall(t *testing.T) {
+	node := &fakeCaller{}
+	b := NewBatcher(node, 10*time.Millisecond, 100)
+	to := common.HexToAddress("0x87870Bca3F3fD6335C3F4ce8392A693fcE16f1D7")
+
+	data, err := b.Call(context.Background(), to, []byte("a"), big.NewInt(100))
+	if err != nil || string(data) != "ok:a" {
+		t.Fatalf("call = %q, %v", data, err)
+	}
+	sent := node.calls()
+	if len(sent) != 1 || sent[0].to != to || sent[0].block.Int64() != 100 {
+		t.Fatalf("sent %+v, want the call itself at block 100", sent)
+	}
+
+	// A lone call's revert is the node's error, not ErrCallFailed
+	if _, err := b.Call(context.Background(), to, revert, nil); err == nil || errors.Is(err, ErrCallFailed) {
+		t.Fatalf("reverted call error = %v", err)
+	}
+}
+
+func TestBatcherBlocks(t *testing.T) {
+	node := &fakeCaller{}
+	b := NewBatcher(node, 50*time.Millisecond, 100)
+
+	calls := []*testCall{
+		{data: []byte("latest1")},
+		{data: []byte("latest2")},
+		{data: []byte("100a"), block: big.NewInt(100)},
+		{data: []byte("100b"), block: big.NewInt(100)},
+		{data: []byte("101"), block: big.NewInt(101)},
+	}
+	callAll(t, b, calls)
+
+	byBlock := make(map[string]sentCall)
+	for _, s := range node.calls() {
+		byBlock[blockKey(s.block)] = s
+	}
+	if len(byBlock) != 3 || len(node.calls()) != 3 {
+		t.Fatalf("sent %+v, want one call per block", node.calls())
+	}
+	if len(byBlock["latest"].calls) != 2 || len(byBlock["100"].calls) != 2 {
+		t.Errorf("latest and block 100 batches = %+v, %+v", byBlock["latest"], byBlock["100"])
+	}
+	if s := byBlock["101"]; s.to == Address {
+		t.Errorf("lone call at block 101 sent through multicall")
+	}
+	for _, c := range calls {
+		if c.err != nil || string(c.result) != "ok:"+string(c.data) {
+			t.Errorf("call %s = %q, %v", c.data, c.result, c.err)
+		}
+	}
+}
+
+func TestBatcherCallerContext(t *testing.T) {
+	b := NewBatcher(&fakeCaller{}, time.Hour, 100)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := b.Call(ctx, Address, []byte("a"), nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want the caller's deadline", err)
+	}
+}
@@ -2,8 +2,13 @@ package protocols
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"math/big"
+	"sync"
 
+	"github.com/defioptimization/defi-service/multicall"
+	"github.com/defioptimization/shared/tokens"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
@@ -11,6 +16,7 @@ import (
 
 // Aave implements the Aave protocol integration
 type Aave struct {
+	reader
 	name       string
 	ethClient  *ethclient.Client
 	baseClient *ethclient.Client
@@ -25,9 +31,45 @@ var (
 	aavePoolAddressBase = common.HexToAddress("0xA238Dd80C259a72e81d7e4664a9801593F98d1c5")
 )
 
+const aavePoolABI = `[
+	{"inputs":[{"name":"asset","type":"address"},{"name":"amount","type":"uint256"},{"name":"onBehalfOf","type":"address"},{"name":"referralCode","type":"uint16"}],"name":"supply","outputs":[],"stateMutability":"nonpayable","type":"function"},
+	{"inputs":[{"name":"asset","type":"address"},{"name":"amount","type":"uint256"},{"name":"to","type":"address"}],"name":"withdraw","outputs":[{"name":"","type":"uint256"}],"stateMutability":"nonpayable","type":"function"},
+	{"inputs":[{"name":"asset","type":"address"},{"name":"amount","type":"uint256"},{"name":"interestRateMode","type":"uint256"},{"name":"onBehalfOf","type":"address"}],"name":"repay","outputs":[{"name":"","type":"uint256"}],"stateMutability":"nonpayable","type":"function"},
+	{"inputs":[{"name":"asset","type":"address"},{"name":"amount","type":"uint256"},{"name":"interestRateMode","type":"uint256"}],"name":"repayWithATokens","outputs":[{"name":"","type":"uint256"}],"stateMutability":"nonpayable","type":"function"},
+	{"inputs":[{"name":"user","type":"address"}],"name":"getUserAccountData","outputs":[{"name":"totalCollateralBase","type":"uint256"},{"name":"totalDebtBase","type":"uint256"},{"name":"availableBorrowsBase","type":"uint256"},{"name":"currentLiquidationThreshold","type":"uint256"},{"name":"ltv","type":"uint256"},{"name":"healthFactor","type":"uint256"}],"stateMutability":"view","type":"function"},
+	{"inputs":[{"name":"user","type":"address"}],"name":"getUserConfiguration","outputs":[{"name":"data","type":"uint256"}],"stateMutability":"view","type":"function"},
+	{"inputs":[{"name":"asset","type":"address"}],"name":"getReserveData","outputs":[{"name":"configuration","type":"uint256"},{"name":"liquidityIndex","type":"uint128"},{"name":"currentLiquidityRate","type":"uint128"},{"name":"variableBorrowIndex","type":"uint128"},{"name":"currentVariableBorrowRate","type":"uint128"},{"name":"currentStableBorrowRate","type":"uint128"},{"name":"lastUpdateTimestamp","type":"uint40"},{"name":"id","type":"uint16"},{"name":"aTokenAddress","type":"address"},{"name":"stableDebtTokenAddress","type":"address"},{"name":"variableDebtTokenAddress","type":"address"},{"name":"interestRateStrategyAddress","type":"address"},{"name":"accruedToTreasury","type":"uint128"},{"name":"unbacked","type":"uint128"},{"name":"isolationModeTotalDebt","type":"uint128"}],"stateMutability":"view","type":"function"}
+]`
+
+// ray is Aave's 27-decimal fixed point unit
+const rayDecimals = 27
+
+var parsedAavePoolABI = mustParseABI(aavePoolABI)
+
+// Aave oracles quote prices in USD with 8 decimals
+var (
+	aaveOracleAddressEth  = common.HexToAddress("0x54586bE62E3c3580375aE3723C145253060Ca0C2")
+	aaveOracleAddressBase = common.HexToAddress("0x2Cc0Fc26eD4563A5ce5e8bdcfe1A2878676Ae156")
+)
+
+const aaveOracleDecimals = 8
+
+const aaveOracleABI = `[{"inputs":[{"name":"asset","type":"address"}],"name":"getAssetPrice","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"}]`
+
+var parsedAaveOracleABI = mustParseABI(aaveOracleABI)
+
+const totalSupplyABI = `[{"inputs":[],"name":"totalSupply","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"}]`
+
+var parsedTotalSupplyABI = mustParseABI(totalSupplyABI)
+
+const balanceOfABI = `[{"inputs":[{"name":"account","type":"address"}],"name":"balanceOf","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"}]`
+
+var parsedBalanceOfABI = mustParseABI(balanceOfABI)
+
 // NewAave creates a new Aave protocol instance
-func NewAave(ethClient, baseClient *ethclient.Client) *Aave {
+func NewAave(ethClient, baseClient *ethclient.Client, batchers map[string]*multicall.Batcher) *Aave {
 	return &Aave{
+		reader:     reader{batchers: batchers},
 		name:       "aave",
 		ethClient:  ethClient,
 		baseClient: baseClient,
@@ -39,61 +81,266 @@ func (a *Aave) GetName() string {
 	return a.name
 }
 
-// GetAPY returns the current APY for an asset
+// GetAPY returns the current supply APY for an asset, in percent
 func (a *Aave) GetAPY(ctx context.Context, asset string, chain string) (float64, error) {
-	client := a.getClient(chain)
-	poolAddress := a.getPoolAddress(chain)
-	
-	// TODO: Implement actual Aave contract interaction
-	// This would involve calling the Aave Pool contract's getReserveData function
-	// For now, return a placeholder
-	_ = client
-	_ = poolAddress
-	
-	// Placeholder: Return example APY
-	// In production, this would query the Aave Pool contract
-	return 4.5, nil
+	token, ok := tokens.Lookup(chain, asset)
+	if !ok {
+		return 0, fmt.Errorf("asset %s not supported on %s", asset, chain)
+	}
+
+	values, err := a.read(ctx, chain, parsedAavePoolABI, a.getPoolAddress(chain), "getReserveData", common.HexToAddress(token.Address))
+	if err != nil {
+		return 0, err
+	}
+
+	// currentLiquidityRate is an APR in ray, compounded every second
+	liquidityRate, ok := values[2].(*big.Int)
+	if !ok {
+		return 0, fmt.Errorf("unexpected getReserveData response")
+	}
+	apr := tokens.FromBaseUnits(liquidityRate, rayDecimals)
+	return compoundPerSecond(apr/secondsPerYear) * 100, nil
 }
 
-// GetUserPositions returns user's positions in Aave
+// GetUtilization returns the share of a reserve's supply that is borrowed,
+// in percent: total stable and variable debt over the aToken supply
+func (a *Aave) GetUtilization(ctx context.Context, asset string, chain string) (float64, error) {
+	_, supplies, err := a.reserveSupplies(ctx, asset, chain)
+	if err != nil {
+		return 0, err
+	}
+
+	if supplies[0].Sign() == 0 {
+		return 0, nil
+	}
+	debt := new(big.Int).Add(supplies[1], supplies[2])
+	utilization, _ := new(big.Rat).SetFrac(debt, supplies[0]).Float64()
+	return utilization * 100, nil
+}
+
+// GetTotalSupplied returns the reserve's aToken supply, in token units
+func (a *Aave) GetTotalSupplied(ctx context.Context, asset string, chain string) (float64, error) {
+	token, supplies, err := a.reserveSupplies(ctx, asset, chain)
+	if err != nil {
+		return 0, err
+	}
+	return tokens.FromBaseUnits(supplies[0], token.Decimals), nil
+}
+
+// reserveSupplies reads the supplies of a reserve's aToken, stable debt
+// token and variable debt token, in base units
+func (a *Aave) reserveSupplies(ctx context.Context, asset string, chain string) (tokens.Token, []*big.Int, error) {
+	token, ok := tokens.Lookup(chain, asset)
+	if !ok {
+		return tokens.Token{}, nil, fmt.Errorf("asset %s not supported on %s", asset, chain)
+	}
+
+	values, err := a.read(ctx, chain, parsedAavePoolABI, a.getPoolAddress(chain), "getReserveData", common.HexToAddress(token.Address))
+	if err != nil {
+		return tokens.Token{}, nil, err
+	}
+
+	// aTokenAddress, stableDebtTokenAddress and variableDebtTokenAddress
+	supplyTokens := make([]common.Address, 3)
+	for i, index := range []int{8, 9, 10} {
+		address, ok := values[index].(common.Address)
+		if !ok {
+			return tokens.Token{}, nil, fmt.Errorf("unexpected getReserveData response")
+		}
+		supplyTokens[i] = address
+	}
+
+	// Read the three supplies concurrently so they share a multicall
+	supplies := make([]*big.Int, len(supplyTokens))
+	errs := make([]error, len(supplyTokens))
+	var wg sync.WaitGroup
+	for i, address := range supplyTokens {
+		wg.Add(1)
+		go func(i int, address common.Address) {
+			defer wg.Done()
+			out, err := a.read(ctx, chain, parsedTotalSupplyABI, address, "totalSupply")
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			supply, ok := out[0].(*big.Int)
+			if !ok {
+				errs[i] = fmt.Errorf("unexpected totalSupply response")
+				return
+			}
+			supplies[i] = supply
+		}(i, address)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return tokens.Token{}, nil, err
+		}
+	}
+	return token, supplies, nil
+}
+
+// GetUserPositions returns the user's supplied and borrowed balances in
+// every listed reserve, read concurrently so they share multicalls
 func (a *Aave) GetUserPositions(ctx context.Context, userAddress string, chain string) ([]Position, error) {
-	client := a.getClient(chain)
-	poolAddress := a.getPoolAddress(chain)
-	
-	// TODO: Implement actual position fetching
-	// This would query the Aave Pool contract for user's supply/borrow positions
-	_ = client
-	_ = poolAddress
-	_ = userAddress
-	
-	// Placeholder: Return empty positions
-	return []Position{}, nil
+	user := common.HexToAddress(userAddress)
+	list := tokens.List(chain)
+	found := make([][]Position, len(list))
+	errs := make([]error, len(list))
+
+	var wg sync.WaitGroup
+	for i, token := range list {
+		wg.Add(1)
+		go func(i int, token tokens.Token) {
+			defer wg.Done()
+			found[i], errs[i] = a.reservePositions(ctx, chain, token, user)
+		}(i, token)
+	}
+	wg.Wait()
+
+	positions := []Position{}
+	for i := range list {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		positions = append(positions, found[i]...)
+	}
+	return positions, nil
 }
 
-// GetHealthFactor returns the user's health factor
+// reservePositions reads the user's aToken and debt token balances of one
+// reserve. Tokens Aave doesn't list have no reserve and no positions.
+func (a *Aave) reservePositions(ctx context.Context, chain string, token tokens.Token, user common.Address) ([]Position, error) {
+	values, err := a.read(ctx, chain, parsedAavePoolABI, a.getPoolAddress(chain), "getReserveData", common.HexToAddress(token.Address))
+	if err != nil {
+		return nil, err
+	}
+	liquidityRate, ok1 := values[2].(*big.Int)
+	borrowRate, ok2 := values[4].(*big.Int)
+	aToken, ok3 := values[8].(common.Address)
+	stableDebt, ok4 := values[9].(common.Address)
+	variableDebt, ok5 := values[10].(common.Address)
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 {
+		return nil, fmt.Errorf("unexpected getReserveData response")
+	}
+	if aToken == (common.Address{}) {
+		return nil, nil
+	}
+
+	balanceTokens := []common.Address{aToken, stableDebt, variableDebt}
+	balances := make([]*big.Int, len(balanceTokens))
+	errs := make([]error, len(balanceTokens))
+	var wg sync.WaitGroup
+	for i, address := range balanceTokens {
+		wg.Add(1)
+		go func(i int, address common.Address) {
+			defer wg.Done()
+			balances[i], errs[i] = a.balanceOf(ctx, chain, address, user)
+		}(i, address)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Rates are APRs in ray, compounded every second
+	apy := func(rate *big.Int) float64 {
+		return compoundPerSecond(tokens.FromBaseUnits(rate, rayDecimals)/secondsPerYear) * 100
+	}
+
+	var positions []Position
+	if balances[0].Sign() > 0 {
+		positions = append(positions, Position{
+			Protocol: a.name,
+			Chain:    chain,
+			Asset:    token.Symbol,
+			Type:     "lending",
+			Amount:   tokens.FromBaseUnits(balances[0], token.Decimals),
+			APY:      apy(liquidityRate),
+			Address:  aToken.Hex(),
+		})
+	}
+	if debt := new(big.Int).Add(balances[1], balances[2]); debt.Sign() > 0 {
+		positions = append(positions, Position{
+			Protocol: a.name,
+			Chain:    chain,
+			Asset:    token.Symbol,
+			Type:     "borrowing",
+			Amount:   tokens.FromBaseUnits(debt, token.Decimals),
+			APY:      apy(borrowRate),
+			Address:  variableDebt.Hex(),
+		})
+	}
+	return positions, nil
+}
+
+// GetHealthFactor returns the user's health factor: collateral weighted by
+// liquidation thresholds over debt. Accounts without debt report a huge value.
 func (a *Aave) GetHealthFactor(ctx context.Context, userAddress string, chain string) (float64, error) {
-	client := a.getClient(chain)
-	poolAddress := a.getPoolAddress(chain)
-	
-	// TODO: Implement health factor calculation
-	// Health factor = (Total Collateral in ETH * Liquidation Threshold) / Total Borrows in ETH
-	_ = client
-	_ = poolAddress
-	_ = userAddress
-	
-	// Placeholder
-	return 1.5, nil
+	account, err := a.accountData(ctx, chain, common.HexToAddress(userAddress))
+	if err != nil {
+		return 0, err
+	}
+	return account.healthFactor, nil
 }
 
-// GetAssetPrice returns the current price of an asset
+// GetAssetPrice returns the USD price of an asset from the Aave oracle
 func (a *Aave) GetAssetPrice(ctx context.Context, asset string, chain string) (float64, error) {
-	// TODO: Implement price oracle query
-	// Aave uses Chainlink oracles for prices
-	_ = asset
-	_ = chain
-	
-	// Placeholder
-	return 1.0, nil
+	token, ok := tokens.Lookup(chain, asset)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s on %s", ErrNoPriceFeed, asset, chain)
+	}
+
+	values, err := a.read(ctx, chain, parsedAaveOracleABI, a.getOracleAddress(chain), "getAssetPrice", common.HexToAddress(token.Address))
+	if err != nil {
+		return 0, fmt.Errorf("failed to query Aave oracle: %w", err)
+	}
+
+	price, ok := values[0].(*big.Int)
+	if !ok {
+		return 0, fmt.Errorf("unexpected getAssetPrice response")
+	}
+	// The oracle answers 0 for assets it has no source for
+	if price.Sign() == 0 {
+		return 0, fmt.Errorf("%w: %s on %s", ErrNoPriceFeed, asset, chain)
+	}
+	return tokens.FromBaseUnits(price, aaveOracleDecimals), nil
+}
+
+// BuildDeposit builds a Pool.supply call for the user
+func (a *Aave) BuildDeposit(ctx context.Context, asset string, amount *big.Int, userAddress string, chain string) ([]Call, error) {
+	token, ok := tokens.Lookup(chain, asset)
+	if !ok {
+		return nil, fmt.Errorf("asset %s not supported on %s", asset, chain)
+	}
+
+	call, err := newCall(parsedAavePoolABI, a.getPoolAddress(chain),
+		fmt.Sprintf("Supply %s to Aave", token.Symbol),
+		"supply", common.HexToAddress(token.Address), amount, common.HexToAddress(userAddress), uint16(0))
+	if err != nil {
+		return nil, err
+	}
+	requireAllowance(&call, token.Address, amount)
+	return []Call{call}, nil
+}
+
+// BuildWithdraw builds a Pool.withdraw call returning funds to the user
+func (a *Aave) BuildWithdraw(ctx context.Context, asset string, amount *big.Int, userAddress string, chain string) ([]Call, error) {
+	token, ok := tokens.Lookup(chain, asset)
+	if !ok {
+		return nil, fmt.Errorf("asset %s not supported on %s", asset, chain)
+	}
+
+	call, err := newCall(parsedAavePoolABI, a.getPoolAddress(chain),
+		fmt.Sprintf("Withdraw %s from Aave", token.Symbol),
+		"withdraw", common.HexToAddress(token.Address), amount, common.HexToAddress(userAddress))
+	if err != nil {
+		return nil, err
+	}
+	return []Call{call}, nil
 }
 
 // Helper methods
@@ -111,6 +358,13 @@ func (a *Aave) getPoolAddress(chain string) common.Address {
 	return aavePoolAddressEth
 }
 
+func (a *Aave) getOracleAddress(chain string) common.Address {
+	if chain == "base" {
+		return aaveOracleAddressBase
+	}
+	return aaveOracleAddressEth
+}
+
 // Helper to convert big.Int to float64
 func weiToEther(wei *big.Int) float64 {
 	if wei == nil {
@@ -122,6 +376,14 @@ func weiToEther(wei *big.Int) float64 {
 	return result
 }
 
+// secondsPerYear is used to annualize per-second rates
+const secondsPerYear = 365 * 24 * 60 * 60
+
+// compoundPerSecond annualizes a per-second rate compounded every second
+func compoundPerSecond(rate float64) float64 {
+	return math.Pow(1+rate, secondsPerYear) - 1
+}
+
 // Helper to create call options
 func newCallOpts(ctx context.Context) *bind.CallOpts {
 	return &bind.CallOpts{
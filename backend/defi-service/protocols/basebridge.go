@@ -0,0 +1,214 @@
+package protocols
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/defioptimization/shared/tokens"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Base's canonical bridge contracts on Ethereum
+var (
+	baseL1StandardBridge = common.HexToAddress("0x3154Cf16ccdb4C6d922629664174b904d80F2C35")
+	baseOptimismPortal   = common.HexToAddress("0x49048044D57e1C92A77f79988d21Fa8fAF74E97e")
+)
+
+// baseBridgedTokens are the assets whose Base token is the canonical
+// bridge's representation of the Ethereum token
+var baseBridgedTokens = map[string]bool{
+	"DAI": true,
+}
+
+const (
+	// baseDepositTime is how long Base usually takes to pick up a deposit
+	baseDepositTime = 5 * time.Minute
+	// baseDepositGasLimit is the gas the deposit gets on Base to mint the
+	// bridged tokens
+	baseDepositGasLimit = 200000
+	baseDepositGas      = 160000
+	// depositTxType is the OP Stack deposit transaction type
+	depositTxType = 0x7e
+)
+
+const baseBridgeABI = `[
+	{"inputs":[{"name":"_l1Token","type":"address"},{"name":"_l2Token","type":"address"},{"name":"_to","type":"address"},{"name":"_amount","type":"uint256"},{"name":"_minGasLimit","type":"uint32"},{"name":"_extraData","type":"bytes"}],"name":"depositERC20To","outputs":[],"stateMutability":"nonpayable","type":"function"},
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":true,"name":"version","type":"uint256"},{"indexed":false,"name":"opaqueData","type":"bytes"}],"name":"TransactionDeposited","type":"event"}
+]`
+
+var parsedBaseBridgeABI = mustParseABI(baseBridgeABI)
+
+// BaseBridge deposits tokens into Base through its canonical OP Stack
+// bridge. Deposits need no claim and arrive within minutes. Withdrawals to
+// Ethereum take seven days and a proof, so they are not supported.
+type BaseBridge struct {
+	name    string
+	clients map[string]*ethclient.Client
+}
+
+// NewBaseBridge creates a canonical Base bridge
+func NewBaseBridge(clients map[string]*ethclient.Client) *BaseBridge {
+	return &BaseBridge{name: "base_canonical", clients: clients}
+}
+
+// GetName returns the bridge name
+func (b *BaseBridge) GetName() string {
+	return b.name
+}
+
+// route returns the token pair of a deposit into Base
+func (b *BaseBridge) route(asset, fromChain, toChain string) (tokens.Token, tokens.Token, error) {
+	l1, ok1 := tokens.Lookup(fromChain, asset)
+	l2, ok2 := tokens.Lookup(toChain, asset)
+	if fromChain != "ethereum" || toChain != "base" || !ok1 || !ok2 || !baseBridgedTokens[l1.Symbol] {
+		return tokens.Token{}, tokens.Token{}, ErrRouteNotSupported
+	}
+	return l1, l2, nil
+}
+
+// Quote returns a fee-free deposit; only Ethereum gas is paid
+func (b *BaseBridge) Quote(ctx context.Context, req BridgeRequest) (*BridgeQuote, error) {
+	l1, _, err := b.route(req.Asset, req.FromChain, req.ToChain)
+	if err != nil {
+		return nil, err
+	}
+	return &BridgeQuote{
+		Bridge:          b.name,
+		Asset:           l1.Symbol,
+		FromChain:       req.FromChain,
+		ToChain:         req.ToChain,
+		AmountIn:        req.Amount.String(),
+		AmountOut:       req.Amount.String(),
+		Fee:             "0",
+		SourceGas:       baseDepositGas,
+		ExpectedSeconds: int64(baseDepositTime / time.Second),
+	}, nil
+}
+
+// BuildTransfer deposits the tokens into the L1 standard bridge for the
+// recipient on Base
+func (b *BaseBridge) BuildTransfer(ctx context.Context, req BridgeRequest) ([]Call, error) {
+	l1, l2, err := b.route(req.Asset, req.FromChain, req.ToChain)
+	if err != nil {
+		return nil, err
+	}
+
+	call, err := newCall(parsedBaseBridgeABI, baseL1StandardBridge,
+		fmt.Sprintf("Bridge %s to Base with the canonical bridge", l1.Symbol),
+		"depositERC20To", common.HexToAddress(l1.Address), common.HexToAddress(l2.Address),
+		req.recipient(), req.Amount, uint32(baseDepositGasLimit), []byte{})
+	if err != nil {
+		return nil, err
+	}
+	requireAllowance(&call, l1.Address, req.Amount)
+	return []Call{call}, nil
+}
+
+// depositTx is an OP Stack deposit transaction, as included on L2
+type depositTx struct {
+	SourceHash          common.Hash
+	From                common.Address
+	To                  *common.Address `rlp:"nil"`
+	Mint                *big.Int        `rlp:"nil"`
+	Value               *big.Int
+	Gas                 uint64
+	IsSystemTransaction bool
+	Data                []byte
+}
+
+// TransferStatus derives the Base transaction a deposit becomes from the
+// portal's TransactionDeposited event, and reports the deposit as arrived
+// once that transaction has succeeded on Base
+func (b *BaseBridge) TransferStatus(ctx context.Context, fromChain, toChain string, sourceTx common.Hash) (*TransferStatus, error) {
+	l1Client, ok1 := b.clients[fromChain]
+	l2Client, ok2 := b.clients[toChain]
+	if fromChain != "ethereum" || toChain != "base" || !ok1 || !ok2 {
+		return nil, ErrRouteNotSupported
+	}
+
+	receipt, err := l1Client.TransactionReceipt(ctx, sourceTx)
+	if errors.Is(err, ethereum.NotFound) {
+		return &TransferStatus{State: TransferPending, Detail: "deposit not mined", Calls: []Call{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if receipt.Status == 0 {
+		return &TransferStatus{State: TransferFailed, Detail: "deposit reverted", Calls: []Call{}}, nil
+	}
+
+	event := parsedBaseBridgeABI.Events["TransactionDeposited"]
+	for _, l := range receipt.Logs {
+		if l.Address != baseOptimismPortal || len(l.Topics) != 4 || l.Topics[0] != event.ID {
+			continue
+		}
+		hash, err := l2DepositHash(l.BlockHash, uint64(l.Index), l.Topics, l.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		l2Receipt, err := l2Client.TransactionReceipt(ctx, hash)
+		if errors.Is(err, ethereum.NotFound) {
+			return &TransferStatus{State: TransferPending, Detail: "awaiting inclusion on Base", Calls: []Call{}}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if l2Receipt.Status == 0 {
+			return &TransferStatus{State: TransferFailed, Detail: "deposit failed on Base", Calls: []Call{}}, nil
+		}
+		return &TransferStatus{State: TransferArrived, Calls: []Call{}}, nil
+	}
+	return &TransferStatus{State: TransferFailed, Detail: "no deposit in transaction", Calls: []Call{}}, nil
+}
+
+// l2DepositHash computes the hash of the L2 transaction a TransactionDeposited
+// event becomes. Its source hash commits to the L1 block and log index, and
+// the version 0 opaque data packs mint, value, gas limit, a creation flag and
+// the calldata.
+func l2DepositHash(blockHash common.Hash, logIndex uint64, topics []common.Hash, data []byte) (common.Hash, error) {
+	values, err := parsedBaseBridgeABI.Events["TransactionDeposited"].Inputs.NonIndexed().Unpack(data)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	opaque, _ := values[0].([]byte)
+	if topics[3] != (common.Hash{}) || len(opaque) < 73 {
+		return common.Hash{}, fmt.Errorf("unsupported deposit event")
+	}
+
+	var input [64]byte
+	copy(input[:32], blockHash[:])
+	binary.BigEndian.PutUint64(input[56:], logIndex)
+	depositID := crypto.Keccak256Hash(input[:])
+	var domain [64]byte // user deposits are domain 0
+	copy(domain[32:], depositID[:])
+
+	tx := depositTx{
+		SourceHash: crypto.Keccak256Hash(domain[:]),
+		From:       common.BytesToAddress(topics[1].Bytes()),
+		Value:      new(big.Int).SetBytes(opaque[32:64]),
+		Gas:        binary.BigEndian.Uint64(opaque[64:72]),
+		Data:       opaque[73:],
+	}
+	if mint := new(big.Int).SetBytes(opaque[:32]); mint.Sign() > 0 {
+		tx.Mint = mint
+	}
+	if opaque[72] == 0 {
+		to := common.BytesToAddress(topics[2].Bytes())
+		tx.To = &to
+	}
+
+	encoded, err := rlp.EncodeToBytes(&tx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash(append([]byte{depositTxType}, encoded...)), nil
+}
@@ -0,0 +1,55 @@
+package protocols
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type blockKey struct{}
+
+// WithBlock pins every protocol read made with the returned context to a
+// block, so values read separately are consistent with each other
+func WithBlock(ctx context.Context, block *big.Int) context.Context {
+	return context.WithValue(ctx, blockKey{}, block)
+}
+
+// BlockFromContext returns the pinned block, or nil for the latest block
+func BlockFromContext(ctx context.Context) *big.Int {
+	block, _ := ctx.Value(blockKey{}).(*big.Int)
+	return block
+}
+
+// ResolveBlock turns a block reference into a block number on a chain. An
+// empty reference or "latest" resolves to the current head, so the caller
+// can pin to it; a 0x-prefixed 32-byte hash is looked up; anything else must
+// be a decimal block number. Historical blocks need an archive node.
+func (m *Manager) ResolveBlock(ctx context.Context, chain, ref string) (*big.Int, error) {
+	client := m.GetClient(chain)
+
+	switch {
+	case ref == "" || ref == "latest":
+		head, err := client.BlockNumber(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch block number: %w", err)
+		}
+		return new(big.Int).SetUint64(head), nil
+
+	case strings.HasPrefix(ref, "0x") && len(ref) == 66:
+		header, err := client.HeaderByHash(ctx, common.HexToHash(ref))
+		if err != nil {
+			return nil, fmt.Errorf("unknown block %s: %w", ref, err)
+		}
+		return header.Number, nil
+
+	default:
+		number, ok := new(big.Int).SetString(ref, 10)
+		if !ok || number.Sign() < 0 {
+			return nil, fmt.Errorf("invalid block: %s", ref)
+		}
+		return number, nil
+	}
+}
@@ -0,0 +1,138 @@
+package protocols
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/defi-service/multicall"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// knownBlockHash is the only block the fake node can find by hash
+var knownBlockHash = common.HexToHash("0x5f0c7a1bb8e0d5c0bbc5b3e8a1a8e5f3b5a0c6d5e4f3a2b1c0d9e8f7a6b5c4d3")
+
+// headNode is a fake node at block 0x1234 that knows one block by hash
+func headNode(t *testing.T) *ethclient.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage   `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		switch req.Method {
+		case "eth_blockNumber":
+			resp["result"] = "0x1234"
+		case "eth_getBlockByHash":
+			var hash common.Hash
+			json.Unmarshal(req.Params[0], &hash)
+			resp["result"] = nil
+			if hash == knownBlockHash {
+				resp["result"] = &types.Header{Number: big.NewInt(4000), Difficulty: new(big.Int)}
+			}
+		default:
+			resp["error"] = map[string]interface{}{"code": -32601, "message": "method not found"}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := ethclient.Dial(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func TestResolveBlock(t *testing.T) {
+	m := &Manager{ethClient: headNode(t)}
+
+	tests := []struct {
+		ref     string
+		want    int64
+		wantErr string
+	}{
+		{"", 0x1234, ""},
+		{"latest", 0x1234, ""},
+		{"19000000", 19000000, ""},
+		{knownBlockHash.Hex(), 4000, ""},
+		{common.Hash{1}.Hex(), 0, "unknown block"},
+		{"-5", 0, "invalid block"},
+		{"0x1234", 0, "invalid block"},
+		{"pending", 0, "invalid block"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			block, err := m.ResolveBlock(context.Background(), "ethereum", tt.ref)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolveBlock(%q) error = %v, want %q", tt.ref, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if block.Int64() != tt.want {
+				t.Fatalf("ResolveBlock(%q) = %s, want %d", tt.ref, block, tt.want)
+			}
+		})
+	}
+}
+
+// blockRecorder answers every call with a price of $1 and records the blocks
+// the calls were made at
+type blockRecorder struct {
+	mu     sync.Mutex
+	blocks []*big.Int
+}
+
+func (r *blockRecorder) CallContract(ctx context.Context, msg ethereum.CallMsg, block *big.Int) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blocks = append(r.blocks, block)
+	return common.LeftPadBytes(big.NewInt(1e8).Bytes(), 32), nil
+}
+
+func TestReadsPinnedToBlock(t *testing.T) {
+	node := &blockRecorder{}
+	aave := NewAave(nil, nil, map[string]*multicall.Batcher{"ethereum": multicall.NewBatcher(node, time.Millisecond, 1)})
+
+	if _, err := aave.GetAssetPrice(context.Background(), "USDC", "ethereum"); err != nil {
+		t.Fatal(err)
+	}
+	pinned := WithBlock(context.Background(), big.NewInt(19000000))
+	for _, asset := range []string{"USDC", "WETH"} {
+		if _, err := aave.GetAssetPrice(pinned, asset, "ethereum"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(node.blocks) != 3 {
+		t.Fatalf("%d calls, want 3", len(node.blocks))
+	}
+	if node.blocks[0] != nil {
+		t.Errorf("unpinned read at block %s, want latest", node.blocks[0])
+	}
+	for _, block := range node.blocks[1:] {
+		if block == nil || block.Int64() != 19000000 {
+			t.Errorf("pinned read at block %v, want 19000000", block)
+		}
+	}
+	if BlockFromContext(context.Background()) != nil {
+		t.Error("unpinned context has a block")
+	}
+}
@@ -0,0 +1,82 @@
+package protocols
+
+import (
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrRouteNotSupported is returned by bridges that can't move an asset
+// between two chains
+var ErrRouteNotSupported = errors.New("route not supported by bridge")
+
+// Bridge transfer states
+const (
+	// TransferPending means the funds haven't reached the destination yet
+	TransferPending = "pending"
+	// TransferClaimable means the funds can be claimed on the destination
+	// with the returned calls
+	TransferClaimable = "claimable"
+	// TransferArrived means the funds are at the destination. Any returned
+	// calls still have to be sent before they can be used.
+	TransferArrived = "arrived"
+	// TransferFailed means the transfer will not arrive
+	TransferFailed = "failed"
+)
+
+// Bridge is implemented by bridges that move tokens between chains
+type Bridge interface {
+	GetName() string
+	// Quote returns the fee and expected duration of a transfer, or
+	// ErrRouteNotSupported
+	Quote(ctx context.Context, req BridgeRequest) (*BridgeQuote, error)
+	// BuildTransfer returns the source chain calls that start a transfer
+	BuildTransfer(ctx context.Context, req BridgeRequest) ([]Call, error)
+	// TransferStatus follows a transfer started by a source chain transaction
+	TransferStatus(ctx context.Context, fromChain, toChain string, sourceTx common.Hash) (*TransferStatus, error)
+}
+
+// BridgeRequest is a transfer of an asset between chains
+type BridgeRequest struct {
+	Asset     string
+	Amount    *big.Int // base units
+	FromChain string
+	ToChain   string
+	Sender    string
+	Recipient string // defaults to Sender
+}
+
+// BridgeQuote is what a transfer costs and how long it takes
+type BridgeQuote struct {
+	Bridge    string `json:"bridge"`
+	Asset     string `json:"asset"`
+	FromChain string `json:"from_chain"`
+	ToChain   string `json:"to_chain"`
+	AmountIn  string `json:"amount_in"`  // base units
+	AmountOut string `json:"amount_out"` // base units, after the bridge fee
+	Fee       string `json:"fee"`        // base units
+	// SourceGas and DestinationGas estimate the gas of the transfer and of
+	// the calls needed to claim it
+	SourceGas      uint64 `json:"source_gas"`
+	DestinationGas uint64 `json:"destination_gas"`
+	// ExpectedSeconds is how long the transfer usually takes to arrive
+	ExpectedSeconds int64 `json:"expected_seconds"`
+}
+
+// TransferStatus is where a bridge transfer stands
+type TransferStatus struct {
+	State  string `json:"state"`
+	Detail string `json:"detail,omitempty"`
+	// Calls claim the funds, or make them usable once they've arrived
+	Calls []Call `json:"calls"`
+}
+
+// recipient returns the address a transfer pays
+func (r BridgeRequest) recipient() common.Address {
+	if r.Recipient != "" {
+		return common.HexToAddress(r.Recipient)
+	}
+	return common.HexToAddress(r.Sender)
+}
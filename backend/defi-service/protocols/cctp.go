@@ -0,0 +1,255 @@
+package protocols
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/defioptimization/defi-service/multicall"
+	"github.com/defioptimization/shared/tokens"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// CCTPDeployment is where a chain's CCTP contracts live
+type CCTPDeployment struct {
+	Domain             uint32
+	TokenMessenger     common.Address
+	MessageTransmitter common.Address
+}
+
+// CCTP deployments (mainnet)
+var cctpDeployments = map[string]CCTPDeployment{
+	"ethereum": {
+		Domain:             0,
+		TokenMessenger:     common.HexToAddress("0xBd3fa81B58Ba92a82136038B25aDec7066af3155"),
+		MessageTransmitter: common.HexToAddress("0x0a992d191DEeC32aFe36203Ad87D7d289a738F81"),
+	},
+	"base": {
+		Domain:             6,
+		TokenMessenger:     common.HexToAddress("0x1682Ae6375C4E4A97e4B583BC394c861A46D8962"),
+		MessageTransmitter: common.HexToAddress("0xAD09780d193884d503182aD4588450C416D6F9D4"),
+	},
+}
+
+// cctpAttestationURL is Circle's attestation service
+const cctpAttestationURL = "https://iris-api.circle.com"
+
+const (
+	// cctpAttestationTime is how long Circle usually takes to attest a burn;
+	// it waits for the source chain (or, on Base, Ethereum) to finalize
+	cctpAttestationTime = 20 * time.Minute
+	cctpBurnGas         = 120000
+	cctpReceiveGas      = 180000
+)
+
+const cctpABI = `[
+	{"inputs":[{"name":"amount","type":"uint256"},{"name":"destinationDomain","type":"uint32"},{"name":"mintRecipient","type":"bytes32"},{"name":"burnToken","type":"address"}],"name":"depositForBurn","outputs":[{"name":"_nonce","type":"uint64"}],"stateMutability":"nonpayable","type":"function"},
+	{"inputs":[{"name":"message","type":"bytes"},{"name":"attestation","type":"bytes"}],"name":"receiveMessage","outputs":[{"name":"success","type":"bool"}],"stateMutability":"nonpayable","type":"function"},
+	{"inputs":[{"name":"","type":"bytes32"}],"name":"usedNonces","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"},
+	{"anonymous":false,"inputs":[{"indexed":false,"name":"message","type":"bytes"}],"name":"MessageSent","type":"event"}
+]`
+
+var parsedCCTPABI = mustParseABI(cctpABI)
+
+// CCTP moves USDC between chains with Circle's Cross-Chain Transfer
+// Protocol: USDC is burned on the source chain and, once Circle attests the
+// burn, minted on the destination by receiveMessage. There is no bridge fee.
+type CCTP struct {
+	reader
+	name           string
+	clients        map[string]*ethclient.Client
+	deployments    map[string]CCTPDeployment
+	attestationURL string
+	httpClient     *http.Client
+}
+
+// NewCCTP creates a CCTP bridge. Nil deployments use the mainnet contracts
+// and an empty attestationURL Circle's service.
+func NewCCTP(clients map[string]*ethclient.Client, batchers map[string]*multicall.Batcher, deployments map[string]CCTPDeployment, attestationURL string) *CCTP {
+	if deployments == nil {
+		deployments = cctpDeployments
+	}
+	if attestationURL == "" {
+		attestationURL = cctpAttestationURL
+	}
+	return &CCTP{
+		reader:         reader{batchers: batchers},
+		name:           "cctp",
+		clients:        clients,
+		deployments:    deployments,
+		attestationURL: strings.TrimRight(attestationURL, "/"),
+		httpClient:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// GetName returns the bridge name
+func (b *CCTP) GetName() string {
+	return b.name
+}
+
+// route returns the deployments on both ends of a USDC transfer
+func (b *CCTP) route(asset, fromChain, toChain string) (CCTPDeployment, CCTPDeployment, error) {
+	from, ok1 := b.deployments[fromChain]
+	to, ok2 := b.deployments[toChain]
+	if !ok1 || !ok2 || fromChain == toChain || !strings.EqualFold(asset, "USDC") {
+		return CCTPDeployment{}, CCTPDeployment{}, ErrRouteNotSupported
+	}
+	return from, to, nil
+}
+
+// Quote returns a fee-free transfer that arrives once Circle attests it
+func (b *CCTP) Quote(ctx context.Context, req BridgeRequest) (*BridgeQuote, error) {
+	if _, _, err := b.route(req.Asset, req.FromChain, req.ToChain); err != nil {
+		return nil, err
+	}
+	return &BridgeQuote{
+		Bridge:          b.name,
+		Asset:           "USDC",
+		FromChain:       req.FromChain,
+		ToChain:         req.ToChain,
+		AmountIn:        req.Amount.String(),
+		AmountOut:       req.Amount.String(),
+		Fee:             "0",
+		SourceGas:       cctpBurnGas,
+		DestinationGas:  cctpReceiveGas,
+		ExpectedSeconds: int64(cctpAttestationTime / time.Second),
+	}, nil
+}
+
+// BuildTransfer burns USDC on the source chain for the recipient to mint on
+// the destination
+func (b *CCTP) BuildTransfer(ctx context.Context, req BridgeRequest) ([]Call, error) {
+	from, to, err := b.route(req.Asset, req.FromChain, req.ToChain)
+	if err != nil {
+		return nil, err
+	}
+	token, ok := tokens.Lookup(req.FromChain, "USDC")
+	if !ok {
+		return nil, fmt.Errorf("USDC not supported on %s", req.FromChain)
+	}
+
+	var mintRecipient [32]byte
+	copy(mintRecipient[12:], req.recipient().Bytes())
+	call, err := newCall(parsedCCTPABI, from.TokenMessenger,
+		fmt.Sprintf("Bridge USDC from %s to %s with CCTP", req.FromChain, req.ToChain),
+		"depositForBurn", req.Amount, to.Domain, mintRecipient, common.HexToAddress(token.Address))
+	if err != nil {
+		return nil, err
+	}
+	requireAllowance(&call, token.Address, req.Amount)
+	return []Call{call}, nil
+}
+
+// TransferStatus finds the burn's message in the source transaction. The
+// transfer has arrived once the destination has used the message's nonce;
+// until then it is claimable as soon as Circle has attested the message.
+func (b *CCTP) TransferStatus(ctx context.Context, fromChain, toChain string, sourceTx common.Hash) (*TransferStatus, error) {
+	from, to, err := b.route("USDC", fromChain, toChain)
+	if err != nil {
+		return nil, err
+	}
+	client, ok := b.clients[fromChain]
+	if !ok {
+		return nil, fmt.Errorf("no client for %s", fromChain)
+	}
+
+	receipt, err := client.TransactionReceipt(ctx, sourceTx)
+	if errors.Is(err, ethereum.NotFound) {
+		return &TransferStatus{State: TransferPending, Detail: "burn not mined", Calls: []Call{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if receipt.Status == 0 {
+		return &TransferStatus{State: TransferFailed, Detail: "burn reverted", Calls: []Call{}}, nil
+	}
+
+	var message []byte
+	event := parsedCCTPABI.Events["MessageSent"]
+	for _, l := range receipt.Logs {
+		if l.Address == from.MessageTransmitter && len(l.Topics) > 0 && l.Topics[0] == event.ID {
+			values, err := event.Inputs.Unpack(l.Data)
+			if err != nil {
+				return nil, err
+			}
+			message, _ = values[0].([]byte)
+			break
+		}
+	}
+	// The header is version, source domain, destination domain and nonce
+	if len(message) < 20 {
+		return &TransferStatus{State: TransferFailed, Detail: "no CCTP message in transaction", Calls: []Call{}}, nil
+	}
+
+	sourceDomain := binary.BigEndian.Uint32(message[4:8])
+	nonce := binary.BigEndian.Uint64(message[12:20])
+	var key [12]byte
+	binary.BigEndian.PutUint32(key[0:4], sourceDomain)
+	binary.BigEndian.PutUint64(key[4:12], nonce)
+	values, err := b.read(ctx, toChain, parsedCCTPABI, to.MessageTransmitter, "usedNonces", crypto.Keccak256Hash(key[:]))
+	if err != nil {
+		return nil, err
+	}
+	if used, ok := values[0].(*big.Int); ok && used.Sign() > 0 {
+		return &TransferStatus{State: TransferArrived, Calls: []Call{}}, nil
+	}
+
+	attestation, err := b.attestation(ctx, crypto.Keccak256Hash(message))
+	if err != nil {
+		return nil, err
+	}
+	if attestation == nil {
+		return &TransferStatus{State: TransferPending, Detail: "awaiting attestation", Calls: []Call{}}, nil
+	}
+
+	call, err := newCall(parsedCCTPABI, to.MessageTransmitter,
+		fmt.Sprintf("Claim USDC bridged from %s with CCTP", fromChain),
+		"receiveMessage", message, attestation)
+	if err != nil {
+		return nil, err
+	}
+	return &TransferStatus{State: TransferClaimable, Calls: []Call{call}}, nil
+}
+
+// attestation fetches Circle's attestation of a message, or nil while it
+// is still pending
+func (b *CCTP) attestation(ctx context.Context, messageHash common.Hash) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/attestations/%s", b.attestationURL, messageHash.Hex()), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch attestation: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch attestation: status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Status      string `json:"status"`
+		Attestation string `json:"attestation"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.Status != "complete" {
+		return nil, nil
+	}
+	return hexutil.Decode(result.Attestation)
+}
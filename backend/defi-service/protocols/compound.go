@@ -2,13 +2,20 @@ package protocols
 
 import (
 	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
 
+	"github.com/defioptimization/defi-service/multicall"
+	"github.com/defioptimization/shared/tokens"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
 // Compound implements the Compound protocol integration
 type Compound struct {
+	reader
 	name       string
 	ethClient  *ethclient.Client
 	baseClient *ethclient.Client
@@ -20,9 +27,34 @@ var (
 	compoundComptrollerBase = common.HexToAddress("0xb125E6687d4313864e53df431d5425969c15Eb2F")
 )
 
+// Compound III (Comet) markets keyed by chain and base asset
+var cometMarkets = map[string]map[string]common.Address{
+	"ethereum": {
+		"USDC": common.HexToAddress("0xc3d688B66703497DAA19211EEdff47f25384cdc3"),
+		"WETH": common.HexToAddress("0xA17581A9E3356d9A858b789D68B4d866e593aE94"),
+	},
+	"base": {
+		"USDC": compoundComptrollerBase,
+		"WETH": common.HexToAddress("0x46e6b214b524310239732D51387075E0e70970bf"),
+	},
+}
+
+const cometABI = `[
+	{"inputs":[{"name":"asset","type":"address"},{"name":"amount","type":"uint256"}],"name":"supply","outputs":[],"stateMutability":"nonpayable","type":"function"},
+	{"inputs":[{"name":"asset","type":"address"},{"name":"amount","type":"uint256"}],"name":"withdraw","outputs":[],"stateMutability":"nonpayable","type":"function"},
+	{"inputs":[],"name":"getUtilization","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"},
+	{"inputs":[{"name":"utilization","type":"uint256"}],"name":"getSupplyRate","outputs":[{"name":"","type":"uint64"}],"stateMutability":"view","type":"function"},
+	{"inputs":[{"name":"utilization","type":"uint256"}],"name":"getBorrowRate","outputs":[{"name":"","type":"uint64"}],"stateMutability":"view","type":"function"},
+	{"inputs":[{"name":"account","type":"address"}],"name":"balanceOf","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"},
+	{"inputs":[{"name":"account","type":"address"}],"name":"borrowBalanceOf","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"}
+]`
+
+var parsedCometABI = mustParseABI(cometABI)
+
 // NewCompound creates a new Compound protocol instance
-func NewCompound(ethClient, baseClient *ethclient.Client) *Compound {
+func NewCompound(ethClient, baseClient *ethclient.Client, batchers map[string]*multicall.Batcher) *Compound {
 	return &Compound{
+		reader:     reader{batchers: batchers},
 		name:       "compound",
 		ethClient:  ethClient,
 		baseClient: baseClient,
@@ -34,26 +66,163 @@ func (c *Compound) GetName() string {
 	return c.name
 }
 
-// GetAPY returns the current APY for an asset
+// GetChains returns the chains with Compound III markets
+func (c *Compound) GetChains() []string {
+	chains := make([]string, 0, len(cometMarkets))
+	for chain := range cometMarkets {
+		chains = append(chains, chain)
+	}
+	sort.Strings(chains)
+	return chains
+}
+
+// GetAPY returns the current supply APY of the Comet market for an asset, in percent
 func (c *Compound) GetAPY(ctx context.Context, asset string, chain string) (float64, error) {
-	// TODO: Implement actual Compound contract interaction
-	// Query the Comptroller contract for supply/borrow rates
-	_ = ctx
-	_ = asset
-	_ = chain
-	
-	// Placeholder
-	return 3.8, nil
+	_, market, err := c.getMarket(asset, chain)
+	if err != nil {
+		return 0, err
+	}
+	return c.marketRate(ctx, chain, market, "getSupplyRate")
 }
 
-// GetUserPositions returns user's positions in Compound
+// marketRate reads a market's supply or borrow rate at its current
+// utilization, as an APY in percent
+func (c *Compound) marketRate(ctx context.Context, chain string, market common.Address, method string) (float64, error) {
+	values, err := c.read(ctx, chain, parsedCometABI, market, "getUtilization")
+	if err != nil {
+		return 0, err
+	}
+	utilization, ok := values[0].(*big.Int)
+	if !ok {
+		return 0, fmt.Errorf("unexpected getUtilization response")
+	}
+
+	values, err = c.read(ctx, chain, parsedCometABI, market, method, utilization)
+	if err != nil {
+		return 0, err
+	}
+	rate, ok := values[0].(uint64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected %s response", method)
+	}
+
+	// Comet rates are per second, scaled by 1e18
+	perSecond := tokens.FromBaseUnits(new(big.Int).SetUint64(rate), 18)
+	return compoundPerSecond(perSecond) * 100, nil
+}
+
+// GetUtilization returns the share of the Comet market's base asset supply
+// that is borrowed, in percent
+func (c *Compound) GetUtilization(ctx context.Context, asset string, chain string) (float64, error) {
+	_, market, err := c.getMarket(asset, chain)
+	if err != nil {
+		return 0, err
+	}
+
+	values, err := c.read(ctx, chain, parsedCometABI, market, "getUtilization")
+	if err != nil {
+		return 0, err
+	}
+	utilization, ok := values[0].(*big.Int)
+	if !ok {
+		return 0, fmt.Errorf("unexpected getUtilization response")
+	}
+
+	// Comet utilization is scaled by 1e18
+	return tokens.FromBaseUnits(utilization, 18) * 100, nil
+}
+
+// GetTotalSupplied returns the base asset supplied to the market, in token
+// units
+func (c *Compound) GetTotalSupplied(ctx context.Context, asset string, chain string) (float64, error) {
+	token, market, err := c.getMarket(asset, chain)
+	if err != nil {
+		return 0, err
+	}
+
+	values, err := c.read(ctx, chain, parsedTotalSupplyABI, market, "totalSupply")
+	if err != nil {
+		return 0, err
+	}
+	supply, ok := values[0].(*big.Int)
+	if !ok {
+		return 0, fmt.Errorf("unexpected totalSupply response")
+	}
+	return tokens.FromBaseUnits(supply, token.Decimals), nil
+}
+
+// GetUserPositions returns the user's supplied and borrowed base asset in
+// every Comet market on the chain. Collateral supplied to a market earns
+// nothing and isn't reported.
 func (c *Compound) GetUserPositions(ctx context.Context, userAddress string, chain string) ([]Position, error) {
-	// TODO: Implement position fetching from Compound cToken contracts
-	_ = ctx
-	_ = userAddress
-	_ = chain
-	
-	return []Position{}, nil
+	user := common.HexToAddress(userAddress)
+	symbols := make([]string, 0, len(cometMarkets[chain]))
+	for symbol := range cometMarkets[chain] {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	found := make([][]Position, len(symbols))
+	errs := make([]error, len(symbols))
+	var wg sync.WaitGroup
+	for i, symbol := range symbols {
+		wg.Add(1)
+		go func(i int, symbol string) {
+			defer wg.Done()
+			found[i], errs[i] = c.marketPositions(ctx, chain, symbol, user)
+		}(i, symbol)
+	}
+	wg.Wait()
+
+	positions := []Position{}
+	for i := range symbols {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		positions = append(positions, found[i]...)
+	}
+	return positions, nil
+}
+
+// marketPositions reads the user's supply and borrow balances of one market
+func (c *Compound) marketPositions(ctx context.Context, chain, asset string, user common.Address) ([]Position, error) {
+	token, market, err := c.getMarket(asset, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	var positions []Position
+	for _, side := range []struct{ method, kind, rate string }{
+		{"balanceOf", "lending", "getSupplyRate"},
+		{"borrowBalanceOf", "borrowing", "getBorrowRate"},
+	} {
+		values, err := c.read(ctx, chain, parsedCometABI, market, side.method, user)
+		if err != nil {
+			return nil, err
+		}
+		balance, ok := values[0].(*big.Int)
+		if !ok {
+			return nil, fmt.Errorf("unexpected %s response", side.method)
+		}
+		if balance.Sign() == 0 {
+			continue
+		}
+
+		apy, err := c.marketRate(ctx, chain, market, side.rate)
+		if err != nil {
+			return nil, err
+		}
+		positions = append(positions, Position{
+			Protocol: c.name,
+			Chain:    chain,
+			Asset:    token.Symbol,
+			Type:     side.kind,
+			Amount:   tokens.FromBaseUnits(balance, token.Decimals),
+			APY:      apy,
+			Address:  market.Hex(),
+		})
+	}
+	return positions, nil
 }
 
 // GetHealthFactor returns the user's health factor (collateral factor in Compound)
@@ -76,3 +245,48 @@ func (c *Compound) GetAssetPrice(ctx context.Context, asset string, chain string
 	return 1.0, nil
 }
 
+// BuildDeposit builds a Comet.supply call into the market for the asset
+func (c *Compound) BuildDeposit(ctx context.Context, asset string, amount *big.Int, userAddress string, chain string) ([]Call, error) {
+	token, market, err := c.getMarket(asset, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	call, err := newCall(parsedCometABI, market,
+		fmt.Sprintf("Supply %s to Compound", token.Symbol),
+		"supply", common.HexToAddress(token.Address), amount)
+	if err != nil {
+		return nil, err
+	}
+	requireAllowance(&call, token.Address, amount)
+	return []Call{call}, nil
+}
+
+// BuildWithdraw builds a Comet.withdraw call from the market for the asset
+func (c *Compound) BuildWithdraw(ctx context.Context, asset string, amount *big.Int, userAddress string, chain string) ([]Call, error) {
+	token, market, err := c.getMarket(asset, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	call, err := newCall(parsedCometABI, market,
+		fmt.Sprintf("Withdraw %s from Compound", token.Symbol),
+		"withdraw", common.HexToAddress(token.Address), amount)
+	if err != nil {
+		return nil, err
+	}
+	return []Call{call}, nil
+}
+
+// getMarket returns the token and the Comet market whose base asset it is
+func (c *Compound) getMarket(asset string, chain string) (tokens.Token, common.Address, error) {
+	token, ok := tokens.Lookup(chain, asset)
+	if !ok {
+		return tokens.Token{}, common.Address{}, fmt.Errorf("asset %s not supported on %s", asset, chain)
+	}
+	market, ok := cometMarkets[chain][token.Symbol]
+	if !ok {
+		return tokens.Token{}, common.Address{}, fmt.Errorf("no Compound market for %s on %s", token.Symbol, chain)
+	}
+	return token, market, nil
+}
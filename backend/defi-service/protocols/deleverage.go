@@ -0,0 +1,275 @@
+package protocols
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/defioptimization/shared/tokens"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Debt repayment sources
+const (
+	// RepayFromWallet repays with the debt asset held in the user's wallet
+	RepayFromWallet = "wallet"
+	// RepayFromCollateral repays with the user's supplied debt asset,
+	// withdrawing it as collateral in the same call
+	RepayFromCollateral = "collateral"
+)
+
+// DeleverageRequest asks for the repayment that brings a borrower's health
+// factor up to a target
+type DeleverageRequest struct {
+	DebtAsset   string
+	Target      float64 // health factor to reach
+	Source      string  // RepayFromWallet or RepayFromCollateral
+	MaxAmount   float64 // most debt asset to repay, in token units; 0 for no limit
+	UserAddress string
+	Chain       string
+}
+
+// DeleveragePlan is the repayment that brings a position to a target health
+// factor, and the calls that make it. RepayAmount is zero when the position
+// is already at the target.
+type DeleveragePlan struct {
+	Chain                 string   `json:"chain"`
+	Asset                 string   `json:"asset"`
+	Token                 string   `json:"token"`
+	Source                string   `json:"source"`
+	HealthFactor          float64  `json:"health_factor"`
+	TargetHealthFactor    float64  `json:"target_health_factor"`
+	ProjectedHealthFactor float64  `json:"projected_health_factor"`
+	Debt                  float64  `json:"debt"`         // owed in the debt asset, in token units
+	RepayAmount           float64  `json:"repay_amount"` // in token units
+	Amount                string   `json:"amount"`       // base units
+	Calls                 []Call   `json:"calls"`
+	Warnings              []string `json:"warnings,omitempty"`
+}
+
+// Deleverager is implemented by lending protocols that can plan debt
+// repayments to restore a health factor
+type Deleverager interface {
+	PlanDeleverage(ctx context.Context, req DeleverageRequest) (*DeleveragePlan, error)
+}
+
+// Aave interest rate modes; only variable rate debt can still be opened
+var aaveVariableRate = big.NewInt(2)
+
+// aaveAccount is an Aave account's totals in the pool's base currency
+type aaveAccount struct {
+	collateral           float64 // USD
+	debt                 float64 // USD
+	liquidationThreshold float64 // collateral-weighted average, as a fraction
+	healthFactor         float64
+}
+
+// accountData reads an account's collateral, debt and health factor
+func (a *Aave) accountData(ctx context.Context, chain string, user common.Address) (*aaveAccount, error) {
+	values, err := a.read(ctx, chain, parsedAavePoolABI, a.getPoolAddress(chain), "getUserAccountData", user)
+	if err != nil {
+		return nil, err
+	}
+
+	ints := make([]*big.Int, len(values))
+	for i, v := range values {
+		n, ok := v.(*big.Int)
+		if !ok {
+			return nil, fmt.Errorf("unexpected getUserAccountData response")
+		}
+		ints[i] = n
+	}
+
+	// Base currency amounts use the oracle's decimals, the threshold is in
+	// basis points and the health factor has 18 decimals
+	return &aaveAccount{
+		collateral:           tokens.FromBaseUnits(ints[0], aaveOracleDecimals),
+		debt:                 tokens.FromBaseUnits(ints[1], aaveOracleDecimals),
+		liquidationThreshold: tokens.FromBaseUnits(ints[3], 4),
+		healthFactor:         tokens.FromBaseUnits(ints[5], 18),
+	}, nil
+}
+
+// balanceOf reads an ERC-20 balance, in base units
+func (a *Aave) balanceOf(ctx context.Context, chain string, token, account common.Address) (*big.Int, error) {
+	values, err := a.read(ctx, chain, parsedBalanceOfABI, token, "balanceOf", account)
+	if err != nil {
+		return nil, err
+	}
+	balance, ok := values[0].(*big.Int)
+	if !ok {
+		return nil, fmt.Errorf("unexpected balanceOf response")
+	}
+	return balance, nil
+}
+
+// PlanDeleverage computes how much of the debt asset to repay to reach the
+// target health factor. Repaying R dollars of debt with the wallet's funds
+// lifts the health factor to weighted/(debt-R); repaying with collateral
+// also removes R times that asset's liquidation threshold from the weighted
+// collateral. Solving for the target gives
+//
+//	R = (target*debt - weighted) / (target - threshold)
+//
+// with a threshold of 0 for wallet repayments. The repayment is capped by the
+// debt owed in the asset, the collateral available and MaxAmount; the plan
+// warns when a cap keeps it from reaching the target.
+func (a *Aave) PlanDeleverage(ctx context.Context, req DeleverageRequest) (*DeleveragePlan, error) {
+	token, ok := tokens.Lookup(req.Chain, req.DebtAsset)
+	if !ok {
+		return nil, fmt.Errorf("asset %s not supported on %s", req.DebtAsset, req.Chain)
+	}
+	if req.Target <= 1 {
+		return nil, fmt.Errorf("target health factor must be above 1")
+	}
+	asset, user := common.HexToAddress(token.Address), common.HexToAddress(req.UserAddress)
+	pool := a.getPoolAddress(req.Chain)
+
+	account, err := a.accountData(ctx, req.Chain, user)
+	if err != nil {
+		return nil, err
+	}
+	reserve, err := a.read(ctx, req.Chain, parsedAavePoolABI, pool, "getReserveData", asset)
+	if err != nil {
+		return nil, err
+	}
+	configuration, ok1 := reserve[0].(*big.Int)
+	reserveID, ok2 := reserve[7].(uint16)
+	aToken, ok3 := reserve[8].(common.Address)
+	debtToken, ok4 := reserve[10].(common.Address)
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return nil, fmt.Errorf("unexpected getReserveData response")
+	}
+	price, err := a.GetAssetPrice(ctx, req.DebtAsset, req.Chain)
+	if err != nil {
+		return nil, err
+	}
+	if price <= 0 {
+		return nil, fmt.Errorf("no price for %s", token.Symbol)
+	}
+	owed, err := a.balanceOf(ctx, req.Chain, debtToken, user)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &DeleveragePlan{
+		Chain:                 req.Chain,
+		Asset:                 token.Symbol,
+		Token:                 token.Address,
+		Source:                req.Source,
+		HealthFactor:          account.healthFactor,
+		TargetHealthFactor:    req.Target,
+		ProjectedHealthFactor: account.healthFactor,
+		Debt:                  tokens.FromBaseUnits(owed, token.Decimals),
+		Amount:                "0",
+		Calls:                 []Call{},
+	}
+	if owed.Sign() == 0 {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("no %s debt to repay", token.Symbol))
+		return plan, nil
+	}
+
+	// The most that can be repaid from the source, and the liquidation
+	// threshold of the collateral repaying removes
+	limit, bySupply := owed, false
+	threshold := 0.0
+	switch req.Source {
+	case RepayFromWallet:
+	case RepayFromCollateral:
+		supplied, err := a.balanceOf(ctx, req.Chain, aToken, user)
+		if err != nil {
+			return nil, err
+		}
+		if supplied.Cmp(limit) < 0 {
+			limit, bySupply = supplied, true
+		}
+		collateral, err := a.usedAsCollateral(ctx, req.Chain, user, reserveID)
+		if err != nil {
+			return nil, err
+		}
+		if collateral {
+			// Bits 16-31 of the reserve configuration, in basis points
+			bps := new(big.Int).And(new(big.Int).Rsh(configuration, 16), big.NewInt(0xffff))
+			threshold = tokens.FromBaseUnits(bps, 4)
+		}
+	default:
+		return nil, fmt.Errorf("source must be %s or %s", RepayFromWallet, RepayFromCollateral)
+	}
+
+	weighted := account.collateral * account.liquidationThreshold
+	repayUSD := (req.Target*account.debt - weighted) / (req.Target - threshold)
+	if repayUSD <= 0 {
+		return plan, nil
+	}
+
+	// Round up so rounding never leaves the position just short of the target
+	amount := tokens.ToBaseUnits(repayUSD/price, token.Decimals)
+	amount.Add(amount, big.NewInt(1))
+	if amount.Cmp(limit) > 0 {
+		amount = new(big.Int).Set(limit)
+		if bySupply {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("supplied %s does not cover the repayment", token.Symbol))
+		} else {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("repaying all %s debt does not reach the target", token.Symbol))
+		}
+	}
+	if req.MaxAmount > 0 {
+		if capped := tokens.ToBaseUnits(req.MaxAmount, token.Decimals); amount.Cmp(capped) > 0 {
+			amount = capped
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("repayment capped at %g %s", req.MaxAmount, token.Symbol))
+		}
+	}
+	if amount.Sign() <= 0 {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("no %s available to repay with", token.Symbol))
+		return plan, nil
+	}
+
+	plan.RepayAmount = tokens.FromBaseUnits(amount, token.Decimals)
+	plan.Amount = amount.String()
+	repaid := plan.RepayAmount * price
+	plan.ProjectedHealthFactor = projectedHealthFactor(weighted-repaid*threshold, account.debt-repaid)
+
+	var call Call
+	if req.Source == RepayFromCollateral {
+		call, err = newCall(parsedAavePoolABI, pool,
+			fmt.Sprintf("Repay %s debt on Aave with supplied %s", token.Symbol, token.Symbol),
+			"repayWithATokens", asset, amount, aaveVariableRate)
+	} else {
+		call, err = newCall(parsedAavePoolABI, pool,
+			fmt.Sprintf("Repay %s debt on Aave", token.Symbol),
+			"repay", asset, amount, aaveVariableRate, user)
+		if err == nil {
+			requireAllowance(&call, token.Address, amount)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	plan.Calls = []Call{call}
+	return plan, nil
+}
+
+// usedAsCollateral reports whether the user has enabled a reserve as
+// collateral. The user configuration holds two bits per reserve ID, the
+// higher of which is the collateral flag.
+func (a *Aave) usedAsCollateral(ctx context.Context, chain string, user common.Address, reserveID uint16) (bool, error) {
+	values, err := a.read(ctx, chain, parsedAavePoolABI, a.getPoolAddress(chain), "getUserConfiguration", user)
+	if err != nil {
+		return false, err
+	}
+	data, ok := values[0].(*big.Int)
+	if !ok {
+		return false, fmt.Errorf("unexpected getUserConfiguration response")
+	}
+	return data.Bit(int(reserveID)*2+1) == 1, nil
+}
+
+// projectedHealthFactor is weighted collateral over debt, or the largest
+// float for a position without debt
+func projectedHealthFactor(weighted, debt float64) float64 {
+	if debt <= 0 {
+		return math.MaxFloat64
+	}
+	return weighted / debt
+}
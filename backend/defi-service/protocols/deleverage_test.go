@@ -0,0 +1,292 @@
+package protocols
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/defi-service/multicall"
+	"github.com/defioptimization/shared/tokens"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var (
+	testATokenAddress    = common.HexToAddress("0x98C23E9d8f34FEFb1B7BD6a91B7FF122F4e16F5c")
+	testDebtTokenAddress = common.HexToAddress("0x72E95b8931767C79bA4EeE721354d6E99a61D004")
+)
+
+// testReserveID is the USDC reserve's index in the fake pool
+const testReserveID = 3
+
+// aaveNode answers the pool, oracle and token reads of PlanDeleverage for
+// one account borrowing USDC at $1
+type aaveNode struct {
+	collateral, debt float64 // USD
+	thresholdBps     int64   // account's weighted liquidation threshold
+	healthFactor     float64
+	configuration    *big.Int // of the USDC reserve
+	userConfig       *big.Int
+	owed, supplied   float64 // USDC
+}
+
+func (n *aaveNode) CallContract(ctx context.Context, msg ethereum.CallMsg, block *big.Int) ([]byte, error) {
+	selector := msg.Data[:4]
+	is := func(contract abi.ABI, method string) bool {
+		return bytes.Equal(selector, contract.Methods[method].ID)
+	}
+	pack := func(contract abi.ABI, method string, values ...interface{}) ([]byte, error) {
+		return contract.Methods[method].Outputs.Pack(values...)
+	}
+	usd := func(v float64) *big.Int { return tokens.ToBaseUnits(v, aaveOracleDecimals) }
+	usdc := func(v float64) *big.Int { return tokens.ToBaseUnits(v, 6) }
+
+	switch {
+	case is(parsedAavePoolABI, "getUserAccountData"):
+		return pack(parsedAavePoolABI, "getUserAccountData", usd(n.collateral), usd(n.debt), big.NewInt(0),
+			big.NewInt(n.thresholdBps), big.NewInt(7000), tokens.ToBaseUnits(n.healthFactor, 18))
+	case is(parsedAavePoolABI, "getReserveData"):
+		zero := new(big.Int)
+		return pack(parsedAavePoolABI, "getReserveData", n.configuration, zero, zero, zero, zero, zero, zero,
+			uint16(testReserveID), testATokenAddress, common.Address{}, testDebtTokenAddress, common.Address{}, zero, zero, zero)
+	case is(parsedAavePoolABI, "getUserConfiguration"):
+		return pack(parsedAavePoolABI, "getUserConfiguration", n.userConfig)
+	case is(parsedAaveOracleABI, "getAssetPrice"):
+		return pack(parsedAaveOracleABI, "getAssetPrice", usd(1))
+	case is(parsedBalanceOfABI, "balanceOf") && *msg.To == testDebtTokenAddress:
+		return pack(parsedBalanceOfABI, "balanceOf", usdc(n.owed))
+	case is(parsedBalanceOfABI, "balanceOf") && *msg.To == testATokenAddress:
+		return pack(parsedBalanceOfABI, "balanceOf", usdc(n.supplied))
+	}
+	return nil, fmt.Errorf("unexpected call to %s", msg.To)
+}
+
+// reserveConfiguration packs an LTV, liquidation threshold and bonus, in
+// basis points, the way Aave lays out bits 0-47
+func reserveConfiguration(ltv, threshold, bonus int64) *big.Int {
+	c := big.NewInt(bonus)
+	c.Lsh(c, 16).Or(c, big.NewInt(threshold))
+	c.Lsh(c, 16).Or(c, big.NewInt(ltv))
+	return c
+}
+
+// userConfiguration sets the borrowing and collateral bits of reserves
+func userConfiguration(borrowing, collateral []int) *big.Int {
+	c := new(big.Int)
+	for _, id := range borrowing {
+		c.SetBit(c, id*2, 1)
+	}
+	for _, id := range collateral {
+		c.SetBit(c, id*2+1, 1)
+	}
+	return c
+}
+
+func TestPlanDeleverage(t *testing.T) {
+	// $10000 collateral at an 80% threshold against $7000 of debt: the
+	// health factor is 8000/7000
+	account := func(change func(n *aaveNode)) *aaveNode {
+		n := &aaveNode{
+			collateral:    10000,
+			debt:          7000,
+			thresholdBps:  8000,
+			healthFactor:  8000.0 / 7000,
+			configuration: reserveConfiguration(7500, 7800, 10500),
+			userConfig:    userConfiguration([]int{testReserveID}, []int{testReserveID, 0}),
+			owed:          7000,
+			supplied:      5000,
+		}
+		if change != nil {
+			change(n)
+		}
+		return n
+	}
+
+	tests := []struct {
+		name   string
+		node   *aaveNode
+		source string
+		max    float64
+
+		wantRepay     float64 // USDC, before rounding up
+		wantProjected float64
+		wantMethod    string
+		wantWarnings  []string
+	}{
+		{
+			name:          "from the wallet",
+			node:          account(nil),
+			source:        RepayFromWallet,
+			wantRepay:     (1.5*7000 - 8000) / 1.5,
+			wantProjected: 1.5,
+			wantMethod:    "repay",
+		},
+		{
+			name:   "from collateral",
+			node:   account(nil),
+			source: RepayFromCollateral,
+			// Repaying with USDC supplied as collateral also removes its 78% threshold
+			wantRepay:     (1.5*7000 - 8000) / (1.5 - 0.78),
+			wantProjected: 1.5,
+			wantMethod:    "repayWithATokens",
+		},
+		{
+			name: "from supply not used as collateral",
+			node: account(func(n *aaveNode) {
+				// Only the neighbouring reserves' collateral bits are set
+				n.userConfig = userConfiguration([]int{testReserveID}, []int{testReserveID - 1, testReserveID + 1})
+			}),
+			source:        RepayFromCollateral,
+			wantRepay:     (1.5*7000 - 8000) / 1.5,
+			wantProjected: 1.5,
+			wantMethod:    "repayWithATokens",
+		},
+		{
+			name:          "capped by the debt owed",
+			node:          account(func(n *aaveNode) { n.owed = 1000 }),
+			source:        RepayFromWallet,
+			wantRepay:     1000,
+			wantProjected: 8000.0 / 6000,
+			wantMethod:    "repay",
+			wantWarnings:  []string{"repaying all USDC debt does not reach the target"},
+		},
+		{
+			name:          "capped by the supply",
+			node:          account(func(n *aaveNode) { n.supplied = 1000 }),
+			source:        RepayFromCollateral,
+			wantRepay:     1000,
+			wantProjected: (8000 - 780.0) / 6000,
+			wantMethod:    "repayWithATokens",
+			wantWarnings:  []string{"supplied USDC does not cover the repayment"},
+		},
+		{
+			name:          "capped by the maximum",
+			node:          account(nil),
+			source:        RepayFromWallet,
+			max:           100,
+			wantRepay:     100,
+			wantProjected: 8000.0 / 6900,
+			wantMethod:    "repay",
+			wantWarnings:  []string{"repayment capped at 100 USDC"},
+		},
+		{
+			name:          "already above the target",
+			node:          account(func(n *aaveNode) { n.debt, n.healthFactor = 4000, 2 }),
+			source:        RepayFromWallet,
+			wantProjected: 2,
+		},
+		{
+			name:          "no debt in the asset",
+			node:          account(func(n *aaveNode) { n.owed = 0 }),
+			source:        RepayFromWallet,
+			wantProjected: 8000.0 / 7000,
+			wantWarnings:  []string{"no USDC debt to repay"},
+		},
+		{
+			name:          "nothing supplied",
+			node:          account(func(n *aaveNode) { n.supplied = 0 }),
+			source:        RepayFromCollateral,
+			wantProjected: 8000.0 / 7000,
+			wantWarnings:  []string{"supplied USDC does not cover the repayment", "no USDC available to repay with"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aave := NewAave(nil, nil, map[string]*multicall.Batcher{"ethereum": multicall.NewBatcher(tt.node, time.Millisecond, 1)})
+			plan, err := aave.PlanDeleverage(context.Background(), DeleverageRequest{
+				DebtAsset:   "USDC",
+				Target:      1.5,
+				Source:      tt.source,
+				MaxAmount:   tt.max,
+				UserAddress: "0x1111111111111111111111111111111111111111",
+				Chain:       "ethereum",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Amounts are rounded up to the next base unit
+			if math.Abs(plan.RepayAmount-tt.wantRepay) > 2e-6 {
+				t.Errorf("repay amount = %f, want %f", plan.RepayAmount, tt.wantRepay)
+			}
+			if math.Abs(plan.ProjectedHealthFactor-tt.wantProjected) > 1e-6 {
+				t.Errorf("projected health factor = %f, want %f", plan.ProjectedHealthFactor, tt.wantProjected)
+			}
+			if math.Abs(plan.HealthFactor-tt.node.healthFactor) > 1e-9 || plan.Debt != tt.node.owed {
+				t.Errorf("plan health factor %f and debt %f, read %f and %f", plan.HealthFactor, plan.Debt, tt.node.healthFactor, tt.node.owed)
+			}
+			if strings.Join(plan.Warnings, "; ") != strings.Join(tt.wantWarnings, "; ") {
+				t.Errorf("warnings = %q, want %q", plan.Warnings, tt.wantWarnings)
+			}
+
+			if tt.wantMethod == "" {
+				if len(plan.Calls) != 0 || plan.Amount != "0" {
+					t.Fatalf("plan repays %s with %+v, want nothing", plan.Amount, plan.Calls)
+				}
+				return
+			}
+			if len(plan.Calls) != 1 {
+				t.Fatalf("%d calls, want 1", len(plan.Calls))
+			}
+			call := plan.Calls[0]
+			data, err := hexutil.Decode(call.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			method := parsedAavePoolABI.Methods[tt.wantMethod]
+			if !bytes.Equal(data[:4], method.ID) {
+				t.Fatalf("call %s, want %s", call.Description, tt.wantMethod)
+			}
+			args, err := method.Inputs.Unpack(data[4:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if amount := args[1].(*big.Int); amount.String() != plan.Amount {
+				t.Errorf("call repays %s, plan says %s", amount, plan.Amount)
+			}
+			if rate := args[2].(*big.Int); rate.Cmp(aaveVariableRate) != 0 {
+				t.Errorf("interest rate mode %s, want variable", rate)
+			}
+			// Only wallet repayments spend an allowance
+			if (call.Requires != nil) != (tt.source == RepayFromWallet) {
+				t.Errorf("allowance requirement = %+v", call.Requires)
+			}
+			if call.Requires != nil && call.Requires.Amount != plan.Amount {
+				t.Errorf("allowance of %s for a repayment of %s", call.Requires.Amount, plan.Amount)
+			}
+		})
+	}
+}
+
+func TestPlanDeleverageRejects(t *testing.T) {
+	node := &aaveNode{collateral: 10000, debt: 7000, thresholdBps: 8000, healthFactor: 1.1,
+		configuration: new(big.Int), userConfig: new(big.Int), owed: 7000}
+	aave := NewAave(nil, nil, map[string]*multicall.Batcher{"ethereum": multicall.NewBatcher(node, time.Millisecond, 1)})
+
+	tests := []struct {
+		name string
+		req  DeleverageRequest
+		want string
+	}{
+		{"target at liquidation", DeleverageRequest{DebtAsset: "USDC", Target: 1, Source: RepayFromWallet}, "target health factor must be above 1"},
+		{"unknown source", DeleverageRequest{DebtAsset: "USDC", Target: 1.5, Source: "bridge"}, "source must be wallet or collateral"},
+		{"unsupported asset", DeleverageRequest{DebtAsset: "DOGE", Target: 1.5, Source: RepayFromWallet}, "asset DOGE not supported on ethereum"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Chain = "ethereum"
+			tt.req.UserAddress = "0x1111111111111111111111111111111111111111"
+			_, err := aave.PlanDeleverage(context.Background(), tt.req)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -31,6 +31,11 @@ func (e *EigenLayer) GetName() string {
 	return e.name
 }
 
+// GetChains returns the chains EigenLayer is deployed on
+func (e *EigenLayer) GetChains() []string {
+	return []string{"ethereum"}
+}
+
 // GetAPY returns the current APY for staking
 func (e *EigenLayer) GetAPY(ctx context.Context, asset string, chain string) (float64, error) {
 	// EigenLayer is Ethereum-only
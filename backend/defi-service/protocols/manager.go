@@ -2,18 +2,28 @@ package protocols
 
 import (
 	"context"
+	"errors"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/defioptimization/defi-service/multicall"
+	"github.com/defioptimization/shared/tokens"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
+// ErrNoPriceFeed is returned by GetAssetPrice when the protocol's oracle has
+// no price for the asset
+var ErrNoPriceFeed = errors.New("no price feed for asset")
+
 // Manager manages all protocol integrations
 type Manager struct {
 	ethClient  *ethclient.Client
 	baseClient *ethclient.Client
 	
 	protocols map[string]Protocol
+	swappers  map[string]Swapper
+	bridges   map[string]Bridge
 	mu        sync.RWMutex
 }
 
@@ -26,6 +36,24 @@ type Protocol interface {
 	GetAssetPrice(ctx context.Context, asset string, chain string) (float64, error)
 }
 
+// UtilizationReader is implemented by protocols that can report how much of
+// a reserve's supplied liquidity is borrowed
+type UtilizationReader interface {
+	GetUtilization(ctx context.Context, asset string, chain string) (float64, error)
+}
+
+// LiquidityReader is implemented by protocols that can report how much of
+// an asset is supplied to a market
+type LiquidityReader interface {
+	GetTotalSupplied(ctx context.Context, asset string, chain string) (float64, error)
+}
+
+// ChainLister is implemented by protocols deployed on only some of the
+// supported chains
+type ChainLister interface {
+	GetChains() []string
+}
+
 // Position represents a DeFi position
 type Position struct {
 	Protocol     string  `json:"protocol"`
@@ -37,6 +65,12 @@ type Position struct {
 	Address      string  `json:"address"`
 }
 
+// Multicall batching parameters
+const (
+	multicallWindow   = 10 * time.Millisecond
+	multicallMaxBatch = 200
+)
+
 // NewManager creates a new protocol manager
 func NewManager(ethRPC, baseRPC string) *Manager {
 	ethClient, err := ethclient.Dial(ethRPC)
@@ -49,17 +83,33 @@ func NewManager(ethRPC, baseRPC string) *Manager {
 		panic("Failed to connect to Base: " + err.Error())
 	}
 
+	// Reads issued within a few milliseconds of each other share one eth_call
+	batchers := map[string]*multicall.Batcher{
+		"ethereum": multicall.NewBatcher(ethClient, multicallWindow, multicallMaxBatch),
+		"base":     multicall.NewBatcher(baseClient, multicallWindow, multicallMaxBatch),
+	}
+
 	m := &Manager{
 		ethClient:  ethClient,
 		baseClient: baseClient,
 		protocols:  make(map[string]Protocol),
+		swappers:   make(map[string]Swapper),
+		bridges:    make(map[string]Bridge),
 	}
 
 	// Register protocols
-	m.RegisterProtocol(NewAave(ethClient, baseClient))
-	m.RegisterProtocol(NewCompound(ethClient, baseClient))
+	m.RegisterProtocol(NewAave(ethClient, baseClient, batchers))
+	m.RegisterProtocol(NewCompound(ethClient, baseClient, batchers))
 	m.RegisterProtocol(NewEigenLayer(ethClient))
 
+	// Register swap venues
+	m.RegisterSwapper(NewUniswapV3(batchers, nil))
+
+	// Register bridges
+	clients := map[string]*ethclient.Client{"ethereum": ethClient, "base": baseClient}
+	m.RegisterBridge(NewCCTP(clients, batchers, nil, ""))
+	m.RegisterBridge(NewBaseBridge(clients))
+
 	return m
 }
 
@@ -78,6 +128,48 @@ func (m *Manager) GetProtocol(name string) (Protocol, bool) {
 	return p, ok
 }
 
+// RegisterSwapper registers a swap venue
+func (m *Manager) RegisterSwapper(s Swapper) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.swappers[s.GetName()] = s
+}
+
+// GetSwapper returns a swap venue by name
+func (m *Manager) GetSwapper(name string) (Swapper, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.swappers[name]
+	return s, ok
+}
+
+// RegisterBridge registers a bridge
+func (m *Manager) RegisterBridge(b Bridge) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bridges[b.GetName()] = b
+}
+
+// GetBridge returns a bridge by name
+func (m *Manager) GetBridge(name string) (Bridge, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.bridges[name]
+	return b, ok
+}
+
+// GetAllBridges returns all registered bridges sorted by name
+func (m *Manager) GetAllBridges() []Bridge {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	bridges := make([]Bridge, 0, len(m.bridges))
+	for _, b := range m.bridges {
+		bridges = append(bridges, b)
+	}
+	sort.Slice(bridges, func(i, j int) bool { return bridges[i].GetName() < bridges[j].GetName() })
+	return bridges
+}
+
 // GetAllProtocols returns all registered protocols
 func (m *Manager) GetAllProtocols() []Protocol {
 	m.mu.RLock()
@@ -89,6 +181,72 @@ func (m *Manager) GetAllProtocols() []Protocol {
 	return protocols
 }
 
+// ProtocolInfo describes a registered protocol: the chains it is on and the
+// optional capabilities it implements
+type ProtocolInfo struct {
+	Name         string   `json:"name"`
+	Chains       []string `json:"chains"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// DescribeProtocols describes every registered protocol, sorted by name
+func (m *Manager) DescribeProtocols() []ProtocolInfo {
+	list := m.GetAllProtocols()
+	sort.Slice(list, func(i, j int) bool { return list[i].GetName() < list[j].GetName() })
+
+	infos := make([]ProtocolInfo, len(list))
+	for i, p := range list {
+		info := ProtocolInfo{Name: p.GetName(), Chains: tokens.Chains(), Capabilities: []string{}}
+		if l, ok := p.(ChainLister); ok {
+			info.Chains = l.GetChains()
+		}
+		if _, ok := p.(UtilizationReader); ok {
+			info.Capabilities = append(info.Capabilities, "utilization")
+		}
+		if _, ok := p.(TransactionBuilder); ok {
+			info.Capabilities = append(info.Capabilities, "transactions")
+		}
+		if _, ok := p.(Deleverager); ok {
+			info.Capabilities = append(info.Capabilities, "deleverage")
+		}
+		infos[i] = info
+	}
+	return infos
+}
+
+// AssetAPY is one entry of an APY sweep
+type AssetAPY struct {
+	Asset string  `json:"asset"`
+	APY   float64 `json:"apy"`
+	Error string  `json:"error,omitempty"`
+}
+
+// SweepAPY reads the APY of every supported asset on a chain concurrently.
+// The reads land in the same multicall window, so the sweep costs one RPC
+// call per dependent read step rather than one per asset.
+func (m *Manager) SweepAPY(ctx context.Context, p Protocol, chain string) []AssetAPY {
+	list := tokens.List(chain)
+	results := make([]AssetAPY, len(list))
+
+	var wg sync.WaitGroup
+	for i, t := range list {
+		wg.Add(1)
+		go func(i int, symbol string) {
+			defer wg.Done()
+			results[i] = AssetAPY{Asset: symbol}
+			apy, err := p.GetAPY(ctx, symbol, chain)
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].APY = apy
+		}(i, t.Symbol)
+	}
+	wg.Wait()
+
+	return results
+}
+
 // GetClient returns the appropriate client for a chain
 func (m *Manager) GetClient(chain string) *ethclient.Client {
 	if chain == "base" {
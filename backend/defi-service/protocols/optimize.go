@@ -0,0 +1,893 @@
+package protocols
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/defioptimization/shared/tokens"
+)
+
+// Risk tolerances, which set an optimization's default limits
+const (
+	RiskLow    = "low"
+	RiskMedium = "medium"
+	RiskHigh   = "high"
+)
+
+const (
+	// defaultOptimizeHorizonDays is how long an allocation is assumed to
+	// last when amortizing the cost of moving into it
+	defaultOptimizeHorizonDays = 30
+	maxOptimizeHorizonDays     = 365
+	hoursPerYear               = 365 * 24
+	// dustUSD is the smallest amount worth allocating
+	dustUSD = 0.01
+)
+
+// estimatedGas is the typical gas of the calls a move sends
+var estimatedGas = map[string]uint64{
+	"approve":  50000,
+	"deposit":  250000,
+	"withdraw": 250000,
+}
+
+// OptimizeLimits bound where an optimization may put funds. Zero fields take
+// the risk tolerance's default.
+type OptimizeLimits struct {
+	// MaxProtocolShare is the most of the portfolio's value one protocol may
+	// hold, in percent
+	MaxProtocolShare float64 `json:"max_protocol_share"`
+	// MaxPoolShare is the most of a market's supply the portfolio may make
+	// up, in percent, so it can always withdraw
+	MaxPoolShare float64 `json:"max_pool_share"`
+	// MaxUtilization excludes markets that lend out more of their supply
+	// than this, in percent, as withdrawals from them can stall
+	MaxUtilization float64 `json:"max_utilization"`
+	// MinTVLUSD excludes markets with less supplied
+	MinTVLUSD float64 `json:"min_tvl_usd"`
+	// MinImprovementBps is the net APY gain, after gas and bridge fees over
+	// the horizon, a plan needs to be recommended
+	MinImprovementBps float64 `json:"min_improvement_bps"`
+}
+
+// riskLimits are the default limits of each risk tolerance
+var riskLimits = map[string]OptimizeLimits{
+	RiskLow:    {MaxProtocolShare: 40, MaxPoolShare: 1, MaxUtilization: 85, MinTVLUSD: 50000000, MinImprovementBps: 25},
+	RiskMedium: {MaxProtocolShare: 60, MaxPoolShare: 5, MaxUtilization: 92, MinTVLUSD: 10000000, MinImprovementBps: 10},
+	RiskHigh:   {MaxProtocolShare: 100, MaxPoolShare: 10, MaxUtilization: 98, MinTVLUSD: 1000000, MinImprovementBps: 5},
+}
+
+// Holding is an amount of an asset supplied to a protocol on a chain
+type Holding struct {
+	Protocol string  `json:"protocol"`
+	Chain    string  `json:"chain"`
+	Asset    string  `json:"asset"`
+	Amount   float64 `json:"amount"` // token units
+}
+
+// OptimizeRequest asks for the allocation of a portfolio's holdings that
+// earns the most within its risk limits
+type OptimizeRequest struct {
+	UserAddress string
+	Holdings    []Holding
+	Risk        string // RiskLow, RiskMedium or RiskHigh; medium when empty
+	Limits      OptimizeLimits
+	Protocols   []string // where funds may go; every protocol that builds transactions when empty
+	Chains      []string // where funds may go; every chain when empty
+	HorizonDays float64
+}
+
+// Market is what the optimizer read about one protocol's market for an
+// asset on a chain
+type Market struct {
+	Protocol    string  `json:"protocol"`
+	Chain       string  `json:"chain"`
+	Asset       string  `json:"asset"`
+	APY         float64 `json:"apy"`
+	Utilization float64 `json:"utilization"`
+	TVLUSD      float64 `json:"tvl_usd"`
+	Eligible    bool    `json:"eligible"`
+	Reason      string  `json:"reason,omitempty"` // why it isn't eligible
+}
+
+// Allocation is an amount of an asset in a market
+type Allocation struct {
+	Protocol string  `json:"protocol"`
+	Chain    string  `json:"chain"`
+	Asset    string  `json:"asset"`
+	Amount   float64 `json:"amount"` // token units
+	ValueUSD float64 `json:"value_usd"`
+	APY      float64 `json:"apy"`
+	Share    float64 `json:"share"` // percent of the portfolio's value
+}
+
+// Move takes an amount of an asset out of one market and into another,
+// bridging it when the markets are on different chains
+type Move struct {
+	Asset        string       `json:"asset"`
+	Amount       float64      `json:"amount"` // token units
+	FromProtocol string       `json:"from_protocol"`
+	FromChain    string       `json:"from_chain"`
+	ToProtocol   string       `json:"to_protocol"`
+	ToChain      string       `json:"to_chain"`
+	FromAPY      float64      `json:"from_apy"`
+	ToAPY        float64      `json:"to_apy"`
+	Bridge       *BridgeQuote `json:"bridge,omitempty"`
+	// GainUSD is the extra yield over the horizon, less what is lost in
+	// transit; CostUSD the gas on both chains and any bridge fee
+	GainUSD float64    `json:"gain_usd"`
+	CostUSD float64    `json:"cost_usd"`
+	NetUSD  float64    `json:"net_usd"`
+	Steps   []MoveStep `json:"steps,omitempty"`
+}
+
+// MoveStep is a batch of calls to send on one chain
+type MoveStep struct {
+	Chain       string `json:"chain"`
+	Description string `json:"description"`
+	Calls       []Call `json:"calls"`
+	// AfterArrival is set on deposits that can only be sent once bridged
+	// funds have arrived, and been claimed if the bridge needs it
+	AfterArrival bool `json:"after_arrival,omitempty"`
+}
+
+// OptimizationPlan is a proposed allocation of a portfolio and the moves
+// that reach it. Moves only carry calls when the plan is recommended.
+type OptimizationPlan struct {
+	Risk           string         `json:"risk"`
+	Limits         OptimizeLimits `json:"limits"`
+	HorizonDays    float64        `json:"horizon_days"`
+	ValueUSD       float64        `json:"value_usd"`
+	CurrentAPY     float64        `json:"current_apy"`
+	ProposedAPY    float64        `json:"proposed_apy"`
+	GainUSD        float64        `json:"gain_usd"`
+	CostUSD        float64        `json:"cost_usd"`
+	NetUSD         float64        `json:"net_usd"`
+	ImprovementBps float64        `json:"improvement_bps"` // net gain, annualized
+	Recommended    bool           `json:"recommended"`
+	Reason         string         `json:"reason,omitempty"`
+	Current        []Allocation   `json:"current"`
+	Target         []Allocation   `json:"target"`
+	Moves          []*Move        `json:"moves"`
+	Markets        []*Market      `json:"markets"`
+	Warnings       []string       `json:"warnings,omitempty"`
+}
+
+// marketKey identifies a market
+type marketKey struct {
+	protocol, chain, asset string
+}
+
+// assetKey identifies an asset on a chain
+type assetKey struct {
+	chain, asset string
+}
+
+// optimization is the state of one Optimize call
+type optimization struct {
+	m    *Manager
+	req  OptimizeRequest
+	plan *OptimizationPlan
+
+	// builders are the protocols holdings can be withdrawn from, and
+	// candidates those funds may be deposited to
+	builders   map[string]TransactionBuilder
+	candidates map[string]bool
+	chains     map[string]bool
+
+	markets map[marketKey]*Market
+	prices  map[assetKey]float64
+	gasUSD  map[string]float64 // USD per gas unit, by chain
+
+	// movable holdings can be withdrawn; fixed ones stay where they are
+	movable map[marketKey]float64
+	fixed   map[marketKey]float64
+	target  map[marketKey]float64
+}
+
+// Optimize proposes the allocation of a portfolio's holdings that earns the
+// most net yield over the horizon. Each asset stays the same asset; it is
+// only moved between protocols and chains. Markets are filled best APY first
+// within the protocol, pool, utilization and TVL limits, and moves that
+// don't pay for their gas and bridge fees are dropped unless they bring a
+// protocol back under its cap.
+func (m *Manager) Optimize(ctx context.Context, req OptimizeRequest) (*OptimizationPlan, error) {
+	o, err := m.newOptimization(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.readMarkets(ctx); err != nil {
+		return nil, err
+	}
+
+	o.allocate()
+	o.planMoves(ctx)
+	o.summarize()
+
+	if o.plan.Recommended {
+		if err := o.buildCalls(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return o.plan, nil
+}
+
+// newOptimization validates a request and applies its defaults
+func (m *Manager) newOptimization(req OptimizeRequest) (*optimization, error) {
+	if req.Risk == "" {
+		req.Risk = RiskMedium
+	}
+	limits, ok := riskLimits[req.Risk]
+	if !ok {
+		return nil, fmt.Errorf("risk must be %s, %s or %s", RiskLow, RiskMedium, RiskHigh)
+	}
+	if req.Limits.MaxProtocolShare > 0 {
+		limits.MaxProtocolShare = req.Limits.MaxProtocolShare
+	}
+	if req.Limits.MaxPoolShare > 0 {
+		limits.MaxPoolShare = req.Limits.MaxPoolShare
+	}
+	if req.Limits.MaxUtilization > 0 {
+		limits.MaxUtilization = req.Limits.MaxUtilization
+	}
+	if req.Limits.MinTVLUSD > 0 {
+		limits.MinTVLUSD = req.Limits.MinTVLUSD
+	}
+	if req.Limits.MinImprovementBps > 0 {
+		limits.MinImprovementBps = req.Limits.MinImprovementBps
+	}
+	if limits.MaxProtocolShare > 100 || limits.MaxPoolShare > 100 || limits.MaxUtilization > 100 {
+		return nil, errors.New("shares and utilization are percentages and can't exceed 100")
+	}
+	req.Limits = limits
+
+	if req.HorizonDays == 0 {
+		req.HorizonDays = defaultOptimizeHorizonDays
+	}
+	if req.HorizonDays < 0 || req.HorizonDays > maxOptimizeHorizonDays {
+		return nil, fmt.Errorf("horizon must be between 0 and %d days", maxOptimizeHorizonDays)
+	}
+
+	o := &optimization{
+		m:          m,
+		req:        req,
+		builders:   make(map[string]TransactionBuilder),
+		candidates: make(map[string]bool),
+		chains:     make(map[string]bool),
+		markets:    make(map[marketKey]*Market),
+		prices:     make(map[assetKey]float64),
+		gasUSD:     make(map[string]float64),
+		movable:    make(map[marketKey]float64),
+		fixed:      make(map[marketKey]float64),
+		target:     make(map[marketKey]float64),
+		plan: &OptimizationPlan{
+			Risk:        req.Risk,
+			Limits:      limits,
+			HorizonDays: req.HorizonDays,
+			Moves:       []*Move{},
+		},
+	}
+
+	for _, p := range m.GetAllProtocols() {
+		if builder, ok := p.(TransactionBuilder); ok {
+			o.builders[p.GetName()] = builder
+		}
+	}
+	if len(req.Protocols) == 0 {
+		for name := range o.builders {
+			o.candidates[name] = true
+		}
+	}
+	for _, name := range req.Protocols {
+		if _, ok := m.GetProtocol(name); !ok {
+			return nil, fmt.Errorf("unknown protocol %s", name)
+		}
+		if _, ok := o.builders[name]; !ok {
+			return nil, fmt.Errorf("protocol %s does not support transactions", name)
+		}
+		o.candidates[name] = true
+	}
+
+	chains := req.Chains
+	if len(chains) == 0 {
+		chains = tokens.Chains()
+	}
+	for _, chain := range chains {
+		if len(tokens.List(chain)) == 0 {
+			return nil, fmt.Errorf("unknown chain %s", chain)
+		}
+		o.chains[chain] = true
+	}
+
+	for _, h := range req.Holdings {
+		if _, ok := m.GetProtocol(h.Protocol); !ok {
+			return nil, fmt.Errorf("unknown protocol %s", h.Protocol)
+		}
+		token, ok := tokens.Lookup(h.Chain, h.Asset)
+		if !ok {
+			return nil, fmt.Errorf("asset %s not supported on %s", h.Asset, h.Chain)
+		}
+		if h.Amount <= 0 {
+			continue
+		}
+		key := marketKey{h.Protocol, h.Chain, token.Symbol}
+		if _, ok := o.builders[h.Protocol]; ok {
+			o.movable[key] += h.Amount
+		} else {
+			o.fixed[key] += h.Amount
+		}
+	}
+	if len(o.movable)+len(o.fixed) == 0 {
+		return nil, errors.New("no holdings to optimize")
+	}
+
+	return o, nil
+}
+
+// readMarkets reads the APY, utilization and supply of every market the
+// holdings are in or could move to, and the prices and gas costs needed to
+// compare them. The reads run concurrently so they share multicalls.
+func (o *optimization) readMarkets(ctx context.Context) error {
+	assets := make(map[string]bool)
+	for key := range o.movable {
+		assets[key.asset] = true
+	}
+
+	keys := make(map[marketKey]bool)
+	for key := range o.movable {
+		keys[key] = true
+	}
+	for key := range o.fixed {
+		keys[key] = true
+	}
+	for protocol := range o.candidates {
+		for chain := range o.chains {
+			for asset := range assets {
+				if _, ok := tokens.Lookup(chain, asset); ok {
+					keys[marketKey{protocol, chain, asset}] = true
+				}
+			}
+		}
+	}
+
+	priced := make(map[assetKey]bool)
+	gasChains := make(map[string]bool)
+	for key := range keys {
+		priced[assetKey{key.chain, key.asset}] = true
+		if _, ok := o.builders[key.protocol]; ok {
+			gasChains[key.chain] = true
+			priced[assetKey{key.chain, "WETH"}] = true
+		}
+	}
+
+	oracle, ok := o.m.GetProtocol("aave")
+	if !ok {
+		return errors.New("no price oracle available")
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	priceErrs := make(map[assetKey]error)
+	gasPrices := make(map[string]*big.Int)
+	var gasErr error
+
+	for key := range priced {
+		wg.Add(1)
+		go func(key assetKey) {
+			defer wg.Done()
+			price, err := oracle.GetAssetPrice(ctx, key.asset, key.chain)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				priceErrs[key] = err
+				return
+			}
+			o.prices[key] = price
+		}(key)
+	}
+
+	for chain := range gasChains {
+		wg.Add(1)
+		go func(chain string) {
+			defer wg.Done()
+			gasPrice, err := o.m.GetClient(chain).SuggestGasPrice(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				gasErr = fmt.Errorf("failed to read gas price on %s: %w", chain, err)
+				return
+			}
+			gasPrices[chain] = gasPrice
+		}(chain)
+	}
+
+	for key := range keys {
+		market := &Market{Protocol: key.protocol, Chain: key.chain, Asset: key.asset}
+		o.markets[key] = market
+		protocol, _ := o.m.GetProtocol(key.protocol)
+
+		wg.Add(1)
+		go func(market *Market) {
+			defer wg.Done()
+			var reason string
+			apy, err := protocol.GetAPY(ctx, market.Asset, market.Chain)
+			if err != nil {
+				reason = err.Error()
+			}
+
+			var utilization, supplied float64
+			if r, ok := protocol.(UtilizationReader); ok && err == nil {
+				if utilization, err = r.GetUtilization(ctx, market.Asset, market.Chain); err != nil {
+					reason = err.Error()
+				}
+			}
+			if r, ok := protocol.(LiquidityReader); ok && err == nil {
+				if supplied, err = r.GetTotalSupplied(ctx, market.Asset, market.Chain); err != nil {
+					reason = err.Error()
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			market.APY, market.Utilization, market.TVLUSD = apy, utilization, supplied
+			market.Reason = reason
+		}(market)
+	}
+	wg.Wait()
+
+	if gasErr != nil {
+		return gasErr
+	}
+	for key := range o.movable {
+		if err := priceErrs[assetKey{key.chain, key.asset}]; err != nil {
+			return fmt.Errorf("failed to price %s on %s: %w", key.asset, key.chain, err)
+		}
+	}
+	for key := range o.fixed {
+		if err := priceErrs[assetKey{key.chain, key.asset}]; err != nil {
+			return fmt.Errorf("failed to price %s on %s: %w", key.asset, key.chain, err)
+		}
+	}
+	for chain, gasPrice := range gasPrices {
+		ethPrice, ok := o.prices[assetKey{chain, "WETH"}]
+		if !ok {
+			return fmt.Errorf("failed to price gas on %s: %w", chain, priceErrs[assetKey{chain, "WETH"}])
+		}
+		o.gasUSD[chain] = weiToEther(gasPrice) * ethPrice
+	}
+
+	for key, market := range o.markets {
+		// Holdings whose market can't be read stay where they are
+		if market.Reason != "" && o.movable[key] > 0 {
+			o.warn("%s %s on %s stays in place: %s", key.protocol, key.asset, key.chain, market.Reason)
+			o.fixed[key] += o.movable[key]
+			delete(o.movable, key)
+		}
+
+		price, priced := o.prices[assetKey{key.chain, key.asset}]
+		market.TVLUSD *= price
+		switch {
+		case market.Reason != "":
+		case !priced:
+			market.Reason = "no price"
+		case !o.candidates[key.protocol]:
+			market.Reason = "protocol not a candidate"
+		case !o.chains[key.chain]:
+			market.Reason = "chain not a candidate"
+		case market.Utilization > o.req.Limits.MaxUtilization:
+			market.Reason = fmt.Sprintf("utilization %.1f%% is above %.1f%%", market.Utilization, o.req.Limits.MaxUtilization)
+		case market.TVLUSD < o.req.Limits.MinTVLUSD:
+			market.Reason = fmt.Sprintf("supply of $%.0f is below $%.0f", market.TVLUSD, o.req.Limits.MinTVLUSD)
+		default:
+			market.Eligible = true
+		}
+	}
+
+	return nil
+}
+
+// allocate fills eligible markets best APY first. A market takes no more
+// than its pool share, and a protocol no more than its share of the
+// portfolio, fixed holdings included. Whatever doesn't fit stays where it is.
+func (o *optimization) allocate() {
+	for key, amount := range o.fixed {
+		o.plan.ValueUSD += amount * o.price(key)
+	}
+	for key, amount := range o.movable {
+		o.plan.ValueUSD += amount * o.price(key)
+	}
+
+	used := make(map[string]float64) // USD by protocol
+	for key, amount := range o.fixed {
+		used[key.protocol] += amount * o.price(key)
+	}
+	protocolCap := o.req.Limits.MaxProtocolShare / 100 * o.plan.ValueUSD
+
+	remaining := make(map[string]float64) // token units by asset
+	for key, amount := range o.movable {
+		remaining[key.asset] += amount
+	}
+
+	eligible := make([]marketKey, 0, len(o.markets))
+	for key, market := range o.markets {
+		if market.Eligible {
+			eligible = append(eligible, key)
+		}
+	}
+	sort.Slice(eligible, func(i, j int) bool {
+		a, b := o.markets[eligible[i]], o.markets[eligible[j]]
+		if a.APY != b.APY {
+			return a.APY > b.APY
+		}
+		// Prefer staying put, then deeper markets
+		if o.movable[eligible[i]] != o.movable[eligible[j]] {
+			return o.movable[eligible[i]] > o.movable[eligible[j]]
+		}
+		return a.TVLUSD > b.TVLUSD
+	})
+
+	for _, key := range eligible {
+		price := o.price(key)
+		amount := math.Min(remaining[key.asset], o.req.Limits.MaxPoolShare/100*o.markets[key].TVLUSD/price)
+		amount = math.Min(amount, (protocolCap-used[key.protocol])/price)
+		if amount*price < dustUSD {
+			continue
+		}
+		o.target[key] += amount
+		used[key.protocol] += amount * price
+		remaining[key.asset] -= amount
+	}
+
+	// Leave what has no room where it is, in its best paying markets first
+	current := make([]marketKey, 0, len(o.movable))
+	for key := range o.movable {
+		current = append(current, key)
+	}
+	sort.Slice(current, func(i, j int) bool { return o.apy(current[i]) > o.apy(current[j]) })
+	for asset, amount := range remaining {
+		if amount <= 0 {
+			continue
+		}
+		warned := false
+		for _, key := range current {
+			if key.asset != asset || amount <= 0 {
+				continue
+			}
+			if !warned && amount*o.price(key) >= dustUSD {
+				o.warn("%g %s stays in place; no eligible market has room for it within the limits", amount, asset)
+				warned = true
+			}
+			take := math.Min(math.Max(o.movable[key]-o.target[key], 0), amount)
+			o.target[key] += take
+			amount -= take
+		}
+	}
+}
+
+// planMoves pairs markets that lose funds with markets that gain them,
+// preferring sources on the same chain, and prices each move
+func (o *optimization) planMoves(ctx context.Context) {
+	all := make([]marketKey, 0, len(o.markets))
+	for key := range o.markets {
+		if o.movable[key] > 0 || o.target[key] > 0 {
+			all = append(all, key)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if o.apy(all[i]) != o.apy(all[j]) {
+			return o.apy(all[i]) > o.apy(all[j])
+		}
+		return fmt.Sprint(all[i]) < fmt.Sprint(all[j])
+	})
+
+	surplus := make(map[marketKey]float64)
+	for _, key := range all {
+		if d := o.movable[key] - o.target[key]; d > 0 {
+			surplus[key] = d
+		}
+	}
+	overCap := o.overCap()
+
+	for _, sink := range all {
+		need := o.target[sink] - o.movable[sink]
+		if need*o.price(sink) < dustUSD {
+			continue
+		}
+
+		sources := make([]marketKey, 0)
+		for _, key := range all {
+			if key.asset == sink.asset && surplus[key] > 0 {
+				sources = append(sources, key)
+			}
+		}
+		sort.SliceStable(sources, func(i, j int) bool {
+			si, sj := sources[i].chain == sink.chain, sources[j].chain == sink.chain
+			if si != sj {
+				return si
+			}
+			return o.apy(sources[i]) < o.apy(sources[j])
+		})
+
+		for _, source := range sources {
+			if need*o.price(sink) < dustUSD {
+				break
+			}
+			amount := math.Min(need, surplus[source])
+			surplus[source] -= amount
+			need -= amount
+
+			move, err := o.priceMove(ctx, source, sink, amount)
+			if err != nil {
+				o.warn("%g %s stays in %s on %s: %v", amount, sink.asset, source.protocol, source.chain, err)
+			} else if move.NetUSD > 0 || overCap[source.protocol] {
+				o.plan.Moves = append(o.plan.Moves, move)
+				continue
+			}
+			// Funds that don't move stay at the source
+			o.target[source] += amount
+			o.target[sink] -= amount
+		}
+	}
+}
+
+// overCap returns the protocols currently holding more than their share of
+// the portfolio
+func (o *optimization) overCap() map[string]bool {
+	held := make(map[string]float64)
+	for key, amount := range o.movable {
+		held[key.protocol] += amount * o.price(key)
+	}
+	for key, amount := range o.fixed {
+		held[key.protocol] += amount * o.price(key)
+	}
+	over := make(map[string]bool)
+	for protocol, value := range held {
+		if value > o.req.Limits.MaxProtocolShare/100*o.plan.ValueUSD+dustUSD {
+			over[protocol] = true
+		}
+	}
+	return over
+}
+
+// priceMove weighs the extra yield of a move over the horizon against its
+// gas, and for a move across chains the bridge with the best net
+func (o *optimization) priceMove(ctx context.Context, from, to marketKey, amount float64) (*Move, error) {
+	move := &Move{
+		Asset:        from.asset,
+		Amount:       amount,
+		FromProtocol: from.protocol,
+		FromChain:    from.chain,
+		ToProtocol:   to.protocol,
+		ToChain:      to.chain,
+		FromAPY:      o.apy(from),
+		ToAPY:        o.apy(to),
+	}
+	value := amount * o.price(from)
+	horizon := o.req.HorizonDays * 24
+	gas := o.gasCost(from.chain, estimatedGas["withdraw"]) + o.gasCost(to.chain, estimatedGas["approve"]+estimatedGas["deposit"])
+
+	if from.chain == to.chain {
+		move.GainUSD = value * (move.ToAPY - move.FromAPY) / 100 * horizon / hoursPerYear
+		move.CostUSD = gas
+		move.NetUSD = move.GainUSD - move.CostUSD
+		return move, nil
+	}
+
+	token, ok := tokens.Lookup(from.chain, from.asset)
+	if !ok {
+		return nil, fmt.Errorf("asset %s not supported on %s", from.asset, from.chain)
+	}
+	req := BridgeRequest{
+		Asset:     from.asset,
+		Amount:    tokens.ToBaseUnits(amount, token.Decimals),
+		FromChain: from.chain,
+		ToChain:   to.chain,
+		Sender:    o.req.UserAddress,
+	}
+
+	found := false
+	for _, bridge := range o.m.GetAllBridges() {
+		quote, err := bridge.Quote(ctx, req)
+		if errors.Is(err, ErrRouteNotSupported) {
+			continue
+		}
+		if err != nil {
+			o.warn("%s quote for %s from %s to %s failed: %v", bridge.GetName(), from.asset, from.chain, to.chain, err)
+			continue
+		}
+		fee, ok := new(big.Int).SetString(quote.Fee, 10)
+		if !ok {
+			continue
+		}
+
+		transit := float64(quote.ExpectedSeconds) / 3600
+		gain := value * (move.ToAPY*(horizon-transit) - move.FromAPY*horizon) / 100 / hoursPerYear
+		cost := gas + tokens.FromBaseUnits(fee, token.Decimals)*o.price(from) +
+			o.gasCost(from.chain, quote.SourceGas) + o.gasCost(to.chain, quote.DestinationGas)
+		if !found || gain-cost > move.NetUSD {
+			found = true
+			move.Bridge = quote
+			move.GainUSD, move.CostUSD, move.NetUSD = gain, cost, gain-cost
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no bridge moves %s from %s to %s", from.asset, from.chain, to.chain)
+	}
+	return move, nil
+}
+
+// summarize fills in the allocations and totals, and whether the plan is
+// worth its cost
+func (o *optimization) summarize() {
+	p := o.plan
+	p.Current = o.allocations(o.movable)
+	p.Target = o.allocations(o.target)
+	for _, a := range p.Current {
+		p.CurrentAPY += a.APY * a.Share / 100
+	}
+	for _, a := range p.Target {
+		p.ProposedAPY += a.APY * a.Share / 100
+	}
+	for _, move := range p.Moves {
+		p.GainUSD += move.GainUSD
+		p.CostUSD += move.CostUSD
+	}
+	p.NetUSD = p.GainUSD - p.CostUSD
+	if p.ValueUSD > 0 && p.HorizonDays > 0 {
+		p.ImprovementBps = p.NetUSD / p.ValueUSD * 365 / p.HorizonDays * 10000
+	}
+
+	p.Markets = make([]*Market, 0, len(o.markets))
+	for _, market := range o.markets {
+		p.Markets = append(p.Markets, market)
+	}
+	sort.Slice(p.Markets, func(i, j int) bool {
+		if p.Markets[i].APY != p.Markets[j].APY {
+			return p.Markets[i].APY > p.Markets[j].APY
+		}
+		return fmt.Sprint(*p.Markets[i]) < fmt.Sprint(*p.Markets[j])
+	})
+
+	overCap, reducesCap := o.overCap(), false
+	for _, move := range p.Moves {
+		reducesCap = reducesCap || overCap[move.FromProtocol]
+	}
+
+	switch {
+	case len(p.Moves) == 0:
+		p.Reason = "the portfolio is already at its best allocation within the limits, after costs"
+	case p.ImprovementBps >= p.Limits.MinImprovementBps:
+		p.Recommended = true
+	case reducesCap:
+		p.Recommended = true
+		p.Reason = "moves funds out of protocols above their share of the portfolio"
+	default:
+		p.Reason = fmt.Sprintf("net improvement of %.1f bps is below the minimum of %.1f bps", p.ImprovementBps, p.Limits.MinImprovementBps)
+	}
+}
+
+// allocations lists the fixed holdings with the given movable amounts
+func (o *optimization) allocations(movable map[marketKey]float64) []Allocation {
+	amounts := make(map[marketKey]float64, len(movable)+len(o.fixed))
+	for key, amount := range movable {
+		amounts[key] += amount
+	}
+	for key, amount := range o.fixed {
+		amounts[key] += amount
+	}
+
+	list := make([]Allocation, 0, len(amounts))
+	for key, amount := range amounts {
+		value := amount * o.price(key)
+		if value < dustUSD {
+			continue
+		}
+		a := Allocation{
+			Protocol: key.protocol,
+			Chain:    key.chain,
+			Asset:    key.asset,
+			Amount:   amount,
+			ValueUSD: value,
+			APY:      o.apy(key),
+		}
+		if o.plan.ValueUSD > 0 {
+			a.Share = value / o.plan.ValueUSD * 100
+		}
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ValueUSD > list[j].ValueUSD })
+	return list
+}
+
+// buildCalls builds each move's withdrawal, bridge transfer and deposit
+func (o *optimization) buildCalls(ctx context.Context) error {
+	for _, move := range o.plan.Moves {
+		fromToken, ok := tokens.Lookup(move.FromChain, move.Asset)
+		if !ok {
+			return fmt.Errorf("asset %s not supported on %s", move.Asset, move.FromChain)
+		}
+		toToken, ok := tokens.Lookup(move.ToChain, move.Asset)
+		if !ok {
+			return fmt.Errorf("asset %s not supported on %s", move.Asset, move.ToChain)
+		}
+		amount := tokens.ToBaseUnits(move.Amount, fromToken.Decimals)
+
+		calls, err := o.builders[move.FromProtocol].BuildWithdraw(ctx, move.Asset, amount, o.req.UserAddress, move.FromChain)
+		if err != nil {
+			return err
+		}
+		move.Steps = append(move.Steps, MoveStep{
+			Chain:       move.FromChain,
+			Description: fmt.Sprintf("Withdraw %g %s from %s", move.Amount, move.Asset, move.FromProtocol),
+			Calls:       calls,
+		})
+
+		deposit := amount
+		if move.Bridge != nil {
+			bridge, ok := o.m.GetBridge(move.Bridge.Bridge)
+			if !ok {
+				return fmt.Errorf("unknown bridge %s", move.Bridge.Bridge)
+			}
+			calls, err := bridge.BuildTransfer(ctx, BridgeRequest{
+				Asset:     move.Asset,
+				Amount:    amount,
+				FromChain: move.FromChain,
+				ToChain:   move.ToChain,
+				Sender:    o.req.UserAddress,
+			})
+			if err != nil {
+				return err
+			}
+			move.Steps = append(move.Steps, MoveStep{
+				Chain:       move.FromChain,
+				Description: fmt.Sprintf("Bridge %s to %s with %s", move.Asset, move.ToChain, move.Bridge.Bridge),
+				Calls:       calls,
+			})
+
+			out, ok := new(big.Int).SetString(move.Bridge.AmountOut, 10)
+			if !ok {
+				return fmt.Errorf("invalid bridge output %s", move.Bridge.AmountOut)
+			}
+			deposit = tokens.ToBaseUnits(tokens.FromBaseUnits(out, fromToken.Decimals), toToken.Decimals)
+		}
+
+		calls, err = o.builders[move.ToProtocol].BuildDeposit(ctx, move.Asset, deposit, o.req.UserAddress, move.ToChain)
+		if err != nil {
+			return err
+		}
+		move.Steps = append(move.Steps, MoveStep{
+			Chain:        move.ToChain,
+			Description:  fmt.Sprintf("Deposit %g %s into %s", tokens.FromBaseUnits(deposit, toToken.Decimals), move.Asset, move.ToProtocol),
+			Calls:        calls,
+			AfterArrival: move.Bridge != nil,
+		})
+	}
+	return nil
+}
+
+// price returns the USD price of a market's asset
+func (o *optimization) price(key marketKey) float64 {
+	return o.prices[assetKey{key.chain, key.asset}]
+}
+
+// apy returns a market's APY, or zero if it couldn't be read
+func (o *optimization) apy(key marketKey) float64 {
+	if market, ok := o.markets[key]; ok {
+		return market.APY
+	}
+	return 0
+}
+
+// gasCost prices gas units on a chain
+func (o *optimization) gasCost(chain string, units uint64) float64 {
+	return float64(units) * o.gasUSD[chain]
+}
+
+func (o *optimization) warn(format string, args ...interface{}) {
+	o.plan.Warnings = append(o.plan.Warnings, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,370 @@
+package protocols
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// stubProtocol is a protocol whose reads the optimizer tests replace with
+// fixed markets
+type stubProtocol struct{ name string }
+
+func (p *stubProtocol) GetName() string { return p.name }
+func (p *stubProtocol) GetAPY(ctx context.Context, asset, chain string) (float64, error) {
+	return 0, nil
+}
+func (p *stubProtocol) GetUserPositions(ctx context.Context, userAddress, chain string) ([]Position, error) {
+	return nil, nil
+}
+func (p *stubProtocol) GetHealthFactor(ctx context.Context, userAddress, chain string) (float64, error) {
+	return 0, nil
+}
+func (p *stubProtocol) GetAssetPrice(ctx context.Context, asset, chain string) (float64, error) {
+	return 1, nil
+}
+
+// stubBuilder is a protocol that builds transactions, so its holdings can move
+type stubBuilder struct{ stubProtocol }
+
+func (p *stubBuilder) BuildDeposit(ctx context.Context, asset string, amount *big.Int, userAddress, chain string) ([]Call, error) {
+	return []Call{{Description: "deposit"}}, nil
+}
+func (p *stubBuilder) BuildWithdraw(ctx context.Context, asset string, amount *big.Int, userAddress, chain string) ([]Call, error) {
+	return []Call{{Description: "withdraw"}}, nil
+}
+
+// stubBridge quotes every transfer the same, or fails with err
+type stubBridge struct {
+	name  string
+	quote BridgeQuote
+	err   error
+}
+
+func (b *stubBridge) GetName() string { return b.name }
+func (b *stubBridge) Quote(ctx context.Context, req BridgeRequest) (*BridgeQuote, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	quote := b.quote
+	quote.Bridge, quote.Asset, quote.FromChain, quote.ToChain = b.name, req.Asset, req.FromChain, req.ToChain
+	quote.AmountIn = req.Amount.String()
+	return &quote, nil
+}
+func (b *stubBridge) BuildTransfer(ctx context.Context, req BridgeRequest) ([]Call, error) {
+	return nil, nil
+}
+func (b *stubBridge) TransferStatus(ctx context.Context, fromChain, toChain string, sourceTx common.Hash) (*TransferStatus, error) {
+	return nil, nil
+}
+
+// optimizeManager registers aave and compound as builders, eigenlayer as a
+// protocol whose holdings can't move, and the given bridges
+func optimizeManager(bridges ...Bridge) *Manager {
+	m := &Manager{
+		protocols: make(map[string]Protocol),
+		swappers:  make(map[string]Swapper),
+		bridges:   make(map[string]Bridge),
+	}
+	m.RegisterProtocol(&stubBuilder{stubProtocol{"aave"}})
+	m.RegisterProtocol(&stubBuilder{stubProtocol{"compound"}})
+	m.RegisterProtocol(&stubProtocol{"eigenlayer"})
+	for _, b := range bridges {
+		m.RegisterBridge(b)
+	}
+	return m
+}
+
+// usdcMarket is an eligible USDC market
+func usdcMarket(protocol, chain string, apy, tvlUSD float64) *Market {
+	return &Market{Protocol: protocol, Chain: chain, Asset: "USDC", APY: apy, TVLUSD: tvlUSD, Eligible: true}
+}
+
+func usdc(protocol, chain string, amount float64) Holding {
+	return Holding{Protocol: protocol, Chain: chain, Asset: "USDC", Amount: amount}
+}
+
+// testOptimization sets up an optimization at high risk over 30 days with
+// the given markets, USDC at $1 and gas at gasUSD per unit on every chain
+func testOptimization(t *testing.T, m *Manager, limits OptimizeLimits, gasUSD float64, holdings []Holding, markets []*Market) *optimization {
+	t.Helper()
+	o, err := m.newOptimization(OptimizeRequest{
+		UserAddress: "0x1111111111111111111111111111111111111111",
+		Holdings:    holdings,
+		Risk:        RiskHigh,
+		Limits:      limits,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, market := range markets {
+		o.markets[marketKey{market.Protocol, market.Chain, market.Asset}] = market
+	}
+	for _, chain := range []string{"ethereum", "base"} {
+		o.prices[assetKey{chain, "USDC"}] = 1
+		o.gasUSD[chain] = gasUSD
+	}
+	return o
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestOptimizeAllocation(t *testing.T) {
+	const (
+		cheapGas  = 1e-9 // a move costs $0.00055
+		pricedGas = 1e-6 // $0.55
+		dearGas   = 1e-2 // $5500
+	)
+	slow := &stubBridge{name: "slow", quote: BridgeQuote{AmountOut: "999000000", Fee: "1000000", ExpectedSeconds: 24 * 3600}}
+	fast := &stubBridge{name: "fast", quote: BridgeQuote{AmountOut: "998000000", Fee: "2000000", ExpectedSeconds: 60}}
+	unsupported := &stubBridge{name: "none", err: ErrRouteNotSupported}
+
+	tests := []struct {
+		name     string
+		limits   OptimizeLimits
+		gasUSD   float64
+		bridges  []Bridge
+		holdings []Holding
+		markets  []*Market
+
+		wantTarget      map[marketKey]float64
+		wantMoves       int
+		wantBridge      string
+		wantRecommended bool
+		wantReason      string
+		wantWarning     string
+	}{
+		{
+			name:            "best APY first",
+			gasUSD:          cheapGas,
+			holdings:        []Holding{usdc("aave", "ethereum", 1000)},
+			markets:         []*Market{usdcMarket("aave", "ethereum", 3, 1e9), usdcMarket("compound", "ethereum", 5, 1e9)},
+			wantTarget:      map[marketKey]float64{{"compound", "ethereum", "USDC"}: 1000},
+			wantMoves:       1,
+			wantRecommended: true,
+		},
+		{
+			name:            "protocol cap",
+			limits:          OptimizeLimits{MaxProtocolShare: 60},
+			gasUSD:          cheapGas,
+			holdings:        []Holding{usdc("aave", "ethereum", 1000)},
+			markets:         []*Market{usdcMarket("aave", "ethereum", 3, 1e9), usdcMarket("compound", "ethereum", 5, 1e9)},
+			wantTarget:      map[marketKey]float64{{"compound", "ethereum", "USDC"}: 600, {"aave", "ethereum", "USDC"}: 400},
+			wantMoves:       1,
+			wantRecommended: true,
+		},
+		{
+			name:            "pool share",
+			gasUSD:          cheapGas,
+			holdings:        []Holding{usdc("aave", "ethereum", 1000)},
+			markets:         []*Market{usdcMarket("aave", "ethereum", 3, 1e9), usdcMarket("compound", "ethereum", 5, 2000)},
+			wantTarget:      map[marketKey]float64{{"compound", "ethereum", "USDC"}: 200, {"aave", "ethereum", "USDC"}: 800},
+			wantMoves:       1,
+			wantRecommended: true,
+		},
+		{
+			name:     "fixed holdings count toward the portfolio",
+			limits:   OptimizeLimits{MaxProtocolShare: 50},
+			gasUSD:   cheapGas,
+			holdings: []Holding{usdc("aave", "ethereum", 1000), usdc("eigenlayer", "ethereum", 1000)},
+			markets: []*Market{usdcMarket("aave", "ethereum", 3, 1e9), usdcMarket("compound", "ethereum", 5, 1e9),
+				{Protocol: "eigenlayer", Chain: "ethereum", Asset: "USDC", APY: 2, Reason: "protocol not a candidate"}},
+			// Half of $2000 fits in compound; without the fixed holding only $500 would
+			wantTarget:      map[marketKey]float64{{"compound", "ethereum", "USDC"}: 1000},
+			wantMoves:       1,
+			wantRecommended: true,
+		},
+		{
+			name:       "move that doesn't cover its gas is dropped",
+			gasUSD:     dearGas,
+			holdings:   []Holding{usdc("aave", "ethereum", 1000)},
+			markets:    []*Market{usdcMarket("aave", "ethereum", 3, 1e9), usdcMarket("compound", "ethereum", 5, 1e9)},
+			wantTarget: map[marketKey]float64{{"aave", "ethereum", "USDC"}: 1000},
+			wantReason: "already at its best allocation",
+		},
+		{
+			name:       "gain below the minimum improvement",
+			limits:     OptimizeLimits{MinImprovementBps: 500},
+			gasUSD:     cheapGas,
+			holdings:   []Holding{usdc("aave", "ethereum", 1000)},
+			markets:    []*Market{usdcMarket("aave", "ethereum", 3, 1e9), usdcMarket("compound", "ethereum", 5, 1e9)},
+			wantTarget: map[marketKey]float64{{"compound", "ethereum", "USDC"}: 1000},
+			wantMoves:  1,
+			wantReason: "below the minimum of 500.0 bps",
+		},
+		{
+			name:     "over-cap protocol is reduced at a loss",
+			limits:   OptimizeLimits{MaxProtocolShare: 60},
+			gasUSD:   pricedGas,
+			holdings: []Holding{usdc("aave", "ethereum", 1000)},
+			markets:  []*Market{usdcMarket("aave", "ethereum", 5, 1e9), usdcMarket("compound", "ethereum", 4.9, 1e9)},
+			// Moving to the lower APY costs yield and gas, but brings aave under its cap
+			wantTarget:      map[marketKey]float64{{"aave", "ethereum", "USDC"}: 600, {"compound", "ethereum", "USDC"}: 400},
+			wantMoves:       1,
+			wantRecommended: true,
+			wantReason:      "above their share of the portfolio",
+		},
+		{
+			name:            "bridge with the best net",
+			gasUSD:          cheapGas,
+			bridges:         []Bridge{fast, slow, unsupported},
+			holdings:        []Holding{usdc("aave", "ethereum", 1000)},
+			markets:         []*Market{usdcMarket("aave", "ethereum", 3, 1e9), usdcMarket("compound", "base", 8, 1e9)},
+			wantTarget:      map[marketKey]float64{{"compound", "base", "USDC"}: 1000},
+			wantMoves:       1,
+			wantBridge:      "slow",
+			wantRecommended: true,
+		},
+		{
+			name:        "no bridge for the route",
+			gasUSD:      cheapGas,
+			bridges:     []Bridge{unsupported},
+			holdings:    []Holding{usdc("aave", "ethereum", 1000)},
+			markets:     []*Market{usdcMarket("aave", "ethereum", 3, 1e9), usdcMarket("compound", "base", 8, 1e9)},
+			wantTarget:  map[marketKey]float64{{"aave", "ethereum", "USDC"}: 1000},
+			wantReason:  "already at its best allocation",
+			wantWarning: "no bridge moves USDC from ethereum to base",
+		},
+		{
+			name:        "no eligible market with room",
+			gasUSD:      cheapGas,
+			holdings:    []Holding{usdc("aave", "ethereum", 1000)},
+			markets:     []*Market{{Protocol: "aave", Chain: "ethereum", Asset: "USDC", APY: 3, Reason: "utilization 99.0% is above 98.0%"}},
+			wantTarget:  map[marketKey]float64{{"aave", "ethereum", "USDC"}: 1000},
+			wantReason:  "already at its best allocation",
+			wantWarning: "stays in place; no eligible market has room",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptimization(t, optimizeManager(tt.bridges...), tt.limits, tt.gasUSD, tt.holdings, tt.markets)
+			o.allocate()
+			o.planMoves(context.Background())
+			o.summarize()
+			plan := o.plan
+
+			for key, amount := range o.target {
+				if !near(amount, tt.wantTarget[key]) {
+					t.Errorf("target of %v = %g, want %g", key, amount, tt.wantTarget[key])
+				}
+			}
+			for key, amount := range tt.wantTarget {
+				if _, ok := o.target[key]; !ok {
+					t.Errorf("no target for %v, want %g", key, amount)
+				}
+			}
+
+			if len(plan.Moves) != tt.wantMoves {
+				t.Fatalf("%d moves, want %d: %+v", len(plan.Moves), tt.wantMoves, plan.Moves)
+			}
+			if tt.wantBridge != "" && (plan.Moves[0].Bridge == nil || plan.Moves[0].Bridge.Bridge != tt.wantBridge) {
+				t.Errorf("move bridged with %+v, want %s", plan.Moves[0].Bridge, tt.wantBridge)
+			}
+			if plan.Recommended != tt.wantRecommended {
+				t.Errorf("recommended = %v (%s), want %v", plan.Recommended, plan.Reason, tt.wantRecommended)
+			}
+			if !strings.Contains(plan.Reason, tt.wantReason) || (tt.wantReason == "" && plan.Reason != "") {
+				t.Errorf("reason = %q, want %q", plan.Reason, tt.wantReason)
+			}
+			if tt.wantWarning != "" && !strings.Contains(strings.Join(plan.Warnings, "\n"), tt.wantWarning) {
+				t.Errorf("warnings = %q, want %q", plan.Warnings, tt.wantWarning)
+			}
+
+			// Totals agree with the moves and allocations
+			var gain, cost, share float64
+			for _, move := range plan.Moves {
+				gain += move.GainUSD
+				cost += move.CostUSD
+			}
+			for _, a := range plan.Target {
+				share += a.Share
+			}
+			if !near(plan.GainUSD, gain) || !near(plan.CostUSD, cost) || !near(plan.NetUSD, gain-cost) {
+				t.Errorf("plan totals %g - %g = %g, moves %g - %g", plan.GainUSD, plan.CostUSD, plan.NetUSD, gain, cost)
+			}
+			if !near(share, 100) {
+				t.Errorf("target shares add up to %g%%", share)
+			}
+			if want := plan.NetUSD / plan.ValueUSD * 365 / 30 * 10000; !near(plan.ImprovementBps, want) {
+				t.Errorf("improvement = %g bps, want %g", plan.ImprovementBps, want)
+			}
+		})
+	}
+}
+
+func TestPriceMove(t *testing.T) {
+	const gasUSD = 1e-6
+	failing := &stubBridge{name: "broken", err: errors.New("quote unavailable")}
+	bridge := &stubBridge{name: "cctp", quote: BridgeQuote{AmountOut: "1000000000", Fee: "500000", SourceGas: 100000, DestinationGas: 200000, ExpectedSeconds: 1200}}
+
+	aaveEth := marketKey{"aave", "ethereum", "USDC"}
+	compoundEth := marketKey{"compound", "ethereum", "USDC"}
+	compoundBase := marketKey{"compound", "base", "USDC"}
+	moveGas := (250000 + 50000 + 250000) * gasUSD
+
+	tests := []struct {
+		name        string
+		to          marketKey
+		bridges     []Bridge
+		wantGain    float64
+		wantCost    float64
+		wantBridge  string
+		wantErr     bool
+		wantWarning string
+	}{
+		{
+			name:     "same chain",
+			to:       compoundEth,
+			wantGain: 1000 * (5 - 3) / 100.0 * 720 / hoursPerYear,
+			wantCost: moveGas,
+		},
+		{
+			name:    "across chains",
+			to:      compoundBase,
+			bridges: []Bridge{failing, bridge},
+			// Nothing is earned while the funds are in transit
+			wantGain:    1000 * (5*(720-1200.0/3600) - 3*720) / 100 / hoursPerYear,
+			wantCost:    moveGas + 0.5 + 300000*gasUSD,
+			wantBridge:  "cctp",
+			wantWarning: "broken quote for USDC from ethereum to base failed",
+		},
+		{
+			name:        "no bridge quotes",
+			to:          compoundBase,
+			bridges:     []Bridge{failing},
+			wantErr:     true,
+			wantWarning: "quote unavailable",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptimization(t, optimizeManager(tt.bridges...), OptimizeLimits{}, gasUSD,
+				[]Holding{usdc("aave", "ethereum", 1000)},
+				[]*Market{usdcMarket("aave", "ethereum", 3, 1e9), usdcMarket("compound", "ethereum", 5, 1e9), usdcMarket("compound", "base", 5, 1e9)})
+
+			move, err := o.priceMove(context.Background(), aaveEth, tt.to, 1000)
+			if tt.wantWarning != "" && !strings.Contains(strings.Join(o.plan.Warnings, "\n"), tt.wantWarning) {
+				t.Errorf("warnings = %q, want %q", o.plan.Warnings, tt.wantWarning)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("priceMove error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !near(move.GainUSD, tt.wantGain) || !near(move.CostUSD, tt.wantCost) || !near(move.NetUSD, tt.wantGain-tt.wantCost) {
+				t.Errorf("move gain %g cost %g net %g, want %g and %g", move.GainUSD, move.CostUSD, move.NetUSD, tt.wantGain, tt.wantCost)
+			}
+			if tt.wantBridge == "" && move.Bridge != nil || tt.wantBridge != "" && (move.Bridge == nil || move.Bridge.Bridge != tt.wantBridge) {
+				t.Errorf("bridge = %+v, want %q", move.Bridge, tt.wantBridge)
+			}
+		})
+	}
+}
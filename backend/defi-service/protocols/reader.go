@@ -0,0 +1,41 @@
+package protocols
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/defioptimization/defi-service/multicall"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// reader performs contract reads through the per-chain multicall batchers,
+// so concurrent reads from any protocol share one RPC call
+type reader struct {
+	batchers map[string]*multicall.Batcher
+}
+
+// read calls a view method at the context's pinned block and returns its
+// unpacked outputs
+func (r reader) read(ctx context.Context, chain string, contract abi.ABI, to common.Address, method string, args ...interface{}) ([]interface{}, error) {
+	batcher, ok := r.batchers[chain]
+	if !ok {
+		return nil, fmt.Errorf("unsupported chain: %s", chain)
+	}
+
+	input, err := contract.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := batcher.Call(ctx, to, input, BlockFromContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", method, err)
+	}
+
+	values, err := contract.Unpack(method, output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", method, err)
+	}
+	return values, nil
+}
@@ -0,0 +1,117 @@
+package protocols
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/defioptimization/shared/tokens"
+)
+
+const (
+	// DefaultSlippageBps is the swap slippage tolerated when none is given
+	DefaultSlippageBps = 50
+	// MaxSlippageBps is the most slippage a swap may be built with
+	MaxSlippageBps = 300
+	// quoteTTL is how long a quote can be used to build a swap
+	quoteTTL = time.Minute
+	// swapDeadline is how long a built swap stays valid on-chain, leaving the
+	// user time to sign. The minimum output protects the price meanwhile.
+	swapDeadline = 20 * time.Minute
+)
+
+// ErrQuoteExpired is returned when building a swap from a stale quote
+var ErrQuoteExpired = errors.New("quote expired")
+
+// Swapper is implemented by DEXes that can quote and build token swaps
+type Swapper interface {
+	GetName() string
+	// Quote returns the best output for selling amountIn of tokenIn
+	Quote(ctx context.Context, chain string, tokenIn, tokenOut tokens.Token, amountIn *big.Int) (*Quote, error)
+	// BuildSwap returns the calls that perform a quoted swap, reverting if
+	// it returns less than minAmountOut or lands after the deadline
+	BuildSwap(quote *Quote, recipient string, minAmountOut *big.Int, deadline time.Time) ([]Call, error)
+}
+
+// Quote is a DEX's price for a swap at the time it was read
+type Quote struct {
+	Venue       string    `json:"venue"`
+	Chain       string    `json:"chain"`
+	TokenIn     string    `json:"token_in"`   // address
+	TokenOut    string    `json:"token_out"`  // address
+	AmountIn    string    `json:"amount_in"`  // base units
+	AmountOut   string    `json:"amount_out"` // base units
+	Fee         uint32    `json:"fee"`        // pool fee in hundredths of a basis point
+	GasEstimate uint64    `json:"gas_estimate"`
+	QuotedAt    time.Time `json:"quoted_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// SwapPlan is a quote and the calls that perform it
+type SwapPlan struct {
+	Quote        *Quote `json:"quote"`
+	SlippageBps  int    `json:"slippage_bps"`
+	MinAmountOut string `json:"min_amount_out"` // base units
+	Deadline     int64  `json:"deadline"`       // unix seconds
+	Calls        []Call `json:"calls"`
+}
+
+// minAmountOut is the least a quoted swap may return within the slippage
+func minAmountOut(amountOut *big.Int, slippageBps int) *big.Int {
+	min := new(big.Int).Mul(amountOut, big.NewInt(int64(10000-slippageBps)))
+	return min.Div(min, big.NewInt(10000))
+}
+
+// PlanSwap builds a swap of amountIn of tokenIn for tokenOut, paid to
+// recipient. A quote the caller already showed the user is used as long as
+// it hasn't expired and is for the same swap; otherwise a fresh one is read.
+func PlanSwap(ctx context.Context, s Swapper, chain string, tokenIn, tokenOut tokens.Token, amountIn *big.Int, recipient string, slippageBps int, quote *Quote) (*SwapPlan, error) {
+	if slippageBps == 0 {
+		slippageBps = DefaultSlippageBps
+	}
+	if slippageBps < 0 || slippageBps > MaxSlippageBps {
+		return nil, fmt.Errorf("slippage must be between 0 and %d basis points", MaxSlippageBps)
+	}
+	if strings.EqualFold(tokenIn.Address, tokenOut.Address) {
+		return nil, fmt.Errorf("cannot swap %s for itself", tokenIn.Symbol)
+	}
+
+	now := time.Now()
+	if quote != nil {
+		switch {
+		case now.After(quote.ExpiresAt):
+			return nil, ErrQuoteExpired
+		case quote.Venue != s.GetName() || quote.Chain != chain ||
+			!strings.EqualFold(quote.TokenIn, tokenIn.Address) || !strings.EqualFold(quote.TokenOut, tokenOut.Address) ||
+			quote.AmountIn != amountIn.String():
+			return nil, errors.New("quote is for a different swap")
+		}
+	} else {
+		var err error
+		if quote, err = s.Quote(ctx, chain, tokenIn, tokenOut, amountIn); err != nil {
+			return nil, err
+		}
+	}
+
+	amountOut, ok := new(big.Int).SetString(quote.AmountOut, 10)
+	if !ok || amountOut.Sign() <= 0 {
+		return nil, fmt.Errorf("no liquidity to swap %s for %s", tokenIn.Symbol, tokenOut.Symbol)
+	}
+	min := minAmountOut(amountOut, slippageBps)
+	deadline := now.Add(swapDeadline)
+
+	calls, err := s.BuildSwap(quote, recipient, min, deadline)
+	if err != nil {
+		return nil, err
+	}
+	return &SwapPlan{
+		Quote:        quote,
+		SlippageBps:  slippageBps,
+		MinAmountOut: min.String(),
+		Deadline:     deadline.Unix(),
+		Calls:        calls,
+	}, nil
+}
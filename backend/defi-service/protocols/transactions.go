@@ -0,0 +1,68 @@
+package protocols
+
+import (
+	"context"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Call is an unsigned contract call the user's wallet must send
+type Call struct {
+	To          string `json:"to"`
+	Data        string `json:"data"`
+	Value       string `json:"value"`
+	Description string `json:"description"`
+
+	// Requires is the token allowance the call spends, if any. The caller
+	// must make sure it is approved before sending the call.
+	Requires *AllowanceRequirement `json:"requires_allowance,omitempty"`
+}
+
+// AllowanceRequirement is an ERC-20 allowance a call needs from the sender
+type AllowanceRequirement struct {
+	Token   string `json:"token"`
+	Spender string `json:"spender"`
+	Amount  string `json:"amount"` // base units
+}
+
+// TransactionBuilder is implemented by protocols that can build deposit and withdraw calls
+type TransactionBuilder interface {
+	BuildDeposit(ctx context.Context, asset string, amount *big.Int, userAddress string, chain string) ([]Call, error)
+	BuildWithdraw(ctx context.Context, asset string, amount *big.Int, userAddress string, chain string) ([]Call, error)
+}
+
+// newCall packs a contract call
+func newCall(contract abi.ABI, to common.Address, description string, method string, args ...interface{}) (Call, error) {
+	data, err := contract.Pack(method, args...)
+	if err != nil {
+		return Call{}, err
+	}
+	return Call{
+		To:          to.Hex(),
+		Data:        hexutil.Encode(data),
+		Value:       "0",
+		Description: description,
+	}, nil
+}
+
+// requireAllowance marks a call as spending amount of token from the sender
+func requireAllowance(call *Call, token string, amount *big.Int) {
+	call.Requires = &AllowanceRequirement{
+		Token:   common.HexToAddress(token).Hex(),
+		Spender: call.To,
+		Amount:  amount.String(),
+	}
+}
+
+// mustParseABI parses a static ABI definition
+func mustParseABI(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic("invalid ABI definition: " + err.Error())
+	}
+	return parsed
+}
@@ -0,0 +1,203 @@
+package protocols
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/defioptimization/defi-service/multicall"
+	"github.com/defioptimization/shared/tokens"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// UniswapDeployment is where a chain's Uniswap v3 quoter and router live
+type UniswapDeployment struct {
+	Quoter common.Address // QuoterV2
+	Router common.Address // SwapRouter02
+}
+
+// Uniswap v3 deployments (mainnet)
+var uniswapDeployments = map[string]UniswapDeployment{
+	"ethereum": {
+		Quoter: common.HexToAddress("0x61fFE014bA17989E743c5F6cB21bF9697530B21e"),
+		Router: common.HexToAddress("0x68b3465833fb72A70ecDF485E0e4C7bD8665Fc45"),
+	},
+	"base": {
+		Quoter: common.HexToAddress("0x3d4e44Eb1374240CE5F1B871ab261CD16335B76a"),
+		Router: common.HexToAddress("0x2626664c2603336E57B271c5C0b26F421741e481"),
+	},
+}
+
+// uniswapFeeTiers are the pool fees quoted, in hundredths of a basis point
+var uniswapFeeTiers = []uint32{100, 500, 3000, 10000}
+
+const uniswapQuoterABI = `[
+	{"inputs":[{"components":[{"name":"tokenIn","type":"address"},{"name":"tokenOut","type":"address"},{"name":"amountIn","type":"uint256"},{"name":"fee","type":"uint24"},{"name":"sqrtPriceLimitX96","type":"uint160"}],"name":"params","type":"tuple"}],"name":"quoteExactInputSingle","outputs":[{"name":"amountOut","type":"uint256"},{"name":"sqrtPriceX96After","type":"uint160"},{"name":"initializedTicksCrossed","type":"uint32"},{"name":"gasEstimate","type":"uint256"}],"stateMutability":"nonpayable","type":"function"}
+]`
+
+const uniswapRouterABI = `[
+	{"inputs":[{"components":[{"name":"tokenIn","type":"address"},{"name":"tokenOut","type":"address"},{"name":"fee","type":"uint24"},{"name":"recipient","type":"address"},{"name":"amountIn","type":"uint256"},{"name":"amountOutMinimum","type":"uint256"},{"name":"sqrtPriceLimitX96","type":"uint160"}],"name":"params","type":"tuple"}],"name":"exactInputSingle","outputs":[{"name":"amountOut","type":"uint256"}],"stateMutability":"payable","type":"function"},
+	{"inputs":[{"name":"deadline","type":"uint256"},{"name":"data","type":"bytes[]"}],"name":"multicall","outputs":[{"name":"","type":"bytes[]"}],"stateMutability":"payable","type":"function"}
+]`
+
+var (
+	parsedUniswapQuoterABI = mustParseABI(uniswapQuoterABI)
+	parsedUniswapRouterABI = mustParseABI(uniswapRouterABI)
+)
+
+// quoteExactInputSingleParams mirrors QuoterV2's params tuple
+type quoteExactInputSingleParams struct {
+	TokenIn           common.Address
+	TokenOut          common.Address
+	AmountIn          *big.Int
+	Fee               *big.Int
+	SqrtPriceLimitX96 *big.Int
+}
+
+// exactInputSingleParams mirrors SwapRouter02's params tuple
+type exactInputSingleParams struct {
+	TokenIn           common.Address
+	TokenOut          common.Address
+	Fee               *big.Int
+	Recipient         common.Address
+	AmountIn          *big.Int
+	AmountOutMinimum  *big.Int
+	SqrtPriceLimitX96 *big.Int
+}
+
+// UniswapV3 swaps through single Uniswap v3 pools, picking the fee tier
+// that pays the most
+type UniswapV3 struct {
+	reader
+	name        string
+	deployments map[string]UniswapDeployment
+}
+
+// NewUniswapV3 creates a Uniswap v3 swapper. Nil deployments use the
+// mainnet contracts; a simulated chain can pass its own quoter and router.
+func NewUniswapV3(batchers map[string]*multicall.Batcher, deployments map[string]UniswapDeployment) *UniswapV3 {
+	if deployments == nil {
+		deployments = uniswapDeployments
+	}
+	return &UniswapV3{
+		reader:      reader{batchers: batchers},
+		name:        "uniswap_v3",
+		deployments: deployments,
+	}
+}
+
+// GetName returns the swapper name
+func (u *UniswapV3) GetName() string {
+	return u.name
+}
+
+// Quote reads QuoterV2 for every fee tier at once and returns the best
+// output. Tiers without a pool revert and are skipped.
+func (u *UniswapV3) Quote(ctx context.Context, chain string, tokenIn, tokenOut tokens.Token, amountIn *big.Int) (*Quote, error) {
+	deployment, ok := u.deployments[chain]
+	if !ok {
+		return nil, fmt.Errorf("uniswap v3 not available on %s", chain)
+	}
+
+	type tierQuote struct {
+		amountOut *big.Int
+		gas       uint64
+		err       error
+	}
+	quotes := make([]tierQuote, len(uniswapFeeTiers))
+	var wg sync.WaitGroup
+	for i, fee := range uniswapFeeTiers {
+		wg.Add(1)
+		go func(i int, fee uint32) {
+			defer wg.Done()
+			values, err := u.read(ctx, chain, parsedUniswapQuoterABI, deployment.Quoter, "quoteExactInputSingle", quoteExactInputSingleParams{
+				TokenIn:           common.HexToAddress(tokenIn.Address),
+				TokenOut:          common.HexToAddress(tokenOut.Address),
+				AmountIn:          amountIn,
+				Fee:               big.NewInt(int64(fee)),
+				SqrtPriceLimitX96: big.NewInt(0),
+			})
+			if err != nil {
+				quotes[i].err = err
+				return
+			}
+			amountOut, ok1 := values[0].(*big.Int)
+			gas, ok2 := values[3].(*big.Int)
+			if !ok1 || !ok2 {
+				quotes[i].err = fmt.Errorf("unexpected quoteExactInputSingle response")
+				return
+			}
+			quotes[i] = tierQuote{amountOut: amountOut, gas: gas.Uint64()}
+		}(i, fee)
+	}
+	wg.Wait()
+
+	best := -1
+	for i, q := range quotes {
+		if q.err == nil && (best < 0 || q.amountOut.Cmp(quotes[best].amountOut) > 0) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil, fmt.Errorf("no uniswap v3 pool for %s/%s on %s: %v", tokenIn.Symbol, tokenOut.Symbol, chain, quotes[0].err)
+	}
+
+	now := time.Now()
+	return &Quote{
+		Venue:       u.name,
+		Chain:       chain,
+		TokenIn:     tokenIn.Address,
+		TokenOut:    tokenOut.Address,
+		AmountIn:    amountIn.String(),
+		AmountOut:   quotes[best].amountOut.String(),
+		Fee:         uniswapFeeTiers[best],
+		GasEstimate: quotes[best].gas,
+		QuotedAt:    now,
+		ExpiresAt:   now.Add(quoteTTL),
+	}, nil
+}
+
+// BuildSwap builds an exactInputSingle through SwapRouter02, wrapped in the
+// router's deadline-checking multicall
+func (u *UniswapV3) BuildSwap(quote *Quote, recipient string, minAmountOut *big.Int, deadline time.Time) ([]Call, error) {
+	deployment, ok := u.deployments[quote.Chain]
+	if !ok {
+		return nil, fmt.Errorf("uniswap v3 not available on %s", quote.Chain)
+	}
+	amountIn, ok := new(big.Int).SetString(quote.AmountIn, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid quote amount: %s", quote.AmountIn)
+	}
+
+	swap, err := parsedUniswapRouterABI.Pack("exactInputSingle", exactInputSingleParams{
+		TokenIn:           common.HexToAddress(quote.TokenIn),
+		TokenOut:          common.HexToAddress(quote.TokenOut),
+		Fee:               big.NewInt(int64(quote.Fee)),
+		Recipient:         common.HexToAddress(recipient),
+		AmountIn:          amountIn,
+		AmountOutMinimum:  minAmountOut,
+		SqrtPriceLimitX96: big.NewInt(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	symbolIn, symbolOut := quote.TokenIn, quote.TokenOut
+	if t, ok := tokens.LookupAddress(quote.Chain, quote.TokenIn); ok {
+		symbolIn = t.Symbol
+	}
+	if t, ok := tokens.LookupAddress(quote.Chain, quote.TokenOut); ok {
+		symbolOut = t.Symbol
+	}
+
+	call, err := newCall(parsedUniswapRouterABI, deployment.Router,
+		fmt.Sprintf("Swap %s for %s on Uniswap", symbolIn, symbolOut),
+		"multicall", big.NewInt(deadline.Unix()), [][]byte{swap})
+	if err != nil {
+		return nil, err
+	}
+	requireAllowance(&call, quote.TokenIn, amountIn)
+	return []Call{call}, nil
+}
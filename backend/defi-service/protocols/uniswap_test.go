@@ -0,0 +1,499 @@
+package protocols
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/defi-service/multicall"
+	"github.com/defioptimization/shared/tokens"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/asm"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// The mock pools pay 99.5% of the input through the 0.05% tier and 99% through
+// the 0.3% tier; the other tiers have no pool and revert.
+const (
+	// mockQuoter answers quoteExactInputSingle with the pool's output
+	mockQuoter = `
+	PUSH 100
+	CALLDATALOAD ;; fee
+	DUP1
+	PUSH 500
+	EQ
+	JUMPI @fee500
+	PUSH 3000
+	EQ
+	JUMPI @fee3000
+	PUSH 0
+	DUP1
+	REVERT
+fee500:
+	POP
+	PUSH 995
+	JUMP @quote
+fee3000:
+	PUSH 990
+quote:
+	PUSH 68
+	CALLDATALOAD ;; amountIn
+	MUL
+	PUSH 1000
+	SWAP1
+	DIV
+	PUSH 0
+	MSTORE ;; amountOut
+	PUSH 100000
+	PUSH 96
+	MSTORE ;; gasEstimate
+	PUSH 128
+	PUSH 0
+	RETURN
+`
+
+	// mockRouter accepts a multicall(deadline, [exactInputSingle]) the way
+	// SwapRouter02 does: it reverts after the deadline or when the pool pays
+	// less than amountOutMinimum, and otherwise credits the output to the
+	// recipient's storage slot
+	mockRouter = `
+	PUSH 0
+	CALLDATALOAD
+	PUSH 224
+	SHR
+	PUSH %s ;; multicall(uint256,bytes[])
+	EQ
+	ISZERO
+	JUMPI @fail
+	TIMESTAMP
+	PUSH 4
+	CALLDATALOAD ;; deadline
+	LT
+	JUMPI @fail
+	PUSH 36
+	CALLDATALOAD
+	PUSH 4
+	ADD ;; data array
+	DUP1
+	CALLDATALOAD
+	PUSH 1
+	EQ
+	ISZERO
+	JUMPI @fail
+	DUP1
+	PUSH 32
+	ADD
+	DUP1
+	CALLDATALOAD
+	ADD
+	SWAP1
+	POP ;; first element
+	PUSH 32
+	ADD ;; inner call
+	DUP1
+	CALLDATALOAD
+	PUSH 224
+	SHR
+	PUSH %s ;; exactInputSingle
+	EQ
+	ISZERO
+	JUMPI @fail
+	PUSH 4
+	ADD ;; params
+	DUP1
+	PUSH 64
+	ADD
+	CALLDATALOAD ;; fee
+	DUP1
+	PUSH 500
+	EQ
+	JUMPI @rate500
+	PUSH 3000
+	EQ
+	JUMPI @rate3000
+	JUMP @fail
+rate500:
+	POP
+	PUSH 995
+	JUMP @swap
+rate3000:
+	PUSH 990
+swap:
+	DUP2
+	PUSH 128
+	ADD
+	CALLDATALOAD ;; amountIn
+	MUL
+	PUSH 1000
+	SWAP1
+	DIV ;; amountOut
+	DUP2
+	PUSH 160
+	ADD
+	CALLDATALOAD ;; amountOutMinimum
+	DUP2
+	LT
+	JUMPI @fail
+	DUP2
+	PUSH 96
+	ADD
+	CALLDATALOAD ;; recipient
+	DUP1
+	SLOAD
+	DUP3
+	ADD
+	SWAP1
+	SSTORE
+	STOP
+fail:
+	PUSH 0
+	DUP1
+	REVERT
+`
+)
+
+// swapChain is a simulated chain with the mock quoter and router deployed
+type swapChain struct {
+	t       *testing.T
+	sim     *backends.SimulatedBackend
+	key     *ecdsa.PrivateKey
+	from    common.Address
+	uniswap *UniswapV3
+	router  common.Address
+}
+
+func newSwapChain(t *testing.T) *swapChain {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	sim := backends.NewSimulatedBackend(core.GenesisAlloc{
+		from: {Balance: new(big.Int).Lsh(big.NewInt(1), 100)},
+	}, 30_000_000)
+	t.Cleanup(func() { sim.Close() })
+
+	c := &swapChain{t: t, sim: sim, key: key, from: from}
+	quoter := c.deploy(mockQuoter)
+	c.router = c.deploy(fmt.Sprintf(mockRouter,
+		hexutil.Encode(parsedUniswapRouterABI.Methods["multicall"].ID),
+		hexutil.Encode(parsedUniswapRouterABI.Methods["exactInputSingle"].ID)))
+
+	// One call per batch, so reads don't need Multicall3 on the chain
+	batchers := map[string]*multicall.Batcher{"ethereum": multicall.NewBatcher(sim, time.Millisecond, 1)}
+	c.uniswap = NewUniswapV3(batchers, map[string]UniswapDeployment{
+		"ethereum": {Quoter: quoter, Router: c.router},
+	})
+	return c
+}
+
+// deploy assembles a contract's runtime code and deploys it
+func (c *swapChain) deploy(source string) common.Address {
+	c.t.Helper()
+	compiler := asm.NewCompiler(false)
+	compiler.Feed(asm.Lex([]byte(source), false))
+	code, errs := compiler.Compile()
+	if len(errs) > 0 {
+		c.t.Fatalf("assembling mock: %v", errs)
+	}
+	runtime := common.FromHex(code)
+
+	// CODECOPY the runtime code that follows this 12-byte prefix and return it
+	initCode := append([]byte{
+		0x61, byte(len(runtime) >> 8), byte(len(runtime)),
+		0x80, 0x60, 0x0c, 0x60, 0x00, 0x39, 0x60, 0x00, 0xf3,
+	}, runtime...)
+	receipt := c.send(nil, initCode)
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		c.t.Fatal("mock deployment failed")
+	}
+	return receipt.ContractAddress
+}
+
+// send mines a transaction and returns its receipt
+func (c *swapChain) send(to *common.Address, data []byte) *types.Receipt {
+	c.t.Helper()
+	ctx := context.Background()
+	nonce, err := c.sim.PendingNonceAt(ctx, c.from)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	gasPrice, err := c.sim.SuggestGasPrice(ctx)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	tx, err := types.SignNewTx(c.key, types.LatestSignerForChainID(big.NewInt(1337)), &types.LegacyTx{
+		Nonce:    nonce,
+		To:       to,
+		Gas:      1_000_000,
+		GasPrice: gasPrice,
+		Data:     data,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.sim.SendTransaction(ctx, tx); err != nil {
+		c.t.Fatal(err)
+	}
+	c.sim.Commit()
+	receipt, err := c.sim.TransactionReceipt(ctx, tx.Hash())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return receipt
+}
+
+// execute sends a swap plan's calls and reports whether they all succeeded
+func (c *swapChain) execute(plan *SwapPlan) bool {
+	c.t.Helper()
+	for _, call := range plan.Calls {
+		to := common.HexToAddress(call.To)
+		if c.send(&to, common.FromHex(call.Data)).Status != types.ReceiptStatusSuccessful {
+			return false
+		}
+	}
+	return true
+}
+
+// credited returns what the mock router has paid out to an account
+func (c *swapChain) credited(account common.Address) *big.Int {
+	c.t.Helper()
+	value, err := c.sim.StorageAt(context.Background(), c.router, common.BytesToHash(account.Bytes()), nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return new(big.Int).SetBytes(value)
+}
+
+func swapTokens(t *testing.T) (tokens.Token, tokens.Token) {
+	t.Helper()
+	usdc, ok1 := tokens.Lookup("ethereum", "USDC")
+	usdt, ok2 := tokens.Lookup("ethereum", "USDT")
+	if !ok1 || !ok2 {
+		t.Fatal("USDC or USDT missing from the registry")
+	}
+	return usdc, usdt
+}
+
+func TestUniswapQuotePicksBestTier(t *testing.T) {
+	c := newSwapChain(t)
+	usdc, usdt := swapTokens(t)
+
+	quote, err := c.uniswap.Quote(context.Background(), "ethereum", usdc, usdt, big.NewInt(1_000_000_000))
+	if err != nil {
+		t.Fatalf("Quote: %v", err)
+	}
+	if quote.Fee != 500 || quote.AmountOut != "995000000" || quote.GasEstimate != 100000 {
+		t.Fatalf("quote = %+v, want the 0.05%% tier paying 995000000", quote)
+	}
+	if quote.Venue != "uniswap_v3" || quote.AmountIn != "1000000000" || !quote.ExpiresAt.After(quote.QuotedAt) {
+		t.Fatalf("quote = %+v", quote)
+	}
+
+	if _, err := c.uniswap.Quote(context.Background(), "base", usdc, usdt, big.NewInt(1)); err == nil {
+		t.Fatal("quoted on a chain without a deployment")
+	}
+}
+
+func TestPlanSwapEncodesMinimumOutput(t *testing.T) {
+	c := newSwapChain(t)
+	usdc, usdt := swapTokens(t)
+	recipient := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	amountIn := big.NewInt(1_000_000_000)
+
+	plan, err := PlanSwap(context.Background(), c.uniswap, "ethereum", usdc, usdt, amountIn, recipient.Hex(), 50, nil)
+	if err != nil {
+		t.Fatalf("PlanSwap: %v", err)
+	}
+	// 995000000 less 0.5%
+	if plan.MinAmountOut != "990025000" || plan.SlippageBps != 50 || len(plan.Calls) != 1 {
+		t.Fatalf("plan = %+v", plan)
+	}
+
+	call := plan.Calls[0]
+	if !strings.EqualFold(call.To, c.router.Hex()) {
+		t.Fatalf("swap sent to %s, want the router %s", call.To, c.router)
+	}
+	if call.Requires == nil || !strings.EqualFold(call.Requires.Token, usdc.Address) ||
+		!strings.EqualFold(call.Requires.Spender, c.router.Hex()) || call.Requires.Amount != amountIn.String() {
+		t.Fatalf("allowance requirement = %+v", call.Requires)
+	}
+
+	// Decode the router call the way SwapRouter02 would
+	data := common.FromHex(call.Data)
+	args, err := parsedUniswapRouterABI.Methods["multicall"].Inputs.Unpack(data[4:])
+	if err != nil {
+		t.Fatalf("decoding multicall: %v", err)
+	}
+	if deadline := args[0].(*big.Int).Int64(); deadline != plan.Deadline {
+		t.Fatalf("deadline = %d, want %d", deadline, plan.Deadline)
+	}
+	inner := args[1].([][]byte)
+	if len(inner) != 1 {
+		t.Fatalf("multicall wraps %d calls, want 1", len(inner))
+	}
+	params, err := parsedUniswapRouterABI.Methods["exactInputSingle"].Inputs.Unpack(inner[0][4:])
+	if err != nil {
+		t.Fatalf("decoding exactInputSingle: %v", err)
+	}
+	p := *abi.ConvertType(params[0], new(exactInputSingleParams)).(*exactInputSingleParams)
+	if p.TokenIn != common.HexToAddress(usdc.Address) || p.TokenOut != common.HexToAddress(usdt.Address) ||
+		p.Fee.Int64() != 500 || p.Recipient != recipient || p.AmountIn.Cmp(amountIn) != 0 ||
+		p.AmountOutMinimum.String() != "990025000" || p.SqrtPriceLimitX96.Sign() != 0 {
+		t.Fatalf("exactInputSingle params = %+v", p)
+	}
+
+	// The router accepts it and pays the pool's output
+	if !c.execute(plan) {
+		t.Fatal("swap reverted")
+	}
+	if got := c.credited(recipient); got.String() != "995000000" {
+		t.Fatalf("recipient received %s, want 995000000", got)
+	}
+}
+
+func TestSwapSlippageProtection(t *testing.T) {
+	usdc, usdt := swapTokens(t)
+	recipient := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	amountIn := big.NewInt(1_000_000_000)
+
+	tests := []struct {
+		name        string
+		slippageBps int
+		// quotedOut is what the quote shown to the user promised; the pool
+		// now pays 995000000
+		quotedOut string
+		minOut    string
+		succeeds  bool
+	}{
+		{"price unchanged", 50, "995000000", "990025000", true},
+		{"price moved within tolerance", 200, "1010000000", "989800000", true},
+		{"price moved beyond tolerance", 50, "1010000000", "1004950000", false},
+		{"zero tolerance", 1, "1000000000", "999900000", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newSwapChain(t)
+			quote, err := c.uniswap.Quote(context.Background(), "ethereum", usdc, usdt, amountIn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			quote.AmountOut = tt.quotedOut
+
+			plan, err := PlanSwap(context.Background(), c.uniswap, "ethereum", usdc, usdt, amountIn, recipient.Hex(), tt.slippageBps, quote)
+			if err != nil {
+				t.Fatalf("PlanSwap: %v", err)
+			}
+			if plan.MinAmountOut != tt.minOut {
+				t.Fatalf("min amount out = %s, want %s", plan.MinAmountOut, tt.minOut)
+			}
+			if got := c.execute(plan); got != tt.succeeds {
+				t.Fatalf("swap succeeded = %v, want %v", got, tt.succeeds)
+			}
+			want := "0"
+			if tt.succeeds {
+				want = "995000000"
+			}
+			if got := c.credited(recipient); got.String() != want {
+				t.Fatalf("recipient received %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestSwapDeadline(t *testing.T) {
+	c := newSwapChain(t)
+	usdc, usdt := swapTokens(t)
+	recipient := common.HexToAddress("0x00000000000000000000000000000000000000cc")
+
+	plan, err := PlanSwap(context.Background(), c.uniswap, "ethereum", usdc, usdt, big.NewInt(1_000_000), recipient.Hex(), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.SlippageBps != DefaultSlippageBps {
+		t.Fatalf("slippage = %d, want the default %d", plan.SlippageBps, DefaultSlippageBps)
+	}
+
+	head, err := c.sim.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Deadline <= int64(head.Time) {
+		t.Fatalf("deadline %d is not after the chain's time %d", plan.Deadline, head.Time)
+	}
+
+	// Signed with a deadline the chain has already passed, the router
+	// refuses the swap. The simulated chain won't run far enough ahead of
+	// the wall clock to reach the plan's own deadline.
+	minOut, _ := new(big.Int).SetString(plan.MinAmountOut, 10)
+	calls, err := c.uniswap.BuildSwap(plan.Quote, recipient.Hex(), minOut, time.Unix(int64(head.Time)-1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	late := *plan
+	late.Calls = calls
+	if c.execute(&late) {
+		t.Fatal("swap past its deadline succeeded")
+	}
+	if c.credited(recipient).Sign() != 0 {
+		t.Fatal("late swap paid out")
+	}
+	if !c.execute(plan) {
+		t.Fatal("swap within its deadline failed")
+	}
+}
+
+func TestPlanSwapRejects(t *testing.T) {
+	c := newSwapChain(t)
+	usdc, usdt := swapTokens(t)
+	amountIn := big.NewInt(1_000_000)
+	recipient := "0x00000000000000000000000000000000000000dd"
+
+	quote, err := c.uniswap.Quote(context.Background(), "ethereum", usdc, usdt, amountIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := *quote
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	otherAmount := *quote
+	otherAmount.AmountIn = "2000000"
+	noLiquidity := *quote
+	noLiquidity.AmountOut = "0"
+
+	tests := []struct {
+		name        string
+		tokenOut    tokens.Token
+		slippageBps int
+		quote       *Quote
+		want        error
+	}{
+		{"expired quote", usdt, 50, &expired, ErrQuoteExpired},
+		{"quote for another amount", usdt, 50, &otherAmount, nil},
+		{"quote without liquidity", usdt, 50, &noLiquidity, nil},
+		{"slippage too high", usdt, MaxSlippageBps + 1, nil, nil},
+		{"negative slippage", usdt, -1, nil, nil},
+		{"same token", usdc, 50, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := PlanSwap(context.Background(), c.uniswap, "ethereum", usdc, tt.tokenOut, amountIn, recipient, tt.slippageBps, tt.quote)
+			if err == nil {
+				t.Fatal("PlanSwap accepted the swap")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("PlanSwap error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
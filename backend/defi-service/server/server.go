@@ -1,11 +1,17 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
 	"net/http"
 	"strconv"
 
 	"github.com/defioptimization/defi-service/protocols"
+	"github.com/defioptimization/shared/tokens"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/gin-gonic/gin"
 )
 
@@ -33,9 +39,20 @@ func (s *Server) setupRoutes() {
 		api.GET("/health", s.healthCheck)
 		api.GET("/protocols", s.getProtocols)
 		api.GET("/protocols/:name/apy", s.getAPY)
+		api.GET("/protocols/:name/apys", s.sweepAPY)
 		api.GET("/protocols/:name/positions", s.getUserPositions)
 		api.GET("/protocols/:name/health-factor", s.getHealthFactor)
 		api.GET("/protocols/:name/price", s.getAssetPrice)
+		api.GET("/protocols/:name/utilization", s.getUtilization)
+		api.POST("/protocols/:name/transactions", s.buildTransactions)
+		api.POST("/protocols/:name/deleverage", s.planDeleverage)
+		api.POST("/swaps/quote", s.quoteSwap)
+		api.POST("/swaps/transactions", s.buildSwap)
+		api.POST("/bridges/quote", s.quoteBridges)
+		api.POST("/bridges/:name/transactions", s.buildBridgeTransfer)
+		api.GET("/bridges/:name/status", s.getBridgeTransferStatus)
+		api.POST("/optimize", s.optimize)
+		api.GET("/portfolio", s.getPortfolio)
 	}
 }
 
@@ -52,18 +69,10 @@ func (s *Server) healthCheck(c *gin.Context) {
 	})
 }
 
-// getProtocols returns all available protocols
+// getProtocols returns all available protocols with their chains and
+// capabilities
 func (s *Server) getProtocols(c *gin.Context) {
-	protocolList := s.protocolManager.GetAllProtocols()
-	protocols := make([]gin.H, len(protocolList))
-	
-	for i, p := range protocolList {
-		protocols[i] = gin.H{
-			"name": p.GetName(),
-		}
-	}
-	
-	c.JSON(http.StatusOK, protocols)
+	c.JSON(http.StatusOK, s.protocolManager.DescribeProtocols())
 }
 
 // getAPY returns the APY for a specific protocol and asset
@@ -83,17 +92,47 @@ func (s *Server) getAPY(c *gin.Context) {
 		return
 	}
 	
-	apy, err := protocol.GetAPY(c.Request.Context(), asset, chain)
+	ctx, block, ok := s.pinBlock(c, chain)
+	if !ok {
+		return
+	}
+
+	apy, err := protocol.GetAPY(ctx, asset, chain)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	
 	c.JSON(http.StatusOK, gin.H{
-		"protocol": protocolName,
-		"asset":    asset,
-		"chain":    chain,
-		"apy":      apy,
+		"protocol":     protocolName,
+		"asset":        asset,
+		"chain":        chain,
+		"apy":          apy,
+		"block_number": block,
+	})
+}
+
+// sweepAPY returns the APY of every supported asset of a protocol on a chain
+func (s *Server) sweepAPY(c *gin.Context) {
+	protocolName := c.Param("name")
+	chain := c.DefaultQuery("chain", "ethereum")
+
+	protocol, ok := s.protocolManager.GetProtocol(protocolName)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Protocol not found"})
+		return
+	}
+
+	ctx, block, ok := s.pinBlock(c, chain)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"protocol":     protocolName,
+		"chain":        chain,
+		"apys":         s.protocolManager.SweepAPY(ctx, protocol, chain),
+		"block_number": block,
 	})
 }
 
@@ -114,13 +153,24 @@ func (s *Server) getUserPositions(c *gin.Context) {
 		return
 	}
 	
-	positions, err := protocol.GetUserPositions(c.Request.Context(), userAddress, chain)
+	ctx, block, ok := s.pinBlock(c, chain)
+	if !ok {
+		return
+	}
+
+	positions, err := protocol.GetUserPositions(ctx, userAddress, chain)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	
-	c.JSON(http.StatusOK, positions)
+	c.JSON(http.StatusOK, gin.H{
+		"protocol":     protocolName,
+		"user_address": userAddress,
+		"chain":        chain,
+		"positions":    positions,
+		"block_number": block,
+	})
 }
 
 // getHealthFactor returns the health factor for a user
@@ -140,17 +190,23 @@ func (s *Server) getHealthFactor(c *gin.Context) {
 		return
 	}
 	
-	healthFactor, err := protocol.GetHealthFactor(c.Request.Context(), userAddress, chain)
+	ctx, block, ok := s.pinBlock(c, chain)
+	if !ok {
+		return
+	}
+
+	healthFactor, err := protocol.GetHealthFactor(ctx, userAddress, chain)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	
 	c.JSON(http.StatusOK, gin.H{
-		"protocol":     protocolName,
-		"user_address": userAddress,
-		"chain":        chain,
+		"protocol":      protocolName,
+		"user_address":  userAddress,
+		"chain":         chain,
 		"health_factor": healthFactor,
+		"block_number":  block,
 	})
 }
 
@@ -171,18 +227,564 @@ func (s *Server) getAssetPrice(c *gin.Context) {
 		return
 	}
 	
-	price, err := protocol.GetAssetPrice(c.Request.Context(), asset, chain)
+	ctx, block, ok := s.pinBlock(c, chain)
+	if !ok {
+		return
+	}
+
+	price, err := protocol.GetAssetPrice(ctx, asset, chain)
+	if errors.Is(err, protocols.ErrNoPriceFeed) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	
+	c.JSON(http.StatusOK, gin.H{
+		"protocol":     protocolName,
+		"asset":        asset,
+		"chain":        chain,
+		"price":        price,
+		"block_number": block,
+	})
+}
+
+// getUtilization returns the borrowed share of a reserve's liquidity
+func (s *Server) getUtilization(c *gin.Context) {
+	protocolName := c.Param("name")
+	asset := c.Query("asset")
+	chain := c.DefaultQuery("chain", "ethereum")
+
+	if asset == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "asset parameter is required"})
+		return
+	}
+
+	protocol, ok := s.protocolManager.GetProtocol(protocolName)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Protocol not found"})
+		return
+	}
+
+	reader, ok := protocol.(protocols.UtilizationReader)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Protocol does not report utilization"})
+		return
+	}
+
+	ctx, block, ok := s.pinBlock(c, chain)
+	if !ok {
+		return
+	}
+
+	utilization, err := reader.GetUtilization(ctx, asset, chain)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"protocol":     protocolName,
+		"asset":        asset,
+		"chain":        chain,
+		"utilization":  utilization,
+		"block_number": block,
+	})
+}
+
+// buildTransactions returns the contract calls for a deposit or withdrawal
+func (s *Server) buildTransactions(c *gin.Context) {
+	protocolName := c.Param("name")
+
+	var req struct {
+		Action      string  `json:"action" binding:"required"` // deposit, withdraw
+		Asset       string  `json:"asset" binding:"required"`
+		Amount      float64 `json:"amount" binding:"required"`
+		Chain       string  `json:"chain"`
+		UserAddress string  `json:"user_address" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Chain == "" {
+		req.Chain = "ethereum"
+	}
+
+	protocol, ok := s.protocolManager.GetProtocol(protocolName)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Protocol not found"})
+		return
+	}
+
+	builder, ok := protocol.(protocols.TransactionBuilder)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Protocol does not support transactions"})
+		return
+	}
+
+	token, ok := tokens.Lookup(req.Chain, req.Asset)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Asset not supported on chain"})
+		return
+	}
+	amount := tokens.ToBaseUnits(req.Amount, token.Decimals)
+
+	ctx, block, ok := s.pinBlock(c, req.Chain)
+	if !ok {
+		return
+	}
+
+	var calls []protocols.Call
+	var err error
+	switch req.Action {
+	case "deposit":
+		calls, err = builder.BuildDeposit(ctx, req.Asset, amount, req.UserAddress, req.Chain)
+	case "withdraw":
+		calls, err = builder.BuildWithdraw(ctx, req.Asset, amount, req.UserAddress, req.Chain)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action must be deposit or withdraw"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"protocol": protocolName,
-		"asset":    asset,
-		"chain":    chain,
-		"price":    price,
+		"chain":    req.Chain,
+		"asset":    token.Symbol,
+		"token":    token.Address,
+		"amount":       amount.String(),
+		"calls":        calls,
+		"block_number": block,
+	})
+}
+
+// planDeleverage returns the debt repayment that restores a borrower's
+// health factor to a target, and its contract calls
+func (s *Server) planDeleverage(c *gin.Context) {
+	protocolName := c.Param("name")
+
+	var req struct {
+		DebtAsset          string  `json:"debt_asset" binding:"required"`
+		TargetHealthFactor float64 `json:"target_health_factor" binding:"required"`
+		Source             string  `json:"source"` // wallet, collateral
+		MaxAmount          float64 `json:"max_amount"`
+		Chain              string  `json:"chain"`
+		UserAddress        string  `json:"user_address" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Chain == "" {
+		req.Chain = "ethereum"
+	}
+	if req.Source == "" {
+		req.Source = protocols.RepayFromWallet
+	}
+
+	protocol, ok := s.protocolManager.GetProtocol(protocolName)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Protocol not found"})
+		return
+	}
+
+	deleverager, ok := protocol.(protocols.Deleverager)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Protocol does not support deleveraging"})
+		return
+	}
+
+	ctx, block, ok := s.pinBlock(c, req.Chain)
+	if !ok {
+		return
+	}
+
+	plan, err := deleverager.PlanDeleverage(ctx, protocols.DeleverageRequest{
+		DebtAsset:   req.DebtAsset,
+		Target:      req.TargetHealthFactor,
+		Source:      req.Source,
+		MaxAmount:   req.MaxAmount,
+		UserAddress: req.UserAddress,
+		Chain:       req.Chain,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"protocol":     protocolName,
+		"plan":         plan,
+		"block_number": block,
+	})
+}
+
+// swapRequest is the body of the swap endpoints
+type swapRequest struct {
+	Venue    string  `json:"venue"`
+	TokenIn  string  `json:"token_in" binding:"required"`  // symbol
+	TokenOut string  `json:"token_out" binding:"required"` // symbol
+	Amount   float64 `json:"amount" binding:"required"`    // of token_in, in token units
+	Chain    string  `json:"chain"`
+}
+
+// swapTokens resolves a swap request's venue and tokens. It responds with an
+// error and returns false if any of them is unknown.
+func (s *Server) swapTokens(c *gin.Context, req *swapRequest) (protocols.Swapper, tokens.Token, tokens.Token, bool) {
+	if req.Chain == "" {
+		req.Chain = "ethereum"
+	}
+	if req.Venue == "" {
+		req.Venue = "uniswap_v3"
+	}
+
+	swapper, ok := s.protocolManager.GetSwapper(req.Venue)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Swap venue not found"})
+		return nil, tokens.Token{}, tokens.Token{}, false
+	}
+	tokenIn, ok1 := tokens.Lookup(req.Chain, req.TokenIn)
+	tokenOut, ok2 := tokens.Lookup(req.Chain, req.TokenOut)
+	if !ok1 || !ok2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Asset not supported on chain"})
+		return nil, tokens.Token{}, tokens.Token{}, false
+	}
+	if req.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return nil, tokens.Token{}, tokens.Token{}, false
+	}
+	return swapper, tokenIn, tokenOut, true
+}
+
+// quoteSwap returns the best price a venue offers for a swap. The quote can
+// be passed back to buildSwap until it expires.
+func (s *Server) quoteSwap(c *gin.Context) {
+	var req swapRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	swapper, tokenIn, tokenOut, ok := s.swapTokens(c, &req)
+	if !ok {
+		return
+	}
+
+	ctx, block, ok := s.pinBlock(c, req.Chain)
+	if !ok {
+		return
+	}
+
+	amountIn := tokens.ToBaseUnits(req.Amount, tokenIn.Decimals)
+	quote, err := swapper.Quote(ctx, req.Chain, tokenIn, tokenOut, amountIn)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	amountOut, _ := new(big.Int).SetString(quote.AmountOut, 10)
+
+	c.JSON(http.StatusOK, gin.H{
+		"quote":        quote,
+		"amount_out":   tokens.FromBaseUnits(amountOut, tokenOut.Decimals),
+		"block_number": block,
+	})
+}
+
+// buildSwap returns the calls for a swap with a minimum output within the
+// slippage tolerance. A quote from quoteSwap is honoured until it expires;
+// without one the swap is quoted afresh.
+func (s *Server) buildSwap(c *gin.Context) {
+	var req struct {
+		swapRequest
+		UserAddress string           `json:"user_address" binding:"required"`
+		SlippageBps int              `json:"slippage_bps"`
+		Quote       *protocols.Quote `json:"quote"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	swapper, tokenIn, tokenOut, ok := s.swapTokens(c, &req.swapRequest)
+	if !ok {
+		return
+	}
+
+	ctx, block, ok := s.pinBlock(c, req.Chain)
+	if !ok {
+		return
+	}
+
+	amountIn := tokens.ToBaseUnits(req.Amount, tokenIn.Decimals)
+	plan, err := protocols.PlanSwap(ctx, swapper, req.Chain, tokenIn, tokenOut, amountIn, req.UserAddress, req.SlippageBps, req.Quote)
+	if errors.Is(err, protocols.ErrQuoteExpired) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	minOut, _ := new(big.Int).SetString(plan.MinAmountOut, 10)
+	amountOut, _ := new(big.Int).SetString(plan.Quote.AmountOut, 10)
+
+	c.JSON(http.StatusOK, gin.H{
+		"chain":          req.Chain,
+		"token_in":       tokenIn.Address,
+		"token_out":      tokenOut.Address,
+		"amount_in":      req.Amount,
+		"amount_out":     tokens.FromBaseUnits(amountOut, tokenOut.Decimals),
+		"min_amount_out": floorTokenUnits(minOut, tokenOut.Decimals),
+		"plan":           plan,
+		"block_number":   block,
+	})
+}
+
+// bridgeRequest is the body of the bridge endpoints
+type bridgeRequest struct {
+	Asset     string  `json:"asset" binding:"required"`
+	Amount    float64 `json:"amount" binding:"required"` // in token units
+	FromChain string  `json:"from_chain" binding:"required"`
+	ToChain   string  `json:"to_chain" binding:"required"`
+}
+
+// request converts the body to a bridge request. It responds with an error
+// and returns false if the asset is unknown on either chain.
+func (r bridgeRequest) request(c *gin.Context) (protocols.BridgeRequest, tokens.Token, bool) {
+	token, ok1 := tokens.Lookup(r.FromChain, r.Asset)
+	_, ok2 := tokens.Lookup(r.ToChain, r.Asset)
+	if !ok1 || !ok2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Asset not supported on both chains"})
+		return protocols.BridgeRequest{}, tokens.Token{}, false
+	}
+	if r.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return protocols.BridgeRequest{}, tokens.Token{}, false
+	}
+	return protocols.BridgeRequest{
+		Asset:     token.Symbol,
+		Amount:    tokens.ToBaseUnits(r.Amount, token.Decimals),
+		FromChain: r.FromChain,
+		ToChain:   r.ToChain,
+	}, token, true
+}
+
+// quoteBridges returns the fee and expected duration of a transfer on every
+// bridge that supports the route
+func (s *Server) quoteBridges(c *gin.Context) {
+	var body bridgeRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req, _, ok := body.request(c)
+	if !ok {
+		return
+	}
+
+	quotes := []*protocols.BridgeQuote{}
+	for _, bridge := range s.protocolManager.GetAllBridges() {
+		quote, err := bridge.Quote(c.Request.Context(), req)
+		if errors.Is(err, protocols.ErrRouteNotSupported) {
+			continue
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		quotes = append(quotes, quote)
+	}
+	if len(quotes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No bridge supports this route"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"quotes": quotes})
+}
+
+// buildBridgeTransfer returns the source chain calls that start a transfer
+func (s *Server) buildBridgeTransfer(c *gin.Context) {
+	var body struct {
+		bridgeRequest
+		UserAddress string `json:"user_address" binding:"required"`
+		Recipient   string `json:"recipient"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	bridge, ok := s.protocolManager.GetBridge(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bridge not found"})
+		return
+	}
+	req, token, ok := body.request(c)
+	if !ok {
+		return
+	}
+	req.Sender, req.Recipient = body.UserAddress, body.Recipient
+
+	quote, err := bridge.Quote(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	calls, err := bridge.BuildTransfer(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"bridge": bridge.GetName(),
+		"quote":  quote,
+		"token":  token.Address,
+		"amount": req.Amount.String(),
+		"calls":  calls,
+	})
+}
+
+// getBridgeTransferStatus follows a transfer by its source chain transaction
+func (s *Server) getBridgeTransferStatus(c *gin.Context) {
+	fromChain, toChain, txHash := c.Query("from_chain"), c.Query("to_chain"), c.Query("tx_hash")
+	if fromChain == "" || toChain == "" || txHash == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from_chain, to_chain and tx_hash parameters are required"})
+		return
+	}
+
+	bridge, ok := s.protocolManager.GetBridge(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bridge not found"})
+		return
+	}
+
+	status, err := bridge.TransferStatus(c.Request.Context(), fromChain, toChain, common.HexToHash(txHash))
+	if errors.Is(err, protocols.ErrRouteNotSupported) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"bridge":  bridge.GetName(),
+		"tx_hash": txHash,
+		"status":  status,
+	})
+}
+
+// optimize proposes the allocation of a portfolio's holdings that earns the
+// most net yield within its risk limits, and the calls that reach it
+func (s *Server) optimize(c *gin.Context) {
+	var req struct {
+		UserAddress string                   `json:"user_address" binding:"required"`
+		Holdings    []protocols.Holding      `json:"holdings" binding:"required"`
+		Risk        string                   `json:"risk"`
+		Limits      protocols.OptimizeLimits `json:"limits"`
+		Protocols   []string                 `json:"protocols"`
+		Chains      []string                 `json:"chains"`
+		HorizonDays float64                  `json:"horizon_days"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, err := s.protocolManager.Optimize(c.Request.Context(), protocols.OptimizeRequest{
+		UserAddress: req.UserAddress,
+		Holdings:    req.Holdings,
+		Risk:        req.Risk,
+		Limits:      req.Limits,
+		Protocols:   req.Protocols,
+		Chains:      req.Chains,
+		HorizonDays: req.HorizonDays,
 	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"plan": plan})
+}
+
+// floorTokenUnits converts base units to token units without rounding up, so
+// a caller spending the result never asks for more than the base amount
+func floorTokenUnits(amount *big.Int, decimals int) float64 {
+	f := tokens.FromBaseUnits(amount, decimals)
+	for f > 0 && tokens.ToBaseUnits(f, decimals).Cmp(amount) > 0 {
+		f = math.Nextafter(f, 0)
+	}
+	return f
+}
+
+// getPortfolio evaluates a user's positions and health factors across every
+// protocol on a chain, with all reads pinned to the same block
+func (s *Server) getPortfolio(c *gin.Context) {
+	userAddress := c.Query("user_address")
+	chain := c.DefaultQuery("chain", "ethereum")
+
+	if userAddress == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_address parameter is required"})
+		return
+	}
+
+	ctx, block, ok := s.pinBlock(c, chain)
+	if !ok {
+		return
+	}
+
+	protocolList := s.protocolManager.GetAllProtocols()
+	results := make([]gin.H, 0, len(protocolList))
+	for _, protocol := range protocolList {
+		entry := gin.H{"protocol": protocol.GetName()}
+
+		positions, err := protocol.GetUserPositions(ctx, userAddress, chain)
+		if err != nil {
+			entry["error"] = err.Error()
+			results = append(results, entry)
+			continue
+		}
+		entry["positions"] = positions
+
+		if len(positions) > 0 {
+			healthFactor, err := protocol.GetHealthFactor(ctx, userAddress, chain)
+			if err != nil {
+				entry["error"] = err.Error()
+			} else {
+				entry["health_factor"] = healthFactor
+			}
+		}
+		results = append(results, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_address": userAddress,
+		"chain":        chain,
+		"protocols":    results,
+		"block_number": block,
+	})
+}
+
+// pinBlock resolves the optional block query parameter (number, hash or
+// "latest") and returns a context that pins protocol reads to that block.
+// It responds with an error and returns false if the block is invalid.
+func (s *Server) pinBlock(c *gin.Context, chain string) (context.Context, uint64, bool) {
+	block, err := s.protocolManager.ResolveBlock(c.Request.Context(), chain, c.Query("block"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, 0, false
+	}
+	return protocols.WithBlock(c.Request.Context(), block), block.Uint64(), true
 }
 
 // Helper function (unused but available)
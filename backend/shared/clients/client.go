@@ -0,0 +1,270 @@
+// Package clients provides typed HTTP clients for the internal DeFi, wallet
+// and ML services, shared by the services that call them.
+package clients
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	defaultRetries = 2
+	// retryBackoff is the wait before the first retry; it doubles with
+	// every further attempt
+	retryBackoff = 250 * time.Millisecond
+)
+
+// ErrUnavailable is wrapped by the errors of requests that got no response
+// from the service
+var ErrUnavailable = errors.New("unavailable")
+
+// Error is a service's error response
+type Error struct {
+	Service    string
+	Method     string
+	Path       string
+	StatusCode int
+	// Message is the service's error message, if it sent one
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s service: status %d: %s", e.Service, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s service: status %d", e.Service, e.StatusCode)
+}
+
+// StatusCode returns the status of a service's error response, or 0 if err
+// is not one
+func StatusCode(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound reports whether err is a service's 404 response
+func IsNotFound(err error) bool {
+	return StatusCode(err) == http.StatusNotFound
+}
+
+// Option configures a client
+type Option func(*client)
+
+// WithTimeout sets how long each attempt of a request may take
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *client) {
+		c.http.Timeout = timeout
+	}
+}
+
+// WithRetries sets how many times an idempotent request is retried after
+// the service could not be reached or was temporarily unavailable
+func WithRetries(retries int) Option {
+	return func(c *client) {
+		c.retries = retries
+	}
+}
+
+// WithInternalToken authenticates requests as another internal service with
+// the shared X-Internal-Token
+func WithInternalToken(token string) Option {
+	return func(c *client) {
+		c.token = token
+	}
+}
+
+// client sends JSON requests to one service
+type client struct {
+	service string
+	baseURL string
+	http    *http.Client
+	retries int
+	token   string
+}
+
+func newClient(service, baseURL string, opts []Option) client {
+	c := client{
+		service: service,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: defaultTimeout},
+		retries: defaultRetries,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// request is one call to a service
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+	// idempotent requests are retried after transport failures and 502,
+	// 503 and 504 responses
+	idempotent bool
+	// cached requests share responses within a WithReadCache context
+	cached bool
+}
+
+// do sends a request and decodes its JSON response into out
+func (c *client) do(ctx context.Context, r request, out interface{}) error {
+	endpoint := c.baseURL + r.path
+	if len(r.query) > 0 {
+		endpoint += "?" + r.query.Encode()
+	}
+
+	var body []byte
+	if r.body != nil {
+		var err error
+		if body, err = json.Marshal(r.body); err != nil {
+			return err
+		}
+	}
+
+	var cache *readCache
+	key := r.method + " " + endpoint + " " + string(body)
+	if r.cached {
+		cache, _ = ctx.Value(readCacheKey{}).(*readCache)
+		if raw, ok := cache.get(key); ok {
+			return json.Unmarshal(raw, out)
+		}
+	}
+
+	attempts := 1
+	if r.idempotent {
+		attempts += c.retries
+	}
+
+	var raw []byte
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(retryBackoff << (attempt - 1)):
+			}
+		}
+		raw, err = c.send(ctx, r.method, endpoint, r.path, body)
+		if !retryable(ctx, err) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	cache.put(key, raw)
+	return json.Unmarshal(raw, out)
+}
+
+// send makes one attempt of a request and returns the response body
+func (c *client) send(ctx context.Context, method, endpoint, path string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("X-Internal-Token", c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s service %w: %w", c.service, ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s service %w: %w", c.service, ErrUnavailable, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &Error{Service: c.service, Method: method, Path: path, StatusCode: resp.StatusCode}
+		// The Go services report {"error": ...}, FastAPI {"detail": ...}
+		var errBody struct {
+			Error  string          `json:"error"`
+			Detail json.RawMessage `json:"detail"`
+		}
+		if json.Unmarshal(raw, &errBody) == nil {
+			apiErr.Message = errBody.Error
+			if apiErr.Message == "" {
+				_ = json.Unmarshal(errBody.Detail, &apiErr.Message)
+			}
+		}
+		return nil, apiErr
+	}
+	return raw, nil
+}
+
+// retryable reports whether a failed attempt may succeed if repeated. Once
+// the caller's context is done nothing is retried.
+func retryable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, ErrUnavailable) {
+		return true
+	}
+	switch StatusCode(err) {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+type readCacheKey struct{}
+
+// readCache holds the responses of cached requests made with one context
+type readCache struct {
+	mu        sync.Mutex
+	responses map[string][]byte
+}
+
+// WithReadCache returns a context in which identical reads of market and
+// account data are only sent once, so everything evaluated with the
+// context sees the same data
+func WithReadCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readCacheKey{}, &readCache{responses: make(map[string][]byte)})
+}
+
+func (c *readCache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	raw, ok := c.responses[key]
+	return raw, ok
+}
+
+func (c *readCache) put(key string, raw []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.responses[key] = raw
+	c.mu.Unlock()
+}
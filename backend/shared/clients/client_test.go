@@ -0,0 +1,214 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// scriptedServer answers requests with the given statuses in turn, then
+// with 200, and counts the requests it got
+type scriptedServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	body     string // of error responses
+	requests int
+}
+
+func newScriptedServer(t *testing.T, body string, statuses ...int) *scriptedServer {
+	t.Helper()
+	s := &scriptedServer{statuses: statuses, body: body}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests++
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`{"apy": 4.2}`))
+			return
+		}
+		w.Write([]byte(s.body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *scriptedServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func TestRetries(t *testing.T) {
+	readAPY := func(ctx context.Context, url string) error {
+		_, err := NewDeFi(url, WithRetries(2)).APY(ctx, "aave", "USDC", "ethereum")
+		return err
+	}
+	build := func(reserve bool) func(context.Context, string) error {
+		return func(ctx context.Context, url string) error {
+			_, err := NewWallet(url, WithRetries(2)).Build(ctx, BuildRequest{Chain: "ethereum", ReserveNonce: reserve})
+			return err
+		}
+	}
+
+	tests := []struct {
+		name         string
+		call         func(context.Context, string) error
+		statuses     []int
+		wantRequests int
+		wantStatus   int // of the error returned, 0 for success
+	}{
+		{"502 retried", readAPY, []int{http.StatusBadGateway}, 2, 0},
+		{"503 retried", readAPY, []int{http.StatusServiceUnavailable}, 2, 0},
+		{"504 retried", readAPY, []int{http.StatusGatewayTimeout}, 2, 0},
+		{"retries run out", readAPY, []int{503, 503, 503, 503}, 3, http.StatusServiceUnavailable},
+		{"500 not retried", readAPY, []int{http.StatusInternalServerError}, 1, http.StatusInternalServerError},
+		{"404 not retried", readAPY, []int{http.StatusNotFound}, 1, http.StatusNotFound},
+		{"build retried", build(false), []int{http.StatusServiceUnavailable}, 2, 0},
+		{"nonce reserving build not retried", build(true), []int{http.StatusServiceUnavailable}, 1, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newScriptedServer(t, `{"error":"try again"}`, tt.statuses...)
+			err := tt.call(context.Background(), srv.URL)
+
+			if got := srv.count(); got != tt.wantRequests {
+				t.Errorf("%d requests, want %d", got, tt.wantRequests)
+			}
+			if got := StatusCode(err); got != tt.wantStatus {
+				t.Fatalf("error %v has status %d, want %d", err, got, tt.wantStatus)
+			}
+			if IsNotFound(err) != (tt.wantStatus == http.StatusNotFound) {
+				t.Errorf("IsNotFound(%v) = %v", err, IsNotFound(err))
+			}
+		})
+	}
+}
+
+func TestRetriesStopWithContext(t *testing.T) {
+	srv := newScriptedServer(t, "", 503, 503, 503)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := NewDeFi(srv.URL).APY(ctx, "aave", "USDC", "ethereum")
+	if err == nil {
+		t.Fatal("request with a cancelled context succeeded")
+	}
+	if srv.count() > 1 {
+		t.Fatalf("%d requests after the context was cancelled", srv.count())
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	srv := newScriptedServer(t, "")
+	url := srv.URL
+	srv.Close()
+
+	_, err := NewDeFi(url, WithRetries(0)).APY(context.Background(), "aave", "USDC", "ethereum")
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("error = %v, want ErrUnavailable", err)
+	}
+	if StatusCode(err) != 0 || IsNotFound(err) {
+		t.Fatalf("unreachable service reported status %d", StatusCode(err))
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantMessage string
+		wantError   string
+	}{
+		{"Go service", `{"error":"asset not supported"}`, "asset not supported", "DeFi service: status 400: asset not supported"},
+		{"FastAPI", `{"detail":"Model not loaded"}`, "Model not loaded", "DeFi service: status 400: Model not loaded"},
+		{"FastAPI validation", `{"detail":[{"loc":["body","positions"],"msg":"field required"}]}`, "", "DeFi service: status 400"},
+		{"not JSON", `Bad Request`, "", "DeFi service: status 400"},
+		{"empty", ``, "", "DeFi service: status 400"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newScriptedServer(t, tt.body, http.StatusBadRequest)
+			_, err := NewDeFi(srv.URL).APY(context.Background(), "aave", "USDC", "ethereum")
+
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error %v is not an *Error", err)
+			}
+			if apiErr.Service != "DeFi" || apiErr.Method != http.MethodGet || apiErr.Path != "/api/v1/protocols/aave/apy" ||
+				apiErr.StatusCode != http.StatusBadRequest || apiErr.Message != tt.wantMessage {
+				t.Errorf("error = %+v", apiErr)
+			}
+			if err.Error() != tt.wantError {
+				t.Errorf("error string = %q, want %q", err.Error(), tt.wantError)
+			}
+		})
+	}
+}
+
+func TestReadCache(t *testing.T) {
+	srv := newScriptedServer(t, `{"error":"no such market"}`)
+	defi := NewDeFi(srv.URL)
+	ctx := WithReadCache(context.Background())
+
+	for i := 0; i < 3; i++ {
+		apy, err := defi.APY(ctx, "aave", "USDC", "ethereum")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if apy.APY != 4.2 {
+			t.Fatalf("cached APY = %g, want 4.2", apy.APY)
+		}
+	}
+	if srv.count() != 1 {
+		t.Fatalf("%d requests for one read in a cached context", srv.count())
+	}
+
+	// Other reads, and reads outside the context, are sent
+	defi.APY(ctx, "aave", "USDC", "base")
+	defi.APY(WithReadCache(context.Background()), "aave", "USDC", "ethereum")
+	defi.APY(context.Background(), "aave", "USDC", "ethereum")
+	if srv.count() != 4 {
+		t.Fatalf("%d requests, want 4", srv.count())
+	}
+
+	// Errors aren't cached
+	srv.mu.Lock()
+	srv.statuses = []int{http.StatusNotFound}
+	srv.mu.Unlock()
+	if _, err := defi.APY(ctx, "compound", "USDC", "ethereum"); !IsNotFound(err) {
+		t.Fatalf("error = %v, want not found", err)
+	}
+	if _, err := defi.APY(ctx, "compound", "USDC", "ethereum"); err != nil {
+		t.Fatalf("failed read was cached: %v", err)
+	}
+	if srv.count() != 6 {
+		t.Fatalf("%d requests, want 6", srv.count())
+	}
+}
+
+func TestInternalToken(t *testing.T) {
+	var token string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("X-Internal-Token")
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	if _, err := NewWallet(srv.URL, WithInternalToken("secret")).Build(context.Background(), BuildRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if token != "secret" {
+		t.Fatalf("X-Internal-Token = %q, want secret", token)
+	}
+}
@@ -0,0 +1,564 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Bridge transfer states reported by the DeFi service
+const (
+	TransferPending   = "pending"
+	TransferClaimable = "claimable"
+	TransferArrived   = "arrived"
+	TransferFailed    = "failed"
+)
+
+// DeFi is a client of the DeFi service
+type DeFi struct {
+	client
+}
+
+// NewDeFi creates a DeFi service client
+func NewDeFi(baseURL string, opts ...Option) *DeFi {
+	return &DeFi{client: newClient("DeFi", baseURL, opts)}
+}
+
+// ProtocolInfo describes a protocol the DeFi service has registered
+type ProtocolInfo struct {
+	Name   string   `json:"name"`
+	Chains []string `json:"chains"`
+	// Capabilities lists the optional endpoints the protocol supports:
+	// utilization, transactions and deleverage
+	Capabilities []string `json:"capabilities"`
+}
+
+// APY is a protocol's supply APY for an asset, in percent
+type APY struct {
+	Protocol    string  `json:"protocol"`
+	Asset       string  `json:"asset"`
+	Chain       string  `json:"chain"`
+	APY         float64 `json:"apy"`
+	BlockNumber uint64  `json:"block_number"`
+}
+
+// AssetAPY is one entry of an APY sweep
+type AssetAPY struct {
+	Asset string  `json:"asset"`
+	APY   float64 `json:"apy"`
+	Error string  `json:"error,omitempty"`
+}
+
+// APYSweep is the APY of every supported asset of a protocol on a chain
+type APYSweep struct {
+	Protocol    string     `json:"protocol"`
+	Chain       string     `json:"chain"`
+	APYs        []AssetAPY `json:"apys"`
+	BlockNumber uint64     `json:"block_number"`
+}
+
+// Price is an asset's oracle USD price
+type Price struct {
+	Protocol    string  `json:"protocol"`
+	Asset       string  `json:"asset"`
+	Chain       string  `json:"chain"`
+	Price       float64 `json:"price"`
+	BlockNumber uint64  `json:"block_number"`
+}
+
+// Utilization is the borrowed share of a reserve, in percent
+type Utilization struct {
+	Protocol    string  `json:"protocol"`
+	Asset       string  `json:"asset"`
+	Chain       string  `json:"chain"`
+	Utilization float64 `json:"utilization"`
+	BlockNumber uint64  `json:"block_number"`
+}
+
+// HealthFactor is an account's health factor on a protocol
+type HealthFactor struct {
+	Protocol     string  `json:"protocol"`
+	UserAddress  string  `json:"user_address"`
+	Chain        string  `json:"chain"`
+	HealthFactor float64 `json:"health_factor"`
+	BlockNumber  uint64  `json:"block_number"`
+}
+
+// Position is an account's supplied, borrowed or staked balance of an asset
+type Position struct {
+	Protocol string  `json:"protocol"`
+	Chain    string  `json:"chain"`
+	Asset    string  `json:"asset"`
+	Type     string  `json:"type"` // lending, borrowing, staking
+	Amount   float64 `json:"amount"`
+	APY      float64 `json:"apy"`
+	Address  string  `json:"address"`
+}
+
+// ProtocolPortfolio is an account's positions on one protocol. Error is set
+// when they could not be read.
+type ProtocolPortfolio struct {
+	Protocol     string     `json:"protocol"`
+	Positions    []Position `json:"positions"`
+	HealthFactor float64    `json:"health_factor"`
+	Error        string     `json:"error"`
+}
+
+// Portfolio is an account's positions across every protocol on a chain
+type Portfolio struct {
+	UserAddress string              `json:"user_address"`
+	Chain       string              `json:"chain"`
+	Protocols   []ProtocolPortfolio `json:"protocols"`
+	BlockNumber uint64              `json:"block_number"`
+}
+
+// Call is an unsigned contract call built by the DeFi service
+type Call struct {
+	To          string `json:"to"`
+	Data        string `json:"data"`
+	Value       string `json:"value"`
+	Description string `json:"description"`
+
+	// Requires is the token allowance the call spends, if any
+	Requires *AllowanceRequirement `json:"requires_allowance,omitempty"`
+}
+
+// AllowanceRequirement is a token allowance a call spends
+type AllowanceRequirement struct {
+	Token   string `json:"token"`
+	Spender string `json:"spender"`
+	Amount  string `json:"amount"` // base units
+}
+
+// TransactionRequest asks for the calls of a deposit or withdrawal
+type TransactionRequest struct {
+	Action      string  `json:"action"` // deposit, withdraw
+	Asset       string  `json:"asset"`
+	Amount      float64 `json:"amount"` // in token units
+	Chain       string  `json:"chain"`
+	UserAddress string  `json:"user_address"`
+}
+
+// ProtocolCalls are the calls of a deposit or withdrawal
+type ProtocolCalls struct {
+	Protocol    string `json:"protocol"`
+	Chain       string `json:"chain"`
+	Asset       string `json:"asset"`
+	Token       string `json:"token"`
+	Amount      string `json:"amount"` // base units
+	Calls       []Call `json:"calls"`
+	BlockNumber uint64 `json:"block_number"`
+}
+
+// DeleverageRequest asks for the repayment that restores a target health
+// factor
+type DeleverageRequest struct {
+	DebtAsset          string  `json:"debt_asset"`
+	TargetHealthFactor float64 `json:"target_health_factor"`
+	Source             string  `json:"source,omitempty"`     // wallet, collateral
+	MaxAmount          float64 `json:"max_amount,omitempty"` // in token units
+	Chain              string  `json:"chain"`
+	UserAddress        string  `json:"user_address"`
+}
+
+// DeleveragePlan is a debt repayment and the calls that make it
+type DeleveragePlan struct {
+	Chain                 string   `json:"chain"`
+	Asset                 string   `json:"asset"`
+	Token                 string   `json:"token"`
+	Source                string   `json:"source"`
+	HealthFactor          float64  `json:"health_factor"`
+	TargetHealthFactor    float64  `json:"target_health_factor"`
+	ProjectedHealthFactor float64  `json:"projected_health_factor"`
+	Debt                  float64  `json:"debt"`         // in token units
+	RepayAmount           float64  `json:"repay_amount"` // in token units
+	Amount                string   `json:"amount"`       // base units
+	Calls                 []Call   `json:"calls"`
+	Warnings              []string `json:"warnings"`
+}
+
+// SwapRequest asks for the calls of a swap
+type SwapRequest struct {
+	Venue       string  `json:"venue,omitempty"`
+	TokenIn     string  `json:"token_in"`  // symbol
+	TokenOut    string  `json:"token_out"` // symbol
+	Amount      float64 `json:"amount"`    // of token_in, in token units
+	Chain       string  `json:"chain"`
+	SlippageBps int     `json:"slippage_bps"`
+	UserAddress string  `json:"user_address"`
+}
+
+// SwapCalls are a quoted swap and the calls that perform it
+type SwapCalls struct {
+	Chain        string  `json:"chain"`
+	TokenIn      string  `json:"token_in"`  // address
+	TokenOut     string  `json:"token_out"` // address
+	AmountIn     float64 `json:"amount_in"`
+	AmountOut    float64 `json:"amount_out"`     // quoted, in token units
+	MinAmountOut float64 `json:"min_amount_out"` // in token units
+	Plan         struct {
+		SlippageBps  int    `json:"slippage_bps"`
+		MinAmountOut string `json:"min_amount_out"` // base units
+		Deadline     int64  `json:"deadline"`       // unix seconds
+		Calls        []Call `json:"calls"`
+	} `json:"plan"`
+	BlockNumber uint64 `json:"block_number"`
+}
+
+// BridgeRequest asks for the quotes or calls of a transfer between chains
+type BridgeRequest struct {
+	Asset       string  `json:"asset"`
+	Amount      float64 `json:"amount"` // in token units
+	FromChain   string  `json:"from_chain"`
+	ToChain     string  `json:"to_chain"`
+	UserAddress string  `json:"user_address,omitempty"`
+	Recipient   string  `json:"recipient,omitempty"` // defaults to UserAddress
+}
+
+// BridgeQuote is what a transfer costs on a bridge and how long it takes
+type BridgeQuote struct {
+	Bridge          string `json:"bridge"`
+	Asset           string `json:"asset"`
+	FromChain       string `json:"from_chain"`
+	ToChain         string `json:"to_chain"`
+	AmountIn        string `json:"amount_in"`  // base units
+	AmountOut       string `json:"amount_out"` // base units
+	Fee             string `json:"fee"`        // base units
+	SourceGas       uint64 `json:"source_gas"`
+	DestinationGas  uint64 `json:"destination_gas"`
+	ExpectedSeconds int64  `json:"expected_seconds"`
+}
+
+// BridgeCalls are the source chain calls that start a transfer
+type BridgeCalls struct {
+	Bridge string      `json:"bridge"`
+	Quote  BridgeQuote `json:"quote"`
+	Token  string      `json:"token"`
+	Amount string      `json:"amount"` // base units
+	Calls  []Call      `json:"calls"`
+}
+
+// TransferStatus is where a bridge transfer stands. Calls claim the funds,
+// or make them usable once they've arrived.
+type TransferStatus struct {
+	State  string `json:"state"`
+	Detail string `json:"detail"`
+	Calls  []Call `json:"calls"`
+}
+
+// OptimizeLimits bound where an optimization may put funds, in percent
+// where they are shares. Zero fields take the risk tolerance's default.
+type OptimizeLimits struct {
+	MaxProtocolShare  float64 `json:"max_protocol_share,omitempty"`
+	MaxPoolShare      float64 `json:"max_pool_share,omitempty"`
+	MaxUtilization    float64 `json:"max_utilization,omitempty"`
+	MinTVLUSD         float64 `json:"min_tvl_usd,omitempty"`
+	MinImprovementBps float64 `json:"min_improvement_bps,omitempty"`
+}
+
+// Holding is an amount of an asset supplied to a protocol on a chain
+type Holding struct {
+	Protocol string  `json:"protocol"`
+	Chain    string  `json:"chain"`
+	Asset    string  `json:"asset"`
+	Amount   float64 `json:"amount"` // in token units
+}
+
+// OptimizeRequest asks for the allocation of holdings that earns the most
+// within a risk tolerance
+type OptimizeRequest struct {
+	UserAddress string         `json:"user_address"`
+	Holdings    []Holding      `json:"holdings"`
+	Risk        string         `json:"risk,omitempty"` // low, medium, high
+	Limits      OptimizeLimits `json:"limits"`
+	Protocols   []string       `json:"protocols,omitempty"`
+	Chains      []string       `json:"chains,omitempty"`
+	HorizonDays float64        `json:"horizon_days,omitempty"`
+}
+
+// Allocation is an amount of an asset in a market
+type Allocation struct {
+	Protocol string  `json:"protocol"`
+	Chain    string  `json:"chain"`
+	Asset    string  `json:"asset"`
+	Amount   float64 `json:"amount"` // in token units
+	ValueUSD float64 `json:"value_usd"`
+	APY      float64 `json:"apy"`
+	Share    float64 `json:"share"` // percent of the portfolio's value
+}
+
+// MoveStep is a batch of calls to send on one chain. AfterArrival steps
+// wait for bridged funds.
+type MoveStep struct {
+	Chain        string `json:"chain"`
+	Description  string `json:"description"`
+	Calls        []Call `json:"calls"`
+	AfterArrival bool   `json:"after_arrival,omitempty"`
+}
+
+// Move takes an amount of an asset from one market to another, bridging it
+// between chains when needed
+type Move struct {
+	Asset        string       `json:"asset"`
+	Amount       float64      `json:"amount"` // in token units
+	FromProtocol string       `json:"from_protocol"`
+	FromChain    string       `json:"from_chain"`
+	ToProtocol   string       `json:"to_protocol"`
+	ToChain      string       `json:"to_chain"`
+	FromAPY      float64      `json:"from_apy"`
+	ToAPY        float64      `json:"to_apy"`
+	Bridge       *BridgeQuote `json:"bridge,omitempty"`
+	GainUSD      float64      `json:"gain_usd"`
+	CostUSD      float64      `json:"cost_usd"`
+	NetUSD       float64      `json:"net_usd"`
+	Steps        []MoveStep   `json:"steps,omitempty"`
+}
+
+// Market is what the optimizer read about a protocol's market for an asset
+type Market struct {
+	Protocol    string  `json:"protocol"`
+	Chain       string  `json:"chain"`
+	Asset       string  `json:"asset"`
+	APY         float64 `json:"apy"`
+	Utilization float64 `json:"utilization"`
+	TVLUSD      float64 `json:"tvl_usd"`
+	Eligible    bool    `json:"eligible"`
+	Reason      string  `json:"reason,omitempty"`
+}
+
+// OptimizationPlan is a proposed allocation and the moves that reach it.
+// Moves carry calls only when the plan is recommended.
+type OptimizationPlan struct {
+	Risk           string         `json:"risk"`
+	Limits         OptimizeLimits `json:"limits"`
+	HorizonDays    float64        `json:"horizon_days"`
+	ValueUSD       float64        `json:"value_usd"`
+	CurrentAPY     float64        `json:"current_apy"`
+	ProposedAPY    float64        `json:"proposed_apy"`
+	GainUSD        float64        `json:"gain_usd"`
+	CostUSD        float64        `json:"cost_usd"`
+	NetUSD         float64        `json:"net_usd"`
+	ImprovementBps float64        `json:"improvement_bps"`
+	Recommended    bool           `json:"recommended"`
+	Reason         string         `json:"reason,omitempty"`
+	Current        []Allocation   `json:"current"`
+	Target         []Allocation   `json:"target"`
+	Moves          []Move         `json:"moves"`
+	Markets        []Market       `json:"markets"`
+	Warnings       []string       `json:"warnings,omitempty"`
+}
+
+// Protocols lists the protocols the service has registered
+func (d *DeFi) Protocols(ctx context.Context) ([]ProtocolInfo, error) {
+	var result []ProtocolInfo
+	if err := d.do(ctx, request{method: http.MethodGet, path: "/api/v1/protocols", idempotent: true}, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// APY reads a protocol's supply APY for an asset
+func (d *DeFi) APY(ctx context.Context, protocol, asset, chain string) (*APY, error) {
+	var result APY
+	if err := d.do(ctx, request{
+		method:     http.MethodGet,
+		path:       "/api/v1/protocols/" + url.PathEscape(protocol) + "/apy",
+		query:      url.Values{"asset": {asset}, "chain": {chain}},
+		idempotent: true,
+		cached:     true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// APYs reads the APY of every supported asset of a protocol on a chain
+func (d *DeFi) APYs(ctx context.Context, protocol, chain string) (*APYSweep, error) {
+	var result APYSweep
+	if err := d.do(ctx, request{
+		method:     http.MethodGet,
+		path:       "/api/v1/protocols/" + url.PathEscape(protocol) + "/apys",
+		query:      url.Values{"chain": {chain}},
+		idempotent: true,
+		cached:     true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Price reads an asset's price from a protocol's oracle at a block, or at
+// the latest block when block is 0. Past blocks need the DeFi service to
+// have archive access.
+func (d *DeFi) Price(ctx context.Context, protocol, asset, chain string, block uint64) (*Price, error) {
+	query := url.Values{"asset": {asset}, "chain": {chain}}
+	if block > 0 {
+		query.Set("block", strconv.FormatUint(block, 10))
+	}
+	var result Price
+	if err := d.do(ctx, request{
+		method:     http.MethodGet,
+		path:       "/api/v1/protocols/" + url.PathEscape(protocol) + "/price",
+		query:      query,
+		idempotent: true,
+		cached:     true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Utilization reads the borrowed share of a protocol's reserve
+func (d *DeFi) Utilization(ctx context.Context, protocol, asset, chain string) (*Utilization, error) {
+	var result Utilization
+	if err := d.do(ctx, request{
+		method:     http.MethodGet,
+		path:       "/api/v1/protocols/" + url.PathEscape(protocol) + "/utilization",
+		query:      url.Values{"asset": {asset}, "chain": {chain}},
+		idempotent: true,
+		cached:     true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// HealthFactor reads an account's health factor on a protocol
+func (d *DeFi) HealthFactor(ctx context.Context, protocol, account, chain string) (*HealthFactor, error) {
+	var result HealthFactor
+	if err := d.do(ctx, request{
+		method:     http.MethodGet,
+		path:       "/api/v1/protocols/" + url.PathEscape(protocol) + "/health-factor",
+		query:      url.Values{"user_address": {account}, "chain": {chain}},
+		idempotent: true,
+		cached:     true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Portfolio reads an account's positions and health factors across every
+// protocol on a chain, all at the same block
+func (d *DeFi) Portfolio(ctx context.Context, account, chain string) (*Portfolio, error) {
+	var result Portfolio
+	if err := d.do(ctx, request{
+		method:     http.MethodGet,
+		path:       "/api/v1/portfolio",
+		query:      url.Values{"user_address": {account}, "chain": {chain}},
+		idempotent: true,
+		cached:     true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ProtocolCalls builds the calls of a deposit or withdrawal
+func (d *DeFi) ProtocolCalls(ctx context.Context, protocol string, req TransactionRequest) (*ProtocolCalls, error) {
+	var result ProtocolCalls
+	if err := d.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/api/v1/protocols/" + url.PathEscape(protocol) + "/transactions",
+		body:       req,
+		idempotent: true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Deleverage plans the debt repayment that restores a target health factor
+func (d *DeFi) Deleverage(ctx context.Context, protocol string, req DeleverageRequest) (*DeleveragePlan, error) {
+	var result struct {
+		Plan DeleveragePlan `json:"plan"`
+	}
+	if err := d.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/api/v1/protocols/" + url.PathEscape(protocol) + "/deleverage",
+		body:       req,
+		idempotent: true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result.Plan, nil
+}
+
+// SwapCalls quotes a swap and builds its calls
+func (d *DeFi) SwapCalls(ctx context.Context, req SwapRequest) (*SwapCalls, error) {
+	var result SwapCalls
+	if err := d.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/api/v1/swaps/transactions",
+		body:       req,
+		idempotent: true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// BridgeQuotes quotes a transfer on every bridge that supports the route
+func (d *DeFi) BridgeQuotes(ctx context.Context, req BridgeRequest) ([]BridgeQuote, error) {
+	var result struct {
+		Quotes []BridgeQuote `json:"quotes"`
+	}
+	if err := d.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/api/v1/bridges/quote",
+		body:       req,
+		idempotent: true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return result.Quotes, nil
+}
+
+// BridgeCalls builds the source chain calls that start a transfer on a
+// bridge
+func (d *DeFi) BridgeCalls(ctx context.Context, bridge string, req BridgeRequest) (*BridgeCalls, error) {
+	var result BridgeCalls
+	if err := d.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/api/v1/bridges/" + url.PathEscape(bridge) + "/transactions",
+		body:       req,
+		idempotent: true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// TransferStatus follows a bridge transfer by its source chain transaction
+func (d *DeFi) TransferStatus(ctx context.Context, bridge, fromChain, toChain, txHash string) (*TransferStatus, error) {
+	var result struct {
+		Status TransferStatus `json:"status"`
+	}
+	if err := d.do(ctx, request{
+		method:     http.MethodGet,
+		path:       "/api/v1/bridges/" + url.PathEscape(bridge) + "/status",
+		query:      url.Values{"from_chain": {fromChain}, "to_chain": {toChain}, "tx_hash": {txHash}},
+		idempotent: true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result.Status, nil
+}
+
+// Optimize proposes the allocation of holdings across protocols and chains
+// that earns the most net yield, and the calls that reach it
+func (d *DeFi) Optimize(ctx context.Context, req OptimizeRequest) (*OptimizationPlan, error) {
+	var result struct {
+		Plan OptimizationPlan `json:"plan"`
+	}
+	if err := d.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/api/v1/optimize",
+		body:       req,
+		idempotent: true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result.Plan, nil
+}
@@ -0,0 +1,63 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+)
+
+// ML is a client of the ML service
+type ML struct {
+	client
+}
+
+// NewML creates an ML service client
+func NewML(baseURL string, opts ...Option) *ML {
+	return &ML{client: newClient("ML", baseURL, opts)}
+}
+
+// RiskPosition is a position valued for a risk forecast
+type RiskPosition struct {
+	Protocol     string  `json:"protocol"`
+	Chain        string  `json:"chain"`
+	Asset        string  `json:"asset"`
+	Type         string  `json:"type"` // lending, borrowing, staking
+	Amount       float64 `json:"amount"`
+	APY          float64 `json:"apy"`
+	Price        float64 `json:"price"`
+	ValueUSD     float64 `json:"value_usd"`
+	HealthFactor float64 `json:"health_factor,omitempty"`
+}
+
+// RiskForecastRequest describes an account's positions for a risk forecast
+type RiskForecastRequest struct {
+	UserAddress string         `json:"user_address"`
+	Positions   []RiskPosition `json:"positions"`
+	// HealthFactor is the account's lowest health factor; without one the
+	// ML service derives it from the totals, or assumes a safe account
+	HealthFactor    *float64 `json:"health_factor,omitempty"`
+	TotalCollateral float64  `json:"total_collateral"` // USD supplied
+	TotalDebt       float64  `json:"total_debt"`       // USD borrowed
+}
+
+// RiskForecast is the ML service's liquidation risk assessment
+type RiskForecast struct {
+	LiquidationRisk float64  `json:"liquidation_risk"`
+	RiskLevel       string   `json:"risk_level"`
+	Recommendations []string `json:"recommendations"`
+	Confidence      float64  `json:"confidence"`
+}
+
+// RiskForecast forecasts an account's liquidation risk
+func (m *ML) RiskForecast(ctx context.Context, req RiskForecastRequest) (*RiskForecast, error) {
+	var result RiskForecast
+	if err := m.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/api/v1/risk/forecast",
+		body:       req,
+		idempotent: true,
+		cached:     true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
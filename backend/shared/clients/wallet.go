@@ -0,0 +1,279 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Wallet is a client of the wallet service
+type Wallet struct {
+	client
+}
+
+// NewWallet creates a wallet service client
+func NewWallet(baseURL string, opts ...Option) *Wallet {
+	return &Wallet{client: newClient("wallet", baseURL, opts)}
+}
+
+// GasPrice is a chain's expected fees
+type GasPrice struct {
+	Chain                string  `json:"chain"`
+	Speed                string  `json:"speed"`
+	BaseFee              string  `json:"base_fee"`                 // wei
+	MaxPriorityFeePerGas string  `json:"max_priority_fee_per_gas"` // wei
+	MaxFeePerGas         string  `json:"max_fee_per_gas"`          // wei
+	GasPriceGwei         float64 `json:"gas_price_gwei"`
+}
+
+// TokenBalance is a wallet's balance of one token
+type TokenBalance struct {
+	Chain    string  `json:"chain"`
+	Token    string  `json:"token"` // token address or "native"
+	Symbol   string  `json:"symbol"`
+	Name     string  `json:"name"`
+	Decimals int     `json:"decimals"`
+	Balance  string  `json:"balance"` // base units
+	Amount   float64 `json:"amount"`
+	PriceUSD float64 `json:"price_usd"`
+	ValueUSD float64 `json:"value_usd"`
+	// Error is set when the token's balance could not be read
+	Error string `json:"error"`
+}
+
+// Balances are a wallet's token balances with USD values
+type Balances struct {
+	Address  string         `json:"address"`
+	Balances []TokenBalance `json:"balances"`
+	TotalUSD float64        `json:"total_usd"`
+}
+
+// Session is a WalletConnect session that can sign for a wallet
+type Session struct {
+	Topic         string   `json:"topic"`
+	WalletAddress string   `json:"wallet_address"`
+	Chains        []string `json:"chains"` // CAIP-2
+	PeerName      string   `json:"peer_name"`
+	PeerURL       string   `json:"peer_url"`
+}
+
+// SessionRequest is a JSON-RPC request for the wallet behind a session
+type SessionRequest struct {
+	Chain  string        `json:"chain"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	// Type and AutomationRuleID are recorded on the transaction an
+	// eth_sendTransaction request sends
+	Type             string `json:"type,omitempty"`
+	AutomationRuleID *uint  `json:"automation_rule_id,omitempty"`
+}
+
+// AllowanceCheckRequest asks whether an allowance covers an amount
+type AllowanceCheckRequest struct {
+	Chain   string `json:"chain"`
+	Owner   string `json:"owner"`
+	Token   string `json:"token"`
+	Spender string `json:"spender"`
+	Amount  string `json:"amount"` // base units
+}
+
+// AllowanceCheck is an allowance and, when it falls short, the approval
+// that covers the amount. Reset must be sent before Approval when set.
+type AllowanceCheck struct {
+	Allowance  string `json:"allowance"` // base units
+	Sufficient bool   `json:"sufficient"`
+	Approval   *Call  `json:"approval"`
+	Reset      *Call  `json:"reset"`
+}
+
+// SimulationRequest is a call to simulate against the pending block
+type SimulationRequest struct {
+	Chain string `json:"chain"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Value string `json:"value"`
+	Data  string `json:"data"`
+
+	// TokenBalances sets the sender's balance of a token (address => base
+	// units), for calls that spend funds an earlier transaction produces
+	TokenBalances map[string]string `json:"token_balances,omitempty"`
+	// TokenAllowances sets the sender's allowances (token => spender => base
+	// units), for calls that spend an approval an earlier transaction grants
+	TokenAllowances map[string]map[string]string `json:"token_allowances,omitempty"`
+}
+
+// BalanceChange is the net change of one asset for the sender
+type BalanceChange struct {
+	Token  string  `json:"token"` // token address or "native"
+	Symbol string  `json:"symbol"`
+	Delta  string  `json:"delta"` // signed, base units
+	Amount float64 `json:"amount"`
+}
+
+// SimulationResult is the outcome of a simulated call. BalanceChanges are
+// only complete when TraceAvailable is set.
+type SimulationResult struct {
+	Success        bool            `json:"success"`
+	RevertReason   string          `json:"revert_reason"`
+	ReturnData     string          `json:"return_data"`
+	GasUsed        uint64          `json:"gas_used"`
+	BalanceChanges []BalanceChange `json:"balance_changes"`
+	TraceAvailable bool            `json:"trace_available"`
+}
+
+// BuildRequest asks for a call to be priced and estimated
+type BuildRequest struct {
+	Chain string `json:"chain"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Value string `json:"value"`
+	Data  string `json:"data"`
+	Speed string `json:"speed,omitempty"` // slow, normal, fast
+	// ReserveNonce assigns the transaction the sender's next free nonce
+	ReserveNonce bool `json:"reserve_nonce"`
+}
+
+// Transaction is a priced, unsigned transaction
+type Transaction struct {
+	From                 string  `json:"from"`
+	To                   string  `json:"to"`
+	Value                string  `json:"value"`
+	Data                 string  `json:"data"`
+	GasLimit             uint64  `json:"gas_limit"`
+	Type                 uint8   `json:"type"`
+	MaxFeePerGas         string  `json:"max_fee_per_gas"`
+	MaxPriorityFeePerGas string  `json:"max_priority_fee_per_gas"`
+	ChainID              int64   `json:"chain_id"`
+	Nonce                *uint64 `json:"nonce"`
+	EstimatedCost        string  `json:"estimated_cost"`
+	MaxCost              string  `json:"max_cost"`
+}
+
+// GasPrice reads a chain's expected fees
+func (w *Wallet) GasPrice(ctx context.Context, chain string) (*GasPrice, error) {
+	var result GasPrice
+	if err := w.do(ctx, request{
+		method:     http.MethodGet,
+		path:       "/api/v1/wallet/gas",
+		query:      url.Values{"chain": {chain}},
+		idempotent: true,
+		cached:     true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Balances reads a wallet's token balances on a chain
+func (w *Wallet) Balances(ctx context.Context, address, chain string) (*Balances, error) {
+	var result Balances
+	if err := w.do(ctx, request{
+		method:     http.MethodGet,
+		path:       "/api/v1/wallet/balances",
+		query:      url.Values{"address": {address}, "chain": {chain}},
+		idempotent: true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ActiveSession finds the session that can sign for a wallet on a chain.
+// Without one the error satisfies IsNotFound.
+func (w *Wallet) ActiveSession(ctx context.Context, address, chain string) (*Session, error) {
+	var result Session
+	if err := w.do(ctx, request{
+		method:     http.MethodGet,
+		path:       "/api/v1/wallet/sessions/active",
+		query:      url.Values{"address": {address}, "chain": {chain}},
+		idempotent: true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Request sends a JSON-RPC request to the wallet behind a session and
+// returns its request ID. It is never retried, so the user is not asked
+// twice.
+func (w *Wallet) Request(ctx context.Context, topic string, req SessionRequest) (int64, error) {
+	var result struct {
+		RequestID int64 `json:"request_id"`
+	}
+	if err := w.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/v1/wallet/sessions/" + url.PathEscape(topic) + "/request",
+		body:   req,
+	}, &result); err != nil {
+		return 0, err
+	}
+	return result.RequestID, nil
+}
+
+// CheckAllowance reads an allowance and builds the approval it needs
+func (w *Wallet) CheckAllowance(ctx context.Context, req AllowanceCheckRequest) (*AllowanceCheck, error) {
+	var result AllowanceCheck
+	if err := w.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/api/v1/wallet/allowances/check",
+		body:       req,
+		idempotent: true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Simulate runs a call against the pending block
+func (w *Wallet) Simulate(ctx context.Context, req SimulationRequest) (*SimulationResult, error) {
+	var result SimulationResult
+	if err := w.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/api/v1/wallet/simulate",
+		body:       req,
+		idempotent: true,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Build prices and estimates a call. Requests that reserve a nonce are not
+// retried, so a lost response cannot reserve a second one.
+func (w *Wallet) Build(ctx context.Context, req BuildRequest) (*Transaction, error) {
+	var result Transaction
+	if err := w.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/api/v1/wallet/build",
+		body:       req,
+		idempotent: !req.ReserveNonce,
+	}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ReserveNonce reserves an account's next nonce
+func (w *Wallet) ReserveNonce(ctx context.Context, chain, address string) (uint64, error) {
+	var result struct {
+		Nonce uint64 `json:"nonce"`
+	}
+	if err := w.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/v1/wallet/nonces/reserve",
+		body:   map[string]interface{}{"chain": chain, "address": address},
+	}, &result); err != nil {
+		return 0, err
+	}
+	return result.Nonce, nil
+}
+
+// ReleaseNonce returns a reserved nonce that will not be used
+func (w *Wallet) ReleaseNonce(ctx context.Context, chain, address string, nonce uint64) error {
+	var result struct{}
+	return w.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/v1/wallet/nonces/release",
+		body:   map[string]interface{}{"chain": chain, "address": address, "nonce": nonce},
+	}, &result)
+}
@@ -56,6 +56,17 @@ func runMigrations() error {
 		&models.AutomationRule{},
 		&models.Transaction{},
 		&models.Subscription{},
+		&models.AccountNonce{},
+		&models.NonceReservation{},
+		&models.WalletSession{},
+		&models.WalletRequest{},
+		&models.EventCursor{},
+		&models.ConditionState{},
+		&models.RuleExecution{},
+		&models.MetricSample{},
+		&models.EngineWorker{},
+		&models.ActionJob{},
+		&models.BridgeTransfer{},
 	)
 }
 
@@ -40,6 +40,10 @@ type Portfolio struct {
 
 	Name        string  `gorm:"not null" json:"name"`
 	Description string  `json:"description,omitempty"`
+
+	// RiskTolerance (low, medium, high) sets the limits the yield optimizer
+	// allocates the portfolio within
+	RiskTolerance string `gorm:"default:medium" json:"risk_tolerance"`
 	
 	// Position data
 	TotalValueUSD    float64 `gorm:"default:0" json:"total_value_usd"`
@@ -106,16 +110,26 @@ type AutomationRule struct {
 	Enabled     bool   `gorm:"default:true" json:"enabled"`
 	
 	// Trigger configuration
-	TriggerType string                 `gorm:"not null" json:"trigger_type"` // apy_drop, health_factor, risk_threshold
-	TriggerConfig map[string]interface{} `gorm:"type:jsonb" json:"trigger_config"`
+	TriggerType string                 `gorm:"not null" json:"trigger_type"` // apy_drop, health_factor, risk_threshold, price_change, apy_spread, utilization, gas_below, schedule, composite
+	TriggerConfig map[string]interface{} `gorm:"type:jsonb;serializer:json" json:"trigger_config"`
 	
 	// Action configuration
-	ActionType string                 `gorm:"not null" json:"action_type"` // rebalance, withdraw, deposit
-	ActionConfig map[string]interface{} `gorm:"type:jsonb" json:"action_config"`
+	ActionType string                 `gorm:"not null" json:"action_type"` // rebalance, withdraw, deposit, deleverage
+	ActionConfig map[string]interface{} `gorm:"type:jsonb;serializer:json" json:"action_config"`
 	
+	// Execution limits
+	CooldownSeconds     int      `gorm:"default:0" json:"cooldown_seconds"`       // minimum time between executions
+	ResetThreshold      *float64 `json:"reset_threshold,omitempty"`               // metric must recover past this before the rule fires again
+	MaxExecutionsPerDay int      `gorm:"default:0" json:"max_executions_per_day"` // 0 = unlimited
+	MaxExecutions       int      `gorm:"default:0" json:"max_executions"`         // lifetime, 0 = unlimited
+	FireOnce            bool     `gorm:"default:false" json:"fire_once"`          // disable after the first execution
+
 	// Execution tracking
-	LastExecutedAt *time.Time `json:"last_executed_at,omitempty"`
-	ExecutionCount int        `gorm:"default:0" json:"execution_count"`
+	LastExecutedAt  *time.Time `json:"last_executed_at,omitempty"`
+	ExecutionCount  int        `gorm:"default:0" json:"execution_count"`
+	ExecutionsToday int        `gorm:"default:0" json:"executions_today"`
+	ExecutionDay    string     `gorm:"size:10" json:"-"`          // UTC date ExecutionsToday counts for
+	Armed           bool       `gorm:"default:true" json:"armed"` // false while waiting for the metric to recover
 }
 
 // Transaction represents a blockchain transaction
@@ -135,18 +149,146 @@ type Transaction struct {
 	
 	// Transaction metadata
 	Type        string  `gorm:"not null" json:"type"` // rebalance, deposit, withdraw
-	Status      string  `gorm:"default:pending" json:"status"` // pending, confirmed, failed
+	Status      string  `gorm:"default:pending;index" json:"status"` // pending, included, confirmed, failed, dropped, replaced
 	Value       float64 `gorm:"default:0" json:"value"`
+	Nonce       *uint64 `json:"nonce,omitempty"`
 	GasUsed     uint64  `json:"gas_used,omitempty"`
 	GasPrice    string  `json:"gas_price,omitempty"`
 	
+	// Receipt tracking
+	EffectiveGasPrice string     `json:"effective_gas_price,omitempty"`
+	BlockNumber       uint64     `json:"block_number,omitempty"`
+	BlockHash         string     `json:"block_hash,omitempty"`
+	Confirmations     uint64     `gorm:"default:0" json:"confirmations"`
+	ReplacedBy        string     `json:"replaced_by,omitempty"`
+	FinalizedAt       *time.Time `json:"finalized_at,omitempty"`
+	
 	// Related automation rule
 	AutomationRuleID *uint `gorm:"index" json:"automation_rule_id,omitempty"`
+	// RequestID is the WalletConnect request the wallet answered with this
+	// transaction, for transactions the wallet broadcast itself
+	RequestID *int64 `gorm:"index" json:"request_id,omitempty"`
 	
 	// Transaction data
 	TxData map[string]interface{} `gorm:"type:jsonb" json:"tx_data,omitempty"`
 }
 
+// Transaction statuses
+const (
+	TxStatusPending   = "pending"   // broadcast, no receipt yet
+	TxStatusIncluded  = "included"  // mined, waiting for confirmation depth
+	TxStatusConfirmed = "confirmed" // mined and past confirmation depth
+	TxStatusFailed    = "failed"    // mined and reverted, past confirmation depth
+	TxStatusDropped   = "dropped"   // evicted from the mempool without being mined
+	TxStatusReplaced  = "replaced"  // nonce consumed by a different transaction
+)
+
+// AccountNonce tracks the next unreserved nonce for an account on a chain
+type AccountNonce struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Chain     string `gorm:"not null;uniqueIndex:idx_account_nonce" json:"chain"`
+	Address   string `gorm:"not null;uniqueIndex:idx_account_nonce" json:"address"`
+	NextNonce uint64 `gorm:"not null;default:0" json:"next_nonce"`
+}
+
+// NonceReservation records a nonce handed out for a transaction
+type NonceReservation struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// A nonce has one reservation row, reused when the nonce is handed out again
+	Chain   string `gorm:"not null;uniqueIndex:idx_nonce_reservation_nonce" json:"chain"`
+	Address string `gorm:"not null;uniqueIndex:idx_nonce_reservation_nonce" json:"address"`
+	Nonce   uint64 `gorm:"not null;uniqueIndex:idx_nonce_reservation_nonce" json:"nonce"`
+
+	Status    string    `gorm:"not null;default:reserved" json:"status"` // reserved, broadcast, released
+	TxHash    string    `json:"tx_hash,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Nonce reservation statuses
+const (
+	NonceStatusReserved  = "reserved"
+	NonceStatusBroadcast = "broadcast"
+	NonceStatusReleased  = "released"
+)
+
+// WalletSession represents a WalletConnect v2 session approved by a user's wallet
+type WalletSession struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	UserID        uint   `gorm:"index;not null" json:"user_id"`
+	WalletAddress string `gorm:"index;not null" json:"wallet_address"`
+
+	// WalletConnect identifiers
+	Topic        string `gorm:"uniqueIndex;not null" json:"topic"`
+	PairingTopic string `gorm:"index" json:"pairing_topic"`
+	SymKey       string `gorm:"not null" json:"-"` // session symmetric key, hex
+
+	// Approved namespace (CAIP-2 chains, CAIP-10 accounts)
+	Chains   []string `gorm:"type:jsonb;serializer:json" json:"chains"`
+	Accounts []string `gorm:"type:jsonb;serializer:json" json:"accounts"`
+	Methods  []string `gorm:"type:jsonb;serializer:json" json:"methods"`
+	Events   []string `gorm:"type:jsonb;serializer:json" json:"events"`
+
+	PeerName string `json:"peer_name,omitempty"`
+	PeerURL  string `json:"peer_url,omitempty"`
+
+	Status    string     `gorm:"default:active;index" json:"status"` // active, revoked, expired
+	ExpiresAt time.Time  `gorm:"index" json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Wallet session statuses
+const (
+	SessionStatusActive  = "active"
+	SessionStatusRevoked = "revoked"
+	SessionStatusExpired = "expired"
+)
+
+// WalletRequest is an eth_sendTransaction request published to a wallet over
+// its WalletConnect session. The wallet signs and broadcasts the transaction
+// itself and answers on the session topic with its hash, which is recorded
+// as a Transaction for the tracker to follow.
+type WalletRequest struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// RequestID is the JSON-RPC id the wallet's response carries
+	Topic     string `gorm:"not null;uniqueIndex:idx_wallet_request" json:"topic"`
+	RequestID int64  `gorm:"not null;uniqueIndex:idx_wallet_request" json:"request_id"`
+	UserID    uint   `gorm:"index;not null" json:"user_id"`
+
+	Chain            string                 `gorm:"not null" json:"chain"`
+	FromAddress      string                 `gorm:"not null" json:"from_address"`
+	Nonce            *uint64                `json:"nonce,omitempty"`
+	Params           map[string]interface{} `gorm:"type:jsonb;serializer:json" json:"params"` // eth_sendTransaction parameters
+	Type             string                 `gorm:"not null" json:"type"`
+	AutomationRuleID *uint                  `gorm:"index" json:"automation_rule_id,omitempty"`
+
+	Status      string     `gorm:"not null;index" json:"status"` // pending, sent, rejected, expired
+	TxHash      string     `json:"tx_hash,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RespondedAt *time.Time `json:"responded_at,omitempty"`
+}
+
+// Wallet request statuses
+const (
+	RequestStatusPending  = "pending"  // published, no response yet
+	RequestStatusSent     = "sent"     // the wallet broadcast the transaction
+	RequestStatusRejected = "rejected" // the wallet answered with an error
+	RequestStatusExpired  = "expired"  // no response before the request expired
+)
+
 // Subscription represents subscription and payment tracking
 type Subscription struct {
 	ID        uint           `gorm:"primaryKey" json:"id"`
@@ -169,3 +311,186 @@ type Subscription struct {
 	PerformanceFee   float64 `gorm:"default:0" json:"performance_fee"`
 }
 
+
+// EventCursor records the last block whose logs were processed for a chain,
+// so the event subscriber resumes where it left off after a restart
+type EventCursor struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Chain       string `gorm:"uniqueIndex;not null" json:"chain"`
+	BlockNumber uint64 `gorm:"not null" json:"block_number"`
+}
+
+// ConditionState records since when a node of a composite trigger with a
+// "for" duration has held, so the duration survives engine restarts
+type ConditionState struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	RuleID uint      `gorm:"uniqueIndex:idx_rule_condition;not null" json:"rule_id"`
+	Path   string    `gorm:"uniqueIndex:idx_rule_condition;not null" json:"path"`
+	Since  time.Time `gorm:"not null" json:"since"`
+}
+
+// RuleExecution records one evaluation of an automation rule: the inputs its
+// trigger saw, what the engine decided and the transactions it requested
+type RuleExecution struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+
+	RuleID uint `gorm:"index;not null" json:"rule_id"`
+	UserID uint `gorm:"index;not null" json:"user_id"`
+
+	Source     string                 `gorm:"not null" json:"source"` // poll, or the on-chain event that caused the evaluation
+	Inputs     map[string]interface{} `gorm:"type:jsonb;serializer:json" json:"inputs"`
+	Triggered  bool                   `json:"triggered"`
+	Value      float64                `json:"value"` // metric compared against the threshold
+	Decision   string                 `gorm:"not null;index" json:"decision"`
+	Reason     string                 `json:"reason,omitempty"`
+	Actions    []ExecutionStep        `gorm:"type:jsonb;serializer:json" json:"actions,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	DurationMs int64                  `json:"duration_ms"`
+	JobID      *uint                  `gorm:"index" json:"job_id,omitempty"` // action job enqueued when the rule fired
+}
+
+// ExecutionStep is a transaction the engine asked the user's wallet to sign,
+// or in a dry run, simulated
+type ExecutionStep struct {
+	Description string  `json:"description"`
+	Chain       string  `json:"chain"`
+	From        string  `json:"from"`
+	To          string  `json:"to"`
+	Nonce       *uint64 `json:"nonce,omitempty"`
+	GasUsed     uint64  `json:"gas_used,omitempty"`   // simulated
+	RequestID   int64   `json:"request_id,omitempty"` // WalletConnect request
+	Status      string  `json:"status"`               // simulated, reverted, requested, failed
+	Error       string  `json:"error,omitempty"`
+}
+
+// Rule execution decisions
+const (
+	DecisionFired        = "fired"         // trigger held and the action was queued
+	DecisionNotTriggered = "not_triggered" // trigger did not hold
+	DecisionSkipped      = "skipped"       // cooldown or execution cap reached
+	DecisionDisarmed     = "disarmed"      // waiting for the metric to recover
+	DecisionRearmed      = "rearmed"       // metric recovered, rule armed again
+	DecisionError        = "error"         // trigger could not be checked
+	DecisionFailed       = "failed"        // trigger held but the action failed
+)
+
+// MetricSample is a market or account metric the automation engine observed,
+// kept as history for rule backtests
+type MetricSample struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Metric   string `gorm:"not null;index:idx_metric_series" json:"metric"` // apy, price, utilization, gas_price, health_factor, liquidation_risk
+	Chain    string `gorm:"index:idx_metric_series" json:"chain,omitempty"`
+	Protocol string `gorm:"index:idx_metric_series" json:"protocol,omitempty"`
+	Asset    string `gorm:"index:idx_metric_series" json:"asset,omitempty"`
+	Account  string `gorm:"index:idx_metric_series" json:"account,omitempty"` // lower-case wallet address, for account metrics
+
+	Value       float64   `json:"value"`
+	BlockNumber uint64    `json:"block_number,omitempty"`
+	SampledAt   time.Time `gorm:"not null;index:idx_metric_series" json:"sampled_at"`
+}
+
+// EngineWorker is a running automation engine replica. Replicas heartbeat
+// their row and split the rules between the ones whose heartbeat is recent.
+type EngineWorker struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	WorkerID    string    `gorm:"uniqueIndex;not null" json:"worker_id"`
+	Hostname    string    `json:"hostname"`
+	HeartbeatAt time.Time `gorm:"index;not null" json:"heartbeat_at"`
+}
+
+// ActionJob is the action of a rule that fired, queued for the engine's job
+// workers. A job is retried with backoff until it succeeds or runs out of
+// attempts, when it is dead-lettered.
+type ActionJob struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// IdempotencyKey identifies the rule execution the job performs, so an
+	// execution can't be queued twice
+	IdempotencyKey string                 `gorm:"uniqueIndex;not null" json:"idempotency_key"`
+	RuleID         uint                   `gorm:"index;not null" json:"rule_id"`
+	UserID         uint                   `gorm:"index;not null" json:"user_id"`
+	ActionType     string                 `gorm:"not null" json:"action_type"`
+	ActionConfig   map[string]interface{} `gorm:"type:jsonb;serializer:json" json:"action_config"` // as when the rule fired
+
+	Status      string     `gorm:"not null;index:idx_job_queue" json:"status"` // queued, running, succeeded, dead
+	RunAt       time.Time  `gorm:"not null;index:idx_job_queue" json:"run_at"`
+	Attempts    int        `gorm:"not null;default:0" json:"attempts"`
+	MaxAttempts int        `gorm:"not null" json:"max_attempts"`
+	LockedBy    string     `json:"locked_by,omitempty"` // engine worker running the job
+	LockedAt    *time.Time `json:"locked_at,omitempty"`
+	// Lease is incremented every time the job is claimed or taken back from
+	// its worker. Unlike attempts it never goes back, so it tells a worker
+	// whether the job is still the one it claimed.
+	Lease int64 `gorm:"not null;default:0" json:"lease"`
+	// Dispatched is set once the job starts asking the wallet to sign. A
+	// dispatched job is never retried, so its transactions can't be sent twice.
+	Dispatched  bool            `gorm:"not null;default:false" json:"dispatched"`
+	Steps       []ExecutionStep `gorm:"type:jsonb;serializer:json" json:"steps,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+}
+
+// Action job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead" // out of attempts, or failed after dispatch
+)
+
+// BridgeTransfer is a cross-chain rebalance in flight: funds bridged from
+// one chain, to be deposited on the other once they arrive. The engine moves
+// it through its statuses across restarts.
+type BridgeTransfer struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	RuleID uint  `gorm:"index;not null" json:"rule_id"`
+	UserID uint  `gorm:"index;not null" json:"user_id"`
+	JobID  *uint `gorm:"index" json:"job_id,omitempty"`
+
+	Bridge    string `gorm:"not null" json:"bridge"`
+	Asset     string `gorm:"not null" json:"asset"`
+	FromChain string `gorm:"not null" json:"from_chain"`
+	ToChain   string `gorm:"not null" json:"to_chain"`
+	Amount    string `gorm:"not null" json:"amount"`     // base units sent
+	AmountOut string `gorm:"not null" json:"amount_out"` // base units expected to arrive
+	Sender    string `gorm:"not null" json:"sender"`
+	// SourceNonce is the nonce of the bridge transaction on FromChain, which
+	// finds it once the wallet has sent it
+	SourceNonce  *uint64 `json:"source_nonce,omitempty"`
+	SourceTxHash string  `json:"source_tx_hash,omitempty"`
+	// ToProtocol receives the deposit that completes the rebalance
+	ToProtocol      string    `gorm:"not null" json:"to_protocol"`
+	ExpectedArrival time.Time `json:"expected_arrival"`
+	// AllowUntraced carries the rule's allow_untraced to the destination deposit
+	AllowUntraced bool `json:"allow_untraced"`
+
+	Status      string          `gorm:"not null;index" json:"status"` // sending, in_flight, completed, failed
+	Steps       []ExecutionStep `gorm:"type:jsonb;serializer:json" json:"steps,omitempty"` // claim and deposit requests
+	LastError   string          `json:"last_error,omitempty"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+}
+
+// Bridge transfer statuses
+const (
+	TransferSending   = "sending"   // bridge transaction requested, not yet confirmed
+	TransferInFlight  = "in_flight" // bridge transaction confirmed, funds not yet deposited
+	TransferCompleted = "completed" // destination deposit requested
+	TransferFailed    = "failed"
+)
@@ -0,0 +1,152 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// maxConditionDepth bounds how deeply condition trees may nest
+const maxConditionDepth = 8
+
+// Condition is a node of a composite trigger's condition tree. A node is
+// either a combinator (And, Or or Not) or a leaf trigger with its own config,
+// written in JSON as:
+//
+//	{"and": [...]}, {"or": [...]}, {"not": {...}}
+//	{"type": "health_factor", "threshold": 1.3, "protocol": "aave"}
+//
+// Any node may also carry "for": "6h", meaning it only counts as true once it
+// has held continuously for that long.
+type Condition struct {
+	And []*Condition
+	Or  []*Condition
+	Not *Condition
+
+	Type   string                 // leaf trigger type
+	Config map[string]interface{} // leaf trigger config
+
+	For  time.Duration
+	Path string // position in the tree, e.g. "and.1.not"; keys held-since state
+}
+
+// ParseCondition parses and validates a condition tree from its decoded JSON
+func ParseCondition(raw interface{}) (*Condition, error) {
+	if raw == nil {
+		return nil, errors.New("condition is required")
+	}
+	return parseCondition(raw, "root", 0)
+}
+
+func parseCondition(raw interface{}, path string, depth int) (*Condition, error) {
+	if depth > maxConditionDepth {
+		return nil, fmt.Errorf("%s: conditions nest deeper than %d levels", path, maxConditionDepth)
+	}
+
+	node, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: condition must be an object", path)
+	}
+
+	c := &Condition{Path: path}
+
+	if v, ok := node["for"]; ok {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: for must be a duration string such as \"6h\"", path)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%s: invalid for duration %q", path, s)
+		}
+		c.For = d
+	}
+
+	kinds := 0
+	for _, key := range []string{"and", "or", "not", "type"} {
+		if _, ok := node[key]; ok {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return nil, fmt.Errorf("%s: condition must have exactly one of and, or, not or type", path)
+	}
+
+	switch {
+	case node["and"] != nil:
+		children, err := parseChildren(node["and"], path+".and", depth)
+		if err != nil {
+			return nil, err
+		}
+		c.And = children
+
+	case node["or"] != nil:
+		children, err := parseChildren(node["or"], path+".or", depth)
+		if err != nil {
+			return nil, err
+		}
+		c.Or = children
+
+	case node["not"] != nil:
+		child, err := parseCondition(node["not"], path+".not", depth+1)
+		if err != nil {
+			return nil, err
+		}
+		c.Not = child
+
+	default:
+		leafType, _ := node["type"].(string)
+		if _, ok := triggerTypes[leafType]; !ok || leafType == "composite" {
+			return nil, fmt.Errorf("%s: unknown condition type %v", path, node["type"])
+		}
+		c.Type = leafType
+		c.Config = make(map[string]interface{}, len(node))
+		for k, v := range node {
+			if k != "type" && k != "for" {
+				c.Config[k] = v
+			}
+		}
+	}
+
+	return c, nil
+}
+
+func parseChildren(raw interface{}, path string, depth int) ([]*Condition, error) {
+	list, ok := raw.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("%s: must be a non-empty list of conditions", path)
+	}
+
+	children := make([]*Condition, 0, len(list))
+	for i, item := range list {
+		child, err := parseCondition(item, path+"."+strconv.Itoa(i), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+	return children, nil
+}
+
+// Leaves returns the leaf conditions of the tree in order
+func (c *Condition) Leaves() []*Condition {
+	switch {
+	case c.And != nil:
+		return leavesOf(c.And)
+	case c.Or != nil:
+		return leavesOf(c.Or)
+	case c.Not != nil:
+		return c.Not.Leaves()
+	default:
+		return []*Condition{c}
+	}
+}
+
+func leavesOf(children []*Condition) []*Condition {
+	var leaves []*Condition
+	for _, child := range children {
+		leaves = append(leaves, child.Leaves()...)
+	}
+	return leaves
+}
@@ -0,0 +1,89 @@
+package rules
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decode returns the condition tree as it arrives in a rule's config
+func decode(t *testing.T, s string) interface{} {
+	t.Helper()
+	var raw interface{}
+	if err := json.Unmarshal([]byte(s), &raw); err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestParseCondition(t *testing.T) {
+	c, err := ParseCondition(decode(t, `{"or": [
+		{"and": [
+			{"type": "health_factor", "protocol": "aave", "threshold": 1.3},
+			{"type": "price_change", "asset": "WETH", "threshold": -10, "window": "1h"}
+		]},
+		{"not": {"type": "gas_below", "threshold": 50}},
+		{"type": "apy_spread", "asset": "USDC", "threshold": 1.5, "for": "6h"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Or) != 3 || len(c.Or[0].And) != 2 || c.Or[1].Not == nil {
+		t.Fatalf("parsed %+v", c)
+	}
+	spread := c.Or[2]
+	if spread.Type != "apy_spread" || spread.For != 6*time.Hour || spread.Path != "root.or.2" {
+		t.Errorf("leaf = %+v", spread)
+	}
+	if _, ok := spread.Config["for"]; ok {
+		t.Error("for duration left in the leaf config")
+	}
+	if spread.Config["asset"] != "USDC" || spread.Config["threshold"] != 1.5 {
+		t.Errorf("leaf config = %v", spread.Config)
+	}
+
+	var paths []string
+	for _, leaf := range c.Leaves() {
+		paths = append(paths, leaf.Path+" "+leaf.Type)
+	}
+	want := "root.or.0.and.0 health_factor, root.or.0.and.1 price_change, root.or.1.not gas_below, root.or.2 apy_spread"
+	if got := strings.Join(paths, ", "); got != want {
+		t.Errorf("leaves = %s, want %s", got, want)
+	}
+}
+
+func TestParseConditionRejects(t *testing.T) {
+	nested := `{"type": "gas_below", "threshold": 50}`
+	for i := 0; i <= maxConditionDepth; i++ {
+		nested = `{"not": ` + nested + `}`
+	}
+
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"missing", `null`, "condition is required"},
+		{"not an object", `[]`, "root: condition must be an object"},
+		{"empty", `{}`, "root: condition must have exactly one of"},
+		{"two kinds", `{"and": [{"type": "gas_below"}], "type": "gas_below"}`, "root: condition must have exactly one of"},
+		{"empty list", `{"and": []}`, "root.and: must be a non-empty list"},
+		{"not a list", `{"or": {"type": "gas_below"}}`, "root.or: must be a non-empty list"},
+		{"unknown type", `{"and": [{"type": "gas_below"}, {"type": "moon_phase"}]}`, "root.and.1: unknown condition type moon_phase"},
+		{"nested composite", `{"not": {"type": "composite"}}`, "root.not: unknown condition type composite"},
+		{"invalid for", `{"type": "gas_below", "for": "soon"}`, `root: invalid for duration "soon"`},
+		{"negative for", `{"type": "gas_below", "for": "-1h"}`, `root: invalid for duration "-1h"`},
+		{"numeric for", `{"type": "gas_below", "for": 3600}`, "root: for must be a duration string"},
+		{"too deep", nested, "conditions nest deeper than 8 levels"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCondition(decode(t, tt.raw))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
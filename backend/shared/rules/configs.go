@@ -0,0 +1,285 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Trigger configs
+
+// APYDropTrigger fires when a protocol's supply APY falls below the threshold
+type APYDropTrigger struct {
+	Protocol  string  `json:"protocol" rule:"required,enum=aave|compound|eigenlayer"`
+	Asset     string  `json:"asset" rule:"required,enum=$assets"`
+	Chain     string  `json:"chain,omitempty" rule:"enum=$chains,default=ethereum"`
+	Threshold float64 `json:"threshold" rule:"required,xmin=0,max=100" description:"Supply APY in percent"`
+}
+
+func (c *APYDropTrigger) Validate() error { return checkAsset(c.Chain, c.Asset) }
+
+// HealthFactorTrigger fires when the user's health factor falls below the threshold
+type HealthFactorTrigger struct {
+	Protocol  string  `json:"protocol,omitempty" rule:"enum=aave|compound,default=aave"`
+	Chain     string  `json:"chain,omitempty" rule:"enum=$chains,default=ethereum"`
+	Threshold float64 `json:"threshold" rule:"required,xmin=1,max=10" description:"Health factor; positions are liquidated below 1"`
+}
+
+// RiskThresholdTrigger fires when the ML liquidation risk rises above the threshold
+type RiskThresholdTrigger struct {
+	Chain     string  `json:"chain,omitempty" rule:"enum=$chains,default=ethereum"`
+	Threshold float64 `json:"threshold" rule:"required,xmin=0,max=1" description:"Liquidation probability from 0 to 1"`
+}
+
+// PriceChangeTrigger fires when an asset's oracle price moves by the threshold over the window
+type PriceChangeTrigger struct {
+	Asset     string  `json:"asset" rule:"required,enum=$assets"`
+	Chain     string  `json:"chain,omitempty" rule:"enum=$chains,default=ethereum"`
+	Window    string  `json:"window" rule:"required,format=duration" description:"Lookback window, e.g. 1h"`
+	Threshold float64 `json:"threshold" rule:"required,xmin=-100,max=1000" description:"Percent change; negative values watch for drops"`
+}
+
+func (c *PriceChangeTrigger) Validate() error {
+	if window, _ := time.ParseDuration(c.Window); window < time.Minute || window > 7*24*time.Hour {
+		return errors.New("window must be between 1m and 168h")
+	}
+	return checkAsset(c.Chain, c.Asset)
+}
+
+// WindowDuration returns the parsed window
+func (c *PriceChangeTrigger) WindowDuration() time.Duration {
+	d, _ := time.ParseDuration(c.Window)
+	return d
+}
+
+// APYSpreadTrigger fires when the best alternative pays more than the threshold above the protocol
+type APYSpreadTrigger struct {
+	Protocol     string   `json:"protocol" rule:"required,enum=aave|compound|eigenlayer" description:"Protocol the funds are in"`
+	Asset        string   `json:"asset" rule:"required,enum=$assets"`
+	Chain        string   `json:"chain,omitempty" rule:"enum=$chains,default=ethereum"`
+	Threshold    float64  `json:"threshold" rule:"required,xmin=0,max=100" description:"Spread in percentage points"`
+	Alternatives []string `json:"alternatives,omitempty" rule:"enum=aave|compound|eigenlayer" description:"Protocols to compare against; defaults to aave and compound"`
+}
+
+func (c *APYSpreadTrigger) Validate() error { return checkAsset(c.Chain, c.Asset) }
+
+// UtilizationTrigger fires when a reserve's utilization rises above the threshold
+type UtilizationTrigger struct {
+	Protocol  string  `json:"protocol" rule:"required,enum=aave|compound"`
+	Asset     string  `json:"asset" rule:"required,enum=$assets"`
+	Chain     string  `json:"chain,omitempty" rule:"enum=$chains,default=ethereum"`
+	Threshold float64 `json:"threshold" rule:"required,xmin=0,max=100" description:"Utilization in percent"`
+}
+
+func (c *UtilizationTrigger) Validate() error { return checkAsset(c.Chain, c.Asset) }
+
+// GasBelowTrigger fires while the expected gas price is below the threshold
+type GasBelowTrigger struct {
+	Chain     string  `json:"chain,omitempty" rule:"enum=$chains,default=ethereum"`
+	Threshold float64 `json:"threshold" rule:"required,xmin=0,max=10000" description:"Gas price in gwei"`
+}
+
+// ScheduleTrigger fires on a cron schedule
+type ScheduleTrigger struct {
+	Cron     string `json:"cron" rule:"required,format=cron" description:"Five-field cron expression or @hourly, @daily, @weekly, @monthly"`
+	Timezone string `json:"timezone,omitempty" rule:"default=UTC" description:"IANA time zone the schedule is read in"`
+}
+
+func (c *ScheduleTrigger) Validate() error {
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone: %s", c.Timezone)
+	}
+	return nil
+}
+
+// Location returns the schedule's time zone
+func (c *ScheduleTrigger) Location() *time.Location {
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// CompositeTrigger combines other triggers in a condition tree
+type CompositeTrigger struct {
+	Condition map[string]interface{} `json:"condition" rule:"required" description:"Condition tree of and, or, not and trigger leaves"`
+}
+
+func (c *CompositeTrigger) Validate() error {
+	root, err := ParseCondition(c.Condition)
+	if err != nil {
+		return err
+	}
+	for _, leaf := range root.Leaves() {
+		config, err := NewTriggerConfig(leaf.Type)
+		if err != nil {
+			return fmt.Errorf("%s: %w", leaf.Path, err)
+		}
+		if err := Decode(leaf.Config, config); err != nil {
+			return fmt.Errorf("%s: %w", leaf.Path, err)
+		}
+	}
+	return nil
+}
+
+// Action configs
+
+// RebalanceAction moves funds from one protocol to another, swapping them
+// on the way when ToAsset differs from Asset, or bridging them when ToChain
+// differs from Chain
+type RebalanceAction struct {
+	FromProtocol  string  `json:"from_protocol" rule:"required,enum=aave|compound"`
+	ToProtocol    string  `json:"to_protocol" rule:"required,enum=aave|compound"`
+	Asset         string  `json:"asset" rule:"required,enum=$assets"`
+	ToAsset       string  `json:"to_asset,omitempty" rule:"enum=$assets" description:"Asset to deposit into to_protocol; defaults to asset"`
+	Chain         string  `json:"chain,omitempty" rule:"enum=$chains,default=ethereum"`
+	ToChain       string  `json:"to_chain,omitempty" rule:"enum=$chains" description:"Chain of to_protocol; defaults to chain"`
+	Bridge        string  `json:"bridge,omitempty" rule:"enum=cctp|base_canonical" description:"Bridge for a cross-chain rebalance; defaults to the cheapest, then fastest"`
+	HorizonDays   float64 `json:"horizon_days,omitempty" rule:"min=0,max=365" description:"Days the funds are expected to stay, to weigh a cross-chain rebalance's yield gain against bridge fees, gas and transit time; defaults to 30"`
+	Amount        float64 `json:"amount" rule:"required,xmin=0" description:"Amount of asset in token units"`
+	SlippageBps   int     `json:"slippage_bps,omitempty" rule:"min=0,max=300" description:"Tolerated swap slippage in basis points; defaults to 50"`
+	MaxDeviation  float64 `json:"max_deviation,omitempty" rule:"xmin=0,max=0.5" description:"Tolerated relative difference between simulated and expected balance changes"`
+	AllowUntraced bool    `json:"allow_untraced,omitempty" description:"Submit when the node can't trace balance changes, relying on the simulation succeeding alone"`
+}
+
+func (c *RebalanceAction) Validate() error {
+	if c.ToAsset == "" {
+		c.ToAsset = c.Asset
+	}
+	if c.ToChain == "" {
+		c.ToChain = c.Chain
+	}
+	if c.FromProtocol == c.ToProtocol && c.ToAsset == c.Asset && c.ToChain == c.Chain {
+		return errors.New("from_protocol and to_protocol must differ")
+	}
+	if c.Swaps() && c.Bridges() {
+		return errors.New("a rebalance can't both swap and bridge")
+	}
+	if err := checkAsset(c.Chain, c.Asset); err != nil {
+		return err
+	}
+	return checkAsset(c.ToChain, c.ToAsset)
+}
+
+// Swaps reports whether the rebalance swaps between assets
+func (c *RebalanceAction) Swaps() bool { return c.ToAsset != c.Asset }
+
+// Bridges reports whether the rebalance moves funds between chains
+func (c *RebalanceAction) Bridges() bool { return c.ToChain != c.Chain }
+
+// WithdrawAction withdraws funds from a protocol
+type WithdrawAction struct {
+	Protocol      string  `json:"protocol" rule:"required,enum=aave|compound"`
+	Asset         string  `json:"asset" rule:"required,enum=$assets"`
+	Chain         string  `json:"chain,omitempty" rule:"enum=$chains,default=ethereum"`
+	Amount        float64 `json:"amount" rule:"required,xmin=0" description:"Amount in token units"`
+	MaxDeviation  float64 `json:"max_deviation,omitempty" rule:"xmin=0,max=0.5" description:"Tolerated relative difference between simulated and expected balance changes"`
+	AllowUntraced bool    `json:"allow_untraced,omitempty" description:"Submit when the node can't trace balance changes, relying on the simulation succeeding alone"`
+}
+
+func (c *WithdrawAction) Validate() error { return checkAsset(c.Chain, c.Asset) }
+
+// DepositAction deposits funds into a protocol
+type DepositAction struct {
+	Protocol      string  `json:"protocol" rule:"required,enum=aave|compound"`
+	Asset         string  `json:"asset" rule:"required,enum=$assets"`
+	Chain         string  `json:"chain,omitempty" rule:"enum=$chains,default=ethereum"`
+	Amount        float64 `json:"amount" rule:"required,xmin=0" description:"Amount in token units"`
+	AllowPartial  bool    `json:"allow_partial,omitempty" description:"Deposit the wallet balance when it is below amount"`
+	MaxDeviation  float64 `json:"max_deviation,omitempty" rule:"xmin=0,max=0.5" description:"Tolerated relative difference between simulated and expected balance changes"`
+	AllowUntraced bool    `json:"allow_untraced,omitempty" description:"Submit when the node can't trace balance changes, relying on the simulation succeeding alone"`
+}
+
+func (c *DepositAction) Validate() error { return checkAsset(c.Chain, c.Asset) }
+
+// DeleverageAction repays debt until the health factor reaches a target
+type DeleverageAction struct {
+	Protocol           string  `json:"protocol,omitempty" rule:"enum=aave,default=aave"`
+	Chain              string  `json:"chain,omitempty" rule:"enum=$chains,default=ethereum"`
+	DebtAsset          string  `json:"debt_asset" rule:"required,enum=$assets" description:"Borrowed asset to repay"`
+	TargetHealthFactor float64 `json:"target_health_factor" rule:"required,xmin=1,max=10" description:"Health factor the repayment restores"`
+	Source             string  `json:"source,omitempty" rule:"enum=wallet|collateral,default=wallet" description:"Repay with the debt asset in the wallet, or with the debt asset supplied as collateral"`
+	MaxAmount          float64 `json:"max_amount,omitempty" rule:"min=0" description:"Most debt asset repaid per execution, in token units; 0 for no limit"`
+	MaxDeviation       float64 `json:"max_deviation,omitempty" rule:"xmin=0,max=0.5" description:"Tolerated relative difference between simulated and expected balance changes"`
+	AllowUntraced      bool    `json:"allow_untraced,omitempty" description:"Submit when the node can't trace balance changes, relying on the simulation succeeding alone"`
+}
+
+func (c *DeleverageAction) Validate() error { return checkAsset(c.Chain, c.DebtAsset) }
+
+// configType is a trigger or action type and its config struct
+type configType struct {
+	description string
+	config      func() interface{}
+}
+
+var triggerTypes = map[string]configType{
+	"apy_drop":       {"Supply APY falls below a threshold", func() interface{} { return &APYDropTrigger{} }},
+	"health_factor":  {"Health factor falls below a threshold", func() interface{} { return &HealthFactorTrigger{} }},
+	"risk_threshold": {"ML liquidation risk rises above a threshold", func() interface{} { return &RiskThresholdTrigger{} }},
+	"price_change":   {"Oracle price moves by a percentage over a window", func() interface{} { return &PriceChangeTrigger{} }},
+	"apy_spread":     {"Best alternative APY exceeds the current protocol's by a margin", func() interface{} { return &APYSpreadTrigger{} }},
+	"utilization":    {"Reserve utilization rises above a threshold", func() interface{} { return &UtilizationTrigger{} }},
+	"gas_below":      {"Gas price is below a threshold", func() interface{} { return &GasBelowTrigger{} }},
+	"schedule":       {"Cron schedule", func() interface{} { return &ScheduleTrigger{} }},
+	"composite":      {"Conditions combined with and, or and not", func() interface{} { return &CompositeTrigger{} }},
+}
+
+var actionTypes = map[string]configType{
+	"rebalance":  {"Move funds between protocols", func() interface{} { return &RebalanceAction{} }},
+	"withdraw":   {"Withdraw funds from a protocol", func() interface{} { return &WithdrawAction{} }},
+	"deposit":    {"Deposit funds into a protocol", func() interface{} { return &DepositAction{} }},
+	"deleverage": {"Repay debt to restore a target health factor", func() interface{} { return &DeleverageAction{} }},
+}
+
+// NewTriggerConfig returns an empty config struct for a trigger type
+func NewTriggerConfig(triggerType string) (interface{}, error) {
+	t, ok := triggerTypes[triggerType]
+	if !ok {
+		return nil, fmt.Errorf("unknown trigger type: %s", triggerType)
+	}
+	return t.config(), nil
+}
+
+// NewActionConfig returns an empty config struct for an action type
+func NewActionConfig(actionType string) (interface{}, error) {
+	t, ok := actionTypes[actionType]
+	if !ok {
+		return nil, fmt.Errorf("unknown action type: %s", actionType)
+	}
+	return t.config(), nil
+}
+
+// ValidateTrigger checks a trigger config against its trigger type's schema
+func ValidateTrigger(triggerType string, config map[string]interface{}) error {
+	c, err := NewTriggerConfig(triggerType)
+	if err != nil {
+		return err
+	}
+	return Decode(config, c)
+}
+
+// ValidateAction checks an action config against its action type's schema
+func ValidateAction(actionType string, config map[string]interface{}) error {
+	c, err := NewActionConfig(actionType)
+	if err != nil {
+		return err
+	}
+	return Decode(config, c)
+}
+
+// FiresAbove reports whether a trigger fires when its metric rises above the
+// threshold rather than falling below it. ok is false for triggers without a
+// single metric to apply a reset threshold to.
+func FiresAbove(triggerType string, threshold float64) (above bool, ok bool) {
+	switch triggerType {
+	case "apy_drop", "health_factor", "gas_below":
+		return false, true
+	case "risk_threshold", "apy_spread", "utilization":
+		return true, true
+	case "price_change":
+		// Negative thresholds watch for drops, positive ones for rises
+		return threshold > 0, true
+	}
+	return false, false
+}
@@ -0,0 +1,75 @@
+package rules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronRejects(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@reboot",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) accepted", expr)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", at("2024-03-01 10:07"), at("2024-03-01 10:15")},
+		{"*/15 * * * *", at("2024-03-01 10:15"), at("2024-03-01 10:30")},
+		{"@hourly", at("2024-03-01 23:59"), at("2024-03-02 00:00")},
+		{"0 9 * * 1-5", at("2024-03-01 09:00"), at("2024-03-04 09:00")}, // Friday to Monday
+		{"30 8 1,15 * *", at("2024-03-02 00:00"), at("2024-03-15 08:30")},
+		{"0 0 * * 7", at("2024-03-01 00:00"), at("2024-03-03 00:00")}, // 7 is Sunday
+		// Both day fields restricted: either matching is enough
+		{"0 0 13 * 5", at("2024-03-02 00:00"), at("2024-03-08 00:00")},
+		{"0 0 29 2 *", at("2024-03-01 00:00"), at("2028-02-29 00:00")},
+		{"0 0 31 2 *", at("2024-03-01 00:00"), time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+		}
+		if got := s.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q after %s = %s, want %s", tt.expr, tt.from, got, tt.want)
+		}
+	}
+
+	// Schedules are read in the location of the time given
+	s, _ := ParseCron("0 9 * * *")
+	got := s.Next(time.Date(2024, 3, 1, 12, 0, 0, 0, berlin))
+	if want := time.Date(2024, 3, 2, 9, 0, 0, 0, berlin); !got.Equal(want) || got.Location() != berlin {
+		t.Fatalf("Next in Berlin = %s, want %s", got, want)
+	}
+}
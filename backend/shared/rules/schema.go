@@ -0,0 +1,376 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/defioptimization/shared/tokens"
+)
+
+// Config structs describe their fields with a rule tag, from which both the
+// JSON Schema and the validation are derived:
+//
+//	required       the field must be set
+//	min=N, max=N   inclusive numeric bounds
+//	xmin=N         exclusive lower bound
+//	enum=a|b       allowed values; $chains and $assets expand to the registry
+//	default=V      value used when the field is omitted
+//	format=F       duration or cron
+//
+// and with a description tag shown to users.
+type fieldRule struct {
+	required bool
+	min, max *float64
+	xmin     *float64
+	enum     []string
+	def      string
+	format   string
+}
+
+func parseFieldRule(tag string) fieldRule {
+	var r fieldRule
+	for _, part := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "required":
+			r.required = true
+		case "min", "max", "xmin":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				panic("invalid rule tag bound: " + part)
+			}
+			switch key {
+			case "min":
+				r.min = &n
+			case "max":
+				r.max = &n
+			default:
+				r.xmin = &n
+			}
+		case "enum":
+			r.enum = expandEnum(value)
+		case "default":
+			r.def = value
+		case "format":
+			r.format = value
+		}
+	}
+	return r
+}
+
+// expandEnum resolves an enum tag value into its allowed values
+func expandEnum(value string) []string {
+	switch value {
+	case "$chains":
+		return tokens.Chains()
+	case "$assets":
+		seen := make(map[string]bool)
+		var symbols []string
+		for _, chain := range tokens.Chains() {
+			for _, t := range tokens.List(chain) {
+				if !seen[t.Symbol] {
+					seen[t.Symbol] = true
+					symbols = append(symbols, t.Symbol)
+				}
+			}
+		}
+		sort.Strings(symbols)
+		return symbols
+	}
+	return strings.Split(value, "|")
+}
+
+// jsonName returns a field's JSON key, or "" for fields JSON ignores
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" || !f.IsExported() {
+		return ""
+	}
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// Decode decodes a rule config into its typed struct, applies defaults and
+// validates it. Unknown keys are rejected so typos don't pass silently.
+func Decode(config map[string]interface{}, out interface{}) error {
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(out); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	v := reflect.ValueOf(out).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := jsonName(field)
+		if name == "" {
+			continue
+		}
+		r := parseFieldRule(field.Tag.Get("rule"))
+		value := v.Field(i)
+		_, present := config[name]
+
+		if !present && r.def != "" && value.Kind() == reflect.String {
+			value.SetString(r.def)
+		}
+		if err := checkField(name, r, value, present); err != nil {
+			return err
+		}
+	}
+
+	if v, ok := out.(interface{ Validate() error }); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// checkField validates one decoded field against its rule tag
+func checkField(name string, r fieldRule, value reflect.Value, present bool) error {
+	if r.required && (!present || value.IsZero()) {
+		return fmt.Errorf("%s is required", name)
+	}
+	if !present {
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.Float64, reflect.Int:
+		n := value.Convert(reflect.TypeOf(float64(0))).Float()
+		if r.min != nil && n < *r.min {
+			return fmt.Errorf("%s must be at least %g", name, *r.min)
+		}
+		if r.xmin != nil && n <= *r.xmin {
+			return fmt.Errorf("%s must be above %g", name, *r.xmin)
+		}
+		if r.max != nil && n > *r.max {
+			return fmt.Errorf("%s must be at most %g", name, *r.max)
+		}
+
+	case reflect.String:
+		s := value.String()
+		if len(r.enum) > 0 && !contains(r.enum, s) {
+			return fmt.Errorf("%s must be one of %s", name, strings.Join(r.enum, ", "))
+		}
+		switch r.format {
+		case "duration":
+			if _, err := time.ParseDuration(s); err != nil {
+				return fmt.Errorf("%s must be a duration such as \"1h\"", name)
+			}
+		case "cron":
+			if _, err := ParseCron(s); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.String && len(r.enum) > 0 {
+			if value.Len() == 0 {
+				return fmt.Errorf("%s must not be empty", name)
+			}
+			for i := 0; i < value.Len(); i++ {
+				if s := value.Index(i).String(); !contains(r.enum, s) {
+					return fmt.Errorf("%s: %s must be one of %s", name, s, strings.Join(r.enum, ", "))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Schema returns the JSON Schema of a config struct
+func Schema(config interface{}) map[string]interface{} {
+	t := reflect.TypeOf(config)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	properties := make(map[string]interface{})
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := jsonName(field)
+		if name == "" {
+			continue
+		}
+		r := parseFieldRule(field.Tag.Get("rule"))
+		if r.required {
+			required = append(required, name)
+		}
+		properties[name] = fieldSchema(field, r)
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func fieldSchema(field reflect.StructField, r fieldRule) map[string]interface{} {
+	s := make(map[string]interface{})
+	if d := field.Tag.Get("description"); d != "" {
+		s["description"] = d
+	}
+
+	switch field.Type.Kind() {
+	case reflect.Float64:
+		s["type"] = "number"
+	case reflect.Int:
+		s["type"] = "integer"
+	case reflect.Bool:
+		s["type"] = "boolean"
+	case reflect.String:
+		s["type"] = "string"
+	case reflect.Slice:
+		items := map[string]interface{}{"type": "string"}
+		if len(r.enum) > 0 {
+			items["enum"] = r.enum
+		}
+		s["type"] = "array"
+		s["items"] = items
+		s["minItems"] = 1
+		return s
+	case reflect.Map:
+		s["type"] = "object"
+	}
+
+	if len(r.enum) > 0 {
+		s["enum"] = r.enum
+	}
+	if r.min != nil {
+		s["minimum"] = *r.min
+	}
+	if r.xmin != nil {
+		s["exclusiveMinimum"] = *r.xmin
+	}
+	if r.max != nil {
+		s["maximum"] = *r.max
+	}
+	if r.def != "" {
+		s["default"] = r.def
+	}
+	if r.format != "" {
+		s["format"] = r.format
+	}
+	return s
+}
+
+// Schemas returns the JSON Schema of every trigger and action config, keyed
+// by type, for clients to build rule forms from
+func Schemas() map[string]interface{} {
+	triggers := make(map[string]interface{}, len(triggerTypes))
+	names := make([]string, 0, len(triggerTypes))
+	for name := range triggerTypes {
+		if name != "composite" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var leaves []interface{}
+	for _, name := range names {
+		t := triggerTypes[name]
+		schema := Schema(t.config())
+		schema["title"] = name
+		schema["description"] = t.description
+		triggers[name] = schema
+		leaves = append(leaves, leafSchema(name, schema))
+	}
+
+	// Condition trees are recursive, so the composite schema refers to a
+	// shared definition of a node
+	composite := Schema(triggerTypes["composite"].config())
+	composite["title"] = "composite"
+	composite["description"] = triggerTypes["composite"].description
+	composite["properties"] = map[string]interface{}{
+		"condition": map[string]interface{}{"$ref": "#/$defs/condition"},
+	}
+	node := func(key string, value map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           map[string]interface{}{key: value, "for": durationSchema},
+			"required":             []string{key},
+			"additionalProperties": false,
+		}
+	}
+	list := map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/$defs/condition"}, "minItems": 1}
+	composite["$defs"] = map[string]interface{}{
+		"condition": map[string]interface{}{
+			"oneOf": append([]interface{}{
+				node("and", list),
+				node("or", list),
+				node("not", map[string]interface{}{"$ref": "#/$defs/condition"}),
+			}, leaves...),
+		},
+	}
+	triggers["composite"] = composite
+
+	actions := make(map[string]interface{}, len(actionTypes))
+	for name, t := range actionTypes {
+		schema := Schema(t.config())
+		schema["title"] = name
+		schema["description"] = t.description
+		actions[name] = schema
+	}
+
+	return map[string]interface{}{
+		"$schema":  "https://json-schema.org/draft/2020-12/schema",
+		"triggers": triggers,
+		"actions":  actions,
+	}
+}
+
+var durationSchema = map[string]interface{}{
+	"type":        "string",
+	"format":      "duration",
+	"description": "How long the condition must hold, e.g. 6h",
+}
+
+// leafSchema extends a trigger schema with the type and for keys it carries
+// as a leaf of a condition tree
+func leafSchema(name string, schema map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{
+		"type": map[string]interface{}{"const": name},
+		"for":  durationSchema,
+	}
+	for k, v := range schema["properties"].(map[string]interface{}) {
+		properties[k] = v
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             append([]string{"type"}, schema["required"].([]string)...),
+		"additionalProperties": false,
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAsset checks that an asset is listed on a chain
+func checkAsset(chain, asset string) error {
+	if _, ok := tokens.Lookup(chain, asset); !ok {
+		return fmt.Errorf("asset %s not supported on %s", asset, chain)
+	}
+	return nil
+}
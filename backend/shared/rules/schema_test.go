@@ -0,0 +1,203 @@
+package rules
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// cfg is shorthand for a decoded JSON config
+type cfg = map[string]interface{}
+
+func TestValidateTrigger(t *testing.T) {
+	tests := []struct {
+		name    string
+		trigger string
+		config  cfg
+		want    string // substring of the error, or "" for a valid config
+	}{
+		{"apy_drop", "apy_drop", cfg{"protocol": "aave", "asset": "USDC", "threshold": 3.5}, ""},
+		{"apy_drop on base", "apy_drop", cfg{"protocol": "aave", "asset": "USDC", "chain": "base", "threshold": 3.5}, ""},
+		{"missing threshold", "apy_drop", cfg{"protocol": "aave", "asset": "USDC"}, "threshold is required"},
+		{"threshold at exclusive minimum", "apy_drop", cfg{"protocol": "aave", "asset": "USDC", "threshold": 0}, "threshold is required"},
+		{"threshold below exclusive minimum", "apy_drop", cfg{"protocol": "aave", "asset": "USDC", "threshold": -1}, "threshold must be above 0"},
+		{"threshold above maximum", "apy_drop", cfg{"protocol": "aave", "asset": "USDC", "threshold": 101}, "threshold must be at most 100"},
+		{"unknown protocol", "apy_drop", cfg{"protocol": "maker", "asset": "USDC", "threshold": 3}, "protocol must be one of"},
+		{"unknown chain", "apy_drop", cfg{"protocol": "aave", "asset": "USDC", "chain": "solana", "threshold": 3}, "chain must be one of"},
+		{"asset not on chain", "apy_drop", cfg{"protocol": "aave", "asset": "USDT", "chain": "base", "threshold": 3}, "USDT not supported on base"},
+		{"unknown key", "apy_drop", cfg{"protocol": "aave", "asset": "USDC", "treshold": 3}, "invalid config"},
+		{"wrong type", "apy_drop", cfg{"protocol": "aave", "asset": "USDC", "threshold": "3"}, "invalid config"},
+
+		{"health_factor defaults", "health_factor", cfg{"threshold": 1.5}, ""},
+		{"health_factor at 1", "health_factor", cfg{"threshold": 1}, "threshold must be above 1"},
+		{"health_factor on eigenlayer", "health_factor", cfg{"protocol": "eigenlayer", "threshold": 1.5}, "protocol must be one of"},
+
+		{"risk_threshold", "risk_threshold", cfg{"threshold": 0.4}, ""},
+		{"risk_threshold above 1", "risk_threshold", cfg{"threshold": 1.5}, "threshold must be at most 1"},
+
+		{"price_change drop", "price_change", cfg{"asset": "WETH", "window": "1h", "threshold": -10}, ""},
+		{"price_change bad window", "price_change", cfg{"asset": "WETH", "window": "an hour", "threshold": 10}, "window must be a duration"},
+		{"price_change short window", "price_change", cfg{"asset": "WETH", "window": "30s", "threshold": 10}, "between 1m and 168h"},
+		{"price_change long window", "price_change", cfg{"asset": "WETH", "window": "200h", "threshold": 10}, "between 1m and 168h"},
+
+		{"apy_spread", "apy_spread", cfg{"protocol": "compound", "asset": "USDC", "threshold": 1, "alternatives": []interface{}{"aave"}}, ""},
+		{"apy_spread empty alternatives", "apy_spread", cfg{"protocol": "compound", "asset": "USDC", "threshold": 1, "alternatives": []interface{}{}}, "alternatives must not be empty"},
+		{"apy_spread unknown alternative", "apy_spread", cfg{"protocol": "compound", "asset": "USDC", "threshold": 1, "alternatives": []interface{}{"maker"}}, "maker must be one of"},
+
+		{"utilization", "utilization", cfg{"protocol": "aave", "asset": "DAI", "threshold": 90}, ""},
+		{"gas_below", "gas_below", cfg{"threshold": 15}, ""},
+
+		{"schedule", "schedule", cfg{"cron": "0 9 * * 1-5", "timezone": "Europe/Berlin"}, ""},
+		{"schedule macro", "schedule", cfg{"cron": "@daily"}, ""},
+		{"schedule bad cron", "schedule", cfg{"cron": "61 * * * *"}, "cron: minute"},
+		{"schedule bad timezone", "schedule", cfg{"cron": "@daily", "timezone": "Mars/Olympus"}, "unknown timezone"},
+
+		{"composite", "composite", cfg{"condition": cfg{"and": []interface{}{
+			cfg{"type": "health_factor", "threshold": 1.3, "for": "10m"},
+			cfg{"not": cfg{"type": "gas_below", "threshold": 50}},
+		}}}, ""},
+		{"composite without condition", "composite", cfg{}, "condition is required"},
+		{"composite with invalid leaf", "composite", cfg{"condition": cfg{"or": []interface{}{
+			cfg{"type": "gas_below", "threshold": 50},
+			cfg{"type": "health_factor", "threshold": 0.5},
+		}}}, "root.or.1: threshold must be above 1"},
+		{"composite with unknown leaf", "composite", cfg{"condition": cfg{"type": "moon_phase"}}, "unknown condition type"},
+		{"composite nested composite", "composite", cfg{"condition": cfg{"type": "composite"}}, "unknown condition type"},
+		{"composite with two kinds", "composite", cfg{"condition": cfg{"and": []interface{}{}, "type": "gas_below"}}, "exactly one of"},
+
+		{"unknown trigger type", "moon_phase", cfg{}, "unknown trigger type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTrigger(tt.trigger, tt.config)
+			checkValidation(t, err, tt.want)
+		})
+	}
+}
+
+func TestValidateAction(t *testing.T) {
+	tests := []struct {
+		name   string
+		action string
+		config cfg
+		want   string
+	}{
+		{"rebalance", "rebalance", cfg{"from_protocol": "aave", "to_protocol": "compound", "asset": "USDC", "amount": 100}, ""},
+		{"rebalance swap", "rebalance", cfg{"from_protocol": "aave", "to_protocol": "aave", "asset": "USDC", "to_asset": "DAI", "amount": 100, "slippage_bps": 30}, ""},
+		{"rebalance bridge", "rebalance", cfg{"from_protocol": "aave", "to_protocol": "aave", "asset": "USDC", "to_chain": "base", "amount": 100}, ""},
+		{"rebalance to itself", "rebalance", cfg{"from_protocol": "aave", "to_protocol": "aave", "asset": "USDC", "amount": 100}, "must differ"},
+		{"rebalance swap and bridge", "rebalance", cfg{"from_protocol": "aave", "to_protocol": "aave", "asset": "USDC", "to_asset": "WETH", "to_chain": "base", "amount": 100}, "both swap and bridge"},
+		{"rebalance to unlisted asset", "rebalance", cfg{"from_protocol": "aave", "to_protocol": "aave", "asset": "USDC", "to_asset": "USDT", "chain": "base", "amount": 100}, "USDT not supported on base"},
+		{"rebalance slippage too high", "rebalance", cfg{"from_protocol": "aave", "to_protocol": "compound", "asset": "USDC", "amount": 100, "slippage_bps": 301}, "slippage_bps must be at most 300"},
+		{"rebalance without amount", "rebalance", cfg{"from_protocol": "aave", "to_protocol": "compound", "asset": "USDC"}, "amount is required"},
+
+		{"withdraw", "withdraw", cfg{"protocol": "compound", "asset": "USDC", "amount": 10, "allow_untraced": true}, ""},
+		{"withdraw negative deviation", "withdraw", cfg{"protocol": "compound", "asset": "USDC", "amount": 10, "max_deviation": -0.1}, "max_deviation must be above 0"},
+
+		{"deposit", "deposit", cfg{"protocol": "aave", "asset": "DAI", "amount": 10, "allow_partial": true}, ""},
+		{"deposit unknown protocol", "deposit", cfg{"protocol": "eigenlayer", "asset": "DAI", "amount": 10}, "protocol must be one of"},
+
+		{"deleverage", "deleverage", cfg{"debt_asset": "USDC", "target_health_factor": 1.8, "source": "collateral"}, ""},
+		{"deleverage unknown source", "deleverage", cfg{"debt_asset": "USDC", "target_health_factor": 1.8, "source": "flash_loan"}, "source must be one of"},
+		{"deleverage negative max", "deleverage", cfg{"debt_asset": "USDC", "target_health_factor": 1.8, "max_amount": -1}, "max_amount must be at least 0"},
+
+		{"unknown action type", "stake", cfg{}, "unknown action type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAction(tt.action, tt.config)
+			checkValidation(t, err, tt.want)
+		})
+	}
+}
+
+func checkValidation(t *testing.T, err error, want string) {
+	t.Helper()
+	if want == "" {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	if err == nil {
+		t.Fatalf("accepted, want error containing %q", want)
+	}
+	if !strings.Contains(err.Error(), want) {
+		t.Fatalf("error %q does not contain %q", err, want)
+	}
+}
+
+func TestDecodeDefaults(t *testing.T) {
+	var hf HealthFactorTrigger
+	if err := Decode(cfg{"threshold": 1.5}, &hf); err != nil {
+		t.Fatal(err)
+	}
+	if hf.Protocol != "aave" || hf.Chain != "ethereum" {
+		t.Fatalf("defaults not applied: %+v", hf)
+	}
+
+	var r RebalanceAction
+	if err := Decode(cfg{"from_protocol": "aave", "to_protocol": "compound", "asset": "USDC", "chain": "base", "amount": 5}, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.ToAsset != "USDC" || r.ToChain != "base" || r.Swaps() || r.Bridges() {
+		t.Fatalf("rebalance targets not defaulted to its source: %+v", r)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	schema := Schema(&APYDropTrigger{})
+	if schema["additionalProperties"] != false {
+		t.Fatal("schema allows unknown properties")
+	}
+	if got := schema["required"]; !reflect.DeepEqual(got, []string{"protocol", "asset", "threshold"}) {
+		t.Fatalf("required = %v", got)
+	}
+
+	properties := schema["properties"].(map[string]interface{})
+	threshold := properties["threshold"].(map[string]interface{})
+	if threshold["type"] != "number" || threshold["exclusiveMinimum"] != 0.0 || threshold["maximum"] != 100.0 {
+		t.Fatalf("threshold schema = %v", threshold)
+	}
+	chain := properties["chain"].(map[string]interface{})
+	if chain["default"] != "ethereum" || !contains(chain["enum"].([]string), "base") {
+		t.Fatalf("chain schema = %v", chain)
+	}
+	asset := properties["asset"].(map[string]interface{})
+	for _, symbol := range []string{"USDC", "USDT", "DAI", "WETH"} {
+		if !contains(asset["enum"].([]string), symbol) {
+			t.Fatalf("asset enum %v lacks %s", asset["enum"], symbol)
+		}
+	}
+
+	spread := Schema(&APYSpreadTrigger{})["properties"].(map[string]interface{})["alternatives"].(map[string]interface{})
+	if spread["type"] != "array" || spread["minItems"] != 1 {
+		t.Fatalf("alternatives schema = %v", spread)
+	}
+	cron := Schema(&ScheduleTrigger{})["properties"].(map[string]interface{})["cron"].(map[string]interface{})
+	if cron["format"] != "cron" {
+		t.Fatalf("cron schema = %v", cron)
+	}
+}
+
+func TestSchemas(t *testing.T) {
+	all := Schemas()
+	triggers := all["triggers"].(map[string]interface{})
+	actions := all["actions"].(map[string]interface{})
+	if len(triggers) != len(triggerTypes) || len(actions) != len(actionTypes) {
+		t.Fatalf("got %d triggers and %d actions, want %d and %d", len(triggers), len(actions), len(triggerTypes), len(actionTypes))
+	}
+
+	composite := triggers["composite"].(map[string]interface{})
+	condition := composite["$defs"].(map[string]interface{})["condition"].(map[string]interface{})
+	// and, or and not nodes, then one leaf per non-composite trigger
+	if got, want := len(condition["oneOf"].([]interface{})), 3+len(triggerTypes)-1; got != want {
+		t.Fatalf("condition has %d alternatives, want %d", got, want)
+	}
+
+	leaf := condition["oneOf"].([]interface{})[3].(map[string]interface{})
+	required := leaf["required"].([]string)
+	if required[0] != "type" {
+		t.Fatalf("leaf schema doesn't require type: %v", required)
+	}
+}
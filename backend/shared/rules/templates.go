@@ -0,0 +1,119 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// RuleSpec is the rule a template creates
+type RuleSpec struct {
+	Name            string                 `json:"name"`
+	Description     string                 `json:"description"`
+	TriggerType     string                 `json:"trigger_type"`
+	TriggerConfig   map[string]interface{} `json:"trigger_config"`
+	ActionType      string                 `json:"action_type"`
+	ActionConfig    map[string]interface{} `json:"action_config"`
+	CooldownSeconds int                    `json:"cooldown_seconds"`
+}
+
+// templateParams is a template's parameter struct, which builds its rule
+type templateParams interface {
+	rule() RuleSpec
+}
+
+// LiquidationProtection repays debt when the health factor falls below the
+// threshold, restoring the target
+type LiquidationProtection struct {
+	Protocol           string  `json:"protocol,omitempty" rule:"enum=aave,default=aave"`
+	Chain              string  `json:"chain,omitempty" rule:"enum=$chains,default=ethereum"`
+	DebtAsset          string  `json:"debt_asset" rule:"required,enum=$assets" description:"Borrowed asset to repay"`
+	Threshold          float64 `json:"threshold" rule:"required,xmin=1,max=10" description:"Health factor below which debt is repaid"`
+	TargetHealthFactor float64 `json:"target_health_factor" rule:"required,xmin=1,max=10" description:"Health factor the repayment restores"`
+	Source             string  `json:"source,omitempty" rule:"enum=wallet|collateral,default=wallet" description:"Repay with the debt asset in the wallet, or with the debt asset supplied as collateral"`
+	MaxAmount          float64 `json:"max_amount,omitempty" rule:"min=0" description:"Most debt asset repaid per execution, in token units; 0 for no limit"`
+}
+
+func (p *LiquidationProtection) Validate() error {
+	if p.TargetHealthFactor <= p.Threshold {
+		return errors.New("target_health_factor must be above threshold")
+	}
+	return checkAsset(p.Chain, p.DebtAsset)
+}
+
+// liquidationProtectionCooldown gives a repayment time to land before the
+// rule can fire again on a stale health factor
+const liquidationProtectionCooldown = 15 * 60
+
+func (p *LiquidationProtection) rule() RuleSpec {
+	action := map[string]interface{}{
+		"protocol":             p.Protocol,
+		"chain":                p.Chain,
+		"debt_asset":           p.DebtAsset,
+		"target_health_factor": p.TargetHealthFactor,
+		"source":               p.Source,
+	}
+	if p.MaxAmount > 0 {
+		action["max_amount"] = p.MaxAmount
+	}
+
+	return RuleSpec{
+		Name: fmt.Sprintf("Liquidation protection (%s on %s)", p.DebtAsset, p.Chain),
+		Description: fmt.Sprintf("Repay %s debt on %s when the health factor falls below %g, restoring %g",
+			p.DebtAsset, p.Protocol, p.Threshold, p.TargetHealthFactor),
+		TriggerType: "health_factor",
+		TriggerConfig: map[string]interface{}{
+			"protocol":  p.Protocol,
+			"chain":     p.Chain,
+			"threshold": p.Threshold,
+		},
+		ActionType:      "deleverage",
+		ActionConfig:    action,
+		CooldownSeconds: liquidationProtectionCooldown,
+	}
+}
+
+// template is a ready-made rule and its parameter struct
+type template struct {
+	description string
+	params      func() templateParams
+}
+
+var templates = map[string]template{
+	"liquidation_protection": {"Repay debt when the health factor falls, restoring a target", func() templateParams { return &LiquidationProtection{} }},
+}
+
+// BuildTemplate validates a template's parameters and returns the rule it
+// creates
+func BuildTemplate(name string, params map[string]interface{}) (*RuleSpec, error) {
+	t, ok := templates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown template: %s", name)
+	}
+	p := t.params()
+	if err := Decode(params, p); err != nil {
+		return nil, err
+	}
+	spec := p.rule()
+	return &spec, nil
+}
+
+// Templates returns every template with the JSON Schema of its parameters
+func Templates() []map[string]interface{} {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		t := templates[name]
+		list = append(list, map[string]interface{}{
+			"name":        name,
+			"description": t.description,
+			"parameters":  Schema(t.params()),
+		})
+	}
+	return list
+}
@@ -0,0 +1,43 @@
+package tokens
+
+import "strings"
+
+// Spender is a protocol contract users grant token allowances to
+type Spender struct {
+	Protocol string `json:"protocol"`
+	Name     string `json:"name"`
+	Chain    string `json:"chain"`
+	Address  string `json:"address"`
+}
+
+// spenders holds the protocol contracts the services ask users to approve,
+// keyed by chain
+var spenders = map[string][]Spender{
+	"ethereum": {
+		{Protocol: "aave", Name: "Aave V3 Pool", Chain: "ethereum", Address: "0x87870Bca3F3fD6335C3F4ce8392A693fcE16f1D7"},
+		{Protocol: "compound", Name: "Compound V3 USDC", Chain: "ethereum", Address: "0xc3d688B66703497DAA19211EEdff47f25384cdc3"},
+		{Protocol: "compound", Name: "Compound V3 WETH", Chain: "ethereum", Address: "0xA17581A9E3356d9A858b789D68B4d866e593aE94"},
+		{Protocol: "uniswap", Name: "Uniswap SwapRouter02", Chain: "ethereum", Address: "0x68b3465833fb72A70ecDF485E0e4C7bD8665Fc45"},
+	},
+	"base": {
+		{Protocol: "aave", Name: "Aave V3 Pool", Chain: "base", Address: "0xA238Dd80C259a72e81d7e4664a9801593F98d1c5"},
+		{Protocol: "compound", Name: "Compound V3 USDC", Chain: "base", Address: "0xb125E6687d4313864e53df431d5425969c15Eb2F"},
+		{Protocol: "compound", Name: "Compound V3 WETH", Chain: "base", Address: "0x46e6b214b524310239732D51387075E0e70970bf"},
+		{Protocol: "uniswap", Name: "Uniswap SwapRouter02", Chain: "base", Address: "0x2626664c2603336E57B271c5C0b26F421741e481"},
+	},
+}
+
+// Spenders returns the known protocol contracts on a chain
+func Spenders(chain string) []Spender {
+	return spenders[chain]
+}
+
+// LookupSpender returns the protocol contract at an address on a chain
+func LookupSpender(chain, address string) (Spender, bool) {
+	for _, s := range spenders[chain] {
+		if strings.EqualFold(s.Address, address) {
+			return s, true
+		}
+	}
+	return Spender{}, false
+}
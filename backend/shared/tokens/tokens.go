@@ -0,0 +1,118 @@
+package tokens
+
+import (
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// Token describes an ERC-20 token deployment on a chain
+type Token struct {
+	Symbol   string `json:"symbol"`
+	Name     string `json:"name"`
+	Chain    string `json:"chain"`
+	Address  string `json:"address"`
+	Decimals int    `json:"decimals"`
+
+	// BalanceSlot is the storage slot of the token's balances mapping, used to
+	// override balances when simulating. Nil when the layout is unknown.
+	BalanceSlot *int `json:"-"`
+	// AllowanceSlot is the storage slot of the token's allowances mapping
+	AllowanceSlot *int `json:"-"`
+	// PermitVersion is the EIP-712 domain version of the token's EIP-2612
+	// permit. Empty when the token has no standard permit.
+	PermitVersion string `json:"-"`
+	// ResetAllowance is set for tokens whose approve reverts unless the
+	// current allowance is zero, like mainnet USDT
+	ResetAllowance bool `json:"-"`
+}
+
+// NativeAsset is the pseudo-address used for the chain's native currency (ETH)
+const NativeAsset = "native"
+
+func slot(n int) *int {
+	return &n
+}
+
+// registry holds the supported tokens keyed by chain and upper-case symbol
+var registry = map[string]map[string]Token{
+	"ethereum": {
+		"USDC": {Symbol: "USDC", Name: "USD Coin", Chain: "ethereum", Address: "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", Decimals: 6, BalanceSlot: slot(9), AllowanceSlot: slot(10), PermitVersion: "2"},
+		"USDT": {Symbol: "USDT", Name: "Tether USD", Chain: "ethereum", Address: "0xdAC17F958D2ee523a2206206994597C13D831ec7", Decimals: 6, BalanceSlot: slot(2), AllowanceSlot: slot(5), ResetAllowance: true},
+		"DAI":  {Symbol: "DAI", Name: "Dai Stablecoin", Chain: "ethereum", Address: "0x6B175474E89094C44Da98b954EedeAC495271d0F", Decimals: 18, BalanceSlot: slot(2), AllowanceSlot: slot(3)},
+		"WETH": {Symbol: "WETH", Name: "Wrapped Ether", Chain: "ethereum", Address: "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2", Decimals: 18, BalanceSlot: slot(3), AllowanceSlot: slot(4)},
+	},
+	"base": {
+		"USDC": {Symbol: "USDC", Name: "USD Coin", Chain: "base", Address: "0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913", Decimals: 6, BalanceSlot: slot(9), AllowanceSlot: slot(10), PermitVersion: "2"},
+		"DAI":  {Symbol: "DAI", Name: "Dai Stablecoin", Chain: "base", Address: "0x50c5725949A6F0c72E6C4a641F24049A917DB0Cb", Decimals: 18},
+		"WETH": {Symbol: "WETH", Name: "Wrapped Ether", Chain: "base", Address: "0x4200000000000000000000000000000000000006", Decimals: 18, BalanceSlot: slot(3), AllowanceSlot: slot(4)},
+	},
+}
+
+// aliases maps asset names used in rules to registry symbols
+var aliases = map[string]string{
+	"ETH": "WETH",
+}
+
+// Lookup returns the token for a symbol on a chain
+func Lookup(chain, symbol string) (Token, bool) {
+	symbol = strings.ToUpper(symbol)
+	if alias, ok := aliases[symbol]; ok {
+		symbol = alias
+	}
+	t, ok := registry[chain][symbol]
+	return t, ok
+}
+
+// LookupAddress returns the token deployed at an address on a chain
+func LookupAddress(chain, address string) (Token, bool) {
+	for _, t := range registry[chain] {
+		if strings.EqualFold(t.Address, address) {
+			return t, true
+		}
+	}
+	return Token{}, false
+}
+
+// List returns all tokens on a chain sorted by symbol
+func List(chain string) []Token {
+	list := make([]Token, 0, len(registry[chain]))
+	for _, t := range registry[chain] {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Symbol < list[j].Symbol })
+	return list
+}
+
+// Chains returns the chains with a token list
+func Chains() []string {
+	chains := make([]string, 0, len(registry))
+	for chain := range registry {
+		chains = append(chains, chain)
+	}
+	sort.Strings(chains)
+	return chains
+}
+
+// ToBaseUnits converts a decimal amount to the token's smallest unit
+func ToBaseUnits(amount float64, decimals int) *big.Int {
+	scaled := new(big.Float).SetPrec(256).SetFloat64(amount)
+	scaled.Mul(scaled, new(big.Float).SetInt(pow10(decimals)))
+	result, _ := scaled.Int(nil)
+	return result
+}
+
+// FromBaseUnits converts an amount in the token's smallest unit to a decimal amount
+func FromBaseUnits(amount *big.Int, decimals int) float64 {
+	if amount == nil {
+		return 0
+	}
+	value := new(big.Float).SetInt(amount)
+	value.Quo(value, new(big.Float).SetInt(pow10(decimals)))
+	result, _ := value.Float64()
+	return result
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
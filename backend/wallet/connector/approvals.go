@@ -0,0 +1,313 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/defioptimization/shared/tokens"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+const erc20ABI = `[
+	{"inputs":[{"name":"owner","type":"address"},{"name":"spender","type":"address"}],"name":"allowance","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"},
+	{"inputs":[{"name":"spender","type":"address"},{"name":"amount","type":"uint256"}],"name":"approve","outputs":[{"name":"","type":"bool"}],"stateMutability":"nonpayable","type":"function"},
+	{"inputs":[{"name":"account","type":"address"}],"name":"balanceOf","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"},
+	{"inputs":[{"name":"owner","type":"address"}],"name":"nonces","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"},
+	{"inputs":[],"name":"name","outputs":[{"name":"","type":"string"}],"stateMutability":"view","type":"function"}
+]`
+
+var parsedERC20ABI = mustParseABI(erc20ABI)
+
+// infiniteAllowance is the threshold above which an allowance is treated as
+// unlimited. No supported token has a supply anywhere near 2^128 base units.
+var infiniteAllowance = new(big.Int).Lsh(big.NewInt(1), 128)
+
+// Allowance risk levels
+const (
+	RiskHigh = "high" // unlimited approval: the spender can drain the full balance
+	RiskLow  = "low"
+)
+
+// ContractCall is an unsigned call for the user's wallet
+type ContractCall struct {
+	To          string `json:"to"`
+	Data        string `json:"data"`
+	Value       string `json:"value"`
+	Description string `json:"description"`
+}
+
+// Allowance is an outstanding approval from an account to a protocol contract
+type Allowance struct {
+	Chain       string       `json:"chain"`
+	Token       string       `json:"token"`
+	Symbol      string       `json:"symbol"`
+	Spender     string       `json:"spender"`
+	SpenderName string       `json:"spender_name"`
+	Protocol    string       `json:"protocol"`
+	Allowance   string       `json:"allowance"` // base units
+	Amount      float64      `json:"amount"`
+	Infinite    bool         `json:"infinite"`
+	Risk        string       `json:"risk"`
+	Revoke      ContractCall `json:"revoke"`
+}
+
+// AllowanceCheck is the result of checking an allowance ahead of a deposit
+type AllowanceCheck struct {
+	Token      string `json:"token"`
+	Spender    string `json:"spender"`
+	Allowance  string `json:"allowance"`
+	Required   string `json:"required"`
+	Sufficient bool   `json:"sufficient"`
+	// Approval is an exact-amount approve to send before the deposit
+	Approval *ContractCall `json:"approval,omitempty"`
+	// Reset is an approve(0) to send before Approval, for tokens that
+	// refuse to change a non-zero allowance
+	Reset *ContractCall `json:"reset,omitempty"`
+	// Permit is EIP-2612 typed data the user can sign instead of approving,
+	// for protocols that accept a permit signature
+	Permit *apitypes.TypedData `json:"permit,omitempty"`
+}
+
+// GetAllowance returns how much of token the spender may transfer from owner
+func (wc *WalletConnector) GetAllowance(ctx context.Context, chain, token, owner, spender string) (*big.Int, error) {
+	input, err := parsedERC20ABI.Pack("allowance", common.HexToAddress(owner), common.HexToAddress(spender))
+	if err != nil {
+		return nil, err
+	}
+
+	var allowance *big.Int
+	if err := wc.callERC20(ctx, chain, token, input, "allowance", &allowance); err != nil {
+		return nil, fmt.Errorf("failed to fetch allowance: %w", err)
+	}
+	return allowance, nil
+}
+
+// ListAllowances returns every non-zero allowance an account has granted to
+// known protocol contracts on a chain, each with a revoke call. All
+// allowances are read in one Multicall3 batch.
+func (wc *WalletConnector) ListAllowances(ctx context.Context, chain, owner string) ([]Allowance, error) {
+	type pair struct {
+		token   tokens.Token
+		spender tokens.Spender
+	}
+
+	var pairs []pair
+	var calls []call3
+	for _, token := range tokens.List(chain) {
+		for _, spender := range tokens.Spenders(chain) {
+			input, err := parsedERC20ABI.Pack("allowance", common.HexToAddress(owner), common.HexToAddress(spender.Address))
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, pair{token: token, spender: spender})
+			calls = append(calls, call3{Target: common.HexToAddress(token.Address), AllowFailure: true, CallData: input})
+		}
+	}
+
+	results, err := wc.aggregate3(ctx, chain, calls)
+	if err != nil {
+		return nil, err
+	}
+
+	allowances := []Allowance{}
+	for i, p := range pairs {
+		if !results[i].Success {
+			return nil, fmt.Errorf("%s allowance for %s: call reverted", p.token.Symbol, p.spender.Name)
+		}
+		values, err := parsedERC20ABI.Unpack("allowance", results[i].ReturnData)
+		if err != nil || len(values) != 1 {
+			return nil, fmt.Errorf("%s allowance for %s: invalid response", p.token.Symbol, p.spender.Name)
+		}
+		amount, ok := values[0].(*big.Int)
+		if !ok || amount.Sign() == 0 {
+			continue
+		}
+
+		revoke, err := ApproveCall(p.token.Address, p.spender.Address, big.NewInt(0))
+		if err != nil {
+			return nil, err
+		}
+		revoke.Description = fmt.Sprintf("Revoke %s approval for %s", p.token.Symbol, p.spender.Name)
+
+		a := Allowance{
+			Chain:       chain,
+			Token:       p.token.Address,
+			Symbol:      p.token.Symbol,
+			Spender:     p.spender.Address,
+			SpenderName: p.spender.Name,
+			Protocol:    p.spender.Protocol,
+			Allowance:   amount.String(),
+			Infinite:    amount.Cmp(infiniteAllowance) >= 0,
+			Risk:        RiskLow,
+			Revoke:      revoke,
+		}
+		if a.Infinite {
+			a.Risk = RiskHigh
+		} else {
+			a.Amount = tokens.FromBaseUnits(amount, p.token.Decimals)
+		}
+		allowances = append(allowances, a)
+	}
+
+	return allowances, nil
+}
+
+// CheckAllowance compares an account's allowance with the amount a deposit
+// needs. When it falls short, an exact-amount approve is returned, preceded
+// by a reset to zero for tokens that require one, and a permit to sign
+// instead when requested and the token supports EIP-2612.
+func (wc *WalletConnector) CheckAllowance(ctx context.Context, chain, owner, token, spender string, amount *big.Int, usePermit bool) (*AllowanceCheck, error) {
+	t, ok := tokens.LookupAddress(chain, token)
+	if !ok {
+		return nil, fmt.Errorf("unknown token %s on %s", token, chain)
+	}
+
+	current, err := wc.GetAllowance(ctx, chain, t.Address, owner, spender)
+	if err != nil {
+		return nil, err
+	}
+
+	check := &AllowanceCheck{
+		Token:      t.Address,
+		Spender:    common.HexToAddress(spender).Hex(),
+		Allowance:  current.String(),
+		Required:   amount.String(),
+		Sufficient: current.Cmp(amount) >= 0,
+	}
+	if check.Sufficient {
+		return check, nil
+	}
+
+	approval, err := ApproveCall(t.Address, spender, amount)
+	if err != nil {
+		return nil, err
+	}
+	approval.Description = fmt.Sprintf("Approve %g %s", tokens.FromBaseUnits(amount, t.Decimals), t.Symbol)
+	if s, ok := tokens.LookupSpender(chain, spender); ok {
+		approval.Description += " for " + s.Name
+	}
+	check.Approval = &approval
+
+	if t.ResetAllowance && current.Sign() > 0 {
+		reset, err := ApproveCall(t.Address, spender, big.NewInt(0))
+		if err != nil {
+			return nil, err
+		}
+		reset.Description = fmt.Sprintf("Reset %s approval", t.Symbol)
+		if s, ok := tokens.LookupSpender(chain, spender); ok {
+			reset.Description += " for " + s.Name
+		}
+		check.Reset = &reset
+	}
+
+	if usePermit && t.PermitVersion != "" {
+		permit, err := wc.PermitTypedData(ctx, chain, owner, t, spender, amount, time.Now().Add(time.Hour))
+		if err != nil {
+			return nil, err
+		}
+		check.Permit = permit
+	}
+
+	return check, nil
+}
+
+// PermitTypedData builds the EIP-2612 permit the owner signs to approve spender
+func (wc *WalletConnector) PermitTypedData(ctx context.Context, chain, owner string, token tokens.Token, spender string, amount *big.Int, deadline time.Time) (*apitypes.TypedData, error) {
+	if token.PermitVersion == "" {
+		return nil, fmt.Errorf("%s does not support EIP-2612 permits", token.Symbol)
+	}
+
+	client, err := wc.GetClient(chain)
+	if err != nil {
+		return nil, err
+	}
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch chain ID: %w", err)
+	}
+
+	// The domain name must match the token's on-chain name exactly
+	input, err := parsedERC20ABI.Pack("name")
+	if err != nil {
+		return nil, err
+	}
+	var name string
+	if err := wc.callERC20(ctx, chain, token.Address, input, "name", &name); err != nil {
+		return nil, fmt.Errorf("failed to fetch token name: %w", err)
+	}
+
+	input, err = parsedERC20ABI.Pack("nonces", common.HexToAddress(owner))
+	if err != nil {
+		return nil, err
+	}
+	var nonce *big.Int
+	if err := wc.callERC20(ctx, chain, token.Address, input, "nonces", &nonce); err != nil {
+		return nil, fmt.Errorf("failed to fetch permit nonce: %w", err)
+	}
+
+	return &apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {
+				{Name: "name", Type: "string"},
+				{Name: "version", Type: "string"},
+				{Name: "chainId", Type: "uint256"},
+				{Name: "verifyingContract", Type: "address"},
+			},
+			"Permit": {
+				{Name: "owner", Type: "address"},
+				{Name: "spender", Type: "address"},
+				{Name: "value", Type: "uint256"},
+				{Name: "nonce", Type: "uint256"},
+				{Name: "deadline", Type: "uint256"},
+			},
+		},
+		PrimaryType: "Permit",
+		Domain: apitypes.TypedDataDomain{
+			Name:              name,
+			Version:           token.PermitVersion,
+			ChainId:           (*math.HexOrDecimal256)(chainID),
+			VerifyingContract: common.HexToAddress(token.Address).Hex(),
+		},
+		Message: apitypes.TypedDataMessage{
+			"owner":    common.HexToAddress(owner).Hex(),
+			"spender":  common.HexToAddress(spender).Hex(),
+			"value":    amount.String(),
+			"nonce":    nonce.String(),
+			"deadline": fmt.Sprintf("%d", deadline.Unix()),
+		},
+	}, nil
+}
+
+// ApproveCall builds an ERC-20 approve call. An amount of zero revokes.
+func ApproveCall(token, spender string, amount *big.Int) (ContractCall, error) {
+	data, err := parsedERC20ABI.Pack("approve", common.HexToAddress(spender), amount)
+	if err != nil {
+		return ContractCall{}, err
+	}
+	return ContractCall{
+		To:    common.HexToAddress(token).Hex(),
+		Data:  hexutil.Encode(data),
+		Value: "0",
+	}, nil
+}
+
+// callERC20 calls a view method on a token and unpacks its single output
+func (wc *WalletConnector) callERC20(ctx context.Context, chain, token string, input []byte, method string, out interface{}) error {
+	client, err := wc.GetClient(chain)
+	if err != nil {
+		return err
+	}
+
+	to := common.HexToAddress(token)
+	output, err := client.CallContract(ctx, ethereum.CallMsg{To: &to, Data: input}, nil)
+	if err != nil {
+		return err
+	}
+	return parsedERC20ABI.UnpackIntoInterface(out, method, output)
+}
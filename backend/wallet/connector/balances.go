@@ -0,0 +1,97 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/defioptimization/shared/tokens"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// TokenBalance is an account's holding of one asset
+type TokenBalance struct {
+	Chain    string  `json:"chain"`
+	Token    string  `json:"token"` // token address or "native"
+	Symbol   string  `json:"symbol"`
+	Name     string  `json:"name"`
+	Decimals int     `json:"decimals"`
+	Balance  string  `json:"balance"` // base units
+	Amount   float64 `json:"amount"`
+	PriceUSD float64 `json:"price_usd,omitempty"`
+	ValueUSD float64 `json:"value_usd,omitempty"`
+	// Error is set when the token's balance could not be read
+	Error string `json:"error,omitempty"`
+}
+
+// GetBalances reads the native balance and every registered token balance of
+// an account in a single Multicall3 batch
+func (wc *WalletConnector) GetBalances(ctx context.Context, chain, owner string) ([]TokenBalance, error) {
+	account := common.HexToAddress(owner)
+	list := tokens.List(chain)
+
+	nativeInput, err := parsedMulticall3ABI.Pack("getEthBalance", account)
+	if err != nil {
+		return nil, err
+	}
+	calls := []call3{{Target: multicall3Address, AllowFailure: true, CallData: nativeInput}}
+
+	for _, t := range list {
+		input, err := parsedERC20ABI.Pack("balanceOf", account)
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, call3{Target: common.HexToAddress(t.Address), AllowFailure: true, CallData: input})
+	}
+
+	results, err := wc.aggregate3(ctx, chain, calls)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make([]TokenBalance, 0, len(calls))
+	balances = append(balances, newTokenBalance(chain, tokens.Token{
+		Symbol:   "ETH",
+		Name:     "Ether",
+		Address:  tokens.NativeAsset,
+		Decimals: 18,
+	}, results[0], parsedMulticall3ABI, "getEthBalance"))
+
+	for i, t := range list {
+		balances = append(balances, newTokenBalance(chain, t, results[i+1], parsedERC20ABI, "balanceOf"))
+	}
+
+	return balances, nil
+}
+
+// newTokenBalance decodes one balance result of a batch
+func newTokenBalance(chain string, t tokens.Token, result call3Result, contract abi.ABI, method string) TokenBalance {
+	b := TokenBalance{
+		Chain:    chain,
+		Token:    t.Address,
+		Symbol:   t.Symbol,
+		Name:     t.Name,
+		Decimals: t.Decimals,
+		Balance:  "0",
+	}
+
+	if !result.Success {
+		b.Error = "balance call reverted"
+		return b
+	}
+	values, err := contract.Unpack(method, result.ReturnData)
+	if err != nil || len(values) != 1 {
+		b.Error = fmt.Sprintf("invalid %s response", method)
+		return b
+	}
+	amount, ok := values[0].(*big.Int)
+	if !ok {
+		b.Error = fmt.Sprintf("invalid %s response", method)
+		return b
+	}
+
+	b.Balance = amount.String()
+	b.Amount = tokens.FromBaseUnits(amount, t.Decimals)
+	return b
+}
@@ -3,11 +3,14 @@ package connector
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math/big"
 	"os"
+	"strconv"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
@@ -16,18 +19,45 @@ import (
 type WalletConnector struct {
 	ethClient  *ethclient.Client
 	baseClient *ethclient.Client
+
+	gasLimitMultiplier float64
 }
 
-// Transaction represents a built transaction
+// Transaction represents a built EIP-1559 (type 2) transaction
 type Transaction struct {
-	To       string `json:"to"`
-	Value    string `json:"value"`
-	Data     string `json:"data"`
-	GasLimit uint64 `json:"gas_limit"`
-	GasPrice string `json:"gas_price"`
-	ChainID  int64  `json:"chain_id"`
+	From                 string  `json:"from,omitempty"`
+	To                   string  `json:"to"`
+	Value                string  `json:"value"`
+	Data                 string  `json:"data"`
+	GasLimit             uint64  `json:"gas_limit"`
+	Type                 uint8   `json:"type"`
+	MaxFeePerGas         string  `json:"max_fee_per_gas"`
+	MaxPriorityFeePerGas string  `json:"max_priority_fee_per_gas"`
+	Speed                string  `json:"speed"`
+	ChainID              int64   `json:"chain_id"`
+	Nonce                *uint64 `json:"nonce,omitempty"`
+	Replaces             string  `json:"replaces,omitempty"`
+
+	// Cost estimates in wei. EstimatedCost assumes the current base fee,
+	// MaxCost is the most the transaction can be charged.
+	L1Fee         string `json:"l1_fee,omitempty"`
+	EstimatedCost string `json:"estimated_cost"`
+	MaxCost       string `json:"max_cost"`
+}
+
+// BuildRequest describes a transaction to build
+type BuildRequest struct {
+	Chain string
+	From  string
+	To    string
+	Value string
+	Data  string
+	Speed FeeSpeed
 }
 
+// defaultGasLimitMultiplier pads gas estimates to absorb state changes between estimation and inclusion
+const defaultGasLimitMultiplier = 1.2
+
 // NewWalletConnector creates a new wallet connector
 func NewWalletConnector() *WalletConnector {
 	ethRPC := os.Getenv("ETH_RPC_URL")
@@ -50,9 +80,17 @@ func NewWalletConnector() *WalletConnector {
 		}
 	}
 	
+	gasLimitMultiplier := defaultGasLimitMultiplier
+	if m := os.Getenv("GAS_LIMIT_MULTIPLIER"); m != "" {
+		if parsed, err := strconv.ParseFloat(m, 64); err == nil && parsed >= 1 {
+			gasLimitMultiplier = parsed
+		}
+	}
+
 	return &WalletConnector{
-		ethClient:  ethClient,
-		baseClient: baseClient,
+		ethClient:          ethClient,
+		baseClient:         baseClient,
+		gasLimitMultiplier: gasLimitMultiplier,
 	}
 }
 
@@ -71,70 +109,146 @@ func (wc *WalletConnector) GetClient(chain string) (*ethclient.Client, error) {
 	return wc.ethClient, nil
 }
 
-// BuildTransaction builds a transaction for a given chain
-func (wc *WalletConnector) BuildTransaction(chain, to, value, data string, params map[string]interface{}) (*Transaction, error) {
-	client, err := wc.GetClient(chain)
+// BuildTransaction builds an EIP-1559 transaction for a given chain
+func (wc *WalletConnector) BuildTransaction(ctx context.Context, req BuildRequest) (*Transaction, error) {
+	client, err := wc.GetClient(req.Chain)
 	if err != nil {
 		return nil, err
 	}
 	
-	toAddress := common.HexToAddress(to)
+	toAddress := common.HexToAddress(req.To)
+	fromAddress := common.HexToAddress(req.From)
+	data := common.FromHex(req.Data)
 	
 	// Parse value
 	valueBig := big.NewInt(0)
-	if value != "" {
-		valueBig, _ = valueBig.SetString(value, 10)
+	if req.Value != "" {
+		if _, ok := valueBig.SetString(req.Value, 10); !ok {
+			return nil, fmt.Errorf("invalid value: %s", req.Value)
+		}
+	}
+
+	speed := req.Speed
+	if speed == "" {
+		speed = FeeSpeedNormal
 	}
 	
 	// Get chain ID
-	chainID, err := client.NetworkID(context.Background())
+	chainID, err := client.ChainID(ctx)
 	if err != nil {
 		return nil, err
 	}
 	
 	// Estimate gas
-	gasLimit, err := client.EstimateGas(context.Background(), ethereum.CallMsg{
-		To:   &toAddress,
-		Data: common.FromHex(data),
+	gasLimit, err := wc.estimateGasLimit(ctx, req.Chain, ethereum.CallMsg{
+		From:  fromAddress,
+		To:    &toAddress,
+		Value: valueBig,
+		Data:  data,
 	})
 	if err != nil {
-		// Use default if estimation fails
-		gasLimit = 21000
+		return nil, err
 	}
 	
-	// Get gas price
-	gasPrice, err := client.SuggestGasPrice(context.Background())
+	// Get EIP-1559 fees
+	fees, err := wc.EstimateFees(ctx, req.Chain, speed)
 	if err != nil {
 		return nil, err
 	}
-	
+
+	// L1 data fee (Base only)
+	l1Fee, err := wc.l1DataFee(ctx, req.Chain, types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Gas:       gasLimit,
+		GasTipCap: fees.MaxPriorityFeePerGas,
+		GasFeeCap: fees.MaxFeePerGas,
+		To:        &toAddress,
+		Value:     valueBig,
+		Data:      data,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	gas := new(big.Int).SetUint64(gasLimit)
+	expectedPrice := new(big.Int).Add(fees.BaseFee, fees.MaxPriorityFeePerGas)
+	if expectedPrice.Cmp(fees.MaxFeePerGas) > 0 {
+		expectedPrice = fees.MaxFeePerGas
+	}
+	estimatedCost := new(big.Int).Mul(gas, expectedPrice)
+	estimatedCost.Add(estimatedCost, l1Fee)
+	maxCost := new(big.Int).Mul(gas, fees.MaxFeePerGas)
+	maxCost.Add(maxCost, l1Fee)
+
 	tx := &Transaction{
-		To:       to,
-		Value:    valueBig.String(),
-		Data:     data,
-		GasLimit: gasLimit,
-		GasPrice: gasPrice.String(),
-		ChainID:  chainID.Int64(),
+		From:                 req.From,
+		To:                   req.To,
+		Value:                valueBig.String(),
+		Data:                 req.Data,
+		GasLimit:             gasLimit,
+		Type:                 types.DynamicFeeTxType,
+		MaxFeePerGas:         fees.MaxFeePerGas.String(),
+		MaxPriorityFeePerGas: fees.MaxPriorityFeePerGas.String(),
+		Speed:                string(speed),
+		ChainID:              chainID.Int64(),
+		EstimatedCost:        estimatedCost.String(),
+		MaxCost:              maxCost.String(),
+	}
+	if req.Chain == "base" {
+		tx.L1Fee = l1Fee.String()
 	}
 	
 	return tx, nil
 }
 
-// SignMessage signs a message (placeholder - actual signing happens client-side)
-func (wc *WalletConnector) SignMessage(message string) (string, error) {
-	// Message signing is typically done client-side with wallet
-	// This is a placeholder for server-side operations if needed
-	return "", errors.New("message signing must be done client-side")
+// ErrInvalidSignedTransaction is returned when a raw transaction fails validation
+var ErrInvalidSignedTransaction = errors.New("invalid signed transaction")
+
+// DecodeSignedTransaction decodes a raw RLP-encoded signed transaction and
+// checks that it targets the chain and was signed by the expected sender
+func (wc *WalletConnector) DecodeSignedTransaction(ctx context.Context, chain, rawTx, expectedSender string) (*types.Transaction, common.Address, error) {
+	client, err := wc.GetClient(chain)
+	if err != nil {
+		return nil, common.Address{}, err
+	}
+
+	raw, err := hexutil.Decode(rawTx)
+	if err != nil {
+		return nil, common.Address{}, fmt.Errorf("%w: %v", ErrInvalidSignedTransaction, err)
+	}
+
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return nil, common.Address{}, fmt.Errorf("%w: %v", ErrInvalidSignedTransaction, err)
+	}
+
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, common.Address{}, err
+	}
+	if tx.ChainId().Cmp(chainID) != 0 {
+		return nil, common.Address{}, fmt.Errorf("%w: chain ID %s, expected %s", ErrInvalidSignedTransaction, tx.ChainId(), chainID)
+	}
+
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID), tx)
+	if err != nil {
+		return nil, common.Address{}, fmt.Errorf("%w: %v", ErrInvalidSignedTransaction, err)
+	}
+	if sender != common.HexToAddress(expectedSender) {
+		return nil, common.Address{}, fmt.Errorf("%w: signed by %s, expected %s", ErrInvalidSignedTransaction, sender.Hex(), expectedSender)
+	}
+
+	return tx, sender, nil
 }
 
 // SendTransaction sends a signed transaction
-func (wc *WalletConnector) SendTransaction(chain string, signedTx *types.Transaction) error {
+func (wc *WalletConnector) SendTransaction(ctx context.Context, chain string, signedTx *types.Transaction) error {
 	client, err := wc.GetClient(chain)
 	if err != nil {
 		return err
 	}
 	
-	return client.SendTransaction(context.Background(), signedTx)
+	return client.SendTransaction(ctx, signedTx)
 }
 
 // GetTransactionReceipt gets a transaction receipt
@@ -147,4 +261,3 @@ func (wc *WalletConnector) GetTransactionReceipt(chain, txHash string) (*types.R
 	hash := common.HexToHash(txHash)
 	return client.TransactionReceipt(context.Background(), hash)
 }
-
@@ -0,0 +1,226 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// FeeSpeed selects how aggressively a transaction is priced
+type FeeSpeed string
+
+const (
+	FeeSpeedSlow   FeeSpeed = "slow"
+	FeeSpeedNormal FeeSpeed = "normal"
+	FeeSpeedFast   FeeSpeed = "fast"
+)
+
+// ErrExecutionReverted is returned when a transaction would revert on-chain
+var ErrExecutionReverted = errors.New("execution reverted")
+
+// feeHistoryBlocks is the number of recent blocks sampled for priority fees
+const feeHistoryBlocks = 20
+
+// feePreset maps a speed to a priority fee percentile and base fee headroom.
+// The headroom multiplier lets a transaction survive a few full blocks of
+// base fee increases before it stops being includable.
+type feePreset struct {
+	percentile   float64
+	baseFeeRatio *big.Rat
+}
+
+var feePresets = map[FeeSpeed]feePreset{
+	FeeSpeedSlow:   {percentile: 10, baseFeeRatio: big.NewRat(5, 4)},
+	FeeSpeedNormal: {percentile: 50, baseFeeRatio: big.NewRat(3, 2)},
+	FeeSpeedFast:   {percentile: 90, baseFeeRatio: big.NewRat(2, 1)},
+}
+
+// ParseFeeSpeed parses a speed name, defaulting to normal when empty
+func ParseFeeSpeed(speed string) (FeeSpeed, error) {
+	if speed == "" {
+		return FeeSpeedNormal, nil
+	}
+	s := FeeSpeed(strings.ToLower(speed))
+	if _, ok := feePresets[s]; !ok {
+		return "", fmt.Errorf("unknown fee speed: %s", speed)
+	}
+	return s, nil
+}
+
+// FeeEstimate holds EIP-1559 fee parameters for a transaction
+type FeeEstimate struct {
+	Speed                FeeSpeed
+	BaseFee              *big.Int
+	MaxPriorityFeePerGas *big.Int
+	MaxFeePerGas         *big.Int
+}
+
+// EstimateFees derives EIP-1559 fees from eth_feeHistory reward percentiles
+func (wc *WalletConnector) EstimateFees(ctx context.Context, chain string, speed FeeSpeed) (*FeeEstimate, error) {
+	client, err := wc.GetClient(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	preset, ok := feePresets[speed]
+	if !ok {
+		return nil, fmt.Errorf("unknown fee speed: %s", speed)
+	}
+
+	history, err := client.FeeHistory(ctx, feeHistoryBlocks, nil, []float64{preset.percentile})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch fee history: %w", err)
+	}
+	if len(history.BaseFee) == 0 {
+		return nil, errors.New("fee history returned no base fees")
+	}
+
+	// The last entry is the base fee of the next (pending) block
+	baseFee := history.BaseFee[len(history.BaseFee)-1]
+
+	rewards := make([]*big.Int, 0, len(history.Reward))
+	for _, reward := range history.Reward {
+		if len(reward) > 0 && reward[0] != nil && reward[0].Sign() > 0 {
+			rewards = append(rewards, reward[0])
+		}
+	}
+
+	var priorityFee *big.Int
+	if len(rewards) > 0 {
+		sort.Slice(rewards, func(i, j int) bool { return rewards[i].Cmp(rewards[j]) < 0 })
+		priorityFee = new(big.Int).Set(rewards[len(rewards)/2])
+	} else {
+		// Empty blocks carry no reward samples; fall back to the node's suggestion
+		priorityFee, err = client.SuggestGasTipCap(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to suggest priority fee: %w", err)
+		}
+	}
+
+	maxFee := new(big.Int).Mul(baseFee, preset.baseFeeRatio.Num())
+	maxFee.Quo(maxFee, preset.baseFeeRatio.Denom())
+	maxFee.Add(maxFee, priorityFee)
+
+	return &FeeEstimate{
+		Speed:                speed,
+		BaseFee:              baseFee,
+		MaxPriorityFeePerGas: priorityFee,
+		MaxFeePerGas:         maxFee,
+	}, nil
+}
+
+// estimateGasLimit estimates gas and applies the configured safety multiplier.
+// Estimation failures are returned with the decoded revert reason, since
+// guessing a limit for a call that reverts only wastes gas on-chain.
+func (wc *WalletConnector) estimateGasLimit(ctx context.Context, chain string, msg ethereum.CallMsg) (uint64, error) {
+	client, err := wc.GetClient(chain)
+	if err != nil {
+		return 0, err
+	}
+
+	gas, err := client.EstimateGas(ctx, msg)
+	if err != nil {
+		return 0, estimationError(err)
+	}
+
+	return uint64(float64(gas) * wc.gasLimitMultiplier), nil
+}
+
+// estimationError wraps a failed estimate. Every revert wraps
+// ErrExecutionReverted, with the decoded reason when the node returned one.
+func estimationError(err error) error {
+	if !isRevert(err) {
+		return fmt.Errorf("gas estimation failed: %w", err)
+	}
+	if reason := RevertReason(err); reason != "" {
+		return fmt.Errorf("gas estimation failed: %w: %s", ErrExecutionReverted, reason)
+	}
+	return fmt.Errorf("gas estimation failed: %w", ErrExecutionReverted)
+}
+
+// RevertReason extracts a human-readable revert reason from an RPC error
+func RevertReason(err error) string {
+	var dataErr rpc.DataError
+	if !errors.As(err, &dataErr) {
+		return ""
+	}
+
+	data, ok := dataErr.ErrorData().(string)
+	if !ok {
+		return ""
+	}
+
+	return DecodeRevert(common.FromHex(data))
+}
+
+// DecodeRevert decodes Error(string) and Panic(uint256) revert payloads,
+// falling back to the raw selector for custom errors
+func DecodeRevert(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	if reason, err := abi.UnpackRevert(data); err == nil {
+		return reason
+	}
+	if len(data) >= 4 {
+		return fmt.Sprintf("custom error %s", common.Bytes2Hex(data[:4]))
+	}
+	return common.Bytes2Hex(data)
+}
+
+// Base (OP Stack) GasPriceOracle predeploy
+var gasPriceOracleAddress = common.HexToAddress("0x420000000000000000000000000000000000000F")
+
+const gasPriceOracleABI = `[{"inputs":[{"internalType":"bytes","name":"_data","type":"bytes"}],"name":"getL1Fee","outputs":[{"internalType":"uint256","name":"","type":"uint256"}],"stateMutability":"view","type":"function"}]`
+
+var parsedGasPriceOracleABI = mustParseABI(gasPriceOracleABI)
+
+// l1DataFee returns the L1 data fee charged by Base for posting the transaction to Ethereum
+func (wc *WalletConnector) l1DataFee(ctx context.Context, chain string, tx *types.Transaction) (*big.Int, error) {
+	if chain != "base" {
+		return big.NewInt(0), nil
+	}
+
+	client, err := wc.GetClient(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := parsedGasPriceOracleABI.Pack("getL1Fee", raw)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := client.CallContract(ctx, ethereum.CallMsg{
+		To:   &gasPriceOracleAddress,
+		Data: input,
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch L1 data fee: %w", err)
+	}
+
+	return new(big.Int).SetBytes(output), nil
+}
+
+// mustParseABI parses a static ABI definition
+func mustParseABI(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic("invalid ABI definition: " + err.Error())
+	}
+	return parsed
+}
@@ -0,0 +1,49 @@
+package connector
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// rpcDataError is an RPC error carrying revert data, as geth's client returns
+type rpcDataError struct {
+	msg  string
+	data interface{}
+}
+
+func (e rpcDataError) Error() string          { return e.msg }
+func (e rpcDataError) ErrorData() interface{} { return e.data }
+
+func TestEstimationError(t *testing.T) {
+	// Error(string) "Insufficient balance"
+	revertData := "0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000014" +
+		"496e73756666696369656e742062616c616e6365000000000000000000000000"
+
+	tests := []struct {
+		name     string
+		err      error
+		reverted bool
+		reason   string
+	}{
+		{"revert with reason", rpcDataError{"execution reverted: Insufficient balance", revertData}, true, "Insufficient balance"},
+		{"custom error", rpcDataError{"execution reverted", "0xdeadbeef"}, true, "custom error deadbeef"},
+		{"bare revert", errors.New("execution reverted"), true, ""},
+		{"revert with empty data", rpcDataError{"execution reverted", "0x"}, true, ""},
+		{"transport failure", errors.New("dial tcp: connection refused"), false, ""},
+		{"out of gas", errors.New("gas required exceeds allowance (30000000)"), false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := estimationError(tt.err)
+			if got := errors.Is(err, ErrExecutionReverted); got != tt.reverted {
+				t.Fatalf("errors.Is(%v, ErrExecutionReverted) = %v, want %v", err, got, tt.reverted)
+			}
+			if tt.reason != "" && !strings.HasSuffix(err.Error(), tt.reason) {
+				t.Fatalf("error %q does not end with reason %q", err, tt.reason)
+			}
+		})
+	}
+}
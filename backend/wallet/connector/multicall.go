@@ -0,0 +1,60 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// multicall3Address is the Multicall3 deployment, identical on every chain
+var multicall3Address = common.HexToAddress("0xcA11bde05977b3631167028862bE2a173976CA11")
+
+const multicall3ABI = `[
+	{"inputs":[{"components":[{"name":"target","type":"address"},{"name":"allowFailure","type":"bool"},{"name":"callData","type":"bytes"}],"name":"calls","type":"tuple[]"}],"name":"aggregate3","outputs":[{"components":[{"name":"success","type":"bool"},{"name":"returnData","type":"bytes"}],"name":"returnData","type":"tuple[]"}],"stateMutability":"payable","type":"function"},
+	{"inputs":[{"name":"addr","type":"address"}],"name":"getEthBalance","outputs":[{"name":"balance","type":"uint256"}],"stateMutability":"view","type":"function"}
+]`
+
+var parsedMulticall3ABI = mustParseABI(multicall3ABI)
+
+// call3 is a single call in an aggregate3 batch
+type call3 struct {
+	Target       common.Address `abi:"target"`
+	AllowFailure bool           `abi:"allowFailure"`
+	CallData     []byte         `abi:"callData"`
+}
+
+// call3Result is the outcome of one call in an aggregate3 batch
+type call3Result struct {
+	Success    bool   `abi:"success"`
+	ReturnData []byte `abi:"returnData"`
+}
+
+// aggregate3 runs a batch of calls in a single eth_call. Calls that allow
+// failure report it in their result instead of reverting the batch.
+func (wc *WalletConnector) aggregate3(ctx context.Context, chain string, calls []call3) ([]call3Result, error) {
+	client, err := wc.GetClient(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := parsedMulticall3ABI.Pack("aggregate3", calls)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := client.CallContract(ctx, ethereum.CallMsg{To: &multicall3Address, Data: input}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("multicall failed: %w", err)
+	}
+
+	var results []call3Result
+	if err := parsedMulticall3ABI.UnpackIntoInterface(&results, "aggregate3", output); err != nil {
+		return nil, err
+	}
+	if len(results) != len(calls) {
+		return nil, fmt.Errorf("multicall returned %d results for %d calls", len(results), len(calls))
+	}
+	return results, nil
+}
@@ -0,0 +1,96 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// cancelGasLimit is the gas used by a zero-value self-transfer
+const cancelGasLimit = 21000
+
+// BuildReplacement builds a transaction that reuses the nonce of a pending
+// transaction with fees high enough for nodes to accept it as a replacement.
+// A speed-up resends the same call; a cancel sends zero ETH to the sender.
+func (wc *WalletConnector) BuildReplacement(ctx context.Context, chain, txHash string, cancel bool) (*Transaction, error) {
+	client, err := wc.GetClient(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	original, isPending, err := client.TransactionByHash(ctx, common.HexToHash(txHash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transaction: %w", err)
+	}
+	if !isPending {
+		return nil, errors.New("transaction is already mined")
+	}
+
+	from, err := types.Sender(types.LatestSignerForChainID(original.ChainId()), original)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recover sender: %w", err)
+	}
+
+	fees, err := wc.EstimateFees(ctx, chain, FeeSpeedFast)
+	if err != nil {
+		return nil, err
+	}
+
+	tip := maxBig(bumpFee(original.GasTipCap()), fees.MaxPriorityFeePerGas)
+	feeCap := maxBig(bumpFee(original.GasFeeCap()), fees.MaxFeePerGas)
+	if feeCap.Cmp(tip) < 0 {
+		feeCap = new(big.Int).Set(tip)
+	}
+
+	nonce := original.Nonce()
+	tx := &Transaction{
+		From:                 from.Hex(),
+		GasLimit:             original.Gas(),
+		Type:                 types.DynamicFeeTxType,
+		MaxFeePerGas:         feeCap.String(),
+		MaxPriorityFeePerGas: tip.String(),
+		Speed:                string(FeeSpeedFast),
+		ChainID:              original.ChainId().Int64(),
+		Nonce:                &nonce,
+		Replaces:             txHash,
+	}
+
+	if cancel {
+		tx.To = from.Hex()
+		tx.Value = "0"
+		tx.Data = "0x"
+		tx.GasLimit = cancelGasLimit
+	} else {
+		if original.To() == nil {
+			return nil, errors.New("contract creations cannot be replaced")
+		}
+		tx.To = original.To().Hex()
+		tx.Value = original.Value().String()
+		tx.Data = hexutil.Encode(original.Data())
+	}
+
+	maxCost := new(big.Int).Mul(new(big.Int).SetUint64(tx.GasLimit), feeCap)
+	tx.MaxCost = maxCost.String()
+	tx.EstimatedCost = maxCost.String()
+
+	return tx, nil
+}
+
+// bumpFee raises a fee by the 10% minimum nodes require for replacement, rounding up
+func bumpFee(fee *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(fee, big.NewInt(110))
+	bumped.Add(bumped, big.NewInt(99))
+	return bumped.Quo(bumped, big.NewInt(100))
+}
+
+func maxBig(a, b *big.Int) *big.Int {
+	if a.Cmp(b) >= 0 {
+		return new(big.Int).Set(a)
+	}
+	return new(big.Int).Set(b)
+}
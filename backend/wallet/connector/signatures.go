@@ -0,0 +1,120 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// eip1271MagicValue is returned by isValidSignature for a valid contract wallet signature
+var eip1271MagicValue = []byte{0x16, 0x26, 0xba, 0x7e}
+
+const eip1271ABI = `[{"inputs":[{"name":"hash","type":"bytes32"},{"name":"signature","type":"bytes"}],"name":"isValidSignature","outputs":[{"name":"","type":"bytes4"}],"stateMutability":"view","type":"function"}]`
+
+var parsedEIP1271ABI = mustParseABI(eip1271ABI)
+
+// SignatureVerification is the result of checking a signature
+type SignatureVerification struct {
+	Valid            bool   `json:"valid"`
+	RecoveredAddress string `json:"recovered_address,omitempty"`
+	ContractWallet   bool   `json:"contract_wallet"`
+}
+
+// PersonalSignHash returns the EIP-191 hash signed by personal_sign.
+// Hex-encoded messages are signed as raw bytes, matching wallet behavior.
+func PersonalSignHash(message string) []byte {
+	data := []byte(message)
+	if strings.HasPrefix(message, "0x") {
+		if decoded, err := hexutil.Decode(message); err == nil {
+			data = decoded
+		}
+	}
+	return accounts.TextHash(data)
+}
+
+// TypedDataHash returns the EIP-712 digest of typed data
+func TypedDataHash(typedData apitypes.TypedData) ([]byte, error) {
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid typed data: %w", err)
+	}
+	return hash, nil
+}
+
+// VerifySignature checks that address signed hash. EOAs are verified by
+// recovering the signer; contract wallets are asked via EIP-1271.
+func (wc *WalletConnector) VerifySignature(ctx context.Context, chain, address string, hash []byte, signature string) (*SignatureVerification, error) {
+	sig, err := hexutil.Decode(signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if len(sig) != crypto.SignatureLength {
+		return wc.verifyContractSignature(ctx, chain, address, hash, sig)
+	}
+
+	// Wallets return V as 27/28; recovery expects 0/1
+	normalized := make([]byte, len(sig))
+	copy(normalized, sig)
+	if normalized[crypto.RecoveryIDOffset] >= 27 {
+		normalized[crypto.RecoveryIDOffset] -= 27
+	}
+
+	pub, err := crypto.SigToPub(hash, normalized)
+	if err == nil {
+		recovered := crypto.PubkeyToAddress(*pub)
+		if recovered == common.HexToAddress(address) {
+			return &SignatureVerification{Valid: true, RecoveredAddress: recovered.Hex()}, nil
+		}
+	}
+
+	result, contractErr := wc.verifyContractSignature(ctx, chain, address, hash, sig)
+	if contractErr != nil {
+		return nil, contractErr
+	}
+	if !result.ContractWallet && pub != nil {
+		result.RecoveredAddress = crypto.PubkeyToAddress(*pub).Hex()
+	}
+	return result, nil
+}
+
+// verifyContractSignature checks a signature with the account's EIP-1271 implementation
+func (wc *WalletConnector) verifyContractSignature(ctx context.Context, chain, address string, hash []byte, sig []byte) (*SignatureVerification, error) {
+	client, err := wc.GetClient(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	account := common.HexToAddress(address)
+	code, err := client.CodeAt(ctx, account, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch account code: %w", err)
+	}
+	if len(code) == 0 {
+		return &SignatureVerification{Valid: false}, nil
+	}
+
+	input, err := parsedEIP1271ABI.Pack("isValidSignature", common.BytesToHash(hash), sig)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := client.CallContract(ctx, ethereum.CallMsg{To: &account, Data: input}, nil)
+	if err != nil {
+		// A reverting isValidSignature means the signature was rejected
+		if RevertReason(err) != "" || strings.Contains(err.Error(), "execution reverted") {
+			return &SignatureVerification{Valid: false, ContractWallet: true}, nil
+		}
+		return nil, fmt.Errorf("failed to call isValidSignature: %w", err)
+	}
+
+	valid := len(output) >= 4 && bytes.Equal(output[:4], eip1271MagicValue)
+	return &SignatureVerification{Valid: valid, ContractWallet: true}, nil
+}
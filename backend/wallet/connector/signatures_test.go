@@ -0,0 +1,235 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+var (
+	// contractWallet has code and accepts contractSignature through EIP-1271
+	contractWallet    = common.HexToAddress("0x5afe5afe5afe5afe5afe5afe5afe5afe5afe5afe")
+	contractSignature = []byte("signed by the owners")
+)
+
+// testConnector returns a connector whose Ethereum client talks to a fake
+// mainnet node that knows one contract wallet
+func testConnector(t *testing.T) *WalletConnector {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage   `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("bad request: %v", err)
+			return
+		}
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		switch req.Method {
+		case "eth_chainId":
+			resp["result"] = "0x1"
+		case "eth_getCode":
+			var address common.Address
+			json.Unmarshal(req.Params[0], &address)
+			resp["result"] = "0x"
+			if address == contractWallet {
+				resp["result"] = "0x6080"
+			}
+		case "eth_call":
+			var msg struct {
+				Input hexutil.Bytes `json:"input"`
+				Data  hexutil.Bytes `json:"data"`
+			}
+			json.Unmarshal(req.Params[0], &msg)
+			input := msg.Input
+			if len(input) == 0 {
+				input = msg.Data
+			}
+			if bytes.Contains(input, contractSignature) {
+				resp["result"] = hexutil.Encode(common.RightPadBytes(eip1271MagicValue, 32))
+			} else {
+				resp["error"] = map[string]interface{}{"code": 3, "message": "execution reverted"}
+			}
+		default:
+			resp["error"] = map[string]interface{}{"code": -32601, "message": "method not found"}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("ETH_RPC_URL", srv.URL)
+	return NewWalletConnector()
+}
+
+// sign signs hash the way wallets return it, with V as 27 or 28
+func sign(t *testing.T, hash []byte, key string) string {
+	t.Helper()
+	privateKey, err := crypto.HexToECDSA(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := crypto.Sign(hash, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig[crypto.RecoveryIDOffset] += 27
+	return hexutil.Encode(sig)
+}
+
+func keyAddress(t *testing.T, key string) common.Address {
+	t.Helper()
+	privateKey, err := crypto.HexToECDSA(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crypto.PubkeyToAddress(privateKey.PublicKey)
+}
+
+const (
+	ownerKey = "b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291"
+	otherKey = "8a1f9a8f95be41cd7ccb6168179afb4504aefe388d1e14474d32c45c72ce7b7a"
+)
+
+func testTypedData() apitypes.TypedData {
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {{Name: "name", Type: "string"}, {Name: "chainId", Type: "uint256"}},
+			"Login":        {{Name: "wallet", Type: "address"}, {Name: "nonce", Type: "uint256"}},
+		},
+		PrimaryType: "Login",
+		Domain:      apitypes.TypedDataDomain{Name: "DeFi Optimizer", ChainId: math.NewHexOrDecimal256(1)},
+		Message:     apitypes.TypedDataMessage{"wallet": "0x1111111111111111111111111111111111111111", "nonce": "7"},
+	}
+}
+
+func TestPersonalSignHash(t *testing.T) {
+	// Hex messages are signed as the bytes they encode
+	if !bytes.Equal(PersonalSignHash("0x68656c6c6f"), PersonalSignHash("hello")) {
+		t.Error("hex message hashed as text")
+	}
+	// Text that only looks like hex is signed as text
+	if bytes.Equal(PersonalSignHash("0xhello"), PersonalSignHash("hello")) {
+		t.Error("invalid hex message hashed as bytes")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	wc := testConnector(t)
+	owner := keyAddress(t, ownerKey)
+	message := PersonalSignHash("Sign in to DeFi Optimizer")
+	typed, err := TypedDataHash(testTypedData())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lowV := hexutil.MustDecode(sign(t, message, ownerKey))
+	lowV[crypto.RecoveryIDOffset] -= 27
+
+	tests := []struct {
+		name          string
+		address       common.Address
+		hash          []byte
+		signature     string
+		wantValid     bool
+		wantContract  bool
+		wantRecovered common.Address
+		wantErr       bool
+	}{
+		{"personal_sign", owner, message, sign(t, message, ownerKey), true, false, owner, false},
+		{"V of 0 or 1", owner, message, hexutil.Encode(lowV), true, false, owner, false},
+		{"EIP-712", owner, typed, sign(t, typed, ownerKey), true, false, owner, false},
+		{"another signer", owner, message, sign(t, message, otherKey), false, false, keyAddress(t, otherKey), false},
+		{"signed another message", owner, typed, sign(t, message, ownerKey), false, false, common.Address{}, false},
+		{"contract wallet accepts", contractWallet, message, hexutil.Encode(contractSignature), true, true, common.Address{}, false},
+		{"contract wallet rejects", contractWallet, message, sign(t, message, otherKey), false, true, common.Address{}, false},
+		{"not hex", owner, message, "signature", false, false, common.Address{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := wc.VerifySignature(context.Background(), "ethereum", tt.address.Hex(), tt.hash, tt.signature)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifySignature error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if result.Valid != tt.wantValid || result.ContractWallet != tt.wantContract {
+				t.Errorf("result = %+v, want valid %v, contract wallet %v", result, tt.wantValid, tt.wantContract)
+			}
+			if tt.wantRecovered != (common.Address{}) && result.RecoveredAddress != tt.wantRecovered.Hex() {
+				t.Errorf("recovered %s, want %s", result.RecoveredAddress, tt.wantRecovered.Hex())
+			}
+		})
+	}
+}
+
+func TestDecodeSignedTransaction(t *testing.T) {
+	wc := testConnector(t)
+	owner := keyAddress(t, ownerKey)
+
+	signed := func(chainID int64, key string) string {
+		t.Helper()
+		privateKey, _ := crypto.HexToECDSA(key)
+		to := common.HexToAddress("0x87870Bca3F3fD6335C3F4ce8392A693fcE16f1D7")
+		tx, err := types.SignNewTx(privateKey, types.LatestSignerForChainID(big.NewInt(chainID)), &types.DynamicFeeTx{
+			ChainID:   big.NewInt(chainID),
+			Nonce:     4,
+			GasTipCap: big.NewInt(1e9),
+			GasFeeCap: big.NewInt(30e9),
+			Gas:       21000,
+			To:        &to,
+			Value:     big.NewInt(1),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := tx.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hexutil.Encode(raw)
+	}
+
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr string
+	}{
+		{"valid", signed(1, ownerKey), ""},
+		{"other chain", signed(8453, ownerKey), "chain ID 8453, expected 1"},
+		{"other sender", signed(1, otherKey), "signed by " + keyAddress(t, otherKey).Hex()},
+		{"not hex", "raw", "invalid signed transaction"},
+		{"not a transaction", "0x01020304", "invalid signed transaction"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, sender, err := wc.DecodeSignedTransaction(context.Background(), "ethereum", tt.raw, strings.ToLower(owner.Hex()))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if sender != owner || tx.Nonce() != 4 {
+					t.Fatalf("decoded nonce %d from %s", tx.Nonce(), sender.Hex())
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidSignedTransaction) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
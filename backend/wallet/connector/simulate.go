@@ -0,0 +1,293 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/defioptimization/shared/tokens"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// transferTopic is keccak256("Transfer(address,address,uint256)")
+var transferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// SimulationRequest describes a call to simulate against the pending block
+type SimulationRequest struct {
+	Chain string
+	From  string
+	To    string
+	Value string
+	Data  string
+
+	// TokenBalances sets the sender's balance of a token (address => base units)
+	// before simulating, for steps that depend on an earlier transaction
+	TokenBalances map[string]string
+	// TokenAllowances sets the sender's allowances (token => spender => base
+	// units), for steps that spend an approval granted by an earlier transaction
+	TokenAllowances map[string]map[string]string
+	// StateOverrides are passed through to eth_call verbatim
+	StateOverrides map[common.Address]AccountOverride
+}
+
+// AccountOverride is an eth_call state override for a single account
+type AccountOverride struct {
+	Balance   *hexutil.Big                `json:"balance,omitempty"`
+	Nonce     *hexutil.Uint64             `json:"nonce,omitempty"`
+	Code      *hexutil.Bytes              `json:"code,omitempty"`
+	State     map[common.Hash]common.Hash `json:"state,omitempty"`
+	StateDiff map[common.Hash]common.Hash `json:"stateDiff,omitempty"`
+}
+
+// BalanceChange is the net change of one asset for the sender
+type BalanceChange struct {
+	Token  string  `json:"token"` // token address or "native"
+	Symbol string  `json:"symbol,omitempty"`
+	Delta  string  `json:"delta"` // signed, base units
+	Amount float64 `json:"amount"`
+}
+
+// SimulationResult is the outcome of a simulated call
+type SimulationResult struct {
+	Success        bool            `json:"success"`
+	RevertReason   string          `json:"revert_reason,omitempty"`
+	ReturnData     string          `json:"return_data,omitempty"`
+	GasUsed        uint64          `json:"gas_used,omitempty"`
+	BalanceChanges []BalanceChange `json:"balance_changes"`
+	// TraceAvailable is false when the node does not support debug_traceCall,
+	// in which case only the native value sent is reported
+	TraceAvailable bool `json:"trace_available"`
+}
+
+// callFrame mirrors the output of geth's callTracer with logs enabled
+type callFrame struct {
+	Type    string         `json:"type"`
+	From    common.Address `json:"from"`
+	To      common.Address `json:"to"`
+	Value   *hexutil.Big   `json:"value"`
+	GasUsed hexutil.Uint64 `json:"gasUsed"`
+	Error   string         `json:"error"`
+	Calls   []callFrame    `json:"calls"`
+	Logs    []callLog      `json:"logs"`
+}
+
+type callLog struct {
+	Address common.Address `json:"address"`
+	Topics  []common.Hash  `json:"topics"`
+	Data    hexutil.Bytes  `json:"data"`
+}
+
+// Simulate runs the call at the pending block and reports the sender's balance changes
+func (wc *WalletConnector) Simulate(ctx context.Context, req SimulationRequest) (*SimulationResult, error) {
+	client, err := wc.GetClient(req.Chain)
+	if err != nil {
+		return nil, err
+	}
+
+	from := common.HexToAddress(req.From)
+	value := big.NewInt(0)
+	if req.Value != "" {
+		if _, ok := value.SetString(req.Value, 10); !ok {
+			return nil, fmt.Errorf("invalid value: %s", req.Value)
+		}
+	}
+
+	overrides, err := buildOverrides(req.Chain, from, req.TokenBalances, req.TokenAllowances, req.StateOverrides)
+	if err != nil {
+		return nil, err
+	}
+
+	to := common.HexToAddress(req.To)
+	args := map[string]interface{}{
+		"from":  from,
+		"to":    to,
+		"value": (*hexutil.Big)(value),
+		"data":  hexutil.Bytes(common.FromHex(req.Data)),
+	}
+
+	result := &SimulationResult{BalanceChanges: []BalanceChange{}}
+
+	var output hexutil.Bytes
+	callParams := []interface{}{args, "pending"}
+	if len(overrides) > 0 {
+		callParams = append(callParams, overrides)
+	}
+	if err := client.Client().CallContext(ctx, &output, "eth_call", callParams...); err != nil {
+		if !isRevert(err) {
+			return nil, fmt.Errorf("simulation failed: %w", err)
+		}
+		result.RevertReason = RevertReason(err)
+		if result.RevertReason == "" {
+			result.RevertReason = err.Error()
+		}
+		return result, nil
+	}
+	result.Success = true
+	result.ReturnData = output.String()
+
+	var frame callFrame
+	traceConfig := map[string]interface{}{
+		"tracer":       "callTracer",
+		"tracerConfig": map[string]interface{}{"withLog": true},
+	}
+	if len(overrides) > 0 {
+		traceConfig["stateOverrides"] = overrides
+	}
+	if err := client.Client().CallContext(ctx, &frame, "debug_traceCall", args, "pending", traceConfig); err != nil {
+		// Tracing is optional; fall back to the value we know is sent
+		if value.Sign() > 0 {
+			result.BalanceChanges = append(result.BalanceChanges, newBalanceChange(req.Chain, tokens.NativeAsset, new(big.Int).Neg(value)))
+		}
+		return result, nil
+	}
+
+	result.TraceAvailable = true
+	result.GasUsed = uint64(frame.GasUsed)
+
+	deltas := make(map[string]*big.Int)
+	collectBalanceChanges(&frame, from, deltas)
+	for token, delta := range deltas {
+		if delta.Sign() != 0 {
+			result.BalanceChanges = append(result.BalanceChanges, newBalanceChange(req.Chain, token, delta))
+		}
+	}
+
+	return result, nil
+}
+
+// collectBalanceChanges walks the call tree summing native value and ERC-20
+// transfers into and out of the account
+func collectBalanceChanges(frame *callFrame, account common.Address, deltas map[string]*big.Int) {
+	if frame.Error != "" {
+		return
+	}
+
+	if frame.Value != nil && frame.Type != "DELEGATECALL" && frame.Type != "STATICCALL" {
+		v := frame.Value.ToInt()
+		if frame.From == account {
+			addDelta(deltas, tokens.NativeAsset, new(big.Int).Neg(v))
+		}
+		if frame.To == account {
+			addDelta(deltas, tokens.NativeAsset, v)
+		}
+	}
+
+	for _, l := range frame.Logs {
+		if len(l.Topics) != 3 || l.Topics[0] != transferTopic || len(l.Data) != 32 {
+			continue
+		}
+		amount := new(big.Int).SetBytes(l.Data)
+		token := strings.ToLower(l.Address.Hex())
+		if common.BytesToAddress(l.Topics[1].Bytes()) == account {
+			addDelta(deltas, token, new(big.Int).Neg(amount))
+		}
+		if common.BytesToAddress(l.Topics[2].Bytes()) == account {
+			addDelta(deltas, token, amount)
+		}
+	}
+
+	for i := range frame.Calls {
+		collectBalanceChanges(&frame.Calls[i], account, deltas)
+	}
+}
+
+func addDelta(deltas map[string]*big.Int, token string, amount *big.Int) {
+	if _, ok := deltas[token]; !ok {
+		deltas[token] = big.NewInt(0)
+	}
+	deltas[token].Add(deltas[token], amount)
+}
+
+// newBalanceChange annotates a delta with token metadata when the token is known
+func newBalanceChange(chain, token string, delta *big.Int) BalanceChange {
+	change := BalanceChange{Token: token, Delta: delta.String()}
+	if token == tokens.NativeAsset {
+		change.Symbol = "ETH"
+		change.Amount = tokens.FromBaseUnits(delta, 18)
+		return change
+	}
+	if t, ok := tokens.LookupAddress(chain, token); ok {
+		change.Symbol = t.Symbol
+		change.Amount = tokens.FromBaseUnits(delta, t.Decimals)
+	}
+	return change
+}
+
+// buildOverrides merges explicit overrides with token balance and allowance
+// overrides, which are translated to storage writes on the token's mappings
+func buildOverrides(chain string, account common.Address, balances map[string]string, allowances map[string]map[string]string, explicit map[common.Address]AccountOverride) (map[common.Address]AccountOverride, error) {
+	overrides := make(map[common.Address]AccountOverride, len(explicit)+len(balances))
+	for addr, o := range explicit {
+		overrides[addr] = o
+	}
+
+	for tokenAddress, amount := range balances {
+		token, ok := tokens.LookupAddress(chain, tokenAddress)
+		if !ok || token.BalanceSlot == nil {
+			return nil, fmt.Errorf("cannot override balance of unknown token %s", tokenAddress)
+		}
+		value, ok := new(big.Int).SetString(amount, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid balance for %s: %s", tokenAddress, amount)
+		}
+
+		addr := common.HexToAddress(token.Address)
+		o := overrides[addr]
+		if o.StateDiff == nil {
+			o.StateDiff = make(map[common.Hash]common.Hash)
+		}
+		o.StateDiff[mappingSlot(account, *token.BalanceSlot)] = common.BigToHash(value)
+		overrides[addr] = o
+	}
+
+	for tokenAddress, spenders := range allowances {
+		token, ok := tokens.LookupAddress(chain, tokenAddress)
+		if !ok || token.AllowanceSlot == nil {
+			return nil, fmt.Errorf("cannot override allowance of unknown token %s", tokenAddress)
+		}
+
+		addr := common.HexToAddress(token.Address)
+		o := overrides[addr]
+		if o.StateDiff == nil {
+			o.StateDiff = make(map[common.Hash]common.Hash)
+		}
+		for spender, amount := range spenders {
+			value, ok := new(big.Int).SetString(amount, 10)
+			if !ok {
+				return nil, fmt.Errorf("invalid allowance for %s: %s", tokenAddress, amount)
+			}
+			// allowances[owner][spender]
+			outer := mappingSlot(account, *token.AllowanceSlot)
+			o.StateDiff[nestedMappingSlot(common.HexToAddress(spender), outer)] = common.BigToHash(value)
+		}
+		overrides[addr] = o
+	}
+
+	return overrides, nil
+}
+
+// mappingSlot returns the storage key of mapping[key] for a mapping at the given slot
+func mappingSlot(key common.Address, slot int) common.Hash {
+	return crypto.Keccak256Hash(
+		common.LeftPadBytes(key.Bytes(), 32),
+		common.LeftPadBytes(big.NewInt(int64(slot)).Bytes(), 32),
+	)
+}
+
+// nestedMappingSlot returns the storage key of mapping[key] for a mapping
+// stored at a computed slot, such as the inner mapping of a mapping of mappings
+func nestedMappingSlot(key common.Address, slot common.Hash) common.Hash {
+	return crypto.Keccak256Hash(common.LeftPadBytes(key.Bytes(), 32), slot.Bytes())
+}
+
+// isRevert reports whether an RPC error is an EVM revert rather than a transport failure
+func isRevert(err error) bool {
+	if RevertReason(err) != "" {
+		return true
+	}
+	return strings.Contains(err.Error(), "execution reverted") || errors.Is(err, ErrExecutionReverted)
+}
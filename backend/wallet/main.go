@@ -1,22 +1,71 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/defioptimization/shared/database"
 	"github.com/defioptimization/wallet/server"
+	"github.com/defioptimization/wallet/tracker"
 )
 
 func main() {
+	// Initialize database
+	if err := database.InitDatabase(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.CloseDatabase()
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8082"
 	}
 
 	srv := server.NewServer()
+
+	// Start transaction tracker
+	apiURL := os.Getenv("API_URL")
+	if apiURL == "" {
+		apiURL = "http://localhost:8080"
+	}
+
+	confirmations := map[string]uint64{
+		"ethereum": getEnvUint("ETH_CONFIRMATIONS", 12),
+		"base":     getEnvUint("BASE_CONFIRMATIONS", 10),
+	}
+
+	notifier := tracker.NewHTTPNotifier(apiURL, os.Getenv("INTERNAL_API_TOKEN"))
+	txTracker := tracker.NewTracker(srv.Connector(), srv.Nonces(), notifier, confirmations, 30*time.Minute)
+	go func() {
+		interval := 15 * time.Second // Poll receipts every 15 seconds
+		if err := txTracker.Start(context.Background(), interval); err != nil {
+			log.Printf("Transaction tracker stopped: %v", err)
+		}
+	}()
+
+	go func() {
+		// Wallets answer transaction requests over the relay
+		if err := srv.WatchResponses(context.Background(), 5*time.Second); err != nil {
+			log.Printf("WalletConnect response watcher stopped: %v", err)
+		}
+	}()
+
 	log.Printf("Wallet Service starting on port %s", port)
 	if err := srv.Start(":" + port); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
 
+// getEnvUint gets an unsigned integer environment variable or returns a default value
+func getEnvUint(key string, defaultValue uint64) uint64 {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseUint(value, 10, 64); err == nil {
+			return parsed
+		}
+		log.Printf("Invalid value for %s: %q, using %d", key, value, defaultValue)
+	}
+	return defaultValue
+}
@@ -0,0 +1,166 @@
+package nonce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/wallet/connector"
+	"github.com/ethereum/go-ethereum/common"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNotReserved is returned when releasing a nonce that is not reserved,
+// e.g. because its transaction was already broadcast
+var ErrNotReserved = errors.New("nonce is not reserved")
+
+// Manager hands out nonces per (chain, address) so concurrent transactions
+// from the same account never collide. The account row is locked with
+// SELECT ... FOR UPDATE, which serializes reservations across replicas.
+type Manager struct {
+	connector *connector.WalletConnector
+	ttl       time.Duration
+}
+
+// NewManager creates a nonce manager. Reservations that are not broadcast
+// within ttl are released so their nonce can be reused.
+func NewManager(wc *connector.WalletConnector, ttl time.Duration) *Manager {
+	return &Manager{
+		connector: wc,
+		ttl:       ttl,
+	}
+}
+
+// Reserve returns the next nonce for an account. Nonces released by dropped
+// or abandoned transactions are handed out again before new ones, so a gap
+// never blocks later transactions.
+func (m *Manager) Reserve(ctx context.Context, chain, address string) (uint64, error) {
+	client, err := m.connector.GetClient(chain)
+	if err != nil {
+		return 0, err
+	}
+
+	account := common.HexToAddress(address)
+	var reserved uint64
+
+	// The node's pending nonce is authoritative: anything below it is used.
+	// It is read before the account row is locked so a slow node doesn't hold
+	// the lock; reservations made meanwhile only raise NextNonce.
+	pending, err := client.PendingNonceAt(ctx, account)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch pending nonce: %w", err)
+	}
+
+	err = database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Ensure the account row exists, then lock it
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.AccountNonce{Chain: chain, Address: account.Hex()}).Error; err != nil {
+			return err
+		}
+
+		var state models.AccountNonce
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("chain = ? AND address = ?", chain, account.Hex()).
+			First(&state).Error; err != nil {
+			return err
+		}
+
+		if state.NextNonce < pending {
+			state.NextNonce = pending
+		}
+
+		now := time.Now()
+		if err := tx.Model(&models.NonceReservation{}).
+			Where("chain = ? AND address = ? AND status = ? AND expires_at < ?",
+				chain, account.Hex(), models.NonceStatusReserved, now).
+			Update("status", models.NonceStatusReleased).Error; err != nil {
+			return err
+		}
+
+		// Fill the lowest gap first
+		var gap models.NonceReservation
+		err := tx.Where("chain = ? AND address = ? AND status = ? AND nonce >= ? AND nonce < ?",
+			chain, account.Hex(), models.NonceStatusReleased, pending, state.NextNonce).
+			Order("nonce ASC").
+			First(&gap).Error
+		if err == nil {
+			gap.Status = models.NonceStatusReserved
+			gap.TxHash = ""
+			gap.ExpiresAt = now.Add(m.ttl)
+			reserved = gap.Nonce
+			return tx.Save(&gap).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		reserved = state.NextNonce
+		state.NextNonce++
+		if err := tx.Save(&state).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.NonceReservation{
+			Chain:     chain,
+			Address:   account.Hex(),
+			Nonce:     reserved,
+			Status:    models.NonceStatusReserved,
+			ExpiresAt: now.Add(m.ttl),
+		}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return reserved, nil
+}
+
+// MarkBroadcast records that a reserved nonce was used by a broadcast transaction
+func (m *Manager) MarkBroadcast(chain, address string, nonce uint64, txHash string) error {
+	return database.DB.Model(&models.NonceReservation{}).
+		Where("chain = ? AND address = ? AND nonce = ? AND status <> ?",
+			chain, common.HexToAddress(address).Hex(), nonce, models.NonceStatusBroadcast).
+		Updates(map[string]interface{}{
+			"status":  models.NonceStatusBroadcast,
+			"tx_hash": txHash,
+		}).Error
+}
+
+// Hold keeps a reserved nonce from expiring before until, while the
+// transaction it was reserved for waits on the user's wallet
+func (m *Manager) Hold(chain, address string, nonce uint64, until time.Time) error {
+	return database.DB.Model(&models.NonceReservation{}).
+		Where("chain = ? AND address = ? AND nonce = ? AND status = ? AND expires_at < ?",
+			chain, common.HexToAddress(address).Hex(), nonce, models.NonceStatusReserved, until).
+		Update("expires_at", until).Error
+}
+
+// Release returns a reserved nonce to the pool when a built transaction is
+// abandoned before signing. Nonces already used by a broadcast transaction
+// are not released; it returns ErrNotReserved for them.
+func (m *Manager) Release(chain, address string, nonce uint64) error {
+	result := database.DB.Model(&models.NonceReservation{}).
+		Where("chain = ? AND address = ? AND nonce = ? AND status = ?",
+			chain, common.HexToAddress(address).Hex(), nonce, models.NonceStatusReserved).
+		Update("status", models.NonceStatusReleased)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotReserved
+	}
+	return nil
+}
+
+// ReleaseDropped returns the nonce of a broadcast transaction the tracker
+// found dropped, so the next transaction fills the gap
+func (m *Manager) ReleaseDropped(chain, address string, nonce uint64, txHash string) error {
+	return database.DB.Model(&models.NonceReservation{}).
+		Where("chain = ? AND address = ? AND nonce = ? AND status = ? AND tx_hash = ?",
+			chain, common.HexToAddress(address).Hex(), nonce, models.NonceStatusBroadcast, txHash).
+		Update("status", models.NonceStatusReleased).Error
+}
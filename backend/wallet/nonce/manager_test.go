@@ -0,0 +1,163 @@
+package nonce
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/wallet/connector"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// pendingNonce is the account nonce the fake node reports
+const pendingNonce = 40
+
+// testManager returns a manager backed by a node that reports pendingNonce
+// for every account, and a fresh account to reserve for
+func testManager(t *testing.T, ttl time.Duration) (*Manager, string) {
+	t.Helper()
+	testDB(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Method != "eth_getTransactionCount" {
+			t.Errorf("unexpected RPC call %s", req.Method)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": hexutil.Uint64(pendingNonce)})
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("ETH_RPC_URL", srv.URL)
+
+	// Stored as checksummed
+	address := common.HexToAddress(fmt.Sprintf("0x%040x", time.Now().UnixNano())).Hex()
+	t.Cleanup(func() {
+		database.DB.Where("address = ?", address).Delete(&models.NonceReservation{})
+		database.DB.Where("address = ?", address).Delete(&models.AccountNonce{})
+	})
+	return NewManager(connector.NewWalletConnector(), ttl), address
+}
+
+func reserve(t *testing.T, m *Manager, address string) uint64 {
+	t.Helper()
+	n, err := m.Reserve(context.Background(), "ethereum", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestReserveConcurrently(t *testing.T) {
+	m, address := testManager(t, time.Minute)
+
+	const workers = 10
+	var wg sync.WaitGroup
+	nonces := make(chan uint64, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := m.Reserve(context.Background(), "ethereum", address)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			nonces <- n
+		}()
+	}
+	wg.Wait()
+	close(nonces)
+
+	var got []uint64
+	for n := range nonces {
+		got = append(got, n)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+	for i, n := range got {
+		if n != pendingNonce+uint64(i) {
+			t.Fatalf("reserved %v, want %d consecutive nonces from %d", got, workers, pendingNonce)
+		}
+	}
+}
+
+func TestReserveFillsGaps(t *testing.T) {
+	m, address := testManager(t, time.Minute)
+	first, second, third := reserve(t, m, address), reserve(t, m, address), reserve(t, m, address)
+
+	// The second transaction is abandoned; the third is already out
+	if err := m.Release("ethereum", address, second); err != nil {
+		t.Fatal(err)
+	}
+	if got := reserve(t, m, address); got != second {
+		t.Fatalf("reserved %d after releasing %d, want the gap filled", got, second)
+	}
+	if got := reserve(t, m, address); got != third+1 {
+		t.Fatalf("reserved %d with no gap, want %d", got, third+1)
+	}
+	if first != pendingNonce {
+		t.Fatalf("first reservation %d, want the node's pending nonce %d", first, pendingNonce)
+	}
+}
+
+func TestReservationExpiry(t *testing.T) {
+	m, address := testManager(t, 50*time.Millisecond)
+	expiring, held := reserve(t, m, address), reserve(t, m, address)
+
+	// The held nonce's transaction is waiting on the user's wallet
+	if err := m.Hold("ethereum", address, held, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if got := reserve(t, m, address); got != expiring {
+		t.Fatalf("reserved %d, want expired nonce %d handed out again", got, expiring)
+	}
+	if got := reserve(t, m, address); got == held {
+		t.Fatalf("held nonce %d handed out again", held)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	m, address := testManager(t, time.Minute)
+	reserved, broadcast := reserve(t, m, address), reserve(t, m, address)
+	if err := m.MarkBroadcast("ethereum", address, broadcast, "0xsent"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Release("ethereum", address, reserved); err != nil {
+		t.Fatalf("releasing a reserved nonce: %v", err)
+	}
+	if err := m.Release("ethereum", address, reserved); !errors.Is(err, ErrNotReserved) {
+		t.Fatalf("releasing twice = %v, want ErrNotReserved", err)
+	}
+	if err := m.Release("ethereum", address, broadcast); !errors.Is(err, ErrNotReserved) {
+		t.Fatalf("releasing a broadcast nonce = %v, want ErrNotReserved", err)
+	}
+
+	// Only the dropped transaction itself frees a broadcast nonce
+	status := func() string {
+		var r models.NonceReservation
+		if err := database.DB.Where("address = ? AND nonce = ?", address, broadcast).First(&r).Error; err != nil {
+			t.Fatal(err)
+		}
+		return r.Status
+	}
+	if err := m.ReleaseDropped("ethereum", address, broadcast, "0xother"); err != nil || status() != models.NonceStatusBroadcast {
+		t.Fatalf("another transaction's drop released the nonce: %v, %s", err, status())
+	}
+	if err := m.ReleaseDropped("ethereum", address, broadcast, "0xsent"); err != nil || status() != models.NonceStatusReleased {
+		t.Fatalf("dropped transaction's nonce not released: %v, %s", err, status())
+	}
+}
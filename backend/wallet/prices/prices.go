@@ -0,0 +1,79 @@
+package prices
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/defioptimization/shared/clients"
+)
+
+// Oracle returns USD prices from the DeFi service's price oracle, caching
+// each price for a short time so balance lookups don't hammer it
+type Oracle struct {
+	defi *clients.DeFi
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedPrice
+}
+
+type cachedPrice struct {
+	price     float64
+	fetchedAt time.Time
+}
+
+// stablecoins are priced at $1 when the oracle has no feed for them
+var stablecoins = map[string]bool{
+	"USDC": true,
+	"USDT": true,
+	"DAI":  true,
+}
+
+// NewOracle creates a price oracle client
+func NewOracle(defiServiceURL string, ttl time.Duration) *Oracle {
+	return &Oracle{
+		defi:  clients.NewDeFi(defiServiceURL, clients.WithTimeout(10*time.Second)),
+		ttl:   ttl,
+		cache: make(map[string]cachedPrice),
+	}
+}
+
+// Price returns the USD price of an asset on a chain
+func (o *Oracle) Price(ctx context.Context, chain, symbol string) (float64, error) {
+	symbol = strings.ToUpper(symbol)
+	key := chain + ":" + symbol
+
+	o.mu.Lock()
+	if cached, ok := o.cache[key]; ok && time.Since(cached.fetchedAt) < o.ttl {
+		o.mu.Unlock()
+		return cached.price, nil
+	}
+	o.mu.Unlock()
+
+	price, err := o.fetch(ctx, chain, symbol)
+	if err != nil {
+		// Only a missing feed falls back to $1. Timeouts and outages are
+		// returned so a depeg or a down oracle isn't reported as par.
+		if stablecoins[symbol] && clients.IsNotFound(err) {
+			return 1, nil
+		}
+		return 0, err
+	}
+
+	o.mu.Lock()
+	o.cache[key] = cachedPrice{price: price, fetchedAt: time.Now()}
+	o.mu.Unlock()
+
+	return price, nil
+}
+
+// fetch queries the Aave oracle through the DeFi service
+func (o *Oracle) fetch(ctx context.Context, chain, symbol string) (float64, error) {
+	result, err := o.defi.Price(ctx, "aave", symbol, chain, 0)
+	if err != nil {
+		return 0, err
+	}
+	return result.Price, nil
+}
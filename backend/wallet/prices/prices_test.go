@@ -0,0 +1,58 @@
+package prices
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPriceStablecoinFallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		symbol  string
+		status  int
+		body    string
+		want    float64
+		wantErr bool
+	}{
+		{"oracle price", "USDC", http.StatusOK, `{"price":0.97}`, 0.97, false},
+		{"no feed for stablecoin", "USDC", http.StatusNotFound, `{"error":"no price feed for asset"}`, 1, false},
+		{"no feed for other asset", "WETH", http.StatusNotFound, `{"error":"no price feed for asset"}`, 0, true},
+		{"oracle error", "USDT", http.StatusInternalServerError, `{"error":"failed to query Aave oracle"}`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v1/protocols/aave/price" || r.URL.Query().Get("asset") != tt.symbol {
+					t.Errorf("unexpected request %s", r.URL)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			price, err := NewOracle(srv.URL, time.Minute).Price(context.Background(), "ethereum", tt.symbol)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Price error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if price != tt.want {
+				t.Fatalf("Price = %v, want %v", price, tt.want)
+			}
+		})
+	}
+}
+
+func TestPriceOracleDown(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if price, err := NewOracle(url, time.Minute).Price(ctx, "ethereum", "DAI"); err == nil {
+		t.Fatalf("Price = %v with the DeFi service down, want an error", price)
+	}
+}
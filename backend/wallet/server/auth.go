@@ -0,0 +1,120 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// internalAuth authenticates service-to-service requests using a shared token
+func internalAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !validInternalToken(c) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid internal token"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// userAuth authenticates users with the JWT issued by the API gateway and
+// sets the user in the context
+func userAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authenticateUser(c) {
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// userOrInternalAuth accepts either another service's internal token or a
+// user's JWT. Only user requests set the user in the context.
+func userOrInternalAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("X-Internal-Token") != "" {
+			if !validInternalToken(c) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid internal token"})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if !authenticateUser(c) {
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func validInternalToken(c *gin.Context) bool {
+	expected := os.Getenv("INTERNAL_API_TOKEN")
+	provided := c.GetHeader("X-Internal-Token")
+	return expected != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) == 1
+}
+
+// authenticateUser validates the bearer token and loads its user. It
+// responds with 401 and returns false when the request is not authenticated.
+func authenticateUser(c *gin.Context) bool {
+	authHeader := c.GetHeader("Authorization")
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+		return false
+	}
+
+	secret := os.Getenv("JWT_SECRET")
+	token, err := jwt.Parse(parts[1], func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(secret), nil
+	})
+	if secret == "" || err != nil || !token.Valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return false
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return false
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID in token"})
+		return false
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, uint(userID)).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return false
+	}
+
+	c.Set("user", &user)
+	return true
+}
+
+// currentUser returns the authenticated user, or nil for internal requests
+func currentUser(c *gin.Context) *models.User {
+	value, ok := c.Get("user")
+	if !ok {
+		return nil
+	}
+	return value.(*models.User)
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("INTERNAL_API_TOKEN", "internal-secret")
+	t.Setenv("JWT_SECRET", "jwt-secret")
+
+	r := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/internal", internalAuth(), ok)
+	r.GET("/user", userAuth(), ok)
+	r.GET("/either", userOrInternalAuth(), ok)
+
+	tests := []struct {
+		name    string
+		path    string
+		headers map[string]string
+		want    int
+	}{
+		{"internal without token", "/internal", nil, http.StatusUnauthorized},
+		{"internal with wrong token", "/internal", map[string]string{"X-Internal-Token": "guess"}, http.StatusUnauthorized},
+		{"internal with token", "/internal", map[string]string{"X-Internal-Token": "internal-secret"}, http.StatusOK},
+		{"user without JWT", "/user", nil, http.StatusUnauthorized},
+		{"user with internal token", "/user", map[string]string{"X-Internal-Token": "internal-secret"}, http.StatusUnauthorized},
+		{"user with bad JWT", "/user", map[string]string{"Authorization": "Bearer not-a-jwt"}, http.StatusUnauthorized},
+		{"either without credentials", "/either", nil, http.StatusUnauthorized},
+		{"either with wrong token", "/either", map[string]string{"X-Internal-Token": "guess"}, http.StatusUnauthorized},
+		{"either with internal token", "/either", map[string]string{"X-Internal-Token": "internal-secret"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestInternalAuthWithoutConfiguredToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("INTERNAL_API_TOKEN", "")
+
+	r := gin.New()
+	r.GET("/internal", internalAuth(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req := httptest.NewRequest(http.MethodGet, "/internal", nil)
+	req.Header.Set("X-Internal-Token", "")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestBuildReserveNonceRequiresInternalToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("INTERNAL_API_TOKEN", "internal-secret")
+
+	s := &Server{router: gin.New()}
+	s.setupRoutes()
+
+	// An unknown speed fails right after the token check, before anything
+	// is built or reserved
+	body := `{"chain":"ethereum","from":"0x1111111111111111111111111111111111111111","to":"0x2222222222222222222222222222222222222222","speed":"warp","reserve_nonce":true}`
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"without token", "", http.StatusUnauthorized},
+		{"with wrong token", "guess", http.StatusUnauthorized},
+		{"with internal token", "internal-secret", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/wallet/build", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.token != "" {
+				req.Header.Set("X-Internal-Token", tt.token)
+			}
+			w := httptest.NewRecorder()
+			s.router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d (%s), want %d", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/shared/tokens"
+	"github.com/defioptimization/wallet/nonce"
+	"github.com/defioptimization/wallet/walletconnect"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// WatchResponses records the wallets' answers to transaction requests every
+// interval until ctx is done
+func (s *Server) WatchResponses(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.recordResponses(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordResponses fetches and records one round of wallet answers
+func (s *Server) recordResponses(ctx context.Context) {
+	responses, err := s.sessions.Responses(ctx)
+	if err != nil {
+		log.Printf("Error fetching wallet responses: %v", err)
+	}
+	for _, r := range responses {
+		s.recordResponse(ctx, r)
+	}
+}
+
+// recordResponse records the transaction a wallet sent for a request, so the
+// tracker follows it and its nonce stays taken. The nonce of a request the
+// wallet refused goes back to the pool.
+func (s *Server) recordResponse(ctx context.Context, r walletconnect.Response) {
+	req := r.Request
+	if !r.Sent() {
+		log.Printf("Wallet %s refused request %d on %s: %s", req.FromAddress, req.RequestID, req.Chain, r.Error)
+		if req.Nonce != nil {
+			if err := s.nonces.Release(req.Chain, req.FromAddress, *req.Nonce); err != nil && !errors.Is(err, nonce.ErrNotReserved) {
+				log.Printf("Error releasing nonce %d of %s: %v", *req.Nonce, req.FromAddress, err)
+			}
+		}
+		return
+	}
+
+	record := requestedTransaction(req, r.TxHash)
+	if err := database.DB.WithContext(ctx).Where("tx_hash = ?", record.TxHash).FirstOrCreate(&record).Error; err != nil {
+		log.Printf("Error recording transaction %s of request %d: %v", r.TxHash, req.RequestID, err)
+		return
+	}
+	if req.Nonce != nil {
+		if err := s.nonces.MarkBroadcast(req.Chain, req.FromAddress, *req.Nonce, r.TxHash); err != nil {
+			log.Printf("Error marking nonce %d as broadcast: %v", *req.Nonce, err)
+		}
+	}
+}
+
+// requestedTransaction builds the pending record of a transaction the wallet
+// sent for a request, from the eth_sendTransaction parameters it was given
+func requestedTransaction(req models.WalletRequest, txHash string) models.Transaction {
+	quantity := func(key string) *big.Int {
+		s, _ := req.Params[key].(string)
+		n, err := hexutil.DecodeBig(s)
+		if err != nil {
+			return new(big.Int)
+		}
+		return n
+	}
+
+	to, _ := req.Params["to"].(string)
+	data, _ := req.Params["data"].(string)
+	requestID := req.RequestID
+	value, maxFee := quantity("value"), quantity("maxFeePerGas")
+
+	return models.Transaction{
+		UserID:           req.UserID,
+		TxHash:           txHash,
+		Chain:            req.Chain,
+		FromAddress:      req.FromAddress,
+		ToAddress:        to,
+		Type:             req.Type,
+		Status:           models.TxStatusPending,
+		Value:            tokens.FromBaseUnits(value, 18),
+		Nonce:            req.Nonce,
+		GasPrice:         maxFee.String(),
+		AutomationRuleID: req.AutomationRuleID,
+		RequestID:        &requestID,
+		TxData: map[string]interface{}{
+			"gas_limit":                quantity("gas").Uint64(),
+			"max_fee_per_gas":          maxFee.String(),
+			"max_priority_fee_per_gas": quantity("maxPriorityFeePerGas").String(),
+			"value_wei":                value.String(),
+			"data":                     data,
+		},
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/defioptimization/shared/models"
+)
+
+func TestRequestedTransaction(t *testing.T) {
+	nonce, ruleID := uint64(9), uint(3)
+	req := models.WalletRequest{
+		RequestID:   1700000000000123,
+		UserID:      4,
+		Chain:       "base",
+		FromAddress: "0x1111111111111111111111111111111111111111",
+		Nonce:       &nonce,
+		Params: map[string]interface{}{
+			"from":                 "0x1111111111111111111111111111111111111111",
+			"to":                   "0xA238Dd80C259a72e81d7e4664a9801593F98d1c5",
+			"data":                 "0x617ba037",
+			"value":                "0xde0b6b3a7640000", // 1 ETH
+			"gas":                  "0x30d40",
+			"maxFeePerGas":         "0x77359400",
+			"maxPriorityFeePerGas": "0x3b9aca00",
+		},
+		Type:             "deposit",
+		AutomationRuleID: &ruleID,
+	}
+
+	tx := requestedTransaction(req, "0xhash")
+	if tx.TxHash != "0xhash" || tx.UserID != 4 || tx.Chain != "base" || tx.FromAddress != req.FromAddress ||
+		tx.ToAddress != "0xA238Dd80C259a72e81d7e4664a9801593F98d1c5" || tx.Type != "deposit" || tx.Status != models.TxStatusPending {
+		t.Fatalf("transaction = %+v", tx)
+	}
+	// The tracker and the engine find it by nonce and request
+	if tx.Nonce == nil || *tx.Nonce != 9 || tx.RequestID == nil || *tx.RequestID != req.RequestID || *tx.AutomationRuleID != 3 {
+		t.Fatalf("nonce %v, request %v, rule %v", tx.Nonce, tx.RequestID, tx.AutomationRuleID)
+	}
+	if tx.Value != 1 || tx.GasPrice != "2000000000" {
+		t.Fatalf("value %v, gas price %s", tx.Value, tx.GasPrice)
+	}
+	want := map[string]interface{}{
+		"gas_limit":                uint64(200000),
+		"max_fee_per_gas":          "2000000000",
+		"max_priority_fee_per_gas": "1000000000",
+		"value_wei":                "1000000000000000000",
+		"data":                     "0x617ba037",
+	}
+	for key, value := range want {
+		if tx.TxData[key] != value {
+			t.Errorf("tx_data[%s] = %v, want %v", key, tx.TxData[key], value)
+		}
+	}
+
+	// A wallet may fill in what it wasn't given
+	tx = requestedTransaction(models.WalletRequest{Params: map[string]interface{}{"to": "0xabc"}}, "0xhash")
+	if tx.Value != 0 || tx.GasPrice != "0" || tx.Nonce != nil {
+		t.Fatalf("transaction without fees = %+v", tx)
+	}
+}
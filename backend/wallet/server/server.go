@@ -1,26 +1,56 @@
 package server
 
 import (
+	"errors"
+	"log"
+	"math/big"
 	"net/http"
+	"os"
+	"time"
 
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/shared/tokens"
 	"github.com/defioptimization/wallet/connector"
+	"github.com/defioptimization/wallet/nonce"
+	"github.com/defioptimization/wallet/prices"
+	"github.com/defioptimization/wallet/walletconnect"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 	"github.com/gin-gonic/gin"
 )
 
 // Server handles HTTP requests for the wallet service
 type Server struct {
-	router     *gin.Engine
-	connector  *connector.WalletConnector
+	router    *gin.Engine
+	connector *connector.WalletConnector
+	nonces    *nonce.Manager
+	sessions  *walletconnect.Manager
+	prices    *prices.Oracle
 }
 
 // NewServer creates a new server instance
 func NewServer() *Server {
 	r := gin.Default()
 	wc := connector.NewWalletConnector()
-	
+
+	relay, err := walletconnect.NewRelay()
+	if err != nil {
+		log.Fatalf("Failed to create WalletConnect relay: %v", err)
+	}
+
+	defiServiceURL := os.Getenv("DEFI_SERVICE_URL")
+	if defiServiceURL == "" {
+		defiServiceURL = "http://localhost:8081"
+	}
+
 	s := &Server{
 		router:    r,
 		connector: wc,
+		nonces:    nonce.NewManager(wc, 10*time.Minute),
+		sessions:  walletconnect.NewManager(relay, wc),
+		prices:    prices.NewOracle(defiServiceURL, time.Minute),
 	}
 	s.setupRoutes()
 	return s
@@ -31,14 +61,47 @@ func (s *Server) setupRoutes() {
 	api := s.router.Group("/api/v1")
 	{
 		api.GET("/health", s.healthCheck)
-		api.POST("/wallet/connect", s.connectWallet)
-		api.POST("/wallet/disconnect", s.disconnectWallet)
 		api.POST("/wallet/sign", s.signMessage)
 		api.POST("/wallet/send", s.sendTransaction)
 		api.POST("/wallet/build", s.buildTransaction)
+		api.POST("/wallet/simulate", s.simulateTransaction)
+		api.POST("/wallet/replace", s.replaceTransaction)
+		api.GET("/wallet/balances", s.getBalances)
+		api.GET("/wallet/gas", s.getGasPrice)
+		api.GET("/wallet/allowances", s.listAllowances)
+		api.POST("/wallet/allowances/check", s.checkAllowance)
+
+		// Users manage their own sessions; the automation engine finds and
+		// uses them with the internal token
+		user := api.Group("")
+		user.Use(userAuth())
+		user.POST("/wallet/connect", s.connectWallet)
+		user.POST("/wallet/disconnect", s.disconnectWallet)
+		user.GET("/wallet/sessions", s.listSessions)
+
+		internal := api.Group("")
+		internal.Use(internalAuth())
+		internal.GET("/wallet/sessions/active", s.activeSession)
+		internal.POST("/wallet/nonces/reserve", s.reserveNonce)
+		internal.POST("/wallet/nonces/release", s.releaseNonce)
+
+		owner := api.Group("")
+		owner.Use(userOrInternalAuth())
+		owner.DELETE("/wallet/sessions/:topic", s.revokeSession)
+		owner.POST("/wallet/sessions/:topic/request", s.sessionRequest)
 	}
 }
 
+// Connector returns the wallet connector used by the server
+func (s *Server) Connector() *connector.WalletConnector {
+	return s.connector
+}
+
+// Nonces returns the nonce manager used by the server
+func (s *Server) Nonces() *nonce.Manager {
+	return s.nonces
+}
+
 // Start starts the HTTP server
 func (s *Server) Start(addr string) error {
 	return s.router.Run(addr)
@@ -52,99 +115,347 @@ func (s *Server) healthCheck(c *gin.Context) {
 	})
 }
 
-// connectWallet handles wallet connection requests
+// connectWallet records a WalletConnect session approved by the user's
+// wallet. Pairing happens in the frontend; the backend keeps the session so
+// it can later ask the wallet to sign. The wallet proves it handed the
+// session over by signing walletconnect.ProofMessage.
 func (s *Server) connectWallet(c *gin.Context) {
 	var req struct {
-		WalletAddress string `json:"wallet_address" binding:"required"`
-		Chain         string `json:"chain" binding:"required"`
+		WalletAddress string                  `json:"wallet_address" binding:"required"`
+		Topic         string                  `json:"topic"`
+		PairingTopic  string                  `json:"pairing_topic"`
+		SymKey        string                  `json:"sym_key" binding:"required"`
+		Namespace     walletconnect.Namespace `json:"namespace" binding:"required"`
+		Expiry        int64                   `json:"expiry" binding:"required"` // unix seconds
+		Peer          walletconnect.Peer      `json:"peer"`
+		Signature     string                  `json:"signature" binding:"required"` // personal_sign of the proof message
 	}
-	
+
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	
-	// TODO: Implement actual wallet connection logic
-	// This would typically involve WalletConnect session creation
-	
+
+	session, err := s.sessions.Register(c.Request.Context(), currentUser(c), walletconnect.Settlement{
+		WalletAddress: req.WalletAddress,
+		Topic:         req.Topic,
+		PairingTopic:  req.PairingTopic,
+		SymKey:        req.SymKey,
+		Namespace:     req.Namespace,
+		Expiry:        time.Unix(req.Expiry, 0),
+		Peer:          req.Peer,
+		Signature:     req.Signature,
+	})
+	if errors.Is(err, walletconnect.ErrInvalidProof) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"connected": true,
-		"wallet_address": req.WalletAddress,
-		"chain": req.Chain,
+		"session":   session,
 	})
 }
 
-// disconnectWallet handles wallet disconnection
+// disconnectWallet revokes every active session of the user
 func (s *Server) disconnectWallet(c *gin.Context) {
+	user := currentUser(c)
+	revoked, err := s.sessions.RevokeUser(c.Request.Context(), user.ID)
+	if err != nil {
+		log.Printf("Error revoking sessions for user %d: %v", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"disconnected":     true,
+		"revoked_sessions": revoked,
+	})
+}
+
+// listSessions returns all sessions of the user
+func (s *Server) listSessions(c *gin.Context) {
+	sessions, err := s.sessions.List(c.Request.Context(), currentUser(c).ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// activeSession reports whether a wallet can currently be asked to sign on a chain
+func (s *Server) activeSession(c *gin.Context) {
+	address := c.Query("address")
+	chain := c.Query("chain")
+	if address == "" || chain == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "address and chain are required"})
+		return
+	}
+
+	session, err := s.sessions.Active(c.Request.Context(), address, chain)
+	if errors.Is(err, walletconnect.ErrNoActiveSession) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// ownsSession reports whether the caller may use the session with the
+// topic: users only their own, other services any. It responds with 404 for
+// sessions the caller can't see.
+func (s *Server) ownsSession(c *gin.Context, topic string) bool {
+	user := currentUser(c)
+	if user == nil {
+		return true
+	}
+	session, err := s.sessions.Get(c.Request.Context(), topic)
+	if err == nil && session.UserID == user.ID {
+		return true
+	}
+	if err == nil || errors.Is(err, walletconnect.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": walletconnect.ErrSessionNotFound.Error()})
+		return false
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	return false
+}
+
+// revokeSession revokes a single session
+func (s *Server) revokeSession(c *gin.Context) {
+	if !s.ownsSession(c, c.Param("topic")) {
+		return
+	}
+
+	session, err := s.sessions.Revoke(c.Request.Context(), c.Param("topic"))
+	if errors.Is(err, walletconnect.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil && session == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking session %s: %v", session.Topic, err)
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// sessionRequest asks the wallet behind a session to run a JSON-RPC method,
+// typically eth_sendTransaction for a built transaction
+func (s *Server) sessionRequest(c *gin.Context) {
 	var req struct {
-		WalletAddress string `json:"wallet_address" binding:"required"`
+		Chain            string        `json:"chain" binding:"required"`
+		Method           string        `json:"method" binding:"required"`
+		Params           []interface{} `json:"params" binding:"required"`
+		Type             string        `json:"type"` // rebalance, deposit, withdraw
+		AutomationRuleID *uint         `json:"automation_rule_id"`
 	}
-	
+
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	
-	c.JSON(http.StatusOK, gin.H{"disconnected": true})
+
+	if !s.ownsSession(c, c.Param("topic")) {
+		return
+	}
+
+	id, err := s.sessions.Request(c.Request.Context(), c.Param("topic"), req.Chain, req.Method, req.Params,
+		walletconnect.Origin{Type: req.Type, AutomationRuleID: req.AutomationRuleID})
+	switch {
+	case errors.Is(err, walletconnect.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, walletconnect.ErrSessionInactive):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The nonce stays reserved for as long as the wallet's answer is accepted
+	if req.Method == "eth_sendTransaction" {
+		if from, n, err := walletconnect.TransactionNonce(req.Params); err == nil && n != nil {
+			if err := s.nonces.Hold(req.Chain, from, *n, time.Now().Add(walletconnect.ResponseWindow)); err != nil {
+				log.Printf("Error holding nonce %d of %s: %v", *n, from, err)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"topic":      c.Param("topic"),
+		"request_id": id,
+	})
 }
 
-// signMessage handles message signing requests
+// signMessage verifies a personal_sign or EIP-712 signature. Signing itself
+// always happens in the user's wallet; the backend only checks the result.
 func (s *Server) signMessage(c *gin.Context) {
 	var req struct {
-		WalletAddress string `json:"wallet_address" binding:"required"`
-		Message       string `json:"message" binding:"required"`
-		Chain         string `json:"chain" binding:"required"`
+		WalletAddress string              `json:"wallet_address" binding:"required"`
+		Chain         string              `json:"chain" binding:"required"`
+		Signature     string              `json:"signature" binding:"required"`
+		Type          string              `json:"type"` // personal_sign (default), eip712
+		Message       string              `json:"message"`
+		TypedData     *apitypes.TypedData `json:"typed_data"`
 	}
-	
+
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	
-	// TODO: Implement actual message signing
-	// This would use WalletConnect or MetaMask SDK
-	
+
+	var hash []byte
+	switch req.Type {
+	case "", "personal_sign":
+		if req.Message == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "message is required for personal_sign"})
+			return
+		}
+		hash = connector.PersonalSignHash(req.Message)
+	case "eip712":
+		if req.TypedData == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "typed_data is required for eip712"})
+			return
+		}
+		var err error
+		hash, err = connector.TypedDataHash(*req.TypedData)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be personal_sign or eip712"})
+		return
+	}
+
+	result, err := s.connector.VerifySignature(c.Request.Context(), req.Chain, req.WalletAddress, hash, req.Signature)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"signature": "0x...", // Placeholder
-		"message": req.Message,
+		"valid":             result.Valid,
+		"wallet_address":    req.WalletAddress,
+		"recovered_address": result.RecoveredAddress,
+		"contract_wallet":   result.ContractWallet,
+		"hash":              hexutil.Encode(hash),
 	})
 }
 
-// sendTransaction handles transaction sending
+// sendTransaction broadcasts a transaction signed by the user's wallet
 func (s *Server) sendTransaction(c *gin.Context) {
 	var req struct {
-		WalletAddress string                 `json:"wallet_address" binding:"required"`
-		Chain         string                 `json:"chain" binding:"required"`
-		To            string                 `json:"to" binding:"required"`
-		Value         string                 `json:"value"`
-		Data          string                 `json:"data"`
-		GasLimit      string                 `json:"gas_limit"`
-		GasPrice      string                 `json:"gas_price"`
-		Params        map[string]interface{} `json:"params"`
+		WalletAddress    string `json:"wallet_address" binding:"required"`
+		Chain            string `json:"chain" binding:"required"`
+		RawTx            string `json:"raw_tx" binding:"required"` // RLP-encoded signed transaction, hex
+		Type             string `json:"type"`                      // rebalance, deposit, withdraw
+		AutomationRuleID *uint  `json:"automation_rule_id"`
 	}
-	
+
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	
-	// TODO: Implement actual transaction building and sending
-	// This would build the transaction and return it for user approval
-	
-	c.JSON(http.StatusOK, gin.H{
-		"tx_hash": "0x...", // Placeholder
-		"status": "pending",
-	})
+
+	signedTx, sender, err := s.connector.DecodeSignedTransaction(c.Request.Context(), req.Chain, req.RawTx, req.WalletAddress)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, connector.ErrInvalidSignedTransaction) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Where("LOWER(wallet_address) = LOWER(?)", sender.Hex()).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Resubmitting a known transaction returns the existing record
+	var existing models.Transaction
+	if err := database.DB.Where("tx_hash = ?", signedTx.Hash().Hex()).First(&existing).Error; err == nil {
+		c.JSON(http.StatusOK, existing)
+		return
+	}
+
+	if err := s.connector.SendTransaction(c.Request.Context(), req.Chain, signedTx); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	txType := req.Type
+	if txType == "" {
+		txType = "transfer"
+	}
+
+	to := ""
+	if signedTx.To() != nil {
+		to = signedTx.To().Hex()
+	}
+
+	nonce := signedTx.Nonce()
+	record := models.Transaction{
+		UserID:           user.ID,
+		TxHash:           signedTx.Hash().Hex(),
+		Chain:            req.Chain,
+		FromAddress:      sender.Hex(),
+		ToAddress:        to,
+		Type:             txType,
+		Status:           models.TxStatusPending,
+		Value:            tokens.FromBaseUnits(signedTx.Value(), 18),
+		Nonce:            &nonce,
+		GasPrice:         signedTx.GasFeeCap().String(),
+		AutomationRuleID: req.AutomationRuleID,
+		TxData: map[string]interface{}{
+			"tx_type":                  signedTx.Type(),
+			"gas_limit":                signedTx.Gas(),
+			"max_fee_per_gas":          signedTx.GasFeeCap().String(),
+			"max_priority_fee_per_gas": signedTx.GasTipCap().String(),
+			"value_wei":                signedTx.Value().String(),
+			"data":                     hexutil.Encode(signedTx.Data()),
+		},
+	}
+
+	if err := database.DB.Create(&record).Error; err != nil {
+		// The transaction is already broadcast; the tracker can't follow it without a record
+		log.Printf("Error recording transaction %s: %v", record.TxHash, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction sent but could not be recorded", "tx_hash": record.TxHash})
+		return
+	}
+
+	if err := s.nonces.MarkBroadcast(req.Chain, sender.Hex(), nonce, record.TxHash); err != nil {
+		log.Printf("Error marking nonce %d as broadcast: %v", nonce, err)
+	}
+
+	c.JSON(http.StatusOK, record)
 }
 
 // buildTransaction builds a transaction without sending
 func (s *Server) buildTransaction(c *gin.Context) {
 	var req struct {
-		Chain    string                 `json:"chain" binding:"required"`
-		To       string                 `json:"to" binding:"required"`
-		Value    string                 `json:"value"`
-		Data     string                 `json:"data"`
-		Params   map[string]interface{} `json:"params"`
+		Chain        string                 `json:"chain" binding:"required"`
+		From         string                 `json:"from" binding:"required"`
+		To           string                 `json:"to" binding:"required"`
+		Value        string                 `json:"value"`
+		Data         string                 `json:"data"`
+		Speed        string                 `json:"speed"` // slow, normal, fast
+		ReserveNonce bool                   `json:"reserve_nonce"`
+		Params       map[string]interface{} `json:"params"`
 	}
 	
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -152,13 +463,318 @@ func (s *Server) buildTransaction(c *gin.Context) {
 		return
 	}
 	
+	// A reservation takes the nonce from the account until it expires, so
+	// only the automation engine may ask for one
+	if req.ReserveNonce && !validInternalToken(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "reserve_nonce requires the internal token"})
+		return
+	}
+
+	speed, err := connector.ParseFeeSpeed(req.Speed)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
 	// Build transaction
-	tx, err := s.connector.BuildTransaction(req.Chain, req.To, req.Value, req.Data, req.Params)
+	tx, err := s.connector.BuildTransaction(c.Request.Context(), connector.BuildRequest{
+		Chain: req.Chain,
+		From:  req.From,
+		To:    req.To,
+		Value: req.Value,
+		Data:  req.Data,
+		Speed: speed,
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, connector.ErrExecutionReverted) {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.ReserveNonce {
+		n, err := s.nonces.Reserve(c.Request.Context(), req.Chain, req.From)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		tx.Nonce = &n
+	}
+
+	c.JSON(http.StatusOK, tx)
+}
+
+// simulateTransaction dry-runs a transaction against the pending block
+func (s *Server) simulateTransaction(c *gin.Context) {
+	var req struct {
+		Chain           string                                       `json:"chain" binding:"required"`
+		From            string                                       `json:"from" binding:"required"`
+		To              string                                       `json:"to" binding:"required"`
+		Value           string                                       `json:"value"`
+		Data            string                                       `json:"data"`
+		TokenBalances   map[string]string                            `json:"token_balances"`
+		TokenAllowances map[string]map[string]string                 `json:"token_allowances"`
+		StateOverrides  map[common.Address]connector.AccountOverride `json:"state_overrides"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := s.connector.Simulate(c.Request.Context(), connector.SimulationRequest{
+		Chain:           req.Chain,
+		From:            req.From,
+		To:              req.To,
+		Value:           req.Value,
+		Data:            req.Data,
+		TokenBalances:   req.TokenBalances,
+		TokenAllowances: req.TokenAllowances,
+		StateOverrides:  req.StateOverrides,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	
+
+	c.JSON(http.StatusOK, result)
+}
+
+// replaceTransaction builds a speed-up or cancel replacement for a pending transaction
+func (s *Server) replaceTransaction(c *gin.Context) {
+	var req struct {
+		Chain  string `json:"chain" binding:"required"`
+		TxHash string `json:"tx_hash" binding:"required"`
+		Mode   string `json:"mode" binding:"required"` // speedup, cancel
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Mode != "speedup" && req.Mode != "cancel" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be speedup or cancel"})
+		return
+	}
+
+	tx, err := s.connector.BuildReplacement(c.Request.Context(), req.Chain, req.TxHash, req.Mode == "cancel")
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, tx)
 }
 
+// getGasPrice returns the current EIP-1559 fees on a chain. gas_price_gwei
+// is what a transaction at the requested speed is expected to pay per gas.
+func (s *Server) getGasPrice(c *gin.Context) {
+	chain := c.DefaultQuery("chain", "ethereum")
+
+	speed, err := connector.ParseFeeSpeed(c.Query("speed"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fees, err := s.connector.EstimateFees(c.Request.Context(), chain, speed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	expected := new(big.Int).Add(fees.BaseFee, fees.MaxPriorityFeePerGas)
+	c.JSON(http.StatusOK, gin.H{
+		"chain":                    chain,
+		"speed":                    speed,
+		"base_fee":                 fees.BaseFee.String(),
+		"max_priority_fee_per_gas": fees.MaxPriorityFeePerGas.String(),
+		"max_fee_per_gas":          fees.MaxFeePerGas.String(),
+		"gas_price_gwei":           tokens.FromBaseUnits(expected, 9),
+	})
+}
+
+// getBalances returns native and token balances of a wallet with USD values
+func (s *Server) getBalances(c *gin.Context) {
+	address := c.Query("address")
+	if address == "" || !common.IsHexAddress(address) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a valid address is required"})
+		return
+	}
+
+	chains, ok := requestedChains(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	balances := []connector.TokenBalance{}
+	totalUSD := 0.0
+	for _, chain := range chains {
+		list, err := s.connector.GetBalances(ctx, chain, address)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		for i := range list {
+			b := &list[i]
+			if b.Amount == 0 {
+				continue
+			}
+			price, err := s.prices.Price(ctx, chain, b.Symbol)
+			if err != nil {
+				log.Printf("Error fetching %s price on %s: %v", b.Symbol, chain, err)
+				continue
+			}
+			b.PriceUSD = price
+			b.ValueUSD = b.Amount * price
+			totalUSD += b.ValueUSD
+		}
+		balances = append(balances, list...)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"address":   address,
+		"balances":  balances,
+		"total_usd": totalUSD,
+	})
+}
+
+// requestedChains returns the chain from the query, or every supported chain
+// when none is given. It responds with an error for unknown chains.
+func requestedChains(c *gin.Context) ([]string, bool) {
+	chain := c.Query("chain")
+	if chain == "" {
+		return tokens.Chains(), true
+	}
+	for _, supported := range tokens.Chains() {
+		if chain == supported {
+			return []string{chain}, true
+		}
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported chain: " + chain})
+	return nil, false
+}
+
+// listAllowances lists outstanding token approvals to protocol contracts
+func (s *Server) listAllowances(c *gin.Context) {
+	address := c.Query("address")
+	if address == "" || !common.IsHexAddress(address) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a valid address is required"})
+		return
+	}
+
+	chains, ok := requestedChains(c)
+	if !ok {
+		return
+	}
+
+	allowances := []connector.Allowance{}
+	infinite := 0
+	for _, chain := range chains {
+		list, err := s.connector.ListAllowances(c.Request.Context(), chain, address)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, a := range list {
+			if a.Infinite {
+				infinite++
+			}
+		}
+		allowances = append(allowances, list...)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"address":            address,
+		"allowances":         allowances,
+		"infinite_approvals": infinite,
+	})
+}
+
+// checkAllowance reports whether an account has approved enough of a token
+// for a deposit and returns the approval to send first if not
+func (s *Server) checkAllowance(c *gin.Context) {
+	var req struct {
+		Chain     string `json:"chain" binding:"required"`
+		Owner     string `json:"owner" binding:"required"`
+		Token     string `json:"token" binding:"required"`
+		Spender   string `json:"spender" binding:"required"`
+		Amount    string `json:"amount" binding:"required"` // base units
+		UsePermit bool   `json:"use_permit"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	amount, ok := new(big.Int).SetString(req.Amount, 10)
+	if !ok || amount.Sign() < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be a non-negative integer in base units"})
+		return
+	}
+
+	check, err := s.connector.CheckAllowance(c.Request.Context(), req.Chain, req.Owner, req.Token, req.Spender, amount, req.UsePermit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, check)
+}
+
+// reserveNonce reserves the next nonce for an account
+func (s *Server) reserveNonce(c *gin.Context) {
+	var req struct {
+		Chain   string `json:"chain" binding:"required"`
+		Address string `json:"address" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	n, err := s.nonces.Reserve(c.Request.Context(), req.Chain, req.Address)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"chain":   req.Chain,
+		"address": req.Address,
+		"nonce":   n,
+	})
+}
+
+// releaseNonce returns a reserved, unused nonce so it can be reserved again
+func (s *Server) releaseNonce(c *gin.Context) {
+	var req struct {
+		Chain   string  `json:"chain" binding:"required"`
+		Address string  `json:"address" binding:"required"`
+		Nonce   *uint64 `json:"nonce" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := s.nonces.Release(req.Chain, req.Address, *req.Nonce)
+	if errors.Is(err, nonce.ErrNotReserved) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"released": true})
+}
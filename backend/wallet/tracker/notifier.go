@@ -0,0 +1,72 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/defioptimization/shared/models"
+)
+
+// Notifier delivers transaction status changes to the owning user
+type Notifier interface {
+	NotifyTransaction(ctx context.Context, tx *models.Transaction, previousStatus string) error
+}
+
+// HTTPNotifier forwards events to the API gateway, which relays them over websocket
+type HTTPNotifier struct {
+	apiURL     string
+	token      string
+	httpClient *http.Client
+}
+
+// NewHTTPNotifier creates a notifier that posts to the API gateway's internal events endpoint
+func NewHTTPNotifier(apiURL, token string) *HTTPNotifier {
+	return &HTTPNotifier{
+		apiURL: apiURL,
+		token:  token,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// NotifyTransaction sends a transaction_status event for the transaction's user
+func (n *HTTPNotifier) NotifyTransaction(ctx context.Context, tx *models.Transaction, previousStatus string) error {
+	reqBody := map[string]interface{}{
+		"user_id": tx.UserID,
+		"type":    "transaction_status",
+		"payload": map[string]interface{}{
+			"previous_status": previousStatus,
+			"transaction":     tx,
+		},
+	}
+
+	reqJSON, err := json.Marshal(reqBody)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/api/v1/internal/events", n.apiURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqJSON))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Internal-Token", n.token)
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to deliver event: status %d", resp.StatusCode)
+	}
+
+	return nil
+}
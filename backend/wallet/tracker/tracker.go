@@ -0,0 +1,259 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/wallet/connector"
+	"github.com/defioptimization/wallet/nonce"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Tracker follows broadcast transactions until they are final
+type Tracker struct {
+	connector     *connector.WalletConnector
+	nonces        *nonce.Manager
+	notifier      Notifier
+	confirmations map[string]uint64
+	dropTimeout   time.Duration
+}
+
+// DefaultConfirmations is the confirmation depth used for chains without an explicit setting
+const DefaultConfirmations uint64 = 12
+
+// NewTracker creates a new transaction tracker.
+// confirmations maps a chain name to the number of blocks a transaction must
+// be buried under before it is considered final.
+func NewTracker(wc *connector.WalletConnector, nonces *nonce.Manager, notifier Notifier, confirmations map[string]uint64, dropTimeout time.Duration) *Tracker {
+	return &Tracker{
+		connector:     wc,
+		nonces:        nonces,
+		notifier:      notifier,
+		confirmations: confirmations,
+		dropTimeout:   dropTimeout,
+	}
+}
+
+// Start polls receipts for non-final transactions until the context is cancelled
+func (t *Tracker) Start(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Println("Transaction tracker started")
+
+	t.poll(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			t.poll(ctx)
+		}
+	}
+}
+
+// poll checks every pending or included transaction once
+func (t *Tracker) poll(ctx context.Context) {
+	var txs []models.Transaction
+	if err := database.DB.Where("status IN ?", []string{models.TxStatusPending, models.TxStatusIncluded}).
+		Order("created_at ASC").
+		Find(&txs).Error; err != nil {
+		log.Printf("Error fetching tracked transactions: %v", err)
+		return
+	}
+
+	heads := make(map[string]uint64)
+	for i := range txs {
+		tx := &txs[i]
+
+		head, ok := heads[tx.Chain]
+		if !ok {
+			client, err := t.connector.GetClient(tx.Chain)
+			if err != nil {
+				log.Printf("Error tracking transaction %s: %v", tx.TxHash, err)
+				continue
+			}
+			head, err = client.BlockNumber(ctx)
+			if err != nil {
+				log.Printf("Error fetching head block for %s: %v", tx.Chain, err)
+				continue
+			}
+			heads[tx.Chain] = head
+		}
+
+		previous := tx.Status
+		if err := t.checkTransaction(ctx, tx, head); err != nil {
+			log.Printf("Error tracking transaction %s: %v", tx.TxHash, err)
+			continue
+		}
+
+		if tx.Status == previous && tx.Status != models.TxStatusIncluded {
+			continue
+		}
+
+		if err := database.DB.Save(tx).Error; err != nil {
+			log.Printf("Error updating transaction %s: %v", tx.TxHash, err)
+			continue
+		}
+
+		if tx.Status != previous && t.notifier != nil {
+			if err := t.notifier.NotifyTransaction(ctx, tx, previous); err != nil {
+				log.Printf("Error notifying user %d about transaction %s: %v", tx.UserID, tx.TxHash, err)
+			}
+		}
+	}
+}
+
+// checkTransaction advances a transaction through its lifecycle based on chain state
+func (t *Tracker) checkTransaction(ctx context.Context, tx *models.Transaction, head uint64) error {
+	client, err := t.connector.GetClient(tx.Chain)
+	if err != nil {
+		return err
+	}
+
+	receipt, err := t.connector.GetTransactionReceipt(tx.Chain, tx.TxHash)
+	if err != nil && !errors.Is(err, ethereum.NotFound) {
+		return fmt.Errorf("failed to fetch receipt: %w", err)
+	}
+
+	// A receipt whose block is no longer canonical is as good as no receipt
+	if receipt != nil {
+		header, err := client.HeaderByNumber(ctx, receipt.BlockNumber)
+		if err != nil {
+			return fmt.Errorf("failed to fetch block %s: %w", receipt.BlockNumber, err)
+		}
+		if header.Hash() != receipt.BlockHash {
+			receipt = nil
+		}
+	}
+
+	if receipt == nil {
+		if tx.Status == models.TxStatusIncluded {
+			log.Printf("Transaction %s was reorged out of block %d", tx.TxHash, tx.BlockNumber)
+			resetInclusion(tx)
+			tx.Status = models.TxStatusPending
+			return nil
+		}
+		return t.checkUnmined(ctx, tx)
+	}
+
+	if tx.BlockHash != "" && tx.BlockHash != receipt.BlockHash.Hex() {
+		log.Printf("Transaction %s moved from block %d to %d after reorg", tx.TxHash, tx.BlockNumber, receipt.BlockNumber.Uint64())
+	}
+
+	tx.BlockNumber = receipt.BlockNumber.Uint64()
+	tx.BlockHash = receipt.BlockHash.Hex()
+	tx.GasUsed = receipt.GasUsed
+	if receipt.EffectiveGasPrice != nil {
+		tx.EffectiveGasPrice = receipt.EffectiveGasPrice.String()
+	}
+
+	tx.Confirmations = 0
+	if head >= tx.BlockNumber {
+		tx.Confirmations = head - tx.BlockNumber + 1
+	}
+
+	if tx.Confirmations < t.confirmationDepth(tx.Chain) {
+		tx.Status = models.TxStatusIncluded
+		return nil
+	}
+
+	now := time.Now()
+	tx.FinalizedAt = &now
+	if receipt.Status == types.ReceiptStatusSuccessful {
+		tx.Status = models.TxStatusConfirmed
+	} else {
+		tx.Status = models.TxStatusFailed
+	}
+	return nil
+}
+
+// checkUnmined decides whether a transaction without a receipt is still
+// waiting, has been replaced by another transaction with the same nonce,
+// or has been dropped from the mempool.
+func (t *Tracker) checkUnmined(ctx context.Context, tx *models.Transaction) error {
+	client, err := t.connector.GetClient(tx.Chain)
+	if err != nil {
+		return err
+	}
+
+	hash := common.HexToHash(tx.TxHash)
+	signed, _, err := client.TransactionByHash(ctx, hash)
+	if err != nil && !errors.Is(err, ethereum.NotFound) {
+		return fmt.Errorf("failed to fetch transaction: %w", err)
+	}
+	inMempool := err == nil
+
+	if tx.Nonce == nil && inMempool {
+		nonce := signed.Nonce()
+		tx.Nonce = &nonce
+	}
+
+	if tx.Nonce != nil {
+		confirmedNonce, err := client.NonceAt(ctx, common.HexToAddress(tx.FromAddress), nil)
+		if err != nil {
+			return fmt.Errorf("failed to fetch account nonce: %w", err)
+		}
+		if confirmedNonce > *tx.Nonce {
+			tx.Status = models.TxStatusReplaced
+			tx.ReplacedBy = t.findReplacement(tx)
+			now := time.Now()
+			tx.FinalizedAt = &now
+			return nil
+		}
+	}
+
+	if !inMempool && time.Since(tx.CreatedAt) > t.dropTimeout {
+		tx.Status = models.TxStatusDropped
+		now := time.Now()
+		tx.FinalizedAt = &now
+
+		// Free the nonce so the next transaction fills the gap
+		if tx.Nonce != nil && t.nonces != nil {
+			if err := t.nonces.ReleaseDropped(tx.Chain, tx.FromAddress, *tx.Nonce, tx.TxHash); err != nil {
+				log.Printf("Error releasing nonce %d for %s: %v", *tx.Nonce, tx.FromAddress, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// findReplacement looks for a tracked transaction that reused the same nonce
+func (t *Tracker) findReplacement(tx *models.Transaction) string {
+	var replacement models.Transaction
+	err := database.DB.Where(
+		"chain = ? AND LOWER(from_address) = LOWER(?) AND nonce = ? AND tx_hash <> ? AND status IN ?",
+		tx.Chain, tx.FromAddress, *tx.Nonce, tx.TxHash,
+		[]string{models.TxStatusIncluded, models.TxStatusConfirmed, models.TxStatusFailed},
+	).First(&replacement).Error
+	if err != nil {
+		return ""
+	}
+	return replacement.TxHash
+}
+
+// confirmationDepth returns the configured confirmation depth for a chain
+func (t *Tracker) confirmationDepth(chain string) uint64 {
+	if depth, ok := t.confirmations[chain]; ok && depth > 0 {
+		return depth
+	}
+	return DefaultConfirmations
+}
+
+// resetInclusion clears block data after a transaction is reorged out
+func resetInclusion(tx *models.Transaction) {
+	tx.BlockNumber = 0
+	tx.BlockHash = ""
+	tx.GasUsed = 0
+	tx.EffectiveGasPrice = ""
+	tx.Confirmations = 0
+}
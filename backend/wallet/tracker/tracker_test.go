@@ -0,0 +1,371 @@
+package tracker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/wallet/connector"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// fakeChain is a JSON-RPC node serving the calls the tracker makes
+type fakeChain struct {
+	mu       sync.Mutex
+	head     uint64
+	headers  map[uint64]*types.Header // canonical chain
+	receipts map[common.Hash]*types.Receipt
+	mempool  map[common.Hash]*types.Transaction
+	nonce    uint64 // the sender's confirmed nonce
+}
+
+func newFakeChain(t *testing.T, head uint64) *fakeChain {
+	t.Helper()
+	c := &fakeChain{
+		head:     head,
+		headers:  make(map[uint64]*types.Header),
+		receipts: make(map[common.Hash]*types.Receipt),
+		mempool:  make(map[common.Hash]*types.Transaction),
+	}
+	srv := httptest.NewServer(c)
+	t.Cleanup(srv.Close)
+	t.Setenv("ETH_RPC_URL", srv.URL)
+	return c
+}
+
+// header returns the canonical header at a height, creating it if needed
+func (c *fakeChain) header(number uint64) *types.Header {
+	h, ok := c.headers[number]
+	if !ok {
+		h = &types.Header{Number: new(big.Int).SetUint64(number), Difficulty: new(big.Int), Time: number * 12}
+		c.headers[number] = h
+	}
+	return h
+}
+
+// mine includes a transaction in the canonical block at number
+func (c *fakeChain) mine(hash common.Hash, number uint64, status uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.mempool, hash)
+	c.receipts[hash] = &types.Receipt{
+		Status:            status,
+		CumulativeGasUsed: 21000,
+		Logs:              []*types.Log{},
+		TxHash:            hash,
+		GasUsed:           21000,
+		EffectiveGasPrice: big.NewInt(1e9),
+		BlockHash:         c.header(number).Hash(),
+		BlockNumber:       new(big.Int).SetUint64(number),
+	}
+}
+
+// reorg replaces the canonical block at number with a sibling
+func (c *fakeChain) reorg(number uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := *c.header(number)
+	h.Extra = []byte("sibling")
+	c.headers[number] = &h
+}
+
+func (c *fakeChain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	hashParam := func() common.Hash {
+		var hash common.Hash
+		json.Unmarshal(req.Params[0], &hash)
+		return hash
+	}
+
+	c.mu.Lock()
+	var result interface{}
+	switch req.Method {
+	case "eth_blockNumber":
+		result = hexutil.Uint64(c.head)
+	case "eth_getBlockByNumber":
+		var number hexutil.Uint64
+		json.Unmarshal(req.Params[0], &number)
+		result = c.header(uint64(number))
+	case "eth_getTransactionReceipt":
+		if receipt, ok := c.receipts[hashParam()]; ok {
+			result = receipt
+		}
+	case "eth_getTransactionByHash":
+		if tx, ok := c.mempool[hashParam()]; ok {
+			result = tx
+		}
+	case "eth_getTransactionCount":
+		result = hexutil.Uint64(c.nonce)
+	}
+	c.mu.Unlock()
+
+	out, _ := json.Marshal(result)
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": json.RawMessage(out)})
+}
+
+var testKey, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+
+// signedTx signs a transfer with the test key
+func signedTx(t *testing.T, key *ecdsa.PrivateKey, nonce uint64) *types.Transaction {
+	t.Helper()
+	to := common.HexToAddress("0x87870Bca3F3fD6335C3F4ce8392A693fcE16f1D7")
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(1)), &types.DynamicFeeTx{
+		ChainID:   big.NewInt(1),
+		Nonce:     nonce,
+		GasTipCap: big.NewInt(1e9),
+		GasFeeCap: big.NewInt(2e9),
+		Gas:       21000,
+		To:        &to,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestCheckTransaction(t *testing.T) {
+	sender := crypto.PubkeyToAddress(testKey.PublicKey).Hex()
+	const depth = 3
+
+	tests := []struct {
+		name   string
+		status string // tracked status before the check
+		age    time.Duration
+		nonce  *uint64
+		chain  func(c *fakeChain, tx *types.Transaction)
+		want   string
+		check  func(t *testing.T, tx *models.Transaction)
+	}{
+		{
+			name: "waiting in the mempool", status: models.TxStatusPending,
+			chain: func(c *fakeChain, tx *types.Transaction) { c.mempool[tx.Hash()] = tx },
+			want:  models.TxStatusPending,
+		},
+		{
+			name: "nonce learned from the mempool", status: models.TxStatusPending,
+			chain: func(c *fakeChain, tx *types.Transaction) { c.mempool[tx.Hash()] = tx },
+			want:  models.TxStatusPending,
+			check: func(t *testing.T, tx *models.Transaction) {
+				if tx.Nonce == nil || *tx.Nonce != 5 {
+					t.Fatalf("nonce = %v, want 5", tx.Nonce)
+				}
+			},
+		},
+		{
+			name: "included below the confirmation depth", status: models.TxStatusPending, nonce: uint64p(5),
+			chain: func(c *fakeChain, tx *types.Transaction) { c.mine(tx.Hash(), 99, types.ReceiptStatusSuccessful) },
+			want:  models.TxStatusIncluded,
+			check: func(t *testing.T, tx *models.Transaction) {
+				if tx.BlockNumber != 99 || tx.Confirmations != 2 || tx.GasUsed != 21000 || tx.EffectiveGasPrice != "1000000000" || tx.FinalizedAt != nil {
+					t.Fatalf("included transaction = %+v", tx)
+				}
+			},
+		},
+		{
+			name: "confirmed at the confirmation depth", status: models.TxStatusIncluded, nonce: uint64p(5),
+			chain: func(c *fakeChain, tx *types.Transaction) { c.mine(tx.Hash(), 98, types.ReceiptStatusSuccessful) },
+			want:  models.TxStatusConfirmed,
+			check: func(t *testing.T, tx *models.Transaction) {
+				if tx.Confirmations != depth || tx.FinalizedAt == nil {
+					t.Fatalf("confirmed transaction = %+v", tx)
+				}
+			},
+		},
+		{
+			name: "reverted", status: models.TxStatusIncluded, nonce: uint64p(5),
+			chain: func(c *fakeChain, tx *types.Transaction) { c.mine(tx.Hash(), 90, types.ReceiptStatusFailed) },
+			want:  models.TxStatusFailed,
+		},
+		{
+			name: "reorged out", status: models.TxStatusIncluded, nonce: uint64p(5),
+			chain: func(c *fakeChain, tx *types.Transaction) {
+				c.mine(tx.Hash(), 99, types.ReceiptStatusSuccessful)
+				c.reorg(99)
+			},
+			want: models.TxStatusPending,
+			check: func(t *testing.T, tx *models.Transaction) {
+				if tx.BlockNumber != 0 || tx.BlockHash != "" || tx.Confirmations != 0 || tx.GasUsed != 0 {
+					t.Fatalf("inclusion not reset: %+v", tx)
+				}
+			},
+		},
+		{
+			name: "missing but recent", status: models.TxStatusPending, nonce: uint64p(5), age: time.Minute,
+			chain: func(c *fakeChain, tx *types.Transaction) {},
+			want:  models.TxStatusPending,
+		},
+		{
+			name: "dropped", status: models.TxStatusPending, nonce: uint64p(5), age: time.Hour,
+			chain: func(c *fakeChain, tx *types.Transaction) {},
+			want:  models.TxStatusDropped,
+			check: func(t *testing.T, tx *models.Transaction) {
+				if tx.FinalizedAt == nil {
+					t.Fatal("dropped transaction has no finalization time")
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := newFakeChain(t, 100)
+			chain.nonce = 5
+			signed := signedTx(t, testKey, 5)
+			tt.chain(chain, signed)
+
+			tracker := NewTracker(connector.NewWalletConnector(), nil, nil, map[string]uint64{"ethereum": depth}, 30*time.Minute)
+			tx := &models.Transaction{
+				TxHash:      signed.Hash().Hex(),
+				Chain:       "ethereum",
+				FromAddress: sender,
+				Status:      tt.status,
+				Nonce:       tt.nonce,
+				BlockNumber: 97,
+				BlockHash:   common.Hash{1}.Hex(),
+			}
+			tx.CreatedAt = time.Now().Add(-tt.age)
+			if tt.status == models.TxStatusPending {
+				tx.BlockNumber, tx.BlockHash = 0, ""
+			}
+
+			if err := tracker.checkTransaction(context.Background(), tx, chain.head); err != nil {
+				t.Fatal(err)
+			}
+			if tx.Status != tt.want {
+				t.Fatalf("status = %s, want %s", tx.Status, tt.want)
+			}
+			if tt.check != nil {
+				tt.check(t, tx)
+			}
+		})
+	}
+}
+
+func uint64p(n uint64) *uint64 { return &n }
+
+// recordingNotifier records the status changes it is told about
+type recordingNotifier struct {
+	changes []string
+}
+
+func (n *recordingNotifier) NotifyTransaction(ctx context.Context, tx *models.Transaction, previousStatus string) error {
+	n.changes = append(n.changes, previousStatus+" -> "+tx.Status)
+	return nil
+}
+
+// trackedTx records a transaction for the tracker to follow
+func trackedTx(t *testing.T, tx models.Transaction) models.Transaction {
+	t.Helper()
+	if tx.Type == "" {
+		tx.Type = "transfer"
+	}
+	if err := database.DB.Create(&tx).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.DB.Unscoped().Delete(&tx) })
+	return tx
+}
+
+func TestPollFollowsWalletTransaction(t *testing.T) {
+	testDB(t)
+	chain := newFakeChain(t, 100)
+	signed := signedTx(t, testKey, 5)
+	chain.nonce = 5
+	chain.mempool[signed.Hash()] = signed
+
+	// The wallet service recorded the hash the wallet answered a request with
+	nonce, requestID := uint64(5), int64(1700000000000001)
+	recorded := trackedTx(t, models.Transaction{
+		TxHash:      signed.Hash().Hex(),
+		Chain:       "ethereum",
+		FromAddress: strings.ToLower(crypto.PubkeyToAddress(testKey.PublicKey).Hex()),
+		Type:        "rebalance",
+		Status:      models.TxStatusPending,
+		Nonce:       &nonce,
+		RequestID:   &requestID,
+	})
+
+	notifier := &recordingNotifier{}
+	tracker := NewTracker(connector.NewWalletConnector(), nil, notifier, map[string]uint64{"ethereum": 2}, 30*time.Minute)
+	status := func() string {
+		var tx models.Transaction
+		if err := database.DB.First(&tx, recorded.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return tx.Status
+	}
+
+	steps := []struct {
+		chain func()
+		want  string
+	}{
+		{func() {}, models.TxStatusPending},
+		{func() { chain.mine(signed.Hash(), 100, types.ReceiptStatusSuccessful) }, models.TxStatusIncluded},
+		{func() { chain.reorg(100) }, models.TxStatusPending},
+		{func() { chain.mine(signed.Hash(), 101, types.ReceiptStatusSuccessful); chain.head = 101 }, models.TxStatusIncluded},
+		{func() { chain.head = 102 }, models.TxStatusConfirmed},
+	}
+	for i, step := range steps {
+		step.chain()
+		tracker.poll(context.Background())
+		if got := status(); got != step.want {
+			t.Fatalf("step %d: status = %s, want %s", i+1, got, step.want)
+		}
+	}
+
+	want := []string{"pending -> included", "included -> pending", "pending -> included", "included -> confirmed"}
+	if strings.Join(notifier.changes, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("notified %v, want %v", notifier.changes, want)
+	}
+}
+
+func TestCheckTransactionReplaced(t *testing.T) {
+	testDB(t)
+	chain := newFakeChain(t, 100)
+	chain.nonce = 6 // nonce 5 is used on chain
+	sender := crypto.PubkeyToAddress(testKey.PublicKey).Hex()
+	original, speedUp := signedTx(t, testKey, 5), common.Hash{5}.Hex()
+
+	nonce := uint64(5)
+	// The wallet sent a speed-up of its own, recorded with a differently
+	// cased address
+	trackedTx(t, models.Transaction{
+		TxHash:      speedUp,
+		Chain:       "ethereum",
+		FromAddress: strings.ToLower(sender),
+		Status:      models.TxStatusConfirmed,
+		Nonce:       &nonce,
+	})
+
+	tracker := NewTracker(connector.NewWalletConnector(), nil, nil, nil, 30*time.Minute)
+	tx := &models.Transaction{
+		TxHash:      original.Hash().Hex(),
+		Chain:       "ethereum",
+		FromAddress: sender,
+		Status:      models.TxStatusPending,
+		Nonce:       &nonce,
+	}
+	tx.CreatedAt = time.Now()
+	if err := tracker.checkTransaction(context.Background(), tx, chain.head); err != nil {
+		t.Fatal(err)
+	}
+	if tx.Status != models.TxStatusReplaced || tx.ReplacedBy != speedUp || tx.FinalizedAt == nil {
+		t.Fatalf("transaction = %+v, want replaced by the speed-up", tx)
+	}
+}
@@ -0,0 +1,62 @@
+package walletconnect
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"gorm.io/gorm/logger"
+)
+
+// testDB connects database.DB to the Postgres database named by
+// TEST_DATABASE_URL and migrates it, skipping the test when it isn't set
+func testDB(t *testing.T) {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	t.Setenv("DATABASE_URL", url)
+	if err := database.InitDatabase(); err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	database.DB.Logger = logger.Default.LogMode(logger.Silent)
+}
+
+// testSession stores an active session with a fresh key for a new user,
+// approved for eth_sendTransaction on ethereum
+func testSession(t *testing.T) models.WalletSession {
+	t.Helper()
+	user := models.User{WalletAddress: fmt.Sprintf("0x%040x", time.Now().UnixNano())}
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	symKey := fmt.Sprintf("%064x", time.Now().UnixNano())
+	topic, err := TopicFromSymKey(symKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := models.WalletSession{
+		UserID:        user.ID,
+		WalletAddress: user.WalletAddress,
+		Topic:         topic,
+		SymKey:        symKey,
+		Chains:        []string{"eip155:1"},
+		Accounts:      []string{"eip155:1:" + user.WalletAddress},
+		Methods:       []string{"eth_sendTransaction"},
+		Status:        models.SessionStatusActive,
+		ExpiresAt:     time.Now().Add(time.Hour),
+	}
+	if err := database.DB.Create(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		database.DB.Where("topic = ?", topic).Delete(&models.WalletRequest{})
+		database.DB.Unscoped().Delete(&session)
+		database.DB.Unscoped().Delete(&user)
+	})
+	return session
+}
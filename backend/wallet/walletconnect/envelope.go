@@ -0,0 +1,134 @@
+package walletconnect
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// envelopeType0 is a symmetric-key envelope: 0x00 || iv || sealed
+const envelopeType0 = 0x00
+
+// Relay message tags used by the sign protocol
+const (
+	tagSessionDelete   = 1112
+	tagSessionRequest  = 1108
+	tagSessionResponse = 1109
+)
+
+// ErrInvalidEnvelope is returned when a relay message can't be decrypted
+var ErrInvalidEnvelope = errors.New("invalid walletconnect envelope")
+
+// rpcRequest is a JSON-RPC request carried inside an envelope
+type rpcRequest struct {
+	ID      int64       `json:"id"`
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// Encrypt seals a payload with the session's symmetric key as a type 0 envelope
+func Encrypt(symKey string, payload []byte) (string, error) {
+	key, err := decodeSymKey(symKey)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, chacha20poly1305.NonceSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	envelope := append([]byte{envelopeType0}, iv...)
+	envelope = aead.Seal(envelope, iv, payload, nil)
+	return base64.StdEncoding.EncodeToString(envelope), nil
+}
+
+// Decrypt opens a type 0 envelope sealed with the session's symmetric key
+func Decrypt(symKey string, message string) ([]byte, error) {
+	key, err := decodeSymKey(symKey)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope, err := base64.StdEncoding.DecodeString(message)
+	if err != nil {
+		return nil, ErrInvalidEnvelope
+	}
+	if len(envelope) < 1+chacha20poly1305.NonceSize || envelope[0] != envelopeType0 {
+		return nil, ErrInvalidEnvelope
+	}
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := envelope[1 : 1+chacha20poly1305.NonceSize]
+	payload, err := aead.Open(nil, iv, envelope[1+chacha20poly1305.NonceSize:], nil)
+	if err != nil {
+		return nil, ErrInvalidEnvelope
+	}
+	return payload, nil
+}
+
+// TopicFromSymKey derives a session topic, sha256(symKey), as wallets do
+func TopicFromSymKey(symKey string) (string, error) {
+	key, err := decodeSymKey(symKey)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// encodeRequest builds and encrypts a JSON-RPC request for a session
+func encodeRequest(symKey, method string, params interface{}) (int64, string, error) {
+	req := rpcRequest{
+		ID:      payloadID(),
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  params,
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return 0, "", err
+	}
+
+	message, err := Encrypt(symKey, payload)
+	if err != nil {
+		return 0, "", err
+	}
+	return req.ID, message, nil
+}
+
+// payloadID returns a JSON-RPC id in the format used by WalletConnect clients:
+// millisecond timestamp followed by three random digits
+func payloadID() int64 {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000))
+	if err != nil {
+		n = big.NewInt(0)
+	}
+	return time.Now().UnixMilli()*1000 + n.Int64()
+}
+
+func decodeSymKey(symKey string) ([]byte, error) {
+	key, err := hex.DecodeString(symKey)
+	if err != nil || len(key) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("sym_key must be %d bytes of hex", chacha20poly1305.KeySize)
+	}
+	return key, nil
+}
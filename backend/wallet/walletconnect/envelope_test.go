@@ -0,0 +1,107 @@
+package walletconnect
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testSymKey = "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0"
+
+func TestEncryptDecrypt(t *testing.T) {
+	payload := []byte(`{"id":1,"jsonrpc":"2.0","method":"wc_sessionPing","params":{}}`)
+
+	message, err := Encrypt(testSymKey, payload)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(message)
+	if err != nil {
+		t.Fatalf("envelope is not base64: %v", err)
+	}
+	if raw[0] != envelopeType0 {
+		t.Fatalf("envelope type = %d, want %d", raw[0], envelopeType0)
+	}
+
+	got, err := Decrypt(testSymKey, message)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if string(got) != string(payload) {
+		t.Fatalf("Decrypt = %s, want %s", got, payload)
+	}
+
+	again, err := Encrypt(testSymKey, payload)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if again == message {
+		t.Fatal("two envelopes of the same payload share an IV")
+	}
+}
+
+func TestDecryptRejects(t *testing.T) {
+	message, err := Encrypt(testSymKey, []byte("payload"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(message)
+
+	tampered := append([]byte(nil), raw...)
+	tampered[len(tampered)-1] ^= 0x01
+	wrongType := append([]byte(nil), raw...)
+	wrongType[0] = 0x01
+
+	tests := []struct {
+		name    string
+		symKey  string
+		message string
+	}{
+		{"wrong key", strings.Repeat("ab", 32), message},
+		{"tampered", testSymKey, base64.StdEncoding.EncodeToString(tampered)},
+		{"type 1 envelope", testSymKey, base64.StdEncoding.EncodeToString(wrongType)},
+		{"truncated", testSymKey, base64.StdEncoding.EncodeToString(raw[:5])},
+		{"not base64", testSymKey, "not base64!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decrypt(tt.symKey, tt.message); !errors.Is(err, ErrInvalidEnvelope) {
+				t.Fatalf("Decrypt error = %v, want ErrInvalidEnvelope", err)
+			}
+		})
+	}
+}
+
+func TestInvalidSymKey(t *testing.T) {
+	for _, key := range []string{"", "zz", strings.Repeat("ab", 16)} {
+		if _, err := Encrypt(key, []byte("payload")); err == nil {
+			t.Errorf("Encrypt accepted sym key %q", key)
+		}
+		if _, err := TopicFromSymKey(key); err == nil {
+			t.Errorf("TopicFromSymKey accepted sym key %q", key)
+		}
+	}
+}
+
+func TestEncodeRequest(t *testing.T) {
+	id, message, err := encodeRequest(testSymKey, "wc_sessionRequest", map[string]interface{}{
+		"chainId": "eip155:1",
+	})
+	if err != nil {
+		t.Fatalf("encodeRequest: %v", err)
+	}
+
+	payload, err := Decrypt(testSymKey, message)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	var req rpcRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		t.Fatalf("payload is not JSON-RPC: %v", err)
+	}
+	if req.ID != id || req.JSONRPC != "2.0" || req.Method != "wc_sessionRequest" {
+		t.Fatalf("request = %+v, want id %d wc_sessionRequest", req, id)
+	}
+}
@@ -0,0 +1,332 @@
+package walletconnect
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Relay publishes encrypted messages to WalletConnect topics and fetches the
+// messages wallets publish back
+type Relay interface {
+	Publish(ctx context.Context, topic string, message string, ttl time.Duration, tag int) error
+	// Subscribe has the relay keep the messages published to a topic until
+	// they are fetched
+	Subscribe(ctx context.Context, topic string) error
+	// FetchMessages returns the messages published to a subscribed topic
+	// since the last fetch
+	FetchMessages(ctx context.Context, topic string) ([]Message, error)
+}
+
+// Message is a message published to a topic
+type Message struct {
+	Topic       string
+	Message     string
+	PublishedAt time.Time
+	Tag         int
+}
+
+// DefaultRelayURL is the public WalletConnect relay
+const DefaultRelayURL = "https://relay.walletconnect.com"
+
+// HTTPRelay publishes to the WalletConnect relay over its JSON-RPC HTTP endpoint
+type HTTPRelay struct {
+	relayURL   string
+	projectID  string
+	key        ed25519.PrivateKey
+	httpClient *http.Client
+}
+
+// NewHTTPRelay creates a relay client. The ed25519 key identifies this
+// backend to the relay; a random key is generated when none is given.
+func NewHTTPRelay(relayURL, projectID string, key ed25519.PrivateKey) (*HTTPRelay, error) {
+	if key == nil {
+		var err error
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &HTTPRelay{
+		relayURL:  relayURL,
+		projectID: projectID,
+		key:       key,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}, nil
+}
+
+// Publish sends an irn_publish request to the relay
+func (r *HTTPRelay) Publish(ctx context.Context, topic string, message string, ttl time.Duration, tag int) error {
+	return r.call(ctx, "irn_publish", map[string]interface{}{
+		"topic":   topic,
+		"message": message,
+		"ttl":     int(ttl.Seconds()),
+		"tag":     tag,
+		"prompt":  tag == tagSessionRequest,
+	}, nil)
+}
+
+// Subscribe sends an irn_subscribe request to the relay
+func (r *HTTPRelay) Subscribe(ctx context.Context, topic string) error {
+	return r.call(ctx, "irn_subscribe", map[string]interface{}{"topic": topic}, nil)
+}
+
+// maxFetchPages bounds the irn_fetchMessages requests of one FetchMessages
+const maxFetchPages = 10
+
+// FetchMessages sends irn_fetchMessages requests until the relay has no
+// more messages for the topic
+func (r *HTTPRelay) FetchMessages(ctx context.Context, topic string) ([]Message, error) {
+	var messages []Message
+	for page := 0; page < maxFetchPages; page++ {
+		var result struct {
+			Messages []struct {
+				Topic       string `json:"topic"`
+				Message     string `json:"message"`
+				PublishedAt int64  `json:"publishedAt"` // unix milliseconds
+				Tag         int    `json:"tag"`
+			} `json:"messages"`
+			HasMore bool `json:"hasMore"`
+		}
+		if err := r.call(ctx, "irn_fetchMessages", map[string]interface{}{"topic": topic}, &result); err != nil {
+			return messages, err
+		}
+		for _, m := range result.Messages {
+			messages = append(messages, Message{
+				Topic:       m.Topic,
+				Message:     m.Message,
+				PublishedAt: time.UnixMilli(m.PublishedAt),
+				Tag:         m.Tag,
+			})
+		}
+		if !result.HasMore {
+			break
+		}
+	}
+	return messages, nil
+}
+
+// call sends a JSON-RPC request to the relay and decodes its result into
+// out, unless out is nil
+func (r *HTTPRelay) call(ctx context.Context, method string, params interface{}, out interface{}) error {
+	auth, err := r.authToken()
+	if err != nil {
+		return err
+	}
+
+	reqBody := map[string]interface{}{
+		"id":      payloadID(),
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+	}
+	reqJSON, err := json.Marshal(reqBody)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/rpc?projectId=%s&auth=%s", r.relayURL, r.projectID, auth)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqJSON))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("relay returned status %d", resp.StatusCode)
+	}
+	if result.Error != nil {
+		return fmt.Errorf("relay error %d: %s", result.Error.Code, result.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("relay returned status %d", resp.StatusCode)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(result.Result, out); err != nil {
+		return fmt.Errorf("invalid %s result: %w", method, err)
+	}
+	return nil
+}
+
+// authToken builds the did:key-signed JWT the relay requires
+func (r *HTTPRelay) authToken() (string, error) {
+	pub := r.key.Public().(ed25519.PublicKey)
+
+	subject := make([]byte, 32)
+	if _, err := rand.Read(subject); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "EdDSA", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss": didKey(pub),
+		"sub": hex.EncodeToString(subject),
+		"aud": r.relayURL,
+		"iat": now.Unix(),
+		"exp": now.Add(24 * time.Hour).Unix(),
+	})
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	signature := ed25519.Sign(r.key, []byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// didKey encodes an ed25519 public key as a did:key identifier
+func didKey(pub ed25519.PublicKey) string {
+	// multicodec prefix for ed25519-pub
+	prefixed := append([]byte{0xed, 0x01}, pub...)
+	return "did:key:z" + base58Encode(prefixed)
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58Encode encodes bytes with the Bitcoin base58 alphabet
+func base58Encode(input []byte) string {
+	var digits []int // base58 digits, least significant first
+	for _, b := range input {
+		carry := int(b)
+		for i := range digits {
+			carry += digits[i] << 8
+			digits[i] = carry % 58
+			carry /= 58
+		}
+		for carry > 0 {
+			digits = append(digits, carry%58)
+			carry /= 58
+		}
+	}
+
+	var out []byte
+	for _, b := range input {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i := len(digits) - 1; i >= 0; i-- {
+		out = append(out, base58Alphabet[digits[i]])
+	}
+	return string(out)
+}
+
+// PublishedMessage is a message captured by MemoryRelay
+type PublishedMessage struct {
+	Topic   string
+	Message string
+	TTL     time.Duration
+	Tag     int
+}
+
+// MemoryRelay is an in-process relay for tests and, with
+// WALLETCONNECT_DEV_RELAY, local development. It records published messages
+// instead of sending them anywhere, and hands out the messages given to
+// Deliver as if a wallet had published them.
+type MemoryRelay struct {
+	mu         sync.Mutex
+	messages   []PublishedMessage
+	subscribed map[string]bool
+	inbox      map[string][]Message
+}
+
+// NewMemoryRelay creates an empty in-memory relay
+func NewMemoryRelay() *MemoryRelay {
+	return &MemoryRelay{
+		subscribed: make(map[string]bool),
+		inbox:      make(map[string][]Message),
+	}
+}
+
+// Publish records the message
+func (r *MemoryRelay) Publish(ctx context.Context, topic string, message string, ttl time.Duration, tag int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, PublishedMessage{Topic: topic, Message: message, TTL: ttl, Tag: tag})
+	return nil
+}
+
+// Subscribe keeps the messages delivered to the topic
+func (r *MemoryRelay) Subscribe(ctx context.Context, topic string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subscribed[topic] = true
+	return nil
+}
+
+// Deliver queues a message on a subscribed topic, as a wallet publishing
+// to it would. Messages to other topics are dropped, as the relay does.
+func (r *MemoryRelay) Deliver(topic, message string, tag int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.subscribed[topic] {
+		return
+	}
+	r.inbox[topic] = append(r.inbox[topic], Message{Topic: topic, Message: message, PublishedAt: time.Now(), Tag: tag})
+}
+
+// FetchMessages returns and forgets the messages delivered to the topic
+func (r *MemoryRelay) FetchMessages(ctx context.Context, topic string) ([]Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	messages := r.inbox[topic]
+	delete(r.inbox, topic)
+	return messages, nil
+}
+
+// Messages returns the messages published so far
+func (r *MemoryRelay) Messages() []PublishedMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]PublishedMessage, len(r.messages))
+	copy(out, r.messages)
+	return out
+}
+
+// NewRelay returns the relay configured by WALLETCONNECT_PROJECT_ID and
+// WALLETCONNECT_RELAY_URL. Without a project it fails, since requests would
+// never reach a wallet, unless WALLETCONNECT_DEV_RELAY=true selects the
+// in-memory relay for local development.
+func NewRelay() (Relay, error) {
+	projectID := os.Getenv("WALLETCONNECT_PROJECT_ID")
+	if projectID == "" {
+		if os.Getenv("WALLETCONNECT_DEV_RELAY") != "true" {
+			return nil, fmt.Errorf("WALLETCONNECT_PROJECT_ID is not set")
+		}
+		log.Println("WALLETCONNECT_DEV_RELAY set, using in-memory relay: requests will not reach wallets")
+		return NewMemoryRelay(), nil
+	}
+
+	relayURL := os.Getenv("WALLETCONNECT_RELAY_URL")
+	if relayURL == "" {
+		relayURL = DefaultRelayURL
+	}
+	return NewHTTPRelay(relayURL, projectID, nil)
+}
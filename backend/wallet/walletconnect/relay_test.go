@@ -0,0 +1,252 @@
+package walletconnect
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// publishedRequest is an irn_publish request as the relay receives it
+type publishedRequest struct {
+	Method string `json:"method"`
+	Params struct {
+		Topic   string `json:"topic"`
+		Message string `json:"message"`
+		TTL     int    `json:"ttl"`
+		Tag     int    `json:"tag"`
+		Prompt  bool   `json:"prompt"`
+	} `json:"params"`
+}
+
+// fakeRelayServer records irn_publish requests and answers with response
+func fakeRelayServer(t *testing.T, response string) (*httptest.Server, *[]publishedRequest, *[]string) {
+	t.Helper()
+	var published []publishedRequest
+	var auths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req publishedRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("relay got invalid JSON: %v", err)
+		}
+		published = append(published, req)
+		auths = append(auths, r.URL.Query().Get("auth"))
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &published, &auths
+}
+
+func TestHTTPRelayRoundTrip(t *testing.T) {
+	srv, published, auths := fakeRelayServer(t, `{"id":1,"jsonrpc":"2.0","result":true}`)
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay, err := NewHTTPRelay(srv.URL, "project", key)
+	if err != nil {
+		t.Fatalf("NewHTTPRelay: %v", err)
+	}
+
+	topic, err := TopicFromSymKey(testSymKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, message, err := encodeRequest(testSymKey, "wc_sessionRequest", map[string]interface{}{
+		"request": map[string]interface{}{"method": "eth_sendTransaction", "params": []string{"tx"}},
+		"chainId": "eip155:1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := relay.Publish(context.Background(), topic, message, requestTTL, tagSessionRequest); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	if len(*published) != 1 {
+		t.Fatalf("relay got %d requests, want 1", len(*published))
+	}
+	got := (*published)[0]
+	if got.Method != "irn_publish" || got.Params.Topic != topic || got.Params.TTL != int(requestTTL.Seconds()) ||
+		got.Params.Tag != tagSessionRequest || !got.Params.Prompt {
+		t.Fatalf("published %+v", got)
+	}
+
+	// The wallet side of the session can open what the relay carried
+	payload, err := Decrypt(testSymKey, got.Params.Message)
+	if err != nil {
+		t.Fatalf("Decrypt published message: %v", err)
+	}
+	var req struct {
+		ID     int64  `json:"id"`
+		Method string `json:"method"`
+		Params struct {
+			Request struct {
+				Method string `json:"method"`
+			} `json:"request"`
+			ChainID string `json:"chainId"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.ID != id || req.Method != "wc_sessionRequest" || req.Params.Request.Method != "eth_sendTransaction" ||
+		req.Params.ChainID != "eip155:1" {
+		t.Fatalf("decrypted request %+v", req)
+	}
+
+	// The auth token is an EdDSA JWT issued by the relay key's did:key
+	parts := strings.Split((*auths)[0], ".")
+	if len(parts) != 3 {
+		t.Fatalf("auth is not a JWT: %q", (*auths)[0])
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !ed25519.Verify(pub, []byte(parts[0]+"."+parts[1]), sig) {
+		t.Fatal("auth token is not signed by the relay key")
+	}
+	claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var c struct {
+		Iss string `json:"iss"`
+		Aud string `json:"aud"`
+	}
+	json.Unmarshal(claims, &c)
+	if c.Iss != didKey(pub) || c.Aud != srv.URL {
+		t.Fatalf("claims %+v", c)
+	}
+}
+
+func TestHTTPRelayError(t *testing.T) {
+	srv, _, _ := fakeRelayServer(t, `{"id":1,"jsonrpc":"2.0","error":{"code":-32600,"message":"bad topic"}}`)
+
+	relay, err := NewHTTPRelay(srv.URL, "project", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = relay.Publish(context.Background(), "topic", "message", time.Minute, tagSessionDelete)
+	if err == nil || !strings.Contains(err.Error(), "bad topic") {
+		t.Fatalf("Publish error = %v, want the relay's error", err)
+	}
+}
+
+func TestMemoryRelayRoundTrip(t *testing.T) {
+	relay := NewMemoryRelay()
+	_, message, err := encodeRequest(testSymKey, "wc_sessionDelete", map[string]interface{}{"code": 6000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := relay.Publish(context.Background(), "topic", message, time.Hour, tagSessionDelete); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := relay.Messages()
+	if len(messages) != 1 || messages[0].Topic != "topic" || messages[0].Tag != tagSessionDelete {
+		t.Fatalf("messages = %+v", messages)
+	}
+	if _, err := Decrypt(testSymKey, messages[0].Message); err != nil {
+		t.Fatalf("Decrypt recorded message: %v", err)
+	}
+}
+
+func TestNewRelay(t *testing.T) {
+	tests := []struct {
+		name      string
+		projectID string
+		devRelay  string
+		want      string // relay type, or "" for an error
+	}{
+		{"project", "project", "", "http"},
+		{"no project", "", "", ""},
+		{"no project, dev relay off", "", "false", ""},
+		{"no project, dev relay", "", "true", "memory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("WALLETCONNECT_PROJECT_ID", tt.projectID)
+			t.Setenv("WALLETCONNECT_DEV_RELAY", tt.devRelay)
+
+			relay, err := NewRelay()
+			got := ""
+			switch relay.(type) {
+			case *HTTPRelay:
+				got = "http"
+			case *MemoryRelay:
+				got = "memory"
+			}
+			if got != tt.want {
+				t.Fatalf("NewRelay() = %T, %v; want %q", relay, err, tt.want)
+			}
+			if tt.want == "" && err == nil {
+				t.Fatal("NewRelay() returned no error without a relay")
+			}
+		})
+	}
+}
+
+func TestHTTPRelayFetchMessages(t *testing.T) {
+	// The relay hands out the topic's messages a page at a time
+	pages := []string{
+		`{"id":1,"jsonrpc":"2.0","result":{"messages":[{"topic":"topic","message":"first","publishedAt":1700000000000,"tag":1109}],"hasMore":true}}`,
+		`{"id":2,"jsonrpc":"2.0","result":{"messages":[{"topic":"topic","message":"second","publishedAt":1700000001000,"tag":1109}],"hasMore":false}}`,
+	}
+	var methods []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req publishedRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		methods = append(methods, req.Method+" "+req.Params.Topic)
+		if req.Method == "irn_subscribe" {
+			w.Write([]byte(`{"id":1,"jsonrpc":"2.0","result":"subscription"}`))
+			return
+		}
+		w.Write([]byte(pages[0]))
+		pages = pages[1:]
+	}))
+	defer srv.Close()
+
+	relay, err := NewHTTPRelay(srv.URL, "project", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := relay.Subscribe(context.Background(), "topic"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	messages, err := relay.FetchMessages(context.Background(), "topic")
+	if err != nil {
+		t.Fatalf("FetchMessages: %v", err)
+	}
+
+	want := []string{"irn_subscribe topic", "irn_fetchMessages topic", "irn_fetchMessages topic"}
+	if strings.Join(methods, ",") != strings.Join(want, ",") {
+		t.Fatalf("relay requests = %v, want %v", methods, want)
+	}
+	if len(messages) != 2 || messages[0].Message != "first" || messages[1].Message != "second" ||
+		messages[1].Tag != tagSessionResponse || !messages[1].PublishedAt.Equal(time.UnixMilli(1700000001000)) {
+		t.Fatalf("messages = %+v", messages)
+	}
+}
+
+func TestMemoryRelayDeliver(t *testing.T) {
+	relay := NewMemoryRelay()
+	ctx := context.Background()
+
+	// Like the relay, it keeps nothing for topics nobody subscribed to
+	relay.Deliver("topic", "early", tagSessionResponse)
+	relay.Subscribe(ctx, "topic")
+	relay.Deliver("topic", "answer", tagSessionResponse)
+
+	messages, _ := relay.FetchMessages(ctx, "topic")
+	if len(messages) != 1 || messages[0].Message != "answer" {
+		t.Fatalf("messages = %+v, want only the answer", messages)
+	}
+	if again, _ := relay.FetchMessages(ctx, "topic"); len(again) != 0 {
+		t.Fatalf("fetched %d messages twice", len(again))
+	}
+}
@@ -0,0 +1,200 @@
+package walletconnect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// rpcResponse is a JSON-RPC response carried inside an envelope
+type rpcResponse struct {
+	ID     int64           `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Response is a wallet's answer to a recorded transaction request
+type Response struct {
+	Request models.WalletRequest
+	TxHash  string // hash of the transaction the wallet sent
+	Error   string // why the wallet refused, when it did
+}
+
+// Sent reports whether the wallet sent the transaction
+func (r Response) Sent() bool {
+	return r.TxHash != ""
+}
+
+// Responses fetches the wallets' answers to pending transaction requests
+// from the relay and records them. Each answer is returned only to the
+// caller that recorded it. A request past its expiry without an answer is
+// marked expired, though a late answer still counts: the wallet may have sent
+// the transaction anyway.
+func (m *Manager) Responses(ctx context.Context) ([]Response, error) {
+	var open []models.WalletRequest
+	if err := database.DB.WithContext(ctx).
+		Where("status IN ?", []string{models.RequestStatusPending, models.RequestStatusExpired}).
+		Where("expires_at > ?", time.Now().Add(-lateResponseWindow)).
+		Order("id").
+		Find(&open).Error; err != nil {
+		return nil, err
+	}
+
+	byTopic := make(map[string][]models.WalletRequest)
+	for _, r := range open {
+		byTopic[r.Topic] = append(byTopic[r.Topic], r)
+	}
+
+	var responses []Response
+	var firstErr error
+	for topic, requests := range byTopic {
+		answered, err := m.topicResponses(ctx, topic, requests)
+		responses = append(responses, answered...)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("topic %s: %w", topic, err)
+			}
+			// The answers may still be on the relay
+			continue
+		}
+		m.expireRequests(ctx, requests)
+	}
+	return responses, firstErr
+}
+
+// lateResponseWindow is how long after a request expires its answer is
+// still looked for
+const lateResponseWindow = time.Hour
+
+// ResponseWindow is how long after it is published a request's answer is
+// still recorded
+const ResponseWindow = requestTTL + lateResponseWindow
+
+// topicResponses records the answers published on one session topic
+func (m *Manager) topicResponses(ctx context.Context, topic string, requests []models.WalletRequest) ([]Response, error) {
+	session, err := m.Get(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := m.relay.FetchMessages(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]models.WalletRequest, len(requests))
+	for _, r := range requests {
+		byID[r.RequestID] = r
+	}
+
+	var responses []Response
+	for _, msg := range messages {
+		payload, err := Decrypt(session.SymKey, msg.Message)
+		if err != nil {
+			log.Printf("Skipping undecryptable message on %s: %v", topic, err)
+			continue
+		}
+		resp, err := decodeResponse(payload)
+		if err != nil {
+			// Requests from the wallet, e.g. session pings, share the topic
+			continue
+		}
+		req, ok := byID[resp.ID]
+		if !ok {
+			continue
+		}
+
+		answer := Response{TxHash: resp.TxHash, Error: resp.Error}
+		recorded, err := recordResponse(ctx, req, answer)
+		if err != nil {
+			return responses, err
+		}
+		if recorded != nil {
+			answer.Request = *recorded
+			responses = append(responses, answer)
+		}
+	}
+	return responses, nil
+}
+
+// recordResponse stores the answer to a request unless another caller
+// already did, returning the updated request or nil
+func recordResponse(ctx context.Context, req models.WalletRequest, answer Response) (*models.WalletRequest, error) {
+	now := time.Now()
+	status := models.RequestStatusSent
+	if !answer.Sent() {
+		status = models.RequestStatusRejected
+	}
+	result := database.DB.WithContext(ctx).Model(&models.WalletRequest{}).
+		Where("id = ? AND status IN ?", req.ID, []string{models.RequestStatusPending, models.RequestStatusExpired}).
+		Updates(map[string]interface{}{
+			"status":       status,
+			"tx_hash":      answer.TxHash,
+			"error":        answer.Error,
+			"responded_at": now,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	req.Status, req.TxHash, req.Error, req.RespondedAt = status, answer.TxHash, answer.Error, &now
+	return &req, nil
+}
+
+// expireRequests marks the pending requests past their expiry as expired
+func (m *Manager) expireRequests(ctx context.Context, requests []models.WalletRequest) {
+	var ids []uint
+	for _, r := range requests {
+		if r.Status == models.RequestStatusPending && r.ExpiresAt.Before(time.Now()) {
+			ids = append(ids, r.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	database.DB.WithContext(ctx).Model(&models.WalletRequest{}).
+		Where("id IN ? AND status = ?", ids, models.RequestStatusPending).
+		Update("status", models.RequestStatusExpired)
+}
+
+// sendResult is a decoded answer to an eth_sendTransaction request
+type sendResult struct {
+	ID     int64
+	TxHash string
+	Error  string
+}
+
+// decodeResponse decodes a wallet's JSON-RPC response to eth_sendTransaction.
+// It fails for anything else, including requests the wallet sends.
+func decodeResponse(payload []byte) (sendResult, error) {
+	var resp rpcResponse
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		return sendResult{}, err
+	}
+	if resp.ID == 0 {
+		return sendResult{}, errors.New("not a response")
+	}
+	if resp.Error != nil {
+		return sendResult{ID: resp.ID, Error: fmt.Sprintf("wallet error %d: %s", resp.Error.Code, resp.Error.Message)}, nil
+	}
+
+	var hash string
+	if err := json.Unmarshal(resp.Result, &hash); err != nil {
+		return sendResult{}, fmt.Errorf("result is not a transaction hash")
+	}
+	if b, err := hexutil.Decode(hash); err != nil || len(b) != 32 {
+		return sendResult{}, fmt.Errorf("result %q is not a transaction hash", hash)
+	}
+	return sendResult{ID: resp.ID, TxHash: hash}, nil
+}
@@ -0,0 +1,156 @@
+package walletconnect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+)
+
+const testTxHash = "0x9fc76417374aa880d4449a1f7f31ec597f00b1f6f3dd2d66f4c9c6c445836d8b"
+
+func TestDecodeResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    sendResult
+		wantErr bool
+	}{
+		{"sent", `{"id":7,"jsonrpc":"2.0","result":"` + testTxHash + `"}`, sendResult{ID: 7, TxHash: testTxHash}, false},
+		{"refused", `{"id":7,"jsonrpc":"2.0","error":{"code":5000,"message":"User rejected."}}`,
+			sendResult{ID: 7, Error: "wallet error 5000: User rejected."}, false},
+		{"request from the wallet", `{"id":8,"jsonrpc":"2.0","method":"wc_sessionPing","params":{}}`, sendResult{}, true},
+		{"not a hash", `{"id":7,"jsonrpc":"2.0","result":true}`, sendResult{}, true},
+		{"short hash", `{"id":7,"jsonrpc":"2.0","result":"0x9fc7"}`, sendResult{}, true},
+		{"no id", `{"jsonrpc":"2.0","result":"` + testTxHash + `"}`, sendResult{}, true},
+		{"not JSON", `sent`, sendResult{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeResponse([]byte(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeResponse error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Fatalf("decodeResponse = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// answer publishes a wallet's encrypted response to a request on the session topic
+func answer(t *testing.T, relay *MemoryRelay, session models.WalletSession, response map[string]interface{}) {
+	t.Helper()
+	response["jsonrpc"] = "2.0"
+	payload, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := Encrypt(session.SymKey, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay.Deliver(session.Topic, message, tagSessionResponse)
+}
+
+func TestResponses(t *testing.T) {
+	testDB(t)
+	ctx := context.Background()
+	relay := NewMemoryRelay()
+	m := NewManager(relay, &fakeVerifier{})
+	session := testSession(t)
+	ruleID := uint(12)
+
+	request := func(nonce uint64) int64 {
+		t.Helper()
+		id, err := m.Request(ctx, session.Topic, "ethereum", "eth_sendTransaction", []interface{}{map[string]interface{}{
+			"from":         session.WalletAddress,
+			"to":           "0x87870Bca3F3fD6335C3F4ce8392A693fcE16f1D7",
+			"data":         "0x",
+			"nonce":        fmt.Sprintf("0x%x", nonce),
+			"maxFeePerGas": "0x3b9aca00",
+		}}, Origin{Type: "deposit", AutomationRuleID: &ruleID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	sent, refused, unanswered := request(4), request(5), request(6)
+
+	var stored models.WalletRequest
+	if err := database.DB.Where("topic = ? AND request_id = ?", session.Topic, sent).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.RequestStatusPending || stored.Nonce == nil || *stored.Nonce != 4 ||
+		stored.Type != "deposit" || *stored.AutomationRuleID != ruleID || stored.UserID != session.UserID {
+		t.Fatalf("recorded request = %+v", stored)
+	}
+
+	answer(t, relay, session, map[string]interface{}{"id": sent, "result": testTxHash})
+	answer(t, relay, session, map[string]interface{}{"id": refused, "error": map[string]interface{}{"code": 5000, "message": "User rejected."}})
+	// Noise on the topic: the wallet's own request and an unknown response
+	answer(t, relay, session, map[string]interface{}{"id": 99, "method": "wc_sessionPing", "params": map[string]interface{}{}})
+	answer(t, relay, session, map[string]interface{}{"id": 98, "result": testTxHash})
+
+	responses, err := m.Responses(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := make(map[int64]Response)
+	for _, r := range responses {
+		if r.Request.Topic == session.Topic {
+			byID[r.Request.RequestID] = r
+		}
+	}
+	if len(byID) != 2 {
+		t.Fatalf("responses = %+v, want the sent and refused requests", responses)
+	}
+	if r := byID[sent]; !r.Sent() || r.TxHash != testTxHash || r.Request.Status != models.RequestStatusSent || *r.Request.Nonce != 4 {
+		t.Errorf("sent response = %+v", r)
+	}
+	if r := byID[refused]; r.Sent() || !strings.Contains(r.Error, "User rejected") || r.Request.Status != models.RequestStatusRejected {
+		t.Errorf("refused response = %+v", r)
+	}
+
+	// Answers are handed out once
+	if again, _ := m.Responses(ctx); len(again) != 0 {
+		t.Fatalf("responses handed out twice: %+v", again)
+	}
+
+	// An unanswered request expires, but a late answer still counts
+	database.DB.Model(&models.WalletRequest{}).Where("topic = ? AND request_id = ?", session.Topic, unanswered).
+		Update("expires_at", time.Now().Add(-time.Minute))
+	m.Responses(ctx)
+	database.DB.Where("topic = ? AND request_id = ?", session.Topic, unanswered).First(&stored)
+	if stored.Status != models.RequestStatusExpired {
+		t.Fatalf("unanswered request status = %s, want expired", stored.Status)
+	}
+	answer(t, relay, session, map[string]interface{}{"id": unanswered, "result": testTxHash})
+	if late, _ := m.Responses(ctx); len(late) != 1 || late[0].Request.RequestID != unanswered || !late[0].Sent() {
+		t.Fatalf("late responses = %+v", late)
+	}
+}
+
+func TestRequestRejectsForeignTransaction(t *testing.T) {
+	testDB(t)
+	m := NewManager(NewMemoryRelay(), &fakeVerifier{})
+	session := testSession(t)
+
+	_, err := m.Request(context.Background(), session.Topic, "ethereum", "eth_sendTransaction", []interface{}{map[string]interface{}{
+		"from": "0x2222222222222222222222222222222222222222",
+		"to":   "0x87870Bca3F3fD6335C3F4ce8392A693fcE16f1D7",
+	}}, Origin{})
+	if err == nil {
+		t.Fatal("requested a transaction from another wallet")
+	}
+	var count int64
+	database.DB.Model(&models.WalletRequest{}).Where("topic = ?", session.Topic).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d requests recorded for a refused request", count)
+	}
+}
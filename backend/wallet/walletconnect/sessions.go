@@ -0,0 +1,476 @@
+package walletconnect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/defioptimization/shared/database"
+	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/wallet/connector"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"gorm.io/gorm"
+)
+
+// requestTTL is how long a signing request waits on the relay for the wallet
+const requestTTL = 5 * time.Minute
+
+// chainIDs maps service chain names to CAIP-2 chain identifiers
+var chainIDs = map[string]string{
+	"ethereum": "eip155:1",
+	"base":     "eip155:8453",
+}
+
+var (
+	// ErrSessionNotFound is returned when no session matches the topic
+	ErrSessionNotFound = errors.New("walletconnect session not found")
+	// ErrSessionInactive is returned when a session was revoked or has expired
+	ErrSessionInactive = errors.New("walletconnect session is not active")
+	// ErrNoActiveSession is returned when the user has no session able to sign
+	ErrNoActiveSession = errors.New("no active walletconnect session")
+	// ErrInvalidProof is returned when a settlement is not signed by its wallet
+	ErrInvalidProof = errors.New("session is not signed by the wallet")
+)
+
+// CAIPChainID returns the CAIP-2 identifier for a chain name
+func CAIPChainID(chain string) (string, error) {
+	id, ok := chainIDs[chain]
+	if !ok {
+		return "", fmt.Errorf("unsupported chain: %s", chain)
+	}
+	return id, nil
+}
+
+// Peer describes the wallet app on the other side of a session
+type Peer struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// Namespace is an approved eip155 namespace of a session
+type Namespace struct {
+	Chains   []string `json:"chains"`
+	Accounts []string `json:"accounts"`
+	Methods  []string `json:"methods"`
+	Events   []string `json:"events"`
+}
+
+// Settlement is a session approved by the user's wallet, as reported by the
+// frontend after pairing. Signature is the wallet's personal_sign of
+// ProofMessage, which shows the session's key was handed over by the wallet's
+// owner.
+type Settlement struct {
+	WalletAddress string
+	Topic         string
+	PairingTopic  string
+	SymKey        string
+	Namespace     Namespace
+	Expiry        time.Time
+	Peer          Peer
+	Signature     string
+}
+
+// SignatureVerifier checks that an address signed a hash
+type SignatureVerifier interface {
+	VerifySignature(ctx context.Context, chain, address string, hash []byte, signature string) (*connector.SignatureVerification, error)
+}
+
+// ProofMessage is the message a wallet signs to hand a session to the backend
+func ProofMessage(topic string, expiry time.Time) string {
+	return fmt.Sprintf("Allow DeFi Optimization to send requests to this wallet.\n\nSession: %s\nExpires: %d", topic, expiry.Unix())
+}
+
+// Manager tracks WalletConnect sessions and publishes requests over the relay
+type Manager struct {
+	relay    Relay
+	verifier SignatureVerifier
+}
+
+// NewManager creates a session manager that publishes through relay and
+// checks settlement proofs with verifier
+func NewManager(relay Relay, verifier SignatureVerifier) *Manager {
+	return &Manager{relay: relay, verifier: verifier}
+}
+
+// Register stores a settled session for user. The session must be for the
+// user's wallet and carry the wallet's signature of its ProofMessage.
+func (m *Manager) Register(ctx context.Context, user *models.User, s Settlement) (*models.WalletSession, error) {
+	if !strings.EqualFold(s.WalletAddress, user.WalletAddress) {
+		return nil, fmt.Errorf("session wallet %s does not belong to the user", s.WalletAddress)
+	}
+	if s.Expiry.Before(time.Now()) {
+		return nil, fmt.Errorf("session already expired")
+	}
+	// The topic is derived from the key, so the signed topic binds the key
+	topic, err := TopicFromSymKey(s.SymKey)
+	if err != nil {
+		return nil, err
+	}
+	if s.Topic != "" && s.Topic != topic {
+		return nil, fmt.Errorf("topic does not match sym_key")
+	}
+	s.Topic = topic
+	if !containsAccount(s.Namespace.Accounts, s.WalletAddress) {
+		return nil, fmt.Errorf("session does not include account %s", s.WalletAddress)
+	}
+	if err := m.verifyProof(ctx, s); err != nil {
+		return nil, err
+	}
+
+	session := models.WalletSession{
+		UserID:        user.ID,
+		WalletAddress: user.WalletAddress,
+		Topic:         s.Topic,
+		PairingTopic:  s.PairingTopic,
+		SymKey:        s.SymKey,
+		Chains:        s.Namespace.Chains,
+		Accounts:      s.Namespace.Accounts,
+		Methods:       s.Namespace.Methods,
+		Events:        s.Namespace.Events,
+		PeerName:      s.Peer.Name,
+		PeerURL:       s.Peer.URL,
+		Status:        models.SessionStatusActive,
+		ExpiresAt:     s.Expiry,
+	}
+
+	// A re-approved session (e.g. after a namespace update) replaces the old record
+	var existing models.WalletSession
+	err = database.DB.WithContext(ctx).Where("topic = ?", s.Topic).First(&existing).Error
+	switch {
+	case err == nil:
+		if existing.UserID != user.ID {
+			return nil, fmt.Errorf("session %s belongs to another user", s.Topic)
+		}
+		session.ID = existing.ID
+		session.CreatedAt = existing.CreatedAt
+		if err := database.DB.WithContext(ctx).Save(&session).Error; err != nil {
+			return nil, err
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if err := database.DB.WithContext(ctx).Create(&session).Error; err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// verifyProof checks the settlement's signature on the first of its chains
+// this service supports, so contract wallets can be asked via EIP-1271
+func (m *Manager) verifyProof(ctx context.Context, s Settlement) error {
+	if s.Signature == "" {
+		return ErrInvalidProof
+	}
+	chain, ok := "", false
+	for _, id := range s.Namespace.Chains {
+		if chain, ok = chainName(id); ok {
+			break
+		}
+	}
+	if !ok {
+		return fmt.Errorf("session has no supported chain")
+	}
+
+	hash := connector.PersonalSignHash(ProofMessage(s.Topic, s.Expiry))
+	result, err := m.verifier.VerifySignature(ctx, chain, s.WalletAddress, hash, s.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+	if !result.Valid {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// List returns a user's sessions, newest first. Sessions past their expiry
+// are marked expired on the way out.
+func (m *Manager) List(ctx context.Context, userID uint) ([]models.WalletSession, error) {
+	m.expireSessions(ctx)
+
+	var sessions []models.WalletSession
+	err := database.DB.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// Get returns the session with the given topic
+func (m *Manager) Get(ctx context.Context, topic string) (*models.WalletSession, error) {
+	var session models.WalletSession
+	if err := database.DB.WithContext(ctx).Where("topic = ?", topic).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	if session.Status == models.SessionStatusActive && session.ExpiresAt.Before(time.Now()) {
+		session.Status = models.SessionStatusExpired
+		database.DB.WithContext(ctx).Model(&session).Update("status", models.SessionStatusExpired)
+	}
+	return &session, nil
+}
+
+// Active returns the newest active session that can sign for the wallet on
+// the chain. Only the wallet's owner can register sessions for it, so every
+// candidate was handed over by the wallet itself.
+func (m *Manager) Active(ctx context.Context, walletAddress, chain string) (*models.WalletSession, error) {
+	chainID, err := CAIPChainID(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []models.WalletSession
+	if err := database.DB.WithContext(ctx).
+		Where("LOWER(wallet_address) = LOWER(?) AND status = ? AND expires_at > ?",
+			walletAddress, models.SessionStatusActive, time.Now()).
+		Order("created_at DESC").
+		Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	account := chainID + ":" + walletAddress
+	for i := range sessions {
+		if contains(sessions[i].Chains, chainID) && containsAccount(sessions[i].Accounts, account) &&
+			contains(sessions[i].Methods, "eth_sendTransaction") {
+			return &sessions[i], nil
+		}
+	}
+	return nil, ErrNoActiveSession
+}
+
+// Revoke ends a session and tells the wallet through the relay. The session
+// is revoked locally even if the relay can't be reached.
+func (m *Manager) Revoke(ctx context.Context, topic string) (*models.WalletSession, error) {
+	session, err := m.Get(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	if session.Status == models.SessionStatusRevoked {
+		return session, nil
+	}
+
+	var publishErr error
+	if session.Status == models.SessionStatusActive {
+		_, message, err := encodeRequest(session.SymKey, "wc_sessionDelete", map[string]interface{}{
+			"code":    6000,
+			"message": "User disconnected.",
+		})
+		if err != nil {
+			return nil, err
+		}
+		publishErr = m.relay.Publish(ctx, session.Topic, message, 24*time.Hour, tagSessionDelete)
+	}
+
+	now := time.Now()
+	session.Status = models.SessionStatusRevoked
+	session.RevokedAt = &now
+	if err := database.DB.WithContext(ctx).Save(session).Error; err != nil {
+		return nil, err
+	}
+
+	if publishErr != nil {
+		return session, fmt.Errorf("session revoked but relay publish failed: %w", publishErr)
+	}
+	return session, nil
+}
+
+// RevokeUser revokes every active session of a user
+func (m *Manager) RevokeUser(ctx context.Context, userID uint) (int, error) {
+	var sessions []models.WalletSession
+	if err := database.DB.WithContext(ctx).
+		Where("user_id = ? AND status = ?", userID, models.SessionStatusActive).
+		Find(&sessions).Error; err != nil {
+		return 0, err
+	}
+
+	var firstErr error
+	for _, s := range sessions {
+		if _, err := m.Revoke(ctx, s.Topic); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return len(sessions), firstErr
+}
+
+// Origin says what a transaction request is for, so the transaction the
+// wallet sends is recorded against it
+type Origin struct {
+	Type             string // rebalance, deposit, withdraw; transfer if empty
+	AutomationRuleID *uint
+}
+
+// Request publishes a wc_sessionRequest asking the wallet to run method on
+// chain. It returns the JSON-RPC id the wallet's response will carry.
+// eth_sendTransaction requests are recorded and the session topic
+// subscribed, so Responses can pick up the hash of the transaction the
+// wallet sends.
+func (m *Manager) Request(ctx context.Context, topic, chain, method string, params []interface{}, origin Origin) (int64, error) {
+	session, err := m.Get(ctx, topic)
+	if err != nil {
+		return 0, err
+	}
+	if session.Status != models.SessionStatusActive {
+		return 0, ErrSessionInactive
+	}
+
+	chainID, err := CAIPChainID(chain)
+	if err != nil {
+		return 0, err
+	}
+	if !contains(session.Chains, chainID) {
+		return 0, fmt.Errorf("session not approved for %s", chainID)
+	}
+	if !contains(session.Methods, method) {
+		return 0, fmt.Errorf("session not approved for %s", method)
+	}
+
+	var pending *models.WalletRequest
+	if method == "eth_sendTransaction" {
+		if pending, err = transactionRequest(session, chain, params, origin); err != nil {
+			return 0, err
+		}
+		if err := m.relay.Subscribe(ctx, session.Topic); err != nil {
+			return 0, fmt.Errorf("failed to subscribe to session: %w", err)
+		}
+	}
+
+	expiry := time.Now().Add(requestTTL)
+	id, message, err := encodeRequest(session.SymKey, "wc_sessionRequest", map[string]interface{}{
+		"request": map[string]interface{}{
+			"method":          method,
+			"params":          params,
+			"expiryTimestamp": expiry.Unix(),
+		},
+		"chainId": chainID,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if pending != nil {
+		pending.RequestID, pending.ExpiresAt = id, expiry
+		if err := database.DB.WithContext(ctx).Create(pending).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	if err := m.relay.Publish(ctx, session.Topic, message, requestTTL, tagSessionRequest); err != nil {
+		if pending != nil {
+			database.DB.WithContext(ctx).Delete(pending)
+		}
+		return 0, fmt.Errorf("failed to publish request: %w", err)
+	}
+	return id, nil
+}
+
+// TransactionNonce returns the sender and nonce of the transaction in
+// eth_sendTransaction parameters. Either is empty when the transaction leaves
+// it to the wallet.
+func TransactionNonce(params []interface{}) (string, *uint64, error) {
+	tx, err := transactionParams(params)
+	if err != nil {
+		return "", nil, err
+	}
+	from, _ := tx["from"].(string)
+	v, ok := tx["nonce"]
+	if !ok {
+		return from, nil, nil
+	}
+	s, _ := v.(string)
+	n, err := hexutil.DecodeUint64(s)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid nonce %v: %w", v, err)
+	}
+	return from, &n, nil
+}
+
+// transactionParams returns the transaction object of eth_sendTransaction parameters
+func transactionParams(params []interface{}) (map[string]interface{}, error) {
+	if len(params) != 1 {
+		return nil, fmt.Errorf("eth_sendTransaction takes one transaction")
+	}
+	tx, ok := params[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("eth_sendTransaction parameter must be a transaction object")
+	}
+	return tx, nil
+}
+
+// transactionRequest builds the record of an eth_sendTransaction request
+func transactionRequest(session *models.WalletSession, chain string, params []interface{}, origin Origin) (*models.WalletRequest, error) {
+	tx, err := transactionParams(params)
+	if err != nil {
+		return nil, err
+	}
+	from, nonce, err := TransactionNonce(params)
+	if err != nil {
+		return nil, err
+	}
+	if from != "" && !strings.EqualFold(from, session.WalletAddress) {
+		return nil, fmt.Errorf("transaction is not from the session's wallet")
+	}
+
+	txType := origin.Type
+	if txType == "" {
+		txType = "transfer"
+	}
+
+	return &models.WalletRequest{
+		Topic:            session.Topic,
+		UserID:           session.UserID,
+		Chain:            chain,
+		FromAddress:      session.WalletAddress,
+		Nonce:            nonce,
+		Params:           tx,
+		Type:             txType,
+		AutomationRuleID: origin.AutomationRuleID,
+		Status:           models.RequestStatusPending,
+	}, nil
+}
+
+// expireSessions marks active sessions past their expiry as expired
+func (m *Manager) expireSessions(ctx context.Context) {
+	database.DB.WithContext(ctx).Model(&models.WalletSession{}).
+		Where("status = ? AND expires_at <= ?", models.SessionStatusActive, time.Now()).
+		Update("status", models.SessionStatusExpired)
+}
+
+// chainName returns the service chain name of a CAIP-2 identifier
+func chainName(chainID string) (string, bool) {
+	for name, id := range chainIDs {
+		if id == chainID {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAccount matches CAIP-10 accounts ("eip155:1:0xabc...") or a bare
+// address against any chain, ignoring checksum case
+func containsAccount(accounts []string, account string) bool {
+	for _, a := range accounts {
+		if strings.EqualFold(a, account) {
+			return true
+		}
+		if !strings.Contains(account, ":") {
+			if i := strings.LastIndex(a, ":"); i >= 0 && strings.EqualFold(a[i+1:], account) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package walletconnect
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defioptimization/shared/models"
+	"github.com/defioptimization/wallet/connector"
+)
+
+// fakeVerifier accepts signatures equal to valid
+type fakeVerifier struct {
+	valid string
+	calls []string // chain of each call
+}
+
+func (v *fakeVerifier) VerifySignature(ctx context.Context, chain, address string, hash []byte, signature string) (*connector.SignatureVerification, error) {
+	v.calls = append(v.calls, chain)
+	return &connector.SignatureVerification{Valid: signature == v.valid}, nil
+}
+
+// TestRegisterRejects covers the checks Register makes before it stores
+// anything, so it needs no database
+func TestRegisterRejects(t *testing.T) {
+	const wallet = "0x1111111111111111111111111111111111111111"
+	user := &models.User{ID: 1, WalletAddress: wallet}
+	topic, _ := TopicFromSymKey(testSymKey)
+
+	valid := Settlement{
+		WalletAddress: wallet,
+		SymKey:        testSymKey,
+		Namespace: Namespace{
+			Chains:   []string{"eip155:10", "eip155:8453"},
+			Accounts: []string{"eip155:8453:" + wallet},
+			Methods:  []string{"eth_sendTransaction"},
+		},
+		Expiry:    time.Now().Add(time.Hour),
+		Signature: "0xvalid",
+	}
+
+	tests := []struct {
+		name    string
+		change  func(s *Settlement)
+		want    string
+		wantErr error
+	}{
+		{"another user's wallet", func(s *Settlement) {
+			s.WalletAddress = "0x2222222222222222222222222222222222222222"
+			s.Namespace.Accounts = []string{"eip155:8453:" + s.WalletAddress}
+		}, "does not belong", nil},
+		{"expired", func(s *Settlement) { s.Expiry = time.Now().Add(-time.Minute) }, "expired", nil},
+		{"topic not derived from key", func(s *Settlement) { s.Topic = strings.Repeat("0", 64) }, "topic", nil},
+		{"account not in namespace", func(s *Settlement) { s.Namespace.Accounts = nil }, "does not include", nil},
+		{"no signature", func(s *Settlement) { s.Signature = "" }, "", ErrInvalidProof},
+		{"wrong signature", func(s *Settlement) { s.Signature = "0xforged" }, "", ErrInvalidProof},
+		{"no supported chain", func(s *Settlement) { s.Namespace.Chains = []string{"eip155:10"} }, "no supported chain", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid
+			s.Namespace.Chains = append([]string(nil), valid.Namespace.Chains...)
+			s.Namespace.Accounts = append([]string(nil), valid.Namespace.Accounts...)
+			tt.change(&s)
+
+			m := NewManager(NewMemoryRelay(), &fakeVerifier{valid: "0xvalid"})
+			_, err := m.Register(context.Background(), user, s)
+			if err == nil {
+				t.Fatal("Register accepted the settlement")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Register error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.want != "" && !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Register error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+
+	// The proof is checked on the first supported chain of the session
+	v := &fakeVerifier{valid: "0xvalid"}
+	s := valid
+	s.Topic = topic
+	NewManager(NewMemoryRelay(), v).verifyProof(context.Background(), s)
+	if len(v.calls) != 1 || v.calls[0] != "base" {
+		t.Fatalf("proof verified on %v, want [base]", v.calls)
+	}
+}
+
+func TestProofMessageBindsSession(t *testing.T) {
+	expiry := time.Unix(1700000000, 0)
+	a := ProofMessage("topic-a", expiry)
+	if a == ProofMessage("topic-b", expiry) || a == ProofMessage("topic-a", expiry.Add(time.Second)) {
+		t.Fatal("proof message does not depend on the topic and expiry")
+	}
+	if !strings.Contains(a, "topic-a") || !strings.Contains(a, "1700000000") {
+		t.Fatalf("proof message %q", a)
+	}
+}